@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +13,34 @@ type CacheConfig struct {
 	TTL             time.Duration
 	CleanupInterval time.Duration
 	MaxCacheSize    int64
+	// EvictionPolicy は追い出しポリシーの名前 (fifo / lru / lfu / gds)
+	EvictionPolicy string
+	// BucketWeights はバケットごとの追い出しの重み。大きいほどキャッシュに残りやすい
+	BucketWeights map[string]float64
+	// Revalidate が true の場合、期限切れのキャッシュは S3 に ETag で変更を確認してから再利用する
+	Revalidate bool
+	// RevalidateEveryNHits は有効期限内でも再検証するヒット回数の間隔。0 の場合は期限切れ時のみ
+	RevalidateEveryNHits int
+	// StaleWhileRevalidate は期限切れ後も古いキャッシュを返しつつバックグラウンドで再検証する期間
+	StaleWhileRevalidate time.Duration
+	// StaleRetention は再検証や R2 に接続できない場合の代替のために、期限切れのキャッシュを残しておく期間。既定は 0 で残さない
+	StaleRetention time.Duration
+	// PrefetchConcurrency はプリフェッチジョブで同時に取得するオブジェクト数
+	PrefetchConcurrency int
+	// ReconcileOnStartup が true の場合、起動時にキャッシュディレクトリと cache_entries を突き合わせる
+	ReconcileOnStartup bool
+	// ReconcileVerify が true の場合、起動時の突き合わせでキャッシュファイルのサイズと SHA-256 も検査する
+	ReconcileVerify bool
+	// Compression はキャッシュファイルの圧縮形式 (none / gzip / zstd)
+	Compression string
+	// CompressionTypes は圧縮する Content-Type のパターン。空の場合は既定のテキスト系の形式
+	CompressionTypes []string
+	// CompressionMinSize はこのサイズ未満のオブジェクトを圧縮しない
+	CompressionMinSize int64
+	// MemorySize はメモリ上のキャッシュ層の上限サイズ。0 の場合はメモリ層を使わない
+	MemorySize int64
+	// MemoryMaxObjectSize はメモリ上に保持するエントリの最大サイズ (ディスク上のサイズ)
+	MemoryMaxObjectSize int64
 }
 
 func LoadCacheConfigFromEnv() *CacheConfig {
@@ -46,11 +75,110 @@ func LoadCacheConfigFromEnv() *CacheConfig {
 		}
 	}
 
+	evictionPolicy := strings.ToLower(strings.TrimSpace(os.Getenv("CACHE_EVICTION_POLICY")))
+	if evictionPolicy == "" {
+		evictionPolicy = "fifo"
+	}
+
+	revalidate := os.Getenv("CACHE_REVALIDATE") == "true"
+
+	var revalidateEveryNHits int
+	if v := os.Getenv("CACHE_REVALIDATE_EVERY_N_HITS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			revalidateEveryNHits = parsed
+		}
+	}
+
+	var staleWhileRevalidateMinutes int
+	if v := os.Getenv("CACHE_STALE_WHILE_REVALIDATE_MINUTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			staleWhileRevalidateMinutes = parsed
+		}
+	}
+
+	// 既定では期限切れのキャッシュを残さない。stale-while-revalidate の期間は残す
+	var staleRetentionMinutes int
+	if v := os.Getenv("CACHE_STALE_RETENTION_MINUTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			staleRetentionMinutes = parsed
+		}
+	}
+	staleRetentionMinutes = max(staleRetentionMinutes, staleWhileRevalidateMinutes)
+
+	prefetchConcurrency := 4
+	if v := os.Getenv("CACHE_PREFETCH_CONCURRENCY"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			prefetchConcurrency = parsed
+		}
+	}
+
+	reconcileOnStartup := os.Getenv("CACHE_RECONCILE_ON_STARTUP") != "false"
+	reconcileVerify := os.Getenv("CACHE_RECONCILE_VERIFY") == "true"
+
+	compression := strings.ToLower(strings.TrimSpace(os.Getenv("CACHE_COMPRESSION")))
+
+	var compressionTypes []string
+	for t := range strings.SplitSeq(os.Getenv("CACHE_COMPRESSION_TYPES"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			compressionTypes = append(compressionTypes, t)
+		}
+	}
+
+	compressionMinSize := int64(1024)
+	if v := os.Getenv("CACHE_COMPRESSION_MIN_SIZE"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed >= 0 {
+			compressionMinSize = parsed
+		}
+	}
+
+	memorySizeMB := int64(32)
+	if v := os.Getenv("CACHE_MEMORY_SIZE_MB"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed >= 0 {
+			memorySizeMB = parsed
+		}
+	}
+
+	memoryMaxObjectKB := int64(256)
+	if v := os.Getenv("CACHE_MEMORY_MAX_OBJECT_KB"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed > 0 {
+			memoryMaxObjectKB = parsed
+		}
+	}
+
 	return &CacheConfig{
-		DBPath:          dbPath,
-		CacheDir:        cacheDir,
-		TTL:             time.Duration(ttlMinutes) * time.Minute,
-		CleanupInterval: time.Duration(cleanupIntervalMinutes) * time.Minute,
-		MaxCacheSize:    maxCacheSize,
+		DBPath:               dbPath,
+		CacheDir:             cacheDir,
+		TTL:                  time.Duration(ttlMinutes) * time.Minute,
+		CleanupInterval:      time.Duration(cleanupIntervalMinutes) * time.Minute,
+		MaxCacheSize:         maxCacheSize,
+		EvictionPolicy:       evictionPolicy,
+		BucketWeights:        parseBucketWeights(os.Getenv("CACHE_BUCKET_WEIGHTS")),
+		Revalidate:           revalidate,
+		RevalidateEveryNHits: revalidateEveryNHits,
+		StaleWhileRevalidate: time.Duration(staleWhileRevalidateMinutes) * time.Minute,
+		StaleRetention:       time.Duration(staleRetentionMinutes) * time.Minute,
+		PrefetchConcurrency:  prefetchConcurrency,
+		ReconcileOnStartup:   reconcileOnStartup,
+		ReconcileVerify:      reconcileVerify,
+		Compression:          compression,
+		CompressionTypes:     compressionTypes,
+		CompressionMinSize:   compressionMinSize,
+		MemorySize:           memorySizeMB * 1024 * 1024,
+		MemoryMaxObjectSize:  memoryMaxObjectKB * 1024,
+	}
+}
+
+// parseBucketWeights は "bucket1=2,bucket2=0.5" 形式の重み指定をパースする。不正な要素は無視する。
+func parseBucketWeights(v string) map[string]float64 {
+	weights := make(map[string]float64)
+	for pair := range strings.SplitSeq(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			continue
+		}
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed > 0 {
+			weights[name] = parsed
+		}
 	}
+	return weights
 }
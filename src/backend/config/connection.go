@@ -0,0 +1,16 @@
+package config
+
+import "os"
+
+type ConnectionConfig struct {
+	// DataDir は追加した接続ごとのデータベースとキャッシュを置くディレクトリ。接続名のサブディレクトリを作る
+	DataDir string
+}
+
+func LoadConnectionConfigFromEnv() *ConnectionConfig {
+	dataDir := os.Getenv("CONNECTIONS_DIR")
+	if dataDir == "" {
+		dataDir = "./data/connections"
+	}
+	return &ConnectionConfig{DataDir: dataDir}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+type HealthConfig struct {
+	// FailureThreshold は縮退モードに切り替えるまでの連続失敗回数
+	FailureThreshold int
+	InitialBackoff   time.Duration
+	MaxBackoff       time.Duration
+}
+
+func LoadHealthConfigFromEnv() *HealthConfig {
+	failureThreshold := 3
+	if v := os.Getenv("UPSTREAM_FAILURE_THRESHOLD"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			failureThreshold = parsed
+		}
+	}
+
+	initialBackoffSeconds := 5
+	if v := os.Getenv("UPSTREAM_BACKOFF_INITIAL_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			initialBackoffSeconds = parsed
+		}
+	}
+
+	maxBackoffSeconds := 300
+	if v := os.Getenv("UPSTREAM_BACKOFF_MAX_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			maxBackoffSeconds = parsed
+		}
+	}
+
+	return &HealthConfig{
+		FailureThreshold: failureThreshold,
+		InitialBackoff:   time.Duration(initialBackoffSeconds) * time.Second,
+		MaxBackoff:       time.Duration(maxBackoffSeconds) * time.Second,
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPresignExpiryMinutes    = 60
+	defaultPresignMaxExpiryMinutes = 24 * 60
+	// SigV4 の署名付きURLの有効期限は最大 7 日間
+	presignExpiryLimit = 7 * 24 * time.Hour
+)
+
+type PresignConfig struct {
+	DefaultExpiry time.Duration
+	MaxExpiry     time.Duration
+	// TrustedProxies からのリクエストに限り、プロキシが付与するユーザーヘッダを発行者として記録する。
+	// PRESIGN_TRUST_PROXY_USER_HEADERS が true の場合のみ設定する
+	TrustedProxies []string
+}
+
+func LoadPresignConfigFromEnv() *PresignConfig {
+	maxMinutes := defaultPresignMaxExpiryMinutes
+	if v := os.Getenv("PRESIGN_MAX_EXPIRY_MINUTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			maxMinutes = parsed
+		}
+	}
+	maxExpiry := min(time.Duration(maxMinutes)*time.Minute, presignExpiryLimit)
+
+	defaultMinutes := defaultPresignExpiryMinutes
+	if v := os.Getenv("PRESIGN_DEFAULT_EXPIRY_MINUTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			defaultMinutes = parsed
+		}
+	}
+	defaultExpiry := min(time.Duration(defaultMinutes)*time.Minute, maxExpiry)
+
+	var trustedProxies []string
+	if os.Getenv("PRESIGN_TRUST_PROXY_USER_HEADERS") == "true" {
+		trustedProxies = LoadTrustedProxiesFromEnv()
+	}
+
+	return &PresignConfig{
+		DefaultExpiry:  defaultExpiry,
+		MaxExpiry:      maxExpiry,
+		TrustedProxies: trustedProxies,
+	}
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// LoadTrustedProxiesFromEnv は信頼するリバースプロキシの IP / CIDR の一覧を返す。
+func LoadTrustedProxiesFromEnv() []string {
+	env := os.Getenv("env")
+	if env == "dev" {
+		return []string{"192.168.0.0/24", "127.0.0.1"}
+	}
+	rawIpList := os.Getenv("IP_LIST")
+	if rawIpList != "" {
+		ipList := strings.Split(rawIpList, ",")
+		results := []string{}
+		for _, ip := range ipList {
+			trimmed := strings.TrimSpace(ip)
+			if trimmed == "" {
+				continue
+			}
+			results = append(results, trimmed)
+		}
+		return results
+	}
+
+	return []string{}
+}
@@ -2,46 +2,177 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/pkg/errors"
+
+	"r2manager/health"
 )
 
 type R2Config struct {
 	AccountID       string
 	AccessKeyID     string
 	SecretAccessKey string
+
+	// Endpoint は S3 互換 API のエンドポイント URL (例: http://localhost:9000)。
+	// 空の場合、AccountID があれば R2 のエンドポイントを、なければ AWS S3 の既定のエンドポイントを使う
+	Endpoint string
+	// Region は署名に使うリージョン。R2 では "auto"
+	Region string
+	// UsePathStyle が true の場合、バケット名をホスト名ではなくパスに含める (MinIO など)
+	UsePathStyle bool
+	// CABundle は TLS の検証に追加で信頼する CA 証明書 (PEM) のパス
+	CABundle string
+	// InsecureSkipVerify が true の場合、TLS 証明書を検証しない。開発環境でのみ使うこと
+	InsecureSkipVerify bool
+	// DefaultBucket は画面で最初に開くバケット
+	DefaultBucket string
 }
 
 func LoadR2ConfigFromEnv() (*R2Config, error) {
 	accountID := os.Getenv("R2_ACCOUNT_ID")
-	accessKeyID := os.Getenv("R2_ACCESS_KEY_ID")
-	secretAccessKey := os.Getenv("R2_SECRET_ACCESS_KEY")
+	endpoint := os.Getenv("S3_ENDPOINT")
+	region := os.Getenv("S3_REGION")
+
+	// アクセスキーは S3_* を優先し、従来の R2_* も受け付ける。どちらもない場合は AWS の既定の認証情報を使う
+	accessKeyID := os.Getenv("S3_ACCESS_KEY_ID")
+	if accessKeyID == "" {
+		accessKeyID = os.Getenv("R2_ACCESS_KEY_ID")
+	}
+	secretAccessKey := os.Getenv("S3_SECRET_ACCESS_KEY")
+	if secretAccessKey == "" {
+		secretAccessKey = os.Getenv("R2_SECRET_ACCESS_KEY")
+	}
+
+	cfg := &R2Config{
+		AccountID:          accountID,
+		AccessKeyID:        accessKeyID,
+		SecretAccessKey:    secretAccessKey,
+		Endpoint:           endpoint,
+		Region:             region,
+		UsePathStyle:       os.Getenv("S3_USE_PATH_STYLE") == "true",
+		CABundle:           os.Getenv("S3_CA_BUNDLE"),
+		InsecureSkipVerify: os.Getenv("S3_INSECURE_SKIP_VERIFY") == "true",
+		DefaultBucket:      os.Getenv("S3_DEFAULT_BUCKET"),
+	}
+	if err := cfg.Normalize(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Normalize は接続先の指定を検証し、省略されたエンドポイントとリージョンを補う。
+func (c *R2Config) Normalize() error {
+	if c.AccountID == "" && c.Endpoint == "" && c.Region == "" {
+		return errors.New("R2_ACCOUNT_ID, S3_ENDPOINT or S3_REGION must be set")
+	}
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		return errors.New("S3_ACCESS_KEY_ID (R2_ACCESS_KEY_ID) and S3_SECRET_ACCESS_KEY (R2_SECRET_ACCESS_KEY) must be set together")
+	}
+	c.Endpoint = strings.TrimRight(strings.TrimSpace(c.Endpoint), "/")
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("S3_ENDPOINT must be an http or https URL: %q", c.Endpoint)
+		}
+	}
 
-	if accountID == "" || accessKeyID == "" || secretAccessKey == "" {
-		return nil, errors.New("R2_ACCOUNT_ID, R2_ACCESS_KEY_ID, R2_SECRET_ACCESS_KEY must be set")
+	if c.Endpoint == "" && c.AccountID != "" {
+		c.Endpoint = fmt.Sprintf("https://%s.r2.cloudflarestorage.com", c.AccountID)
+	}
+	if c.Region == "" {
+		if c.AccountID != "" {
+			c.Region = "auto"
+		} else {
+			c.Region = "us-east-1"
+		}
 	}
+	return nil
+}
 
-	return &R2Config{
-		AccountID:       accountID,
-		AccessKeyID:     accessKeyID,
-		SecretAccessKey: secretAccessKey,
-	}, nil
+// LoadCredentialsFromEnv は ref を接頭辞とする環境変数 (<ref>_ACCESS_KEY_ID, <ref>_SECRET_ACCESS_KEY) からアクセスキーを読み込む。
+// 認証情報そのものはデータベースに保存せず、接続の設定には参照名だけを保存する。
+func LoadCredentialsFromEnv(ref string) (accessKeyID, secretAccessKey string, err error) {
+	accessKeyID = os.Getenv(ref + "_ACCESS_KEY_ID")
+	secretAccessKey = os.Getenv(ref + "_SECRET_ACCESS_KEY")
+	if accessKeyID == "" || secretAccessKey == "" {
+		return "", "", errors.Errorf("%s_ACCESS_KEY_ID and %s_SECRET_ACCESS_KEY must be set", ref, ref)
+	}
+	return accessKeyID, secretAccessKey, nil
 }
 
-func NewS3Client(ctx context.Context, r2cfg *R2Config) (*s3.Client, error) {
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion("auto"),
-		config.WithBaseEndpoint(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", r2cfg.AccountID)),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(r2cfg.AccessKeyID, r2cfg.SecretAccessKey, "")),
-	)
+// NewS3Client は S3 クライアントを作成する。monitor を指定した場合、通信の成否を監視させる。
+func NewS3Client(ctx context.Context, r2cfg *R2Config, monitor *health.Monitor) (*s3.Client, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(r2cfg.Region),
+	}
+	if r2cfg.Endpoint != "" {
+		opts = append(opts, config.WithBaseEndpoint(r2cfg.Endpoint))
+	}
+	if r2cfg.AccessKeyID != "" {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(r2cfg.AccessKeyID, r2cfg.SecretAccessKey, "")))
+	}
+
+	httpClient, err := newHTTPClient(r2cfg)
+	if err != nil {
+		return nil, err
+	}
+	if monitor != nil {
+		httpClient = monitor.WrapHTTPClient(httpClient)
+	}
+	opts = append(opts, config.WithHTTPClient(httpClient))
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load AWS config")
 	}
 
-	return s3.NewFromConfig(cfg), nil
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = r2cfg.UsePathStyle
+	}), nil
+}
+
+// newHTTPClient は CA 証明書と TLS 検証の設定を反映した HTTP クライアントを作成する。
+func newHTTPClient(r2cfg *R2Config) (aws.HTTPClient, error) {
+	client := awshttp.NewBuildableClient()
+	if r2cfg.CABundle == "" && !r2cfg.InsecureSkipVerify {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r2cfg.CABundle != "" {
+		pem, err := os.ReadFile(r2cfg.CABundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA bundle")
+		}
+		// システムの CA に追加する。取得できない環境では CA バンドルだけを信頼する
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in CA bundle %s", r2cfg.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if r2cfg.InsecureSkipVerify {
+		log.Printf("warning: TLS certificate verification for the S3 endpoint is disabled")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return client.WithTransportOptions(func(tr *http.Transport) {
+		tr.TLSClientConfig = tlsConfig
+	}), nil
 }
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var r2EnvKeys = []string{
+	"R2_ACCOUNT_ID", "S3_ENDPOINT", "S3_REGION",
+	"S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY", "R2_ACCESS_KEY_ID", "R2_SECRET_ACCESS_KEY",
+	"S3_USE_PATH_STYLE", "S3_CA_BUNDLE", "S3_INSECURE_SKIP_VERIFY", "S3_DEFAULT_BUCKET",
+}
+
+// setR2Env は接続先の環境変数をすべて空にしてから env を設定する。
+func setR2Env(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, key := range r2EnvKeys {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func TestLoadR2ConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    R2Config
+		wantErr bool
+	}{
+		{
+			name: "r2 account",
+			env:  map[string]string{"R2_ACCOUNT_ID": "abc123", "R2_ACCESS_KEY_ID": "key", "R2_SECRET_ACCESS_KEY": "secret"},
+			want: R2Config{AccountID: "abc123", AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: "https://abc123.r2.cloudflarestorage.com", Region: "auto"},
+		},
+		{
+			name: "s3 keys take precedence over r2 keys",
+			env: map[string]string{"S3_REGION": "ap-northeast-1", "S3_ACCESS_KEY_ID": "s3-key", "S3_SECRET_ACCESS_KEY": "s3-secret",
+				"R2_ACCESS_KEY_ID": "r2-key", "R2_SECRET_ACCESS_KEY": "r2-secret"},
+			want: R2Config{AccessKeyID: "s3-key", SecretAccessKey: "s3-secret", Region: "ap-northeast-1"},
+		},
+		{
+			name: "endpoint defaults region",
+			env:  map[string]string{"S3_ENDPOINT": "http://localhost:9000/"},
+			want: R2Config{Endpoint: "http://localhost:9000", Region: "us-east-1"},
+		},
+		{
+			name: "endpoint overrides r2 endpoint",
+			env:  map[string]string{"R2_ACCOUNT_ID": "abc123", "S3_ENDPOINT": "https://s3.example.com"},
+			want: R2Config{AccountID: "abc123", Endpoint: "https://s3.example.com", Region: "auto"},
+		},
+		{
+			name: "region only uses default endpoint",
+			env:  map[string]string{"S3_REGION": "eu-west-1"},
+			want: R2Config{Region: "eu-west-1"},
+		},
+		{
+			name: "path style",
+			env:  map[string]string{"S3_ENDPOINT": "http://localhost:9000", "S3_USE_PATH_STYLE": "true"},
+			want: R2Config{Endpoint: "http://localhost:9000", Region: "us-east-1", UsePathStyle: true},
+		},
+		{
+			name: "path style other than true is disabled",
+			env:  map[string]string{"S3_ENDPOINT": "http://localhost:9000", "S3_USE_PATH_STYLE": "yes"},
+			want: R2Config{Endpoint: "http://localhost:9000", Region: "us-east-1"},
+		},
+		{
+			name: "tls settings",
+			env: map[string]string{"S3_ENDPOINT": "https://localhost:9000", "S3_CA_BUNDLE": "/etc/ssl/minio.pem",
+				"S3_INSECURE_SKIP_VERIFY": "true", "S3_DEFAULT_BUCKET": "photos"},
+			want: R2Config{Endpoint: "https://localhost:9000", Region: "us-east-1", CABundle: "/etc/ssl/minio.pem", InsecureSkipVerify: true, DefaultBucket: "photos"},
+		},
+		{
+			name: "insecure skip verify other than true is disabled",
+			env:  map[string]string{"S3_ENDPOINT": "https://localhost:9000", "S3_INSECURE_SKIP_VERIFY": "1"},
+			want: R2Config{Endpoint: "https://localhost:9000", Region: "us-east-1"},
+		},
+		{name: "no target", env: map[string]string{"S3_ACCESS_KEY_ID": "key", "S3_SECRET_ACCESS_KEY": "secret"}, wantErr: true},
+		{name: "access key without secret", env: map[string]string{"S3_REGION": "us-east-1", "S3_ACCESS_KEY_ID": "key"}, wantErr: true},
+		{name: "secret without access key", env: map[string]string{"S3_REGION": "us-east-1", "R2_SECRET_ACCESS_KEY": "secret"}, wantErr: true},
+		{name: "endpoint without scheme", env: map[string]string{"S3_ENDPOINT": "localhost:9000"}, wantErr: true},
+		{name: "endpoint with unsupported scheme", env: map[string]string{"S3_ENDPOINT": "ftp://localhost:9000"}, wantErr: true},
+		{name: "endpoint without host", env: map[string]string{"S3_ENDPOINT": "http://"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setR2Env(t, tt.env)
+			got, err := LoadR2ConfigFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, *got)
+			}
+		})
+	}
+}
+
+func TestLoadCredentialsFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantKey    string
+		wantSecret string
+		wantErr    bool
+	}{
+		{name: "both set", env: map[string]string{"STAGING_ACCESS_KEY_ID": "key", "STAGING_SECRET_ACCESS_KEY": "secret"}, wantKey: "key", wantSecret: "secret"},
+		{name: "missing secret", env: map[string]string{"STAGING_ACCESS_KEY_ID": "key"}, wantErr: true},
+		{name: "missing access key", env: map[string]string{"STAGING_SECRET_ACCESS_KEY": "secret"}, wantErr: true},
+		{name: "other prefix", env: map[string]string{"PROD_ACCESS_KEY_ID": "key", "PROD_SECRET_ACCESS_KEY": "secret"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"STAGING_ACCESS_KEY_ID", "STAGING_SECRET_ACCESS_KEY", "PROD_ACCESS_KEY_ID", "PROD_SECRET_ACCESS_KEY"} {
+				t.Setenv(key, "")
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			key, secret, err := LoadCredentialsFromEnv("STAGING")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %q/%q", key, secret)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if key != tt.wantKey || secret != tt.wantSecret {
+				t.Errorf("expected %q/%q, got %q/%q", tt.wantKey, tt.wantSecret, key, secret)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(dir, "not-pem.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		cfg        R2Config
+		wantErr    bool
+		wantReject bool
+	}{
+		// 自己署名の証明書はシステムの CA だけでは検証できない
+		{name: "default", cfg: R2Config{}, wantReject: true},
+		{name: "ca bundle", cfg: R2Config{CABundle: bundle}},
+		{name: "insecure skip verify", cfg: R2Config{InsecureSkipVerify: true}},
+		{name: "missing ca bundle", cfg: R2Config{CABundle: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "ca bundle without certificates", cfg: R2Config{CABundle: notPEM}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newHTTPClient(&tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			resp, err := client.Do(req)
+			if tt.wantReject {
+				if err == nil {
+					resp.Body.Close()
+					t.Error("expected the certificate to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+const (
+	defaultTransferConcurrency    = 4
+	defaultTransferMaxConcurrency = 16
+	defaultTransferMaxRunningJobs = 2
+)
+
+type TransferConfig struct {
+	// Concurrency はジョブで同時に転送するオブジェクト数の既定値
+	Concurrency int
+	// MaxConcurrency はジョブごとに指定できる同時転送数の上限
+	MaxConcurrency int
+	// MaxRunningJobs は同時に実行する転送ジョブの数。後続のジョブは queued のまま待つ
+	MaxRunningJobs int
+}
+
+func LoadTransferConfigFromEnv() *TransferConfig {
+	maxConcurrency := defaultTransferMaxConcurrency
+	if v := os.Getenv("TRANSFER_MAX_CONCURRENCY"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			maxConcurrency = parsed
+		}
+	}
+
+	concurrency := defaultTransferConcurrency
+	if v := os.Getenv("TRANSFER_CONCURRENCY"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			concurrency = parsed
+		}
+	}
+
+	maxRunningJobs := defaultTransferMaxRunningJobs
+	if v := os.Getenv("TRANSFER_MAX_RUNNING_JOBS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			maxRunningJobs = parsed
+		}
+	}
+
+	return &TransferConfig{
+		Concurrency:    min(concurrency, maxConcurrency),
+		MaxConcurrency: maxConcurrency,
+		MaxRunningJobs: maxRunningJobs,
+	}
+}
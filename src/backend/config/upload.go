@@ -3,12 +3,33 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
-const defaultMaxUploadSizeMB = 100
+const (
+	defaultMaxUploadSizeMB      = 100
+	defaultMultipartThresholdMB = 32
+	defaultMultipartPartSizeMB  = 16
+	defaultMultipartConcurrency = 4
+	minMultipartPartSizeMB      = 5 // S3 の最小パートサイズ（最終パートを除く）
+	defaultResumableMaxSizeMB   = 50 * 1024
+	defaultResumableTTLHours    = 24
+	defaultResumableSweepMin    = 30
+)
 
 type UploadConfig struct {
 	MaxUploadSize int64 // bytes
+	// ResumableMaxSize は再開可能アップロードで受け付ける最大サイズ。大きなファイル向けなので MaxUploadSize とは別に指定する
+	ResumableMaxSize int64 // bytes
+	// MultipartThreshold を超えるファイルはマルチパートアップロードでストリーミング送信する
+	MultipartThreshold   int64 // bytes
+	MultipartPartSize    int64 // bytes
+	MultipartConcurrency int
+	// ResumableDir は再開可能アップロードのステージングファイルを置くディレクトリ
+	ResumableDir string
+	// ResumableTTL の間更新されていない再開可能アップロードは、ResumableSweepInterval ごとの掃除で破棄する
+	ResumableTTL           time.Duration
+	ResumableSweepInterval time.Duration
 }
 
 func LoadUploadConfigFromEnv() *UploadConfig {
@@ -19,7 +40,61 @@ func LoadUploadConfigFromEnv() *UploadConfig {
 		}
 	}
 
+	thresholdMB := defaultMultipartThresholdMB
+	if v := os.Getenv("UPLOAD_MULTIPART_THRESHOLD_MB"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			thresholdMB = parsed
+		}
+	}
+
+	partSizeMB := defaultMultipartPartSizeMB
+	if v := os.Getenv("UPLOAD_PART_SIZE_MB"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= minMultipartPartSizeMB {
+			partSizeMB = parsed
+		}
+	}
+
+	concurrency := defaultMultipartConcurrency
+	if v := os.Getenv("UPLOAD_CONCURRENCY"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			concurrency = parsed
+		}
+	}
+
+	resumableMaxSizeMB := defaultResumableMaxSizeMB
+	if v := os.Getenv("UPLOAD_RESUMABLE_MAX_SIZE_MB"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			resumableMaxSizeMB = parsed
+		}
+	}
+
+	resumableDir := os.Getenv("UPLOAD_RESUMABLE_DIR")
+	if resumableDir == "" {
+		resumableDir = "./data/uploads"
+	}
+
+	ttlHours := defaultResumableTTLHours
+	if v := os.Getenv("UPLOAD_RESUMABLE_TTL_HOURS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			ttlHours = parsed
+		}
+	}
+
+	sweepMinutes := defaultResumableSweepMin
+	if v := os.Getenv("UPLOAD_RESUMABLE_SWEEP_INTERVAL_MINUTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			sweepMinutes = parsed
+		}
+	}
+
 	return &UploadConfig{
-		MaxUploadSize: int64(maxSizeMB) * 1024 * 1024,
+		MaxUploadSize:          int64(maxSizeMB) * 1024 * 1024,
+		MultipartThreshold:     int64(thresholdMB) * 1024 * 1024,
+		MultipartPartSize:      int64(partSizeMB) * 1024 * 1024,
+		MultipartConcurrency:   concurrency,
+		ResumableMaxSize:       int64(resumableMaxSizeMB) * 1024 * 1024,
+		ResumableDir:           resumableDir,
+		ResumableTTL:           time.Duration(ttlHours) * time.Hour,
+		ResumableSweepInterval: time.Duration(sweepMinutes) * time.Minute,
 	}
 }
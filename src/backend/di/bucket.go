@@ -1,6 +1,9 @@
 package di
 
 import (
+	"database/sql"
+
+	appconfig "r2manager/config"
 	"r2manager/handler"
 	"r2manager/repository"
 	service "r2manager/service/model"
@@ -8,9 +11,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
-func CreateBucketsHandler(s3Client *s3.Client, listCache *repository.ListCacheRepository) *handler.BucketsHandler {
+func CreateBucketsHandler(s3Client *s3.Client, db *sql.DB, cacheCfg *appconfig.CacheConfig, listCache *repository.ListCacheRepository) *handler.BucketsHandler {
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
+
 	bucketRepo := repository.NewBucketRepository(s3Client)
-	bucketService := service.NewBucketService(bucketRepo, listCache)
+	objectRepo := repository.NewObjectRepository(s3Client)
+	deleteRepo := repository.NewDeleteRepository(s3Client)
+	settingsRepo := repository.NewSettingsRepository(db)
+	bucketService := service.NewBucketService(bucketRepo, objectRepo, deleteRepo, settingsRepo, cacheRepo, listCache)
 	bucketsHandler := handler.NewBucketsHandler(bucketService)
 
 	return bucketsHandler
@@ -2,6 +2,7 @@ package di
 
 import (
 	"database/sql"
+	"log"
 
 	appconfig "r2manager/config"
 	"r2manager/handler"
@@ -9,6 +10,41 @@ import (
 )
 
 func CreateCacheHandler(db *sql.DB, cacheCfg *appconfig.CacheConfig, listCache *repository.ListCacheRepository) *handler.CacheHandler {
-	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL)
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
 	return handler.NewCacheHandler(cacheRepo, listCache)
 }
+
+// CacheOptions は設定から CacheRepository のオプションを組み立てる。
+func CacheOptions(cacheCfg *appconfig.CacheConfig) []repository.CacheOption {
+	var opts []repository.CacheOption
+	if cacheCfg.MaxCacheSize > 0 {
+		opts = append(opts, repository.WithMaxCacheSize(cacheCfg.MaxCacheSize))
+	}
+
+	policy, err := repository.NewEvictionPolicy(cacheCfg.EvictionPolicy)
+	if err != nil {
+		log.Printf("warning: %v, falling back to %s", err, repository.EvictionFIFO)
+	} else {
+		opts = append(opts, repository.WithEvictionPolicy(policy))
+	}
+	if len(cacheCfg.BucketWeights) > 0 {
+		opts = append(opts, repository.WithBucketWeights(cacheCfg.BucketWeights))
+	}
+	// 期限切れのキャッシュは再検証と、R2 に接続できない場合の代替に使うため一定期間残す
+	if cacheCfg.StaleRetention > 0 {
+		opts = append(opts, repository.WithStaleRetention(cacheCfg.StaleRetention))
+	}
+
+	if cacheCfg.MemorySize > 0 {
+		opts = append(opts, repository.WithMemoryTier(cacheCfg.MemorySize, cacheCfg.MemoryMaxObjectSize))
+	}
+
+	encoding, err := repository.ParseEncoding(cacheCfg.Compression)
+	if err != nil {
+		log.Printf("warning: %v, cache compression disabled", err)
+	} else if encoding != "" {
+		opts = append(opts, repository.WithCompression(encoding, cacheCfg.CompressionTypes, cacheCfg.CompressionMinSize))
+	}
+
+	return opts
+}
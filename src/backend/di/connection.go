@@ -0,0 +1,387 @@
+package di
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+
+	appconfig "r2manager/config"
+	"r2manager/domain"
+	"r2manager/handler"
+	"r2manager/health"
+	"r2manager/infrastructure"
+	"r2manager/progress"
+	"r2manager/repository"
+	serviceif "r2manager/service/interface"
+	service "r2manager/service/model"
+)
+
+// ConnectionRegistry は追加した接続ごとの S3 クライアント・データベース・キャッシュを、最初に使われたときに作成して保持する。
+// 接続ごとに専用の SQLite データベースとキャッシュディレクトリを使うため、同じ名前のバケットがあってもキャッシュや設定は混ざらない。
+type ConnectionRegistry struct {
+	ctx  context.Context
+	repo *repository.ConnectionRepository
+
+	connCfg       *appconfig.ConnectionConfig
+	cacheCfg      *appconfig.CacheConfig
+	uploadCfg     *appconfig.UploadConfig
+	presignCfg    *appconfig.PresignConfig
+	healthCfg     *appconfig.HealthConfig
+	progressStore *progress.UploadProgressStore
+
+	mu       sync.Mutex
+	runtimes map[string]*connectionRuntime
+	// draining は削除した接続のうち、処理中のリクエストが終わるのを待っているもの。閉じ終えると done が閉じられる
+	draining map[string]chan struct{}
+}
+
+type connectionRuntime struct {
+	// stale が true の場合、次のリクエストで接続設定を読み込み直してハンドラを作り直す
+	stale bool
+	// clear が true の場合、ハンドラを作り直す前に保存済みのキャッシュを破棄する
+	clear bool
+
+	r2cfg     *appconfig.R2Config
+	s3Client  *s3.Client
+	db        *sql.DB
+	cacheCfg  *appconfig.CacheConfig
+	listCache *repository.ListCacheRepository
+	// state はハンドラを作り直しても引き継ぐ
+	state *ConnectionState
+	// ctx は接続を閉じるときに cancel で終了する。バックグラウンドの処理はこの下で動かす
+	ctx      context.Context
+	cancel   context.CancelFunc
+	handlers *handler.Handlers
+	// stopSweep は現在の S3 クライアントで動いている再開可能アップロードの掃除を止める
+	stopSweep context.CancelFunc
+
+	// inflight はハンドラや転送のリポジトリを使用中の数。削除後はこれが 0 になってからデータベースを閉じる
+	inflight sync.WaitGroup
+}
+
+// acquire は使用中の数を増やし、使い終わったら呼ぶ関数を返す。r.mu を保持した状態で呼ぶ。
+func (rt *connectionRuntime) acquire() func() {
+	rt.inflight.Add(1)
+	var once sync.Once
+	return func() { once.Do(rt.inflight.Done) }
+}
+
+// NewConnectionRegistry は ctx が終了するまで、接続ごとのキャッシュの掃除を続ける。
+func NewConnectionRegistry(ctx context.Context, db *sql.DB, connCfg *appconfig.ConnectionConfig, cacheCfg *appconfig.CacheConfig, uploadCfg *appconfig.UploadConfig, presignCfg *appconfig.PresignConfig, healthCfg *appconfig.HealthConfig, progressStore *progress.UploadProgressStore) *ConnectionRegistry {
+	return &ConnectionRegistry{
+		ctx:           ctx,
+		repo:          repository.NewConnectionRepository(db),
+		connCfg:       connCfg,
+		cacheCfg:      cacheCfg,
+		uploadCfg:     uploadCfg,
+		presignCfg:    presignCfg,
+		healthCfg:     healthCfg,
+		progressStore: progressStore,
+		runtimes:      make(map[string]*connectionRuntime),
+		draining:      make(map[string]chan struct{}),
+	}
+}
+
+// CreateConnectionsHandler は接続設定の API のハンドラを作成する。
+func CreateConnectionsHandler(registry *ConnectionRegistry, r2cfg *appconfig.R2Config) *handler.ConnectionsHandler {
+	connectionService := service.NewConnectionService(registry.repo, registry, DefaultConnection(r2cfg))
+	return handler.NewConnectionsHandler(connectionService, registry)
+}
+
+// DefaultConnection は環境変数の設定を既定の接続として表す。認証情報は含めない。
+func DefaultConnection(r2cfg *appconfig.R2Config) domain.Connection {
+	return domain.Connection{
+		Name:               domain.DefaultConnectionName,
+		AccountID:          r2cfg.AccountID,
+		Endpoint:           r2cfg.Endpoint,
+		Region:             r2cfg.Region,
+		UsePathStyle:       r2cfg.UsePathStyle,
+		CABundle:           r2cfg.CABundle,
+		InsecureSkipVerify: r2cfg.InsecureSkipVerify,
+		DefaultBucket:      r2cfg.DefaultBucket,
+		Builtin:            true,
+	}
+}
+
+// RegisterDefault は環境変数で設定した既定の接続を登録し、そのハンドラ一式を返す。
+func (r *ConnectionRegistry) RegisterDefault(r2cfg *appconfig.R2Config, s3Client *s3.Client, monitor *health.Monitor, db *sql.DB, listCache *repository.ListCacheRepository) *handler.Handlers {
+	state := NewConnectionState()
+	rt := &connectionRuntime{
+		r2cfg:     r2cfg,
+		s3Client:  s3Client,
+		db:        db,
+		cacheCfg:  r.cacheCfg,
+		listCache: listCache,
+		state:     state,
+		ctx:       r.ctx,
+		handlers:  CreateHandlers(s3Client, db, monitor, listCache, state, r.cacheCfg, r.uploadCfg, r.presignCfg, r.progressStore),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startUploadSweep(rt)
+	r.runtimes[domain.DefaultConnectionName] = rt
+	return rt.handlers
+}
+
+// Handlers は接続名に対応するハンドラ一式を返す。release はリクエストの処理を終えたら呼ぶ。
+func (r *ConnectionRegistry) Handlers(ctx context.Context, name string) (*handler.Handlers, func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rt, err := r.runtimeLocked(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rt.handlers, rt.acquire(), nil
+}
+
+// Endpoint は転送ジョブで使う、接続のリポジトリを返す。
+func (r *ConnectionRegistry) Endpoint(ctx context.Context, name string) (*serviceif.TransferEndpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rt, err := r.runtimeLocked(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadService := service.NewUploadService(repository.NewUploadRepository(rt.s3Client), rt.listCache,
+		service.WithMultipartUpload(r.uploadCfg.MultipartThreshold, r.uploadCfg.MultipartPartSize, r.uploadCfg.MultipartConcurrency),
+	)
+	return &serviceif.TransferEndpoint{
+		Objects:   repository.NewObjectRepository(rt.s3Client),
+		Content:   repository.NewContentRepository(rt.s3Client),
+		Copies:    repository.NewCopyRepository(rt.s3Client),
+		Uploads:   uploadService,
+		Cache:     repository.NewCacheRepository(rt.db, rt.cacheCfg.CacheDir, rt.cacheCfg.TTL, CacheOptions(rt.cacheCfg)...),
+		ListCache: rt.listCache,
+		Release:   rt.acquire(),
+	}, nil
+}
+
+// SharesCredentials は 2 つの接続のエンドポイントとアクセスキーが同じかを返す。
+// 同じ場合は転送先の認証情報で転送元を読み取れるため、CopyObject でサーバー側でコピーできる。
+func (r *ConnectionRegistry) SharesCredentials(ctx context.Context, a, b string) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ra, err := r.runtimeLocked(ctx, a)
+	if err != nil {
+		return false, err
+	}
+	rb, err := r.runtimeLocked(ctx, b)
+	if err != nil {
+		return false, err
+	}
+	return ra.r2cfg.Endpoint == rb.r2cfg.Endpoint && ra.r2cfg.AccessKeyID == rb.r2cfg.AccessKeyID, nil
+}
+
+// runtimeLocked は接続の S3 クライアントとハンドラを返す。設定が変更されていれば作り直す。r.mu を保持した状態で呼ぶ。
+func (r *ConnectionRegistry) runtimeLocked(ctx context.Context, name string) (*connectionRuntime, error) {
+	rt := r.runtimes[name]
+	if rt != nil && !rt.stale {
+		return rt, nil
+	}
+	// 同じ名前で作り直した接続が、削除した接続のディレクトリを使わないよう閉じ終えるまで待たせる
+	if _, ok := r.draining[name]; ok {
+		return nil, errors.Wrap(serviceif.ErrConnectionBusy, name)
+	}
+
+	conn, err := r.repo.GetConnection(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, errors.Wrap(serviceif.ErrConnectionNotFound, name)
+	}
+
+	if rt == nil {
+		rt, err = r.open(name)
+		if err != nil {
+			return nil, err
+		}
+		r.runtimes[name] = rt
+	} else if rt.clear {
+		r.clearCaches(ctx, name, rt)
+		rt.clear = false
+	}
+
+	// 失敗した場合は stale のまま残し、次のリクエストで再試行する
+	rt.stale = true
+	r2cfg, err := r2ConfigFor(conn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connection %s", name)
+	}
+	monitor := health.NewMonitor(r.healthCfg.FailureThreshold, r.healthCfg.InitialBackoff, r.healthCfg.MaxBackoff)
+	s3Client, err := appconfig.NewS3Client(r.ctx, r2cfg, monitor)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connection %s", name)
+	}
+
+	rt.r2cfg = r2cfg
+	rt.s3Client = s3Client
+	rt.handlers = CreateHandlers(s3Client, rt.db, monitor, rt.listCache, rt.state, rt.cacheCfg, r.uploadCfg, r.presignCfg, r.progressStore)
+	r.startUploadSweep(rt)
+	rt.stale = false
+	return rt, nil
+}
+
+// startUploadSweep は接続の現在の S3 クライアントで、期限切れの再開可能アップロードの掃除を開始する。
+// ハンドラを作り直した場合は、前の S3 クライアントでの掃除を止めてから開始する。
+func (r *ConnectionRegistry) startUploadSweep(rt *connectionRuntime) {
+	if rt.stopSweep != nil {
+		rt.stopSweep()
+	}
+	ctx, cancel := context.WithCancel(rt.ctx)
+	rt.stopSweep = cancel
+	StartResumableUploadSweep(ctx, rt.s3Client, rt.db, rt.listCache, rt.state.UploadLocks, r.uploadCfg)
+}
+
+// Reload は次のリクエストで接続設定を読み込み直させる。
+// targetChanged が true で、まだ開いていない接続の場合は前回の起動時のキャッシュをディレクトリごと削除する。
+func (r *ConnectionRegistry) Reload(name string, targetChanged bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rt, ok := r.runtimes[name]; ok {
+		rt.stale = true
+		rt.clear = rt.clear || targetChanged
+		return
+	}
+	if _, ok := r.draining[name]; ok {
+		return
+	}
+	if targetChanged {
+		if err := os.RemoveAll(r.connectionDir(name)); err != nil {
+			log.Printf("warning: failed to remove cache of connection %s: %v", name, err)
+		}
+	}
+}
+
+// Remove は接続のデータベースとキャッシュディレクトリを削除する。
+// 開いている接続は新しいリクエストを受け付けなくし、処理中のリクエストと転送が終わってからバックグラウンドで閉じる。
+func (r *ConnectionRegistry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.draining[name]; ok {
+		return nil
+	}
+	rt, ok := r.runtimes[name]
+	if !ok {
+		if err := os.RemoveAll(r.connectionDir(name)); err != nil {
+			return errors.Wrap(err, "failed to remove connection directory")
+		}
+		return nil
+	}
+
+	delete(r.runtimes, name)
+	done := make(chan struct{})
+	r.draining[name] = done
+	// プリフェッチと掃除はリクエストの外で続くため、待たずに中断させる
+	rt.state.PrefetchJobs.CancelAll()
+	if rt.stopSweep != nil {
+		rt.stopSweep()
+	}
+	go r.drain(name, rt, done)
+	return nil
+}
+
+// drain は削除した接続の使用中の処理が終わるのを待ってから、データベースを閉じてディレクトリを削除する。
+func (r *ConnectionRegistry) drain(name string, rt *connectionRuntime, done chan struct{}) {
+	rt.inflight.Wait()
+	// 待っている間に登録されたジョブも中断する
+	rt.state.PrefetchJobs.CancelAll()
+	rt.state.PrefetchJobs.Wait()
+
+	rt.cancel()
+	repository.ReleaseCacheRepository(rt.cacheCfg.CacheDir)
+	if err := rt.db.Close(); err != nil {
+		log.Printf("warning: failed to close database of connection %s: %v", name, err)
+	}
+	if err := os.RemoveAll(r.connectionDir(name)); err != nil {
+		log.Printf("warning: failed to remove directory of connection %s: %v", name, err)
+	}
+
+	r.mu.Lock()
+	delete(r.draining, name)
+	r.mu.Unlock()
+	close(done)
+}
+
+func (r *ConnectionRegistry) connectionDir(name string) string {
+	return filepath.Join(r.connCfg.DataDir, name)
+}
+
+// open は接続専用のデータベースとキャッシュディレクトリを開き、キャッシュの掃除を開始する。
+func (r *ConnectionRegistry) open(name string) (*connectionRuntime, error) {
+	dir := r.connectionDir(name)
+	cacheCfg := *r.cacheCfg
+	cacheCfg.DBPath = filepath.Join(dir, "cache.db")
+	cacheCfg.CacheDir = filepath.Join(dir, "cache")
+
+	if err := os.MkdirAll(cacheCfg.CacheDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create connection cache directory")
+	}
+	db, err := infrastructure.NewSQLiteDB(cacheCfg.DBPath)
+	if err != nil {
+		return nil, err
+	}
+
+	listCache := repository.NewListCacheRepository(repository.WithListCacheDB(db))
+	ctx, cancel := context.WithCancel(r.ctx)
+	StartCacheMaintenance(ctx, name, db, &cacheCfg, listCache)
+
+	return &connectionRuntime{
+		db:        db,
+		cacheCfg:  &cacheCfg,
+		listCache: listCache,
+		state:     NewConnectionState(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+func (r *ConnectionRegistry) clearCaches(ctx context.Context, name string, rt *connectionRuntime) {
+	cacheRepo := repository.NewCacheRepository(rt.db, rt.cacheCfg.CacheDir, rt.cacheCfg.TTL, CacheOptions(rt.cacheCfg)...)
+	if _, err := cacheRepo.ClearAll(ctx); err != nil {
+		log.Printf("warning: failed to clear content cache of connection %s: %v", name, err)
+	}
+	rt.listCache.InvalidateAll()
+}
+
+// r2ConfigFor は接続設定から S3 クライアントの設定を作成する。認証情報は CredentialsRef の環境変数から読み込む。
+func r2ConfigFor(conn *domain.Connection) (*appconfig.R2Config, error) {
+	cfg := &appconfig.R2Config{
+		AccountID:          conn.AccountID,
+		Endpoint:           conn.Endpoint,
+		Region:             conn.Region,
+		UsePathStyle:       conn.UsePathStyle,
+		CABundle:           conn.CABundle,
+		InsecureSkipVerify: conn.InsecureSkipVerify,
+		DefaultBucket:      conn.DefaultBucket,
+	}
+	if conn.CredentialsRef != "" {
+		accessKeyID, secretAccessKey, err := appconfig.LoadCredentialsFromEnv(conn.CredentialsRef)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AccessKeyID = accessKeyID
+		cfg.SecretAccessKey = secretAccessKey
+	}
+	if err := cfg.Normalize(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
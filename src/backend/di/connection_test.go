@@ -0,0 +1,223 @@
+package di
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	appconfig "r2manager/config"
+	"r2manager/domain"
+	"r2manager/infrastructure"
+	"r2manager/progress"
+	serviceif "r2manager/service/interface"
+)
+
+func newTestRegistry(t *testing.T) *ConnectionRegistry {
+	t.Helper()
+	// AWS SDK が読み込む CA 証明書の設定は、ヘルスモニターで包んだ HTTP クライアントには適用できない
+	t.Setenv("AWS_CA_BUNDLE", "")
+	dir := t.TempDir()
+	db, err := infrastructure.NewSQLiteDB(filepath.Join(dir, "main.db"))
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cacheCfg := appconfig.LoadCacheConfigFromEnv()
+	cacheCfg.ReconcileOnStartup = false
+	uploadCfg := appconfig.LoadUploadConfigFromEnv()
+	uploadCfg.ResumableDir = filepath.Join(dir, "resumable")
+	r := NewConnectionRegistry(ctx, db, &appconfig.ConnectionConfig{DataDir: filepath.Join(dir, "connections")},
+		cacheCfg, uploadCfg, appconfig.LoadPresignConfigFromEnv(), appconfig.LoadHealthConfigFromEnv(), progress.NewUploadProgressStore())
+
+	t.Cleanup(func() {
+		r.mu.Lock()
+		names := make([]string, 0, len(r.runtimes))
+		for name := range r.runtimes {
+			names = append(names, name)
+		}
+		r.mu.Unlock()
+		for _, name := range names {
+			r.Remove(name)
+			waitDrained(t, r, name)
+		}
+		cancel()
+		db.Close()
+	})
+	return r
+}
+
+func addTestConnection(t *testing.T, r *ConnectionRegistry, name string) {
+	t.Helper()
+	conn := &domain.Connection{Name: name, Endpoint: "http://localhost:9000", Region: "us-east-1", UsePathStyle: true}
+	if _, err := r.repo.CreateConnection(context.Background(), conn); err != nil {
+		t.Fatalf("CreateConnection: %v", err)
+	}
+}
+
+// waitDrained は削除した接続を閉じ終えるまで待つ。
+func waitDrained(t *testing.T, r *ConnectionRegistry, name string) {
+	t.Helper()
+	r.mu.Lock()
+	done, ok := r.draining[name]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection %s was not closed", name)
+	}
+}
+
+func TestConnectionRegistry_OpensOnFirstUse(t *testing.T) {
+	r := newTestRegistry(t)
+	addTestConnection(t, r, "staging")
+	ctx := context.Background()
+
+	handlers, release, err := r.Handlers(ctx, "staging")
+	if err != nil {
+		t.Fatalf("Handlers: %v", err)
+	}
+	release()
+	if handlers == nil || handlers.Objects == nil {
+		t.Fatal("expected handlers to be created")
+	}
+	if _, err := os.Stat(filepath.Join(r.connectionDir("staging"), "cache.db")); err != nil {
+		t.Errorf("expected the connection database to be created: %v", err)
+	}
+
+	again, release, err := r.Handlers(ctx, "staging")
+	if err != nil {
+		t.Fatalf("Handlers (again): %v", err)
+	}
+	release()
+	if again != handlers {
+		t.Error("expected the same handlers until the connection is reloaded")
+	}
+
+	if _, _, err := r.Handlers(ctx, "missing"); !errors.Is(err, serviceif.ErrConnectionNotFound) {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+}
+
+func TestConnectionRegistry_ReloadKeepsState(t *testing.T) {
+	r := newTestRegistry(t)
+	addTestConnection(t, r, "staging")
+	ctx := context.Background()
+
+	before, release, err := r.Handlers(ctx, "staging")
+	if err != nil {
+		t.Fatalf("Handlers: %v", err)
+	}
+	release()
+	r.mu.Lock()
+	state := r.runtimes["staging"].state
+	r.mu.Unlock()
+
+	r.Reload("staging", false)
+	after, release, err := r.Handlers(ctx, "staging")
+	if err != nil {
+		t.Fatalf("Handlers after reload: %v", err)
+	}
+	release()
+	if after == before {
+		t.Error("expected handlers to be rebuilt after reload")
+	}
+
+	// 作り直したハンドラもアップロードのロックとプリフェッチのジョブを引き継ぐ
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runtimes["staging"].state != state {
+		t.Error("expected connection state to be kept across reloads")
+	}
+}
+
+func TestConnectionRegistry_ReloadRemovesCacheOfClosedConnection(t *testing.T) {
+	r := newTestRegistry(t)
+	dir := r.connectionDir("staging")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Reload("staging", false)
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected cache to be kept when the target is unchanged: %v", err)
+	}
+
+	r.Reload("staging", true)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected cache to be removed when the target changed, got %v", err)
+	}
+}
+
+func TestConnectionRegistry_RemoveWaitsForInFlightRequests(t *testing.T) {
+	r := newTestRegistry(t)
+	addTestConnection(t, r, "staging")
+	ctx := context.Background()
+
+	_, release, err := r.Handlers(ctx, "staging")
+	if err != nil {
+		t.Fatalf("Handlers: %v", err)
+	}
+	endpoint, err := r.Endpoint(ctx, "staging")
+	if err != nil {
+		t.Fatalf("Endpoint: %v", err)
+	}
+
+	if _, err := r.repo.DeleteConnection(ctx, "staging"); err != nil {
+		t.Fatalf("DeleteConnection: %v", err)
+	}
+	if err := r.Remove("staging"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	dir := r.connectionDir("staging")
+
+	// 処理中のリクエストと転送が終わるまではデータベースを閉じない
+	release()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("expected the connection directory to be kept while in use: %v", err)
+	}
+	if _, err := endpoint.Cache.LookupStale(ctx, "bucket", "key"); err != nil {
+		t.Errorf("expected the database to stay open while in use: %v", err)
+	}
+
+	// 閉じ終えるまでは同じ名前で作り直した接続を開かない
+	addTestConnection(t, r, "staging")
+	if _, _, err := r.Handlers(ctx, "staging"); !errors.Is(err, serviceif.ErrConnectionBusy) {
+		t.Errorf("expected ErrConnectionBusy while draining, got %v", err)
+	}
+
+	endpoint.Release()
+	waitDrained(t, r, "staging")
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the connection directory to be removed, got %v", err)
+	}
+
+	// 閉じ終えた後は作り直した接続を新しく開く
+	_, release, err = r.Handlers(ctx, "staging")
+	if err != nil {
+		t.Fatalf("Handlers after drain: %v", err)
+	}
+	release()
+}
+
+func TestConnectionRegistry_RemoveClosedConnection(t *testing.T) {
+	r := newTestRegistry(t)
+	dir := r.connectionDir("staging")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Remove("staging"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the connection directory to be removed, got %v", err)
+	}
+}
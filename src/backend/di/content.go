@@ -11,14 +11,16 @@ import (
 )
 
 func CreateContentHandler(s3Client *s3.Client, db *sql.DB, cacheCfg *appconfig.CacheConfig) *handler.ContentHandler {
-	var opts []repository.CacheOption
-	if cacheCfg.MaxCacheSize > 0 {
-		opts = append(opts, repository.WithMaxCacheSize(cacheCfg.MaxCacheSize))
-	}
-	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, opts...)
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
 
 	contentRepo := repository.NewContentRepository(s3Client)
-	contentService := service.NewContentService(contentRepo, cacheRepo)
+	contentOpts := []service.ContentOption{
+		service.WithBucketCachePolicies(cacheRepo),
+	}
+	if cacheCfg.Revalidate {
+		contentOpts = append(contentOpts, service.WithRevalidation(cacheCfg.RevalidateEveryNHits, cacheCfg.StaleWhileRevalidate))
+	}
+	contentService := service.NewContentService(contentRepo, cacheRepo, contentOpts...)
 	contentHandler := handler.NewContentHandler(contentService)
 
 	return contentHandler
@@ -0,0 +1,25 @@
+package di
+
+import (
+	"database/sql"
+
+	appconfig "r2manager/config"
+	"r2manager/handler"
+	"r2manager/progress"
+	"r2manager/repository"
+	service "r2manager/service/model"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func CreateCopyHandler(s3Client *s3.Client, db *sql.DB, cacheCfg *appconfig.CacheConfig, listCache *repository.ListCacheRepository, progressStore *progress.UploadProgressStore) *handler.CopyHandler {
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
+
+	copyRepo := repository.NewCopyRepository(s3Client)
+	objectRepo := repository.NewObjectRepository(s3Client)
+	deleteRepo := repository.NewDeleteRepository(s3Client)
+	copyService := service.NewCopyService(copyRepo, objectRepo, deleteRepo, cacheRepo, listCache)
+	copyHandler := handler.NewCopyHandler(copyService, progressStore)
+
+	return copyHandler
+}
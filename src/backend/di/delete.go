@@ -0,0 +1,23 @@
+package di
+
+import (
+	"database/sql"
+
+	appconfig "r2manager/config"
+	"r2manager/handler"
+	"r2manager/repository"
+	service "r2manager/service/model"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func CreateDeleteHandler(s3Client *s3.Client, db *sql.DB, cacheCfg *appconfig.CacheConfig, listCache *repository.ListCacheRepository) *handler.DeleteHandler {
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
+
+	deleteRepo := repository.NewDeleteRepository(s3Client)
+	objectRepo := repository.NewObjectRepository(s3Client)
+	deleteService := service.NewDeleteService(deleteRepo, objectRepo, cacheRepo, listCache)
+	deleteHandler := handler.NewDeleteHandler(deleteService)
+
+	return deleteHandler
+}
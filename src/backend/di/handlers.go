@@ -0,0 +1,71 @@
+package di
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	appconfig "r2manager/config"
+	"r2manager/handler"
+	"r2manager/health"
+	"r2manager/progress"
+	"r2manager/repository"
+	service "r2manager/service/model"
+)
+
+// ConnectionState は接続設定を読み込み直してハンドラを作り直しても引き継ぐ、接続ごとの状態。
+// 作り直す前に始まったアップロードやプリフェッチのジョブを、新しいハンドラからも参照・操作できるようにする。
+type ConnectionState struct {
+	UploadLocks  *service.UploadLocks
+	PrefetchJobs *service.PrefetchJobs
+}
+
+func NewConnectionState() *ConnectionState {
+	return &ConnectionState{
+		UploadLocks:  service.NewUploadLocks(),
+		PrefetchJobs: service.NewPrefetchJobs(),
+	}
+}
+
+// CreateHandlers は 1 つの接続に対する API のハンドラ一式を作成する。
+func CreateHandlers(s3Client *s3.Client, db *sql.DB, monitor *health.Monitor, listCache *repository.ListCacheRepository, state *ConnectionState, cacheCfg *appconfig.CacheConfig, uploadCfg *appconfig.UploadConfig, presignCfg *appconfig.PresignConfig, progressStore *progress.UploadProgressStore) *handler.Handlers {
+	return &handler.Handlers{
+		Buckets:         CreateBucketsHandler(s3Client, db, cacheCfg, listCache),
+		Objects:         CreateObjectsHandler(s3Client, db, cacheCfg, listCache),
+		Content:         CreateContentHandler(s3Client, db, cacheCfg),
+		Cache:           CreateCacheHandler(db, cacheCfg, listCache),
+		Settings:        CreateSettingsHandler(db, cacheCfg),
+		Upload:          CreateUploadHandler(s3Client, listCache, uploadCfg, progressStore),
+		UploadProgress:  CreateUploadProgressHandler(progressStore),
+		Delete:          CreateDeleteHandler(s3Client, db, cacheCfg, listCache),
+		Copy:            CreateCopyHandler(s3Client, db, cacheCfg, listCache, progressStore),
+		Metadata:        CreateMetadataHandler(s3Client, db, cacheCfg, listCache),
+		ResumableUpload: CreateResumableUploadHandler(s3Client, db, listCache, state.UploadLocks, uploadCfg, progressStore),
+		Presign:         CreatePresignHandler(s3Client, db, presignCfg),
+		Health:          CreateHealthHandler(monitor),
+		Prefetch:        CreatePrefetchHandler(s3Client, db, state.PrefetchJobs, cacheCfg, progressStore),
+	}
+}
+
+// StartCacheMaintenance はコンテンツキャッシュと一覧キャッシュの定期的な掃除を開始する。
+// 設定により、前回の実行で残ったキャッシュファイルと cache_entries の不整合もバックグラウンドで解消する。
+func StartCacheMaintenance(ctx context.Context, name string, db *sql.DB, cacheCfg *appconfig.CacheConfig, listCache *repository.ListCacheRepository) {
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
+	cacheRepo.StartCleanupLoop(ctx, cacheCfg.CleanupInterval)
+
+	if cacheCfg.ReconcileOnStartup {
+		go func() {
+			report, err := cacheRepo.Reconcile(ctx, repository.ReconcileOptions{Verify: cacheCfg.ReconcileVerify, GracePeriod: repository.DefaultReconcileGracePeriod})
+			if err != nil {
+				log.Printf("cache reconcile error: connection=%s: %v", name, err)
+				return
+			}
+			log.Printf("cache reconcile: connection=%s removed %d orphan files, %d temp files, %d dangling entries, %d corrupt entries (%d bytes freed, %d errors)",
+				name, report.OrphanFiles, report.TempFiles, report.DanglingEntries, report.CorruptEntries, report.FreedBytes, len(report.Errors))
+		}()
+	}
+
+	listCache.StartCleanupLoop(ctx, repository.CleanupInterval)
+}
@@ -0,0 +1,10 @@
+package di
+
+import (
+	"r2manager/handler"
+	"r2manager/health"
+)
+
+func CreateHealthHandler(monitor *health.Monitor) *handler.HealthHandler {
+	return handler.NewHealthHandler(monitor)
+}
@@ -0,0 +1,23 @@
+package di
+
+import (
+	"database/sql"
+
+	appconfig "r2manager/config"
+	"r2manager/handler"
+	"r2manager/repository"
+	service "r2manager/service/model"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func CreateMetadataHandler(s3Client *s3.Client, db *sql.DB, cacheCfg *appconfig.CacheConfig, listCache *repository.ListCacheRepository) *handler.MetadataHandler {
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
+
+	objectRepo := repository.NewObjectRepository(s3Client)
+	copyRepo := repository.NewCopyRepository(s3Client)
+	metadataService := service.NewMetadataService(objectRepo, copyRepo, cacheRepo, listCache)
+	metadataHandler := handler.NewMetadataHandler(metadataService)
+
+	return metadataHandler
+}
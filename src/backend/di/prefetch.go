@@ -0,0 +1,22 @@
+package di
+
+import (
+	"database/sql"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	appconfig "r2manager/config"
+	"r2manager/handler"
+	"r2manager/progress"
+	"r2manager/repository"
+	service "r2manager/service/model"
+)
+
+func CreatePrefetchHandler(s3Client *s3.Client, db *sql.DB, jobs *service.PrefetchJobs, cacheCfg *appconfig.CacheConfig, progressStore *progress.UploadProgressStore) *handler.PrefetchHandler {
+	objectRepo := repository.NewObjectRepository(s3Client)
+	contentRepo := repository.NewContentRepository(s3Client)
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
+
+	prefetchService := service.NewPrefetchService(objectRepo, contentRepo, cacheRepo, cacheRepo, cacheCfg.PrefetchConcurrency, service.WithPrefetchJobs(jobs))
+	return handler.NewPrefetchHandler(prefetchService, progressStore)
+}
@@ -0,0 +1,20 @@
+package di
+
+import (
+	"database/sql"
+
+	appconfig "r2manager/config"
+	"r2manager/handler"
+	"r2manager/repository"
+	service "r2manager/service/model"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func CreatePresignHandler(s3Client *s3.Client, db *sql.DB, presignCfg *appconfig.PresignConfig) *handler.PresignHandler {
+	presignRepo := repository.NewPresignRepository(s3.NewPresignClient(s3Client))
+	logRepo := repository.NewPresignLogRepository(db)
+	presignService := service.NewPresignService(presignRepo, logRepo, presignCfg.DefaultExpiry, presignCfg.MaxExpiry)
+
+	return handler.NewPresignHandler(presignService, presignCfg.TrustedProxies)
+}
@@ -3,13 +3,15 @@ package di
 import (
 	"database/sql"
 
+	appconfig "r2manager/config"
 	"r2manager/handler"
 	"r2manager/repository"
 	service "r2manager/service/model"
 )
 
-func CreateSettingsHandler(db *sql.DB) *handler.SettingsHandler {
+func CreateSettingsHandler(db *sql.DB, cacheCfg *appconfig.CacheConfig) *handler.SettingsHandler {
 	repo := repository.NewSettingsRepository(db)
-	svc := service.NewSettingsService(repo)
+	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, CacheOptions(cacheCfg)...)
+	svc := service.NewSettingsService(repo, cacheRepo)
 	return handler.NewSettingsHandler(svc)
 }
@@ -0,0 +1,21 @@
+package di
+
+import (
+	"database/sql"
+
+	appconfig "r2manager/config"
+	"r2manager/handler"
+	"r2manager/progress"
+	"r2manager/repository"
+	service "r2manager/service/model"
+)
+
+// CreateTransferHandler は接続・バケットをまたいだ転送ジョブのハンドラを作成する。
+// ジョブは既定の接続の DB に保存する。
+func CreateTransferHandler(registry *ConnectionRegistry, db *sql.DB, transferCfg *appconfig.TransferConfig, progressStore *progress.UploadProgressStore) *handler.TransferHandler {
+	jobRepo := repository.NewTransferJobRepository(db)
+	transferService := service.NewTransferService(registry, jobRepo, transferCfg.Concurrency, transferCfg.MaxConcurrency, transferCfg.MaxRunningJobs)
+	transferHandler := handler.NewTransferHandler(transferService, progressStore)
+
+	return transferHandler
+}
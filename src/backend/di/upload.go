@@ -1,6 +1,9 @@
 package di
 
 import (
+	"context"
+	"database/sql"
+
 	appconfig "r2manager/config"
 	"r2manager/handler"
 	"r2manager/progress"
@@ -12,12 +15,31 @@ import (
 
 func CreateUploadHandler(s3Client *s3.Client, listCache *repository.ListCacheRepository, uploadCfg *appconfig.UploadConfig, progressStore *progress.UploadProgressStore) *handler.UploadHandler {
 	uploadRepo := repository.NewUploadRepository(s3Client)
-	uploadService := service.NewUploadService(uploadRepo, listCache)
+	uploadService := service.NewUploadService(uploadRepo, listCache,
+		service.WithMultipartUpload(uploadCfg.MultipartThreshold, uploadCfg.MultipartPartSize, uploadCfg.MultipartConcurrency),
+	)
 	uploadHandler := handler.NewUploadHandler(uploadService, uploadCfg.MaxUploadSize, progressStore)
 
 	return uploadHandler
 }
 
+func CreateResumableUploadHandler(s3Client *s3.Client, db *sql.DB, listCache *repository.ListCacheRepository, locks *service.UploadLocks, uploadCfg *appconfig.UploadConfig, progressStore *progress.UploadProgressStore) *handler.ResumableUploadHandler {
+	resumableRepo := repository.NewResumableUploadRepository(db, uploadCfg.ResumableDir)
+	uploadRepo := repository.NewUploadRepository(s3Client)
+	resumableService := service.NewResumableUploadService(resumableRepo, uploadRepo, listCache, uploadCfg.MultipartPartSize, uploadCfg.ResumableMaxSize, service.WithUploadLocks(locks))
+
+	return handler.NewResumableUploadHandler(resumableService, progressStore)
+}
+
+// StartResumableUploadSweep は一定時間更新されていない再開可能アップロードの定期的な破棄を開始する。
+// locks には接続のハンドラと同じものを渡し、処理中のアップロードを破棄しないようにする。
+func StartResumableUploadSweep(ctx context.Context, s3Client *s3.Client, db *sql.DB, listCache *repository.ListCacheRepository, locks *service.UploadLocks, uploadCfg *appconfig.UploadConfig) {
+	resumableRepo := repository.NewResumableUploadRepository(db, uploadCfg.ResumableDir)
+	uploadRepo := repository.NewUploadRepository(s3Client)
+	resumableService := service.NewResumableUploadService(resumableRepo, uploadRepo, listCache, uploadCfg.MultipartPartSize, uploadCfg.ResumableMaxSize, service.WithUploadLocks(locks))
+	resumableService.StartSweepLoop(ctx, uploadCfg.ResumableSweepInterval, uploadCfg.ResumableTTL)
+}
+
 func CreateUploadProgressHandler(progressStore *progress.UploadProgressStore) *handler.UploadProgressHandler {
 	return handler.NewUploadProgressHandler(progressStore)
 }
@@ -6,3 +6,58 @@ type Bucket struct {
 	Name         string    `json:"name"`
 	CreationDate time.Time `json:"creation_date"`
 }
+
+type ListBucketsResult struct {
+	Buckets []Bucket `json:"buckets"`
+	// Stale は S3 に接続できず、最後に取得できた一覧を返した場合に true
+	Stale bool `json:"stale,omitempty"`
+}
+
+// CORSRule はバケットの CORS ルール
+type CORSRule struct {
+	ID             string   `json:"id,omitempty"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods"`
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	ExposeHeaders  []string `json:"expose_headers,omitempty"`
+	// MaxAgeSeconds はプリフライトの結果をブラウザがキャッシュする秒数。0 の場合は指定しない
+	MaxAgeSeconds int32 `json:"max_age_seconds,omitempty"`
+}
+
+type BucketCORS struct {
+	Rules []CORSRule `json:"rules"`
+}
+
+// LifecycleRule はバケットのライフサイクルルール。0 や空の項目は設定しない
+type LifecycleRule struct {
+	ID      string `json:"id"`
+	Enabled bool   `json:"enabled"`
+	// Prefix はルールを適用するキーのプレフィックス。空の場合はバケット全体
+	Prefix string `json:"prefix"`
+	// ExpirationDays / ExpirationDate はオブジェクトを削除するまでの日数・日付。どちらか一方のみ指定できる
+	ExpirationDays int32      `json:"expiration_days,omitempty"`
+	ExpirationDate *time.Time `json:"expiration_date,omitempty"`
+	// AbortIncompleteMultipartUploadDays は未完了のマルチパートアップロードを中断するまでの日数
+	AbortIncompleteMultipartUploadDays int32                 `json:"abort_incomplete_multipart_upload_days,omitempty"`
+	Transitions                        []LifecycleTransition `json:"transitions,omitempty"`
+}
+
+// LifecycleTransition は作成から Days 日後にストレージクラスを変更する設定
+type LifecycleTransition struct {
+	Days         int32  `json:"days"`
+	StorageClass string `json:"storage_class"`
+}
+
+type BucketLifecycle struct {
+	Rules []LifecycleRule `json:"rules"`
+}
+
+type DeleteBucketResult struct {
+	Bucket string `json:"bucket"`
+	// PurgedObjects は force 指定で削除前に消したオブジェクトの数
+	PurgedObjects int `json:"purged_objects"`
+	// AbortedUploads は force 指定で削除前に中止した、完了していないマルチパートアップロードの数
+	AbortedUploads int `json:"aborted_uploads"`
+	// ClearedCacheEntries は破棄したコンテンツキャッシュのエントリ数
+	ClearedCacheEntries int64 `json:"cleared_cache_entries"`
+}
@@ -3,12 +3,20 @@ package domain
 import "time"
 
 type CacheEntry struct {
-	BucketName  string
-	ObjectKey   string
-	ContentType string
-	Size        int64
-	ETag        string
-	CachePath   string
-	CachedAt    time.Time
-	ExpiresAt   time.Time
+	BucketName  string `json:"bucket_name"`
+	ObjectKey   string `json:"object_key"`
+	ContentType string `json:"content_type"`
+	// Size はオブジェクトの論理サイズ
+	Size int64 `json:"size"`
+	// DiskSize はキャッシュファイルのディスク上のサイズ。圧縮していない場合は Size と同じ
+	DiskSize int64 `json:"disk_size"`
+	// Encoding はキャッシュファイルの圧縮形式 (gzip / zstd)。圧縮していない場合は空
+	Encoding  string    `json:"encoding,omitempty"`
+	ETag      string    `json:"etag"`
+	CachePath string    `json:"-"`
+	CachedAt  time.Time `json:"cached_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// LastAccessedAt はキャッシュヒットした最後の日時。一度もヒットしていない場合は CachedAt と同じ
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+	HitCount       int64     `json:"hit_count"`
 }
@@ -0,0 +1,49 @@
+package domain
+
+import "time"
+
+// CacheReconcileFix の種類
+const (
+	// CacheReconcileOrphanFile は cache_entries に行がないキャッシュファイル
+	CacheReconcileOrphanFile = "orphan_file"
+	// CacheReconcileTempFile は書き込み途中で残った一時ファイル
+	CacheReconcileTempFile = "temp_file"
+	// CacheReconcileDanglingEntry はキャッシュファイルが存在しない cache_entries の行
+	CacheReconcileDanglingEntry = "dangling_entry"
+	// CacheReconcileCorruptEntry はサイズや SHA-256 が記録と一致しないエントリ
+	CacheReconcileCorruptEntry = "corrupt_entry"
+)
+
+// CacheReconcileReport はキャッシュディレクトリと cache_entries の突き合わせ結果。
+type CacheReconcileReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Verify が true の場合、キャッシュファイルのサイズと SHA-256 も検査している
+	Verify bool `json:"verify"`
+
+	ScannedFiles    int `json:"scanned_files"`
+	ScannedEntries  int `json:"scanned_entries"`
+	VerifiedEntries int `json:"verified_entries"`
+
+	OrphanFiles     int `json:"orphan_files"`
+	TempFiles       int `json:"temp_files"`
+	DanglingEntries int `json:"dangling_entries"`
+	CorruptEntries  int `json:"corrupt_entries"`
+	// FreedBytes は削除したファイルの合計サイズ
+	FreedBytes int64 `json:"freed_bytes"`
+
+	// Fixes は修正した内容。件数が多い場合は先頭の一部だけを保持し、FixesTruncated を true にする
+	Fixes          []CacheReconcileFix `json:"fixes"`
+	FixesTruncated bool                `json:"fixes_truncated"`
+	// Errors は修正できなかったファイルや行のエラー
+	Errors []string `json:"errors"`
+}
+
+type CacheReconcileFix struct {
+	Kind       string `json:"kind"`
+	Path       string `json:"path,omitempty"`
+	BucketName string `json:"bucket_name,omitempty"`
+	ObjectKey  string `json:"object_key,omitempty"`
+	Size       int64  `json:"size"`
+	Reason     string `json:"reason,omitempty"`
+}
@@ -0,0 +1,57 @@
+package domain
+
+import "time"
+
+// ContentCacheStats はコンテンツキャッシュの集計。ヒット数などのカウンタはサーバー起動時からの値。
+type ContentCacheStats struct {
+	EntryCount int64 `json:"entry_count"`
+	// TotalSize はキャッシュファイルのディスク上の合計サイズ。MaxSize はこの値に対する上限
+	TotalSize int64 `json:"total_size"`
+	// LogicalSize は圧縮前のオブジェクトの合計サイズ
+	LogicalSize    int64              `json:"logical_size"`
+	MaxSize        int64              `json:"max_size"`
+	EvictionPolicy string             `json:"eviction_policy"`
+	TTLSeconds     int64              `json:"ttl_seconds"`
+	OldestCachedAt *time.Time         `json:"oldest_cached_at"`
+	NewestCachedAt *time.Time         `json:"newest_cached_at"`
+	Hits           int64              `json:"hits"`
+	Misses         int64              `json:"misses"`
+	Evictions      int64              `json:"evictions"`
+	Expirations    int64              `json:"expirations"`
+	Revalidations  int64              `json:"revalidations"`
+	Buckets        []BucketCacheStats `json:"buckets"`
+	// Memory はメモリ上のキャッシュ層の集計。メモリ層を使わない場合は nil。
+	// メモリ層でヒットしたリクエストは Hits・Misses に含まれない
+	Memory *MemoryCacheStats `json:"memory,omitempty"`
+}
+
+// MemoryCacheStats はディスクキャッシュの手前に置くメモリ上のキャッシュ層の集計。
+type MemoryCacheStats struct {
+	EntryCount    int64 `json:"entry_count"`
+	TotalSize     int64 `json:"total_size"`
+	MaxSize       int64 `json:"max_size"`
+	MaxObjectSize int64 `json:"max_object_size"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`
+}
+
+type BucketCacheStats struct {
+	BucketName  string `json:"bucket_name"`
+	EntryCount  int64  `json:"entry_count"`
+	TotalSize   int64  `json:"total_size"`
+	LogicalSize int64  `json:"logical_size"`
+}
+
+// ListCacheStats は API レスポンスキャッシュ (バケット一覧・オブジェクト一覧) の件数と TTL。
+type ListCacheStats struct {
+	Buckets ListCacheTypeStats `json:"buckets"`
+	Objects ListCacheTypeStats `json:"objects"`
+	// ObjectsByBucket はバケットごとのオブジェクト一覧キャッシュの件数
+	ObjectsByBucket map[string]int `json:"objects_by_bucket"`
+}
+
+type ListCacheTypeStats struct {
+	ItemCount  int   `json:"item_count"`
+	TTLSeconds int64 `json:"ttl_seconds"`
+}
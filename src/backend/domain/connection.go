@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// DefaultConnectionName は環境変数で設定した既定の接続の名前
+const DefaultConnectionName = "default"
+
+// Connection は S3 互換ストレージへの接続設定。
+type Connection struct {
+	Name string `json:"name"`
+	// AccountID を指定した場合、Endpoint を省略すると R2 のエンドポイントを使う
+	AccountID    string `json:"account_id"`
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	UsePathStyle bool   `json:"use_path_style"`
+	// CABundle は TLS の検証に追加で信頼する CA 証明書 (PEM) のサーバー上のパス
+	CABundle           string `json:"ca_bundle"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// CredentialsRef は認証情報を読み込む環境変数の接頭辞。"STAGING" の場合は STAGING_ACCESS_KEY_ID と
+	// STAGING_SECRET_ACCESS_KEY を使う。空の場合は AWS の既定の認証情報を使う
+	CredentialsRef string `json:"credentials_ref"`
+	DefaultBucket  string `json:"default_bucket"`
+	// Builtin は環境変数で設定した既定の接続であることを示す。API からは変更・削除できない
+	Builtin   bool      `json:"builtin"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SameTarget は接続先と認証情報が c と同じかを返す。異なる場合、保存済みのキャッシュは使えない。
+func (c *Connection) SameTarget(other *Connection) bool {
+	return c.AccountID == other.AccountID &&
+		c.Endpoint == other.Endpoint &&
+		c.Region == other.Region &&
+		c.UsePathStyle == other.UsePathStyle &&
+		c.CredentialsRef == other.CredentialsRef
+}
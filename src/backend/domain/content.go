@@ -1,11 +1,26 @@
 package domain
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type ObjectContent struct {
 	Body        io.ReadCloser
 	ContentType string
-	Size        int64
-	ETag        string
-	CacheHit    bool
+	// Size は Body のバイト数。ContentEncoding で圧縮されている場合は圧縮後のサイズ
+	Size int64
+	// ContentEncoding は Body の圧縮形式。キャッシュファイルを圧縮したまま返す場合に設定される
+	ContentEncoding string
+	// DecodedSize は ContentEncoding で圧縮されている場合の展開後のサイズ
+	DecodedSize  int64
+	ETag         string
+	LastModified time.Time
+	// ContentRange は部分取得した場合の Content-Range ヘッダ値。全体を取得した場合は空
+	ContentRange string
+	CacheHit     bool
+	// Stale は有効期限切れのキャッシュを返した場合に true
+	Stale bool
+	// Degraded は S3 に接続できず、キャッシュで代替した場合に true
+	Degraded bool
 }
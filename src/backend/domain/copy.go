@@ -0,0 +1,20 @@
+package domain
+
+type CopiedObject struct {
+	SourceKey      string `json:"source_key"`
+	DestinationKey string `json:"destination_key"`
+	Size           int64  `json:"size"`
+	ETag           string `json:"etag"`
+}
+
+type CopyError struct {
+	SourceKey      string `json:"source_key"`
+	DestinationKey string `json:"destination_key"`
+	Code           string `json:"code,omitempty"`
+	Message        string `json:"message"`
+}
+
+type CopyObjectsResult struct {
+	Copied []CopiedObject `json:"copied"`
+	Errors []CopyError    `json:"errors"`
+}
@@ -0,0 +1,12 @@
+package domain
+
+type DeleteError struct {
+	Key     string `json:"key"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+type DeleteObjectsResult struct {
+	Deleted []string      `json:"deleted"`
+	Errors  []DeleteError `json:"errors"`
+}
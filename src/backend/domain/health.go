@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+type UpstreamMode string
+
+const (
+	// UpstreamOnline は S3 (R2) に正常に接続できている状態
+	UpstreamOnline UpstreamMode = "online"
+	// UpstreamDegraded は S3 への接続に失敗しており、キャッシュから応答している状態
+	UpstreamDegraded UpstreamMode = "degraded"
+	// UpstreamProbing は縮退中に復旧を確認するリクエストを 1 件だけ通している状態
+	UpstreamProbing UpstreamMode = "probing"
+)
+
+type UpstreamStatus struct {
+	Mode                UpstreamMode `json:"mode"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time   `json:"last_failure_at,omitempty"`
+	LastSuccessAt       *time.Time   `json:"last_success_at,omitempty"`
+	DegradedSince       *time.Time   `json:"degraded_since,omitempty"`
+	NextProbeAt         *time.Time   `json:"next_probe_at,omitempty"`
+}
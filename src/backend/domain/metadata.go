@@ -0,0 +1,38 @@
+package domain
+
+import "time"
+
+// ObjectMetadata は HeadObject で取得したオブジェクトのメタデータ
+type ObjectMetadata struct {
+	Key                string    `json:"key"`
+	Size               int64     `json:"size"`
+	ETag               string    `json:"etag"`
+	LastModified       time.Time `json:"last_modified"`
+	ContentType        string    `json:"content_type"`
+	CacheControl       string    `json:"cache_control"`
+	ContentDisposition string    `json:"content_disposition"`
+	ContentEncoding    string    `json:"content_encoding"`
+	// Metadata はユーザー定義のメタデータ (x-amz-meta-*)。キーは小文字
+	Metadata map[string]string `json:"metadata"`
+}
+
+// ObjectMetadataUpdate はメタデータの変更内容。nil の項目は現在の値を引き継ぎ、空文字列を指定すると削除する
+type ObjectMetadataUpdate struct {
+	ContentType        *string `json:"content_type"`
+	CacheControl       *string `json:"cache_control"`
+	ContentDisposition *string `json:"content_disposition"`
+	ContentEncoding    *string `json:"content_encoding"`
+	// Metadata が nil でない場合、ユーザー定義のメタデータを丸ごと置き換える
+	Metadata map[string]string `json:"metadata"`
+}
+
+type MetadataError struct {
+	Key     string `json:"key"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+type UpdateMetadataResult struct {
+	Updated []string        `json:"updated"`
+	Errors  []MetadataError `json:"errors"`
+}
@@ -0,0 +1,8 @@
+package domain
+
+// UploadPart はマルチパートアップロードで送信済みのパートを表す。
+type UploadPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
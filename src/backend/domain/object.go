@@ -10,9 +10,11 @@ type Object struct {
 }
 
 type ListObjectsResult struct {
-	Objects                 []Object `json:"objects"`
-	Prefix                  string   `json:"prefix"`
-	Delimiter               string   `json:"delimiter"`
-	IsTruncated             bool     `json:"is_truncated"`
-	NextContinuationToken   string   `json:"next_continuation_token,omitempty"`
+	Objects               []Object `json:"objects"`
+	Prefix                string   `json:"prefix"`
+	Delimiter             string   `json:"delimiter"`
+	IsTruncated           bool     `json:"is_truncated"`
+	NextContinuationToken string   `json:"next_continuation_token,omitempty"`
+	// Stale は S3 に接続できず、最後に取得できた一覧を返した場合に true
+	Stale bool `json:"stale,omitempty"`
 }
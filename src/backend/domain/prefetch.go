@@ -0,0 +1,46 @@
+package domain
+
+import "time"
+
+type PrefetchJobStatus string
+
+const (
+	PrefetchQueued    PrefetchJobStatus = "queued"
+	PrefetchRunning   PrefetchJobStatus = "running"
+	PrefetchCompleted PrefetchJobStatus = "completed"
+	PrefetchFailed    PrefetchJobStatus = "failed"
+	PrefetchCanceled  PrefetchJobStatus = "canceled"
+)
+
+// PrefetchJob はプレフィックス配下のオブジェクトをキャッシュに取り込むジョブ。
+type PrefetchJob struct {
+	ID         string `json:"id"`
+	BucketName string `json:"bucket_name"`
+	Prefix     string `json:"prefix"`
+	// ContentTypes を指定した場合、一致する Content-Type のオブジェクトのみ取り込む。"image/*" のように指定できる
+	ContentTypes []string `json:"content_types,omitempty"`
+	// MaxObjectSize を超えるオブジェクトは取り込まない。0 の場合は制限なし
+	MaxObjectSize int64 `json:"max_object_size,omitempty"`
+	// MaxCount は取り込むオブジェクト数の上限。0 の場合は制限なし
+	MaxCount int `json:"max_count,omitempty"`
+
+	Status PrefetchJobStatus `json:"status"`
+	// Total は一覧から取得した対象候補の数
+	Total int `json:"total"`
+	// Fetched は S3 から取得してキャッシュしたオブジェクト数、Cached は既にキャッシュ済みだった数
+	Fetched      int    `json:"fetched"`
+	Cached       int    `json:"cached"`
+	Skipped      int    `json:"skipped"`
+	Failed       int    `json:"failed"`
+	BytesFetched int64  `json:"bytes_fetched"`
+	Error        string `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Done はジョブが終了しているかどうかを返す。
+func (j *PrefetchJob) Done() bool {
+	return j.Status == PrefetchCompleted || j.Status == PrefetchFailed || j.Status == PrefetchCanceled
+}
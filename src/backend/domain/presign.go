@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+type PresignedURL struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	Method     string    `json:"method"`
+	BucketName string    `json:"bucket_name"`
+	ObjectKey  string    `json:"object_key"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// PresignRecord は発行した署名付きURLの記録。URL 自体は認証情報となるため保存しない。
+type PresignRecord struct {
+	ID                 int64     `json:"id"`
+	BucketName         string    `json:"bucket_name"`
+	ObjectKey          string    `json:"object_key"`
+	Method             string    `json:"method"`
+	IssuedBy           string    `json:"issued_by"`
+	ContentDisposition string    `json:"content_disposition,omitempty"`
+	IssuedAt           time.Time `json:"issued_at"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+type ResumableUpload struct {
+	ID          string     `json:"id"`
+	BucketName  string     `json:"bucket_name"`
+	ObjectKey   string     `json:"object_key"`
+	ContentType string     `json:"content_type"`
+	Overwrite   bool       `json:"overwrite"`
+	Length      int64      `json:"length"`
+	Offset      int64      `json:"offset"`
+	S3UploadID  string     `json:"-"`
+	ETag        string     `json:"etag,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
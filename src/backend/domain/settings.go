@@ -1,6 +1,63 @@
 package domain
 
+import "strings"
+
 type BucketSettings struct {
 	BucketName string `json:"bucket_name"`
 	PublicUrl  string `json:"public_url"`
+	// CachePolicy はバケットごとのコンテンツキャッシュの設定。一括更新で省略した場合は変更しない
+	CachePolicy *BucketCachePolicy `json:"cache_policy,omitempty"`
+}
+
+// BucketCachePolicy はバケットごとのコンテンツキャッシュの設定。ゼロ値は全体の設定に従う。
+type BucketCachePolicy struct {
+	// TTLSeconds が 0 の場合は全体の TTL を使う
+	TTLSeconds int64 `json:"ttl_seconds"`
+	// MaxSize はこのバケットのキャッシュの合計サイズの上限（バイト）。0 の場合は全体の上限のみ
+	MaxSize int64 `json:"max_size"`
+	// NeverCache が true の場合、このバケットのオブジェクトはキャッシュせず常に S3 から取得する
+	NeverCache bool `json:"never_cache"`
+	// Pinned が true の場合、このバケットのキャッシュは期限切れにならず追い出しの対象にもならない
+	Pinned bool `json:"pinned"`
+	// IncludeContentTypes を指定した場合、一致する Content-Type のみキャッシュする。"image/*" のように指定できる
+	IncludeContentTypes []string `json:"include_content_types"`
+	// ExcludeContentTypes に一致する Content-Type はキャッシュしない
+	ExcludeContentTypes []string `json:"exclude_content_types"`
+}
+
+// AllowsContentType は contentType のオブジェクトをキャッシュしてよいかを返す。
+func (p *BucketCachePolicy) AllowsContentType(contentType string) bool {
+	if p == nil {
+		return true
+	}
+	if MatchContentType(p.ExcludeContentTypes, contentType) {
+		return false
+	}
+	return len(p.IncludeContentTypes) == 0 || MatchContentType(p.IncludeContentTypes, contentType)
+}
+
+// FiltersContentType は Content-Type によってキャッシュの対象を絞り込むかを返す。
+func (p *BucketCachePolicy) FiltersContentType() bool {
+	return p != nil && (len(p.IncludeContentTypes) > 0 || len(p.ExcludeContentTypes) > 0)
+}
+
+// MatchContentType は contentType が patterns のいずれかに一致するかを返す。
+// パターンは "image/png" のような完全一致か、"image/*" のようなメインタイプの指定。
+func MatchContentType(patterns []string, contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range patterns {
+		if matchContentType(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchContentType(pattern, mediaType string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return pattern == "*" || pattern == mediaType
 }
@@ -0,0 +1,89 @@
+package domain
+
+import "time"
+
+type TransferJobStatus string
+
+const (
+	TransferQueued    TransferJobStatus = "queued"
+	TransferRunning   TransferJobStatus = "running"
+	TransferCompleted TransferJobStatus = "completed"
+	TransferFailed    TransferJobStatus = "failed"
+	TransferCanceled  TransferJobStatus = "canceled"
+)
+
+// TransferMode はオブジェクトの転送方法
+type TransferMode string
+
+const (
+	// TransferServerCopy は転送元と転送先の認証情報が同じ場合に CopyObject でサーバー側でコピーする
+	TransferServerCopy TransferMode = "server_copy"
+	// TransferStream は転送元から取得したボディをそのまま転送先にアップロードする
+	TransferStream TransferMode = "stream"
+)
+
+// TransferLocation は転送元・転送先の接続・バケット・プレフィックス。
+type TransferLocation struct {
+	Connection string `json:"connection"`
+	Bucket     string `json:"bucket"`
+	Prefix     string `json:"prefix"`
+}
+
+// TransferJob はプレフィックス配下のオブジェクトを別のバケット・接続にコピーするジョブ。
+type TransferJob struct {
+	ID          string           `json:"id"`
+	Source      TransferLocation `json:"source"`
+	Destination TransferLocation `json:"destination"`
+	// Include を指定した場合、転送元のプレフィックスからの相対パスがいずれかに一致するオブジェクトのみ転送する
+	Include []string `json:"include,omitempty"`
+	// Exclude のいずれかに一致するオブジェクトは転送しない
+	Exclude []string `json:"exclude,omitempty"`
+	// SkipSameETag が true の場合、転送先に同じ ETag のオブジェクトがあれば転送しない
+	SkipSameETag bool `json:"skip_same_etag"`
+	// DryRun が true の場合、転送せずに対象を Preview に記録する。Copied は転送する予定の数になる
+	DryRun      bool         `json:"dry_run"`
+	Concurrency int          `json:"concurrency"`
+	Mode        TransferMode `json:"mode,omitempty"`
+
+	Status TransferJobStatus `json:"status"`
+	// Total と BytesTotal はフィルタ後の転送対象の数と合計サイズ
+	Total       int   `json:"total"`
+	Copied      int   `json:"copied"`
+	Skipped     int   `json:"skipped"`
+	Failed      int   `json:"failed"`
+	BytesTotal  int64 `json:"bytes_total"`
+	BytesCopied int64 `json:"bytes_copied"`
+	// Checkpoint はこのキー以前の対象が失敗せずに処理済みであることを示す。再開時はこれより後のキーから転送する
+	Checkpoint string `json:"checkpoint,omitempty"`
+	// CheckpointCopied・CheckpointSkipped・CheckpointBytesCopied は Checkpoint までの処理結果。再開時はここから数え直す
+	CheckpointCopied      int               `json:"-"`
+	CheckpointSkipped     int               `json:"-"`
+	CheckpointBytesCopied int64             `json:"-"`
+	Failures              []TransferFailure `json:"failures,omitempty"`
+	// Preview は dry-run で転送する予定のオブジェクト。件数が多い場合は先頭のみ
+	Preview          []TransferItem `json:"preview,omitempty"`
+	PreviewTruncated bool           `json:"preview_truncated,omitempty"`
+	Error            string         `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// TransferItem は転送するオブジェクト。
+type TransferItem struct {
+	Key            string `json:"key"`
+	DestinationKey string `json:"destination_key"`
+	Size           int64  `json:"size"`
+}
+
+// TransferFailure は転送に失敗したオブジェクト。
+type TransferFailure struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// Done はジョブが終了しているかどうかを返す。
+func (j *TransferJob) Done() bool {
+	return j.Status == TransferCompleted || j.Status == TransferFailed || j.Status == TransferCanceled
+}
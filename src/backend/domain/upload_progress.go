@@ -5,6 +5,7 @@ type UploadPhase string
 const (
 	PhaseReceiving UploadPhase = "receiving"
 	PhaseUploading UploadPhase = "uploading"
+	PhaseCopying   UploadPhase = "copying"
 	PhaseComplete  UploadPhase = "complete"
 	PhaseError     UploadPhase = "error"
 )
@@ -13,7 +14,8 @@ type UploadProgress struct {
 	UploadID       string      `json:"upload_id"`
 	Phase          UploadPhase `json:"phase"`
 	BytesProcessed int64       `json:"bytes_processed"`
-	TotalBytes     int64       `json:"total_bytes"`
+	// TotalBytes は処理する全体のバイト数。分からない場合は 0
+	TotalBytes int64 `json:"total_bytes"`
 }
 
 type UploadComplete struct {
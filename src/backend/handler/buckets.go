@@ -1,10 +1,12 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
+	"r2manager/domain"
 	serviceif "r2manager/service/interface"
 )
 
@@ -17,10 +19,132 @@ func NewBucketsHandler(service serviceif.BucketService) *BucketsHandler {
 }
 
 func (bh *BucketsHandler) GetBuckets(ctx *gin.Context) {
-	buckets, err := bh.service.GetBuckets(ctx.Request.Context())
+	result, err := bh.service.GetBuckets(ctx.Request.Context())
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondUpstreamError(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{"buckets": buckets})
+	if result.Stale {
+		setStaleWarning(ctx)
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+type createBucketRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// CreateBucket はバケットを作成する。
+// POST /api/v1/buckets
+func (bh *BucketsHandler) CreateBucket(ctx *gin.Context) {
+	var req createBucketRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	bucket, err := bh.service.CreateBucket(ctx.Request.Context(), req.Name)
+	if err != nil {
+		respondBucketError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, bucket)
+}
+
+// DeleteBucket はバケットを削除する。force=true の場合は残っているオブジェクトを先に全て削除する。
+// DELETE /api/v1/buckets/:bucketName
+func (bh *BucketsHandler) DeleteBucket(ctx *gin.Context) {
+	bucketName := ctx.Param("bucketName")
+	force := ctx.Query("force") == "true"
+
+	result, err := bh.service.DeleteBucket(ctx.Request.Context(), bucketName, force)
+	if err != nil {
+		// 途中までオブジェクトを削除してから失敗した場合は、削除した数をエラーとともに返す
+		if result != nil {
+			ctx.JSON(http.StatusMultiStatus, gin.H{
+				"error":                 err.Error(),
+				"bucket":                result.Bucket,
+				"purged_objects":        result.PurgedObjects,
+				"aborted_uploads":       result.AbortedUploads,
+				"cleared_cache_entries": result.ClearedCacheEntries,
+			})
+			return
+		}
+		respondBucketError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// GET /api/v1/buckets/:bucketName/cors
+func (bh *BucketsHandler) GetBucketCORS(ctx *gin.Context) {
+	cors, err := bh.service.GetBucketCORS(ctx.Request.Context(), ctx.Param("bucketName"))
+	if err != nil {
+		respondBucketError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, cors)
+}
+
+// UpdateBucketCORS はバケットの CORS ルールを置き換える。rules を空にすると設定を削除する。
+// PUT /api/v1/buckets/:bucketName/cors
+func (bh *BucketsHandler) UpdateBucketCORS(ctx *gin.Context) {
+	var req domain.BucketCORS
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	cors, err := bh.service.UpdateBucketCORS(ctx.Request.Context(), ctx.Param("bucketName"), req)
+	if err != nil {
+		respondBucketError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, cors)
+}
+
+// GET /api/v1/buckets/:bucketName/lifecycle
+func (bh *BucketsHandler) GetBucketLifecycle(ctx *gin.Context) {
+	lifecycle, err := bh.service.GetBucketLifecycle(ctx.Request.Context(), ctx.Param("bucketName"))
+	if err != nil {
+		respondBucketError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, lifecycle)
+}
+
+// UpdateBucketLifecycle はバケットのライフサイクルルールを置き換える。rules を空にすると設定を削除する。
+// PUT /api/v1/buckets/:bucketName/lifecycle
+func (bh *BucketsHandler) UpdateBucketLifecycle(ctx *gin.Context) {
+	var req domain.BucketLifecycle
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	lifecycle, err := bh.service.UpdateBucketLifecycle(ctx.Request.Context(), ctx.Param("bucketName"), req)
+	if err != nil {
+		respondBucketError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, lifecycle)
+}
+
+func respondBucketError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, serviceif.ErrBucketNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, serviceif.ErrBucketAlreadyExists), errors.Is(err, serviceif.ErrBucketNotEmpty):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, serviceif.ErrInvalidBucketRequest):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondUpstreamError(ctx, err)
+	}
 }
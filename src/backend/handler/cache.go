@@ -1,13 +1,20 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
 	"r2manager/repository"
 )
 
+const (
+	defaultCacheEntriesLimit = 100
+	maxCacheEntriesLimit     = 1000
+)
+
 type CacheHandler struct {
 	cacheRepo     *repository.CacheRepository
 	listCacheRepo *repository.ListCacheRepository
@@ -74,3 +81,87 @@ func (h *CacheHandler) ClearAPICache(ctx *gin.Context) {
 		"message": message,
 	})
 }
+
+// GetContentCacheStats はコンテンツキャッシュの件数・サイズとヒット率などのカウンタを返す。
+// GET /api/v1/cache/content/stats
+func (h *CacheHandler) GetContentCacheStats(ctx *gin.Context) {
+	stats, err := h.cacheRepo.Stats(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stats)
+}
+
+// GetContentCacheEntries はキャッシュエントリの一覧を返す。bucket と prefix で絞り込める。
+// GET /api/v1/cache/content/entries
+func (h *CacheHandler) GetContentCacheEntries(ctx *gin.Context) {
+	filter := repository.CacheEntryFilter{
+		BucketName: ctx.Query("bucket"),
+		Prefix:     ctx.Query("prefix"),
+		Limit:      defaultCacheEntriesLimit,
+	}
+	if v := ctx.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxCacheEntriesLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		filter.Limit = limit
+	}
+	if v := ctx.Query("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+		filter.Offset = offset
+	}
+
+	entries, total, err := h.cacheRepo.ListEntries(ctx.Request.Context(), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+// ReconcileContentCache はキャッシュディレクトリと cache_entries を突き合わせ、
+// 孤立したファイルや一時ファイル、ファイルのない行を削除して結果を返す。verify=true でサイズと SHA-256 も検査する。
+// POST /api/v1/cache/content/reconcile
+func (h *CacheHandler) ReconcileContentCache(ctx *gin.Context) {
+	opts := repository.ReconcileOptions{GracePeriod: repository.DefaultReconcileGracePeriod}
+	if v := ctx.Query("verify"); v != "" {
+		verify, err := strconv.ParseBool(v)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "verify must be a boolean"})
+			return
+		}
+		opts.Verify = verify
+	}
+
+	report, err := h.cacheRepo.Reconcile(ctx.Request.Context(), opts)
+	if err != nil {
+		if errors.Is(err, repository.ErrReconcileInProgress) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// GetAPICacheStats はバケット一覧・オブジェクト一覧キャッシュの件数と TTL を返す。
+// GET /api/v1/cache/api/stats
+func (h *CacheHandler) GetAPICacheStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.listCacheRepo.Stats())
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type ConnectionsHandler struct {
+	service  serviceif.ConnectionService
+	resolver HandlersResolver
+}
+
+func NewConnectionsHandler(service serviceif.ConnectionService, resolver HandlersResolver) *ConnectionsHandler {
+	return &ConnectionsHandler{service: service, resolver: resolver}
+}
+
+// UseConnection はパスの :conn に対応する接続のハンドラ一式を選択するミドルウェア。
+func (h *ConnectionsHandler) UseConnection(ctx *gin.Context) {
+	handlers, release, err := h.resolver.Handlers(ctx.Request.Context(), ctx.Param("conn"))
+	if err != nil {
+		respondConnectionError(ctx, err)
+		ctx.Abort()
+		return
+	}
+	defer release()
+	ctx.Set(handlersKey, handlers)
+	ctx.Next()
+}
+
+// GetConnections は既定の接続を含む全ての接続を返す。
+// GET /api/v1/connections
+func (h *ConnectionsHandler) GetConnections(ctx *gin.Context) {
+	conns, err := h.service.ListConnections(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"connections": conns})
+}
+
+// GET /api/v1/connections/:conn
+func (h *ConnectionsHandler) GetConnection(ctx *gin.Context) {
+	conn, err := h.service.GetConnection(ctx.Request.Context(), ctx.Param("conn"))
+	if err != nil {
+		respondConnectionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, conn)
+}
+
+// POST /api/v1/connections
+func (h *ConnectionsHandler) CreateConnection(ctx *gin.Context) {
+	var req domain.Connection
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	conn, err := h.service.CreateConnection(ctx.Request.Context(), req)
+	if err != nil {
+		respondConnectionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, conn)
+}
+
+// UpdateConnection は接続設定を置き換える。接続先が変わった場合、その接続のキャッシュは破棄される。
+// PUT /api/v1/connections/:conn
+func (h *ConnectionsHandler) UpdateConnection(ctx *gin.Context) {
+	var req domain.Connection
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req.Name = ctx.Param("conn")
+
+	conn, err := h.service.UpdateConnection(ctx.Request.Context(), req)
+	if err != nil {
+		respondConnectionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, conn)
+}
+
+// DeleteConnection は接続設定と、その接続のキャッシュ・設定を削除する。ストレージ上のデータは削除しない。
+// DELETE /api/v1/connections/:conn
+func (h *ConnectionsHandler) DeleteConnection(ctx *gin.Context) {
+	if err := h.service.DeleteConnection(ctx.Request.Context(), ctx.Param("conn")); err != nil {
+		respondConnectionError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func respondConnectionError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, serviceif.ErrConnectionNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, serviceif.ErrConnectionExists), errors.Is(err, serviceif.ErrConnectionBusy):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, serviceif.ErrInvalidConnection):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, serviceif.ErrConnectionReadOnly):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
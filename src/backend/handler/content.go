@@ -1,13 +1,16 @@
 package handler
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"r2manager/domain"
 	serviceif "r2manager/service/interface"
 )
 
@@ -33,16 +36,35 @@ func (ch *ContentHandler) GetContent(ctx *gin.Context) {
 		return
 	}
 
-	content, err := ch.service.GetContent(ctx.Request.Context(), bucketName, key)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	opts := serviceif.GetContentOptions{
+		Range:          ctx.GetHeader("Range"),
+		AcceptEncoding: ctx.GetHeader("Accept-Encoding"),
+	}
+
+	content, ok := ch.getContent(ctx, bucketName, key, opts)
+	if !ok {
 		return
 	}
+	// S3 から範囲取得した後で If-Range が一致しないと分かった場合は、範囲を外して全体を取得し直す
+	if content.ContentRange != "" && !ifRangeMatches(ctx.GetHeader("If-Range"), content.ETag, content.LastModified) {
+		content.Body.Close()
+		opts.Range = ""
+		if content, ok = ch.getContent(ctx, bucketName, key, opts); !ok {
+			return
+		}
+	}
 	defer content.Body.Close()
 
-	if content.CacheHit {
+	switch {
+	case content.Stale && content.Degraded:
+		ctx.Header("X-Cache", "STALE")
+		setStaleWarning(ctx)
+	case content.Stale:
+		ctx.Header("X-Cache", "STALE")
+		ctx.Header("Warning", `110 - "Response is Stale"`)
+	case content.CacheHit:
 		ctx.Header("X-Cache", "HIT")
-	} else {
+	default:
 		ctx.Header("X-Cache", "MISS")
 	}
 
@@ -51,6 +73,49 @@ func (ch *ContentHandler) GetContent(ctx *gin.Context) {
 	}
 
 	ctx.Header("Content-Type", content.ContentType)
+	// 圧縮して保存したキャッシュは Accept-Encoding によって圧縮したまま返すか展開して返すかが変わる
+	ctx.Header("Vary", "Accept-Encoding")
+	if content.ContentEncoding != "" {
+		ctx.Header("Content-Encoding", content.ContentEncoding)
+	}
+
+	// S3 から範囲取得した場合はそのまま 206 で返す
+	if content.ContentRange != "" {
+		if etagNoneMatchFails(ctx.GetHeader("If-None-Match"), content.ETag) {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+		ctx.Header("Accept-Ranges", "bytes")
+		ctx.Header("Content-Range", content.ContentRange)
+		ctx.Header("Content-Length", strconv.FormatInt(content.Size, 10))
+		if !content.LastModified.IsZero() {
+			ctx.Header("Last-Modified", content.LastModified.UTC().Format(http.TimeFormat))
+		}
+		ctx.Status(http.StatusPartialContent)
+		io.Copy(ctx.Writer, content.Body)
+		return
+	}
+
+	// キャッシュファイルはシーク可能なので、Range・条件付きリクエストの処理を http.ServeContent に任せる。
+	// ETag ヘッダは設定済みのため If-None-Match も評価される
+	if rs, ok := content.Body.(io.ReadSeeker); ok {
+		// Content-Encoding を設定すると http.ServeContent は Content-Length を設定しないため、ここで設定する
+		if content.ContentEncoding != "" {
+			ctx.Header("Content-Length", strconv.FormatInt(content.Size, 10))
+		}
+		http.ServeContent(ctx.Writer, ctx.Request, "", content.LastModified, rs)
+		return
+	}
+
+	// ダウンロード中のボディはシークできないため、Range は無視して全体を返し、If-None-Match のみ評価する。
+	// 範囲取得に応じられないため Accept-Ranges は返さない
+	if etagNoneMatchFails(ctx.GetHeader("If-None-Match"), content.ETag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	if !content.LastModified.IsZero() {
+		ctx.Header("Last-Modified", content.LastModified.UTC().Format(http.TimeFormat))
+	}
 	if content.Size > 0 {
 		ctx.Header("Content-Length", strconv.FormatInt(content.Size, 10))
 	}
@@ -58,3 +123,49 @@ func (ch *ContentHandler) GetContent(ctx *gin.Context) {
 	ctx.Status(http.StatusOK)
 	io.Copy(ctx.Writer, content.Body)
 }
+
+// getContent はコンテンツを取得する。失敗した場合はエラーレスポンスを書き込んで false を返す。
+func (ch *ContentHandler) getContent(ctx *gin.Context, bucketName, key string, opts serviceif.GetContentOptions) (*domain.ObjectContent, bool) {
+	content, err := ch.service.GetContent(ctx.Request.Context(), bucketName, key, opts)
+	if err != nil {
+		if errors.Is(err, serviceif.ErrInvalidRange) {
+			ctx.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		respondUpstreamError(ctx, err)
+		return nil, false
+	}
+	return content, true
+}
+
+// etagNoneMatchFails は If-None-Match が etag に一致し、304 を返すべきかを返す。弱い比較で評価する。
+func etagNoneMatchFails(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for candidate := range strings.SplitSeq(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches は If-Range が現在の表現と一致し、範囲を返してよいかを返す。
+// ETag は強い比較で、日付は Last-Modified と秒単位で比較する。
+func ifRangeMatches(header, etag string, lastModified time.Time) bool {
+	if header == "" {
+		return true
+	}
+	if strings.HasPrefix(header, `"`) || strings.HasPrefix(header, "W/") {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && header == etag
+	}
+	t, err := http.ParseTime(header)
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return lastModified.Truncate(time.Second).Equal(t)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/domain"
+	"r2manager/progress"
+	serviceif "r2manager/service/interface"
+)
+
+type CopyHandler struct {
+	service       serviceif.CopyService
+	progressStore *progress.UploadProgressStore
+}
+
+func NewCopyHandler(service serviceif.CopyService, progressStore *progress.UploadProgressStore) *CopyHandler {
+	return &CopyHandler{service: service, progressStore: progressStore}
+}
+
+type copyRequest struct {
+	Source            string `json:"source" binding:"required"`
+	Destination       string `json:"destination" binding:"required"`
+	DestinationBucket string `json:"destination_bucket"`
+	Recursive         bool   `json:"recursive"`
+	Overwrite         bool   `json:"overwrite"`
+}
+
+// CopyObjects はオブジェクトまたはフォルダをサーバーサイドでコピーする。
+// POST /api/v1/buckets/:bucketName/copy
+func (h *CopyHandler) CopyObjects(ctx *gin.Context) {
+	h.handle(ctx, h.service.Copy)
+}
+
+// MoveObjects はオブジェクトまたはフォルダをサーバーサイドで移動（リネーム）する。
+// POST /api/v1/buckets/:bucketName/move
+func (h *CopyHandler) MoveObjects(ctx *gin.Context) {
+	h.handle(ctx, h.service.Move)
+}
+
+type copyFunc func(ctx context.Context, params serviceif.CopyParams, onProgress serviceif.CopyProgressCallback) (*domain.CopyObjectsResult, error)
+
+func (h *CopyHandler) handle(ctx *gin.Context, run copyFunc) {
+	bucketName := ctx.Param("bucketName")
+	if bucketName == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "bucketName is required"})
+		return
+	}
+
+	var req copyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "source and destination are required"})
+		return
+	}
+
+	// 進捗はアップロードと同じ SSE エンドポイントで配信する
+	uploadID := ctx.GetHeader("X-Upload-ID")
+	if uploadID == "" {
+		uploadID = ctx.Query("upload_id")
+	}
+
+	var onProgress serviceif.CopyProgressCallback
+	if uploadID != "" {
+		h.progressStore.Register(uploadID)
+		onProgress = func(bytesProcessed, totalBytes int64) {
+			h.progressStore.Publish(uploadID, domain.UploadEvent{
+				EventType: domain.EventProgress,
+				Data: domain.UploadProgress{
+					UploadID:       uploadID,
+					Phase:          domain.PhaseCopying,
+					BytesProcessed: bytesProcessed,
+					TotalBytes:     totalBytes,
+				},
+			})
+		}
+	}
+
+	params := serviceif.CopyParams{
+		SourceBucket:      bucketName,
+		SourceKey:         req.Source,
+		DestinationBucket: req.DestinationBucket,
+		DestinationKey:    req.Destination,
+		Recursive:         req.Recursive,
+		Overwrite:         req.Overwrite,
+	}
+
+	result, err := run(ctx.Request.Context(), params, onProgress)
+	if err != nil {
+		if uploadID != "" {
+			h.progressStore.Publish(uploadID, domain.UploadEvent{
+				EventType: domain.EventError,
+				Data:      domain.UploadError{UploadID: uploadID, Error: err.Error()},
+			})
+		}
+		switch {
+		case errors.Is(err, serviceif.ErrObjectAlreadyExists):
+			ctx.JSON(http.StatusConflict, gin.H{"error": "object already exists", "code": "CONFLICT"})
+		case errors.Is(err, serviceif.ErrObjectNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "source not found"})
+		case errors.Is(err, serviceif.ErrInvalidDestination):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if uploadID != "" {
+		h.progressStore.Publish(uploadID, domain.UploadEvent{
+			EventType: domain.EventComplete,
+			Data:      domain.UploadComplete{UploadID: uploadID, Result: result},
+		})
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
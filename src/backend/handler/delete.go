@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type DeleteHandler struct {
+	service serviceif.DeleteService
+}
+
+func NewDeleteHandler(service serviceif.DeleteService) *DeleteHandler {
+	return &DeleteHandler{service: service}
+}
+
+// DeleteObject はオブジェクトを削除する。recursive=true の場合は key をフォルダとみなし、配下を全て削除する。
+// DELETE /api/v1/buckets/:bucketName/objects/*key
+func (h *DeleteHandler) DeleteObject(ctx *gin.Context) {
+	bucketName := ctx.Param("bucketName")
+	if bucketName == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "bucketName is required"})
+		return
+	}
+
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+	if key == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	recursive := ctx.Query("recursive") == "true"
+
+	var result *domain.DeleteObjectsResult
+	var err error
+	if recursive {
+		result, err = h.service.DeletePrefix(ctx.Request.Context(), bucketName, key)
+	} else {
+		result, err = h.service.DeleteObject(ctx.Request.Context(), bucketName, key)
+	}
+	if err != nil {
+		// 一部のキーを削除できた後に失敗した場合は、キーごとの結果をエラーとともに返す
+		if result != nil && len(result.Deleted) > 0 {
+			ctx.JSON(http.StatusMultiStatus, gin.H{
+				"error":   err.Error(),
+				"deleted": result.Deleted,
+				"errors":  result.Errors,
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handlers は 1 つの接続に対する API のハンドラ一式。接続ごとに S3 クライアントとキャッシュが異なる。
+type Handlers struct {
+	Buckets         *BucketsHandler
+	Objects         *ObjectsHandler
+	Content         *ContentHandler
+	Cache           *CacheHandler
+	Settings        *SettingsHandler
+	Upload          *UploadHandler
+	UploadProgress  *UploadProgressHandler
+	Delete          *DeleteHandler
+	Copy            *CopyHandler
+	Metadata        *MetadataHandler
+	ResumableUpload *ResumableUploadHandler
+	Presign         *PresignHandler
+	Health          *HealthHandler
+	Prefetch        *PrefetchHandler
+}
+
+// HandlersResolver は接続名からその接続のハンドラ一式を返す。
+// release はリクエストの処理を終えたら呼ぶ。
+type HandlersResolver interface {
+	Handlers(ctx context.Context, name string) (handlers *Handlers, release func(), err error)
+}
+
+const handlersKey = "handlers"
+
+// ConnectionHandlers は UseConnection で選択した接続のハンドラ一式を返す。
+func ConnectionHandlers(ctx *gin.Context) *Handlers {
+	return ctx.MustGet(handlersKey).(*Handlers)
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/health"
+)
+
+type HealthHandler struct {
+	monitor *health.Monitor
+}
+
+func NewHealthHandler(monitor *health.Monitor) *HealthHandler {
+	return &HealthHandler{monitor: monitor}
+}
+
+// GetHealth は S3 (R2) への接続状態を返す。縮退中でもキャッシュから応答できるため 200 を返す。
+// GET /api/v1/health
+func (h *HealthHandler) GetHealth(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.monitor.Status())
+}
+
+// respondUpstreamError は S3 に接続できない場合は 503、それ以外は 500 を返す。
+func respondUpstreamError(ctx *gin.Context, err error) {
+	if health.IsUpstreamFailure(err) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "code": "UPSTREAM_UNAVAILABLE"})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// setStaleWarning は S3 に接続できず古いデータを返すことを Warning ヘッダで示す。
+func setStaleWarning(ctx *gin.Context) {
+	ctx.Header("Warning", `111 - "Revalidation Failed"`)
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type MetadataHandler struct {
+	service serviceif.MetadataService
+}
+
+func NewMetadataHandler(service serviceif.MetadataService) *MetadataHandler {
+	return &MetadataHandler{service: service}
+}
+
+// GetObjectMetadata はオブジェクトの Content-Type などのメタデータとユーザー定義のメタデータを返す。
+// GET /api/v1/buckets/:bucketName/metadata/*key
+func (h *MetadataHandler) GetObjectMetadata(ctx *gin.Context) {
+	bucketName := ctx.Param("bucketName")
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+	if key == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	meta, err := h.service.GetMetadata(ctx.Request.Context(), bucketName, key)
+	if err != nil {
+		respondMetadataError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, meta)
+}
+
+// UpdateObjectMetadata はオブジェクトのメタデータを書き換える。指定しなかった項目は現在の値を引き継ぐ。
+// recursive=true の場合は key をフォルダとみなし、配下の全オブジェクトに同じ変更を適用する。
+// PUT /api/v1/buckets/:bucketName/metadata/*key
+func (h *MetadataHandler) UpdateObjectMetadata(ctx *gin.Context) {
+	bucketName := ctx.Param("bucketName")
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+	if key == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	var req domain.ObjectMetadataUpdate
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if ctx.Query("recursive") == "true" {
+		result, err := h.service.UpdatePrefixMetadata(ctx.Request.Context(), bucketName, key, req)
+		if err != nil {
+			respondMetadataError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, result)
+		return
+	}
+
+	meta, err := h.service.UpdateMetadata(ctx.Request.Context(), bucketName, key, req)
+	if err != nil {
+		respondMetadataError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, meta)
+}
+
+func respondMetadataError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, serviceif.ErrObjectNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+	case errors.Is(err, serviceif.ErrObjectModified):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "CONFLICT"})
+	case errors.Is(err, serviceif.ErrInvalidMetadata):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondUpstreamError(ctx, err)
+	}
+}
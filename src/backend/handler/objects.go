@@ -2,12 +2,16 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
 	serviceif "r2manager/service/interface"
 )
 
+// ListObjectsV2 の 1 リクエストあたりの最大件数
+const maxListKeys = 1000
+
 type ObjectsHandler struct {
 	service serviceif.ObjectService
 }
@@ -24,14 +28,29 @@ func (oh *ObjectsHandler) GetObjects(ctx *gin.Context) {
 	}
 
 	params := serviceif.ListObjectsParams{
-		Prefix:    ctx.Query("prefix"),
-		Delimiter: ctx.Query("delimiter"),
+		Prefix:            ctx.Query("prefix"),
+		Delimiter:         ctx.Query("delimiter"),
+		ContinuationToken: ctx.Query("continuation_token"),
+		StartAfter:        ctx.Query("start_after"),
+		All:               ctx.Query("all") == "true",
+	}
+
+	if v := ctx.Query("max_keys"); v != "" {
+		maxKeys, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || maxKeys < 1 || maxKeys > maxListKeys {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "max_keys must be between 1 and 1000"})
+			return
+		}
+		params.MaxKeys = int32(maxKeys)
 	}
 
 	result, err := oh.service.GetObjects(ctx.Request.Context(), bucketName, params)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondUpstreamError(ctx, err)
 		return
 	}
+	if result.Stale {
+		setStaleWarning(ctx)
+	}
 	ctx.JSON(http.StatusOK, result)
 }
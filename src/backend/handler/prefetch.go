@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/domain"
+	"r2manager/progress"
+	serviceif "r2manager/service/interface"
+)
+
+type PrefetchHandler struct {
+	service       serviceif.PrefetchService
+	progressStore *progress.UploadProgressStore
+}
+
+func NewPrefetchHandler(service serviceif.PrefetchService, progressStore *progress.UploadProgressStore) *PrefetchHandler {
+	return &PrefetchHandler{service: service, progressStore: progressStore}
+}
+
+type prefetchRequest struct {
+	Bucket        string   `json:"bucket" binding:"required"`
+	Prefix        string   `json:"prefix"`
+	ContentTypes  []string `json:"content_types"`
+	MaxObjectSize int64    `json:"max_object_size"`
+	MaxCount      int      `json:"max_count"`
+}
+
+// CreatePrefetchJob はプレフィックス配下のオブジェクトをキャッシュに取り込むジョブを登録する。
+// 進捗は GET /api/v1/cache/prefetch/:jobId/progress の SSE で配信する。
+// POST /api/v1/cache/prefetch
+func (h *PrefetchHandler) CreatePrefetchJob(ctx *gin.Context) {
+	var req prefetchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "bucket is required"})
+		return
+	}
+
+	job, err := h.service.Enqueue(serviceif.PrefetchParams{
+		BucketName:    req.Bucket,
+		Prefix:        req.Prefix,
+		ContentTypes:  req.ContentTypes,
+		MaxObjectSize: req.MaxObjectSize,
+		MaxCount:      req.MaxCount,
+	}, h.publish)
+	if err != nil {
+		if errors.Is(err, serviceif.ErrInvalidPrefetchRequest) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// publish はジョブの状態をアップロードと同じ進捗ストアに配信する。
+func (h *PrefetchHandler) publish(job domain.PrefetchJob) {
+	h.progressStore.RegisterIfAbsent(job.ID)
+
+	event := domain.UploadEvent{EventType: domain.EventProgress, Data: job}
+	switch job.Status {
+	case domain.PrefetchCompleted, domain.PrefetchCanceled:
+		event.EventType = domain.EventComplete
+	case domain.PrefetchFailed:
+		event.EventType = domain.EventError
+	}
+	h.progressStore.Publish(job.ID, event)
+}
+
+// GetPrefetchJobs は保持しているプリフェッチジョブを返す。
+// GET /api/v1/cache/prefetch
+func (h *PrefetchHandler) GetPrefetchJobs(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"jobs": h.service.ListJobs()})
+}
+
+// GetPrefetchJob はプリフェッチジョブの状態を返す。
+// GET /api/v1/cache/prefetch/:jobId
+func (h *PrefetchHandler) GetPrefetchJob(ctx *gin.Context) {
+	job, err := h.service.GetJob(ctx.Param("jobId"))
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, job)
+}
+
+// CancelPrefetchJob はプリフェッチジョブを中断する。取り込み済みのキャッシュは残る。
+// DELETE /api/v1/cache/prefetch/:jobId
+func (h *PrefetchHandler) CancelPrefetchJob(ctx *gin.Context) {
+	if err := h.service.Cancel(ctx.Param("jobId")); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetPrefetchProgress は SSE ストリームでプリフェッチジョブの進捗を配信する。
+// GET /api/v1/cache/prefetch/:jobId/progress
+func (h *PrefetchHandler) GetPrefetchProgress(ctx *gin.Context) {
+	jobID := ctx.Param("jobId")
+	job, err := h.service.GetJob(jobID)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	// 購読前に完了していても最後の状態を受け取れるよう、現在の状態を配信しておく
+	h.publish(*job)
+	streamProgress(ctx, h.progressStore, jobID)
+}
+
+func (h *PrefetchHandler) respondError(ctx *gin.Context, err error) {
+	if errors.Is(err, serviceif.ErrPrefetchJobNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	serviceif "r2manager/service/interface"
+)
+
+const (
+	defaultPresignListLimit = 100
+	maxPresignListLimit     = 1000
+)
+
+type PresignHandler struct {
+	service serviceif.PresignService
+	// trustedProxies から届いたリクエストだけ、ユーザーヘッダを信頼する
+	trustedProxies []netip.Prefix
+}
+
+// NewPresignHandler は trustedProxies（IP または CIDR）を信頼するプロキシとして PresignHandler を作成する。
+// 不正な値は読み飛ばす。
+func NewPresignHandler(service serviceif.PresignService, trustedProxies []string) *PresignHandler {
+	h := &PresignHandler{service: service}
+	for _, proxy := range trustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				log.Printf("warning: ignoring invalid trusted proxy: %s", proxy)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		h.trustedProxies = append(h.trustedProxies, prefix.Masked())
+	}
+	return h
+}
+
+type presignRequest struct {
+	Key                string `json:"key" binding:"required"`
+	Method             string `json:"method"`
+	ExpiresIn          int64  `json:"expires_in"` // seconds
+	ContentType        string `json:"content_type"`
+	ContentDisposition string `json:"content_disposition"`
+}
+
+// CreatePresignedURL は署名付きの GET / PUT URL を発行し、発行記録を残す。
+// POST /api/v1/buckets/:bucketName/presign
+func (h *PresignHandler) CreatePresignedURL(ctx *gin.Context) {
+	bucketName := ctx.Param("bucketName")
+	if bucketName == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "bucketName is required"})
+		return
+	}
+
+	var req presignRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+
+	result, err := h.service.Presign(ctx.Request.Context(), serviceif.PresignParams{
+		BucketName:         bucketName,
+		ObjectKey:          req.Key,
+		Method:             req.Method,
+		ContentType:        req.ContentType,
+		ContentDisposition: req.ContentDisposition,
+		Expires:            time.Duration(req.ExpiresIn) * time.Second,
+		IssuedBy:           h.requestIssuer(ctx),
+	})
+	if err != nil {
+		if errors.Is(err, serviceif.ErrInvalidPresignRequest) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// GetPresignedURLs は発行済みの署名付きURLの記録を新しい順に返す。
+// GET /api/v1/presigned-urls
+func (h *PresignHandler) GetPresignedURLs(ctx *gin.Context) {
+	filter := serviceif.PresignRecordFilter{
+		BucketName: ctx.Query("bucket"),
+		ActiveOnly: ctx.Query("active") == "true",
+		Limit:      defaultPresignListLimit,
+	}
+
+	if v := ctx.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPresignListLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		filter.Limit = limit
+	}
+	if v := ctx.Query("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+		filter.Offset = offset
+	}
+
+	records, err := h.service.ListRecords(ctx.Request.Context(), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"presigned_urls": records})
+}
+
+// requestIssuer はリクエスト元を識別する文字列を返す。
+// 信頼するプロキシからのリクエストではプロキシが付与するユーザーヘッダを優先し、無ければ接続元IPを使う。
+// それ以外のリクエストのユーザーヘッダはクライアントが自由に付けられるため使わない。
+func (h *PresignHandler) requestIssuer(ctx *gin.Context) string {
+	if h.fromTrustedProxy(ctx) {
+		for _, header := range []string{"X-Forwarded-User", "X-Remote-User", "X-Auth-Request-User"} {
+			if v := ctx.GetHeader(header); v != "" {
+				return v
+			}
+		}
+	}
+	return ctx.ClientIP()
+}
+
+func (h *PresignHandler) fromTrustedProxy(ctx *gin.Context) bool {
+	addr, err := netip.ParseAddr(ctx.RemoteIP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range h.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/domain"
+	"r2manager/progress"
+	serviceif "r2manager/service/interface"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+)
+
+// ResumableUploadHandler は tus 1.0 (core + creation + termination) による再開可能アップロードを提供する。
+type ResumableUploadHandler struct {
+	service       serviceif.ResumableUploadService
+	progressStore *progress.UploadProgressStore
+}
+
+func NewResumableUploadHandler(service serviceif.ResumableUploadService, progressStore *progress.UploadProgressStore) *ResumableUploadHandler {
+	return &ResumableUploadHandler{service: service, progressStore: progressStore}
+}
+
+// Options はサーバーがサポートする tus のバージョンと拡張を返す。
+// OPTIONS /api/v1/uploads
+func (h *ResumableUploadHandler) Options(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+	ctx.Header("Tus-Version", tusVersion)
+	ctx.Header("Tus-Extension", tusExtensions)
+	if maxSize := h.service.MaxSize(); maxSize > 0 {
+		ctx.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// CreateUpload はアップロードを作成する。バケットとキーは Upload-Metadata の bucket / key で指定する。
+// POST /api/v1/uploads
+func (h *ResumableUploadHandler) CreateUpload(ctx *gin.Context) {
+	if !h.checkTusResumable(ctx) {
+		return
+	}
+
+	length, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+
+	metadata, err := parseUploadMetadata(ctx.GetHeader("Upload-Metadata"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Metadata"})
+		return
+	}
+
+	key := metadata["key"]
+	if key == "" {
+		key = metadata["filename"]
+	}
+	contentType := metadata["content_type"]
+	if contentType == "" {
+		contentType = metadata["filetype"]
+	}
+
+	upload, err := h.service.Create(ctx.Request.Context(), serviceif.CreateResumableUploadParams{
+		BucketName:  metadata["bucket"],
+		ObjectKey:   key,
+		ContentType: detectContentType(key, contentType),
+		Length:      length,
+		Overwrite:   metadata["overwrite"] == "true",
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, serviceif.ErrUploadTooLarge):
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large", "max_size": h.service.MaxSize()})
+		case errors.Is(err, serviceif.ErrObjectAlreadyExists):
+			ctx.JSON(http.StatusConflict, gin.H{"error": "object already exists", "code": "CONFLICT"})
+		default:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	h.progressStore.Register(upload.ID)
+	if upload.CompletedAt != nil {
+		h.publishComplete(upload)
+	}
+
+	ctx.Header("Location", strings.TrimSuffix(ctx.Request.URL.Path, "/")+"/"+upload.ID)
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Status(http.StatusCreated)
+}
+
+// GetUploadOffset は現在のオフセットを返す。クライアントはこれを元に送信を再開する。
+// HEAD /api/v1/uploads/:uploadId
+func (h *ResumableUploadHandler) GetUploadOffset(ctx *gin.Context) {
+	if !h.checkTusResumable(ctx) {
+		return
+	}
+
+	upload, err := h.service.Get(ctx.Request.Context(), ctx.Param("uploadId"))
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	ctx.Status(http.StatusOK)
+}
+
+// PatchUpload は Upload-Offset の位置からデータを追記する。
+// PATCH /api/v1/uploads/:uploadId
+func (h *ResumableUploadHandler) PatchUpload(ctx *gin.Context) {
+	if !h.checkTusResumable(ctx) {
+		return
+	}
+
+	if ctx.GetHeader("Content-Type") != "application/offset+octet-stream" {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+		return
+	}
+
+	uploadID := ctx.Param("uploadId")
+	upload, err := h.service.Get(ctx.Request.Context(), uploadID)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	totalBytes := upload.Length
+
+	// Upload-Length を超えることが分かっている場合は読み込む前に拒否する
+	if ctx.Request.ContentLength > 0 && offset+ctx.Request.ContentLength > upload.Length {
+		ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload exceeds Upload-Length"})
+		return
+	}
+
+	// 再起動後に再開された場合もイベントを配信できるようにする
+	h.progressStore.RegisterIfAbsent(uploadID)
+
+	body, err := progress.NewProgressReadCloser(
+		ctx.Request.Body,
+		func(bytesProcessed int64) {
+			h.progressStore.Publish(uploadID, domain.UploadEvent{
+				EventType: domain.EventProgress,
+				Data: domain.UploadProgress{
+					UploadID:       uploadID,
+					Phase:          domain.PhaseReceiving,
+					BytesProcessed: offset + bytesProcessed,
+					TotalBytes:     totalBytes,
+				},
+			})
+		},
+		100*time.Millisecond,
+	)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	upload, err = h.service.Append(ctx.Request.Context(), uploadID, offset, body, func(bytesProcessed int64) {
+		h.progressStore.Publish(uploadID, domain.UploadEvent{
+			EventType: domain.EventProgress,
+			Data: domain.UploadProgress{
+				UploadID:       uploadID,
+				Phase:          domain.PhaseUploading,
+				BytesProcessed: bytesProcessed,
+				TotalBytes:     totalBytes,
+			},
+		})
+	})
+	if upload != nil {
+		ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	if err != nil {
+		if errors.Is(err, serviceif.ErrObjectAlreadyExists) {
+			h.progressStore.Publish(uploadID, domain.UploadEvent{
+				EventType: domain.EventError,
+				Data:      domain.UploadError{UploadID: uploadID, Error: err.Error()},
+			})
+		}
+		h.respondError(ctx, err)
+		return
+	}
+
+	if upload.CompletedAt != nil {
+		h.publishComplete(upload)
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// TerminateUpload はアップロードを中断し、送信済みのデータを破棄する。
+// DELETE /api/v1/uploads/:uploadId
+func (h *ResumableUploadHandler) TerminateUpload(ctx *gin.Context) {
+	if !h.checkTusResumable(ctx) {
+		return
+	}
+
+	uploadID := ctx.Param("uploadId")
+	if err := h.service.Terminate(ctx.Request.Context(), uploadID); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	h.progressStore.Publish(uploadID, domain.UploadEvent{
+		EventType: domain.EventError,
+		Data:      domain.UploadError{UploadID: uploadID, Error: "upload terminated"},
+	})
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// checkTusResumable は Tus-Resumable ヘッダを検証し、レスポンスにも付与する。
+func (h *ResumableUploadHandler) checkTusResumable(ctx *gin.Context) bool {
+	ctx.Header("Tus-Resumable", tusVersion)
+	if ctx.GetHeader("Tus-Resumable") != tusVersion {
+		ctx.Header("Tus-Version", tusVersion)
+		ctx.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported Tus-Resumable version"})
+		return false
+	}
+	return true
+}
+
+func (h *ResumableUploadHandler) respondError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, serviceif.ErrUploadNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+	case errors.Is(err, serviceif.ErrUploadOffsetMismatch):
+		ctx.JSON(http.StatusConflict, gin.H{"error": "upload offset mismatch"})
+	case errors.Is(err, serviceif.ErrUploadExceedsLength):
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload exceeds Upload-Length"})
+	case errors.Is(err, serviceif.ErrUploadLocked):
+		ctx.JSON(http.StatusLocked, gin.H{"error": "upload is locked by another request"})
+	case errors.Is(err, serviceif.ErrObjectAlreadyExists):
+		ctx.JSON(http.StatusConflict, gin.H{"error": "object already exists", "code": "CONFLICT"})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (h *ResumableUploadHandler) publishComplete(upload *domain.ResumableUpload) {
+	h.progressStore.Publish(upload.ID, domain.UploadEvent{
+		EventType: domain.EventComplete,
+		Data: domain.UploadComplete{
+			UploadID: upload.ID,
+			Result: serviceif.UploadResult{
+				Key:  upload.ObjectKey,
+				Size: upload.Length,
+				ETag: upload.ETag,
+			},
+		},
+	})
+}
+
+// parseUploadMetadata は "key base64value,key2 base64value2" 形式の Upload-Metadata をパースする。
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+
+	for pair := range strings.SplitSeq(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -43,8 +44,9 @@ func (h *SettingsHandler) GetBucketSettings(ctx *gin.Context) {
 	}
 	if settings == nil {
 		ctx.JSON(http.StatusOK, domain.BucketSettings{
-			BucketName: bucketName,
-			PublicUrl:  "",
+			BucketName:  bucketName,
+			PublicUrl:   "",
+			CachePolicy: &domain.BucketCachePolicy{IncludeContentTypes: []string{}, ExcludeContentTypes: []string{}},
 		})
 		return
 	}
@@ -64,6 +66,10 @@ func (h *SettingsHandler) BulkUpdateBucketSettings(ctx *gin.Context) {
 	}
 
 	if err := h.service.BulkUpdateBucketSettings(ctx.Request.Context(), req.Settings); err != nil {
+		if errors.Is(err, serviceif.ErrInvalidCachePolicy) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -98,3 +104,36 @@ func (h *SettingsHandler) UpdateBucketSettings(ctx *gin.Context) {
 		PublicUrl:  req.PublicUrl,
 	})
 }
+
+// UpdateBucketCachePolicy はバケットのコンテンツキャッシュの設定を更新し、既存のキャッシュに反映する。
+// PUT /api/v1/settings/buckets/:bucketName/cache-policy
+func (h *SettingsHandler) UpdateBucketCachePolicy(ctx *gin.Context) {
+	bucketName := ctx.Param("bucketName")
+	if bucketName == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "bucketName is required"})
+		return
+	}
+
+	var req domain.BucketCachePolicy
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.service.UpdateBucketCachePolicy(ctx.Request.Context(), bucketName, req); err != nil {
+		if errors.Is(err, serviceif.ErrInvalidCachePolicy) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.service.GetBucketSettings(ctx.Request.Context(), bucketName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"r2manager/domain"
+	"r2manager/progress"
+	serviceif "r2manager/service/interface"
+)
+
+type TransferHandler struct {
+	service       serviceif.TransferService
+	progressStore *progress.UploadProgressStore
+}
+
+func NewTransferHandler(service serviceif.TransferService, progressStore *progress.UploadProgressStore) *TransferHandler {
+	return &TransferHandler{service: service, progressStore: progressStore}
+}
+
+type transferRequest struct {
+	Source       domain.TransferLocation `json:"source"`
+	Destination  domain.TransferLocation `json:"destination"`
+	Include      []string                `json:"include"`
+	Exclude      []string                `json:"exclude"`
+	SkipSameETag bool                    `json:"skip_same_etag"`
+	DryRun       bool                    `json:"dry_run"`
+	Concurrency  int                     `json:"concurrency"`
+}
+
+// CreateTransferJob は転送元のプレフィックス配下を転送先にコピーするジョブを登録する。
+// 接続を省略した場合は既定の接続を使う。進捗は GET /api/v1/transfers/:jobId/progress の SSE で配信する。
+// POST /api/v1/transfers
+func (h *TransferHandler) CreateTransferJob(ctx *gin.Context) {
+	var req transferRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	job, err := h.service.Enqueue(ctx.Request.Context(), serviceif.TransferParams{
+		Source:       req.Source,
+		Destination:  req.Destination,
+		Include:      req.Include,
+		Exclude:      req.Exclude,
+		SkipSameETag: req.SkipSameETag,
+		DryRun:       req.DryRun,
+		Concurrency:  req.Concurrency,
+	}, h.publish)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// ResumeUnfinished は前回の実行中に終了しなかったジョブを再開する。起動時に呼び出す。
+func (h *TransferHandler) ResumeUnfinished(ctx context.Context) {
+	n, err := h.service.ResumeUnfinished(ctx, h.publish)
+	if err != nil {
+		log.Printf("warning: failed to resume transfer jobs: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("resumed %d unfinished transfer jobs", n)
+	}
+}
+
+// publish はジョブの状態をアップロードと同じ進捗ストアに配信する。
+func (h *TransferHandler) publish(job domain.TransferJob) {
+	h.progressStore.RegisterIfAbsent(job.ID)
+
+	// プレビューは大きくなりうるため、進捗イベントには含めない
+	job.Preview = nil
+	event := domain.UploadEvent{EventType: domain.EventProgress, Data: job}
+	switch job.Status {
+	case domain.TransferCompleted, domain.TransferCanceled:
+		event.EventType = domain.EventComplete
+	case domain.TransferFailed:
+		event.EventType = domain.EventError
+	}
+	h.progressStore.Publish(job.ID, event)
+}
+
+// GetTransferJobs は保存している転送ジョブを返す。
+// GET /api/v1/transfers
+func (h *TransferHandler) GetTransferJobs(ctx *gin.Context) {
+	jobs, err := h.service.ListJobs(ctx.Request.Context())
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	if jobs == nil {
+		jobs = []domain.TransferJob{}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// GetTransferJob は転送ジョブの状態を返す。dry-run の場合は転送する予定のオブジェクトも返す。
+// GET /api/v1/transfers/:jobId
+func (h *TransferHandler) GetTransferJob(ctx *gin.Context) {
+	job, err := h.service.GetJob(ctx.Request.Context(), ctx.Param("jobId"))
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, job)
+}
+
+// CancelTransferJob は転送ジョブを中断する。転送済みのオブジェクトは残る。終了済みのジョブは 409 を返す。
+// DELETE /api/v1/transfers/:jobId
+func (h *TransferHandler) CancelTransferJob(ctx *gin.Context) {
+	if err := h.service.Cancel(ctx.Request.Context(), ctx.Param("jobId")); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// ResumeTransferJob は失敗・中断した転送ジョブをチェックポイントから再開する。
+// POST /api/v1/transfers/:jobId/resume
+func (h *TransferHandler) ResumeTransferJob(ctx *gin.Context) {
+	job, err := h.service.Resume(ctx.Request.Context(), ctx.Param("jobId"), h.publish)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// GetTransferProgress は SSE ストリームで転送ジョブの進捗を配信する。
+// GET /api/v1/transfers/:jobId/progress
+func (h *TransferHandler) GetTransferProgress(ctx *gin.Context) {
+	jobID := ctx.Param("jobId")
+	job, err := h.service.GetJob(ctx.Request.Context(), jobID)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	// 購読前に完了していても最後の状態を受け取れるよう、現在の状態を配信しておく
+	h.publish(*job)
+	streamProgress(ctx, h.progressStore, jobID)
+}
+
+func (h *TransferHandler) respondError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, serviceif.ErrTransferJobNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, serviceif.ErrInvalidTransferRequest), errors.Is(err, serviceif.ErrConnectionNotFound):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, serviceif.ErrTransferJobNotResumable), errors.Is(err, serviceif.ErrTransferJobNotRunning), errors.Is(err, serviceif.ErrConnectionBusy):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -3,8 +3,10 @@ package handler
 import (
 	"errors"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -24,6 +26,8 @@ func NewUploadHandler(service serviceif.UploadService, maxUploadSize int64, prog
 	return &UploadHandler{service: service, maxUploadSize: maxUploadSize, progressStore: progressStore}
 }
 
+// UploadObject は multipart/form-data の file パートをサーバーに保存せず、読み込みながら S3 へ送信する。
+// ファイルのサイズが分からない場合でも、大きなファイルはサービス側でマルチパートアップロードに切り替わる。
 func (h *UploadHandler) UploadObject(ctx *gin.Context) {
 	bucketName := ctx.Param("bucketName")
 	if bucketName == "" {
@@ -44,9 +48,24 @@ func (h *UploadHandler) UploadObject(ctx *gin.Context) {
 	if uploadID == "" {
 		uploadID = ctx.Query("upload_id")
 	}
+	publishError := func(message string) {
+		if uploadID != "" {
+			h.progressStore.Publish(uploadID, domain.UploadEvent{
+				EventType: domain.EventError,
+				Data:      domain.UploadError{UploadID: uploadID, Error: message},
+			})
+		}
+	}
+	tooLarge := func() {
+		publishError("file too large")
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":    "file too large",
+			"max_size": h.maxUploadSize,
+		})
+	}
 
 	// マルチパートのオーバーヘッド分を加算してボディサイズを制限する
-	// FormFile によるパース前に制限をかけることで、巨大リクエストによるリソース消費を防ぐ
+	// ストリーミングで読み込むため、上限を超えた時点で読み込みが失敗する
 	const multipartOverhead = 4096
 	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, h.maxUploadSize+multipartOverhead)
 
@@ -76,48 +95,32 @@ func (h *UploadHandler) UploadObject(ctx *gin.Context) {
 		ctx.Request.Body = progressReader
 	}
 
-	file, header, err := ctx.Request.FormFile("file")
+	file, err := nextFilePart(ctx.Request)
 	if err != nil {
 		var maxBytesErr *http.MaxBytesError
 		if errors.As(err, &maxBytesErr) {
-			if uploadID != "" {
-				h.progressStore.Publish(uploadID, domain.UploadEvent{
-					EventType: domain.EventError,
-					Data:      domain.UploadError{UploadID: uploadID, Error: "file too large"},
-				})
-			}
-			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
-				"error":    "file too large",
-				"max_size": h.maxUploadSize,
-			})
+			tooLarge()
 			return
 		}
-		if uploadID != "" {
-			h.progressStore.Publish(uploadID, domain.UploadEvent{
-				EventType: domain.EventError,
-				Data:      domain.UploadError{UploadID: uploadID, Error: "file is required"},
-			})
-		}
+		publishError("file is required")
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
 		return
 	}
 	defer file.Close()
 
-	if header.Size > h.maxUploadSize {
-		if uploadID != "" {
-			h.progressStore.Publish(uploadID, domain.UploadEvent{
-				EventType: domain.EventError,
-				Data:      domain.UploadError{UploadID: uploadID, Error: "file too large"},
-			})
-		}
-		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
-			"error":    "file too large",
-			"max_size": h.maxUploadSize,
-		})
+	// ファイルのサイズはクライアントが送った場合だけ分かる。分からない場合の合計は 0 とする
+	fileSize, err := declaredFileSize(ctx.Request, file)
+	if err != nil {
+		publishError(err.Error())
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if fileSize > h.maxUploadSize {
+		tooLarge()
 		return
 	}
 
-	contentType := detectContentType(header.Filename, header.Header.Get("Content-Type"))
+	contentType := detectContentType(file.FileName(), file.Header.Get("Content-Type"))
 
 	// Phase 2 のコールバックを準備
 	var uploadingCallback serviceif.ProgressCallback
@@ -129,20 +132,21 @@ func (h *UploadHandler) UploadObject(ctx *gin.Context) {
 					UploadID:       uploadID,
 					Phase:          domain.PhaseUploading,
 					BytesProcessed: bytesProcessed,
-					TotalBytes:     header.Size,
+					TotalBytes:     max(fileSize, 0),
 				},
 			})
 		}
 	}
 
-	result, err := h.service.UploadObject(ctx.Request.Context(), bucketName, key, contentType, file, header.Size, overwrite, uploadingCallback)
+	// 申告されたサイズは検証できないため、サービスにはサイズが分からないものとして渡す
+	result, err := h.service.UploadObject(ctx.Request.Context(), bucketName, key, contentType, file, -1, overwrite, uploadingCallback)
 	if err != nil {
-		if uploadID != "" {
-			h.progressStore.Publish(uploadID, domain.UploadEvent{
-				EventType: domain.EventError,
-				Data:      domain.UploadError{UploadID: uploadID, Error: err.Error()},
-			})
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			tooLarge()
+			return
 		}
+		publishError(err.Error())
 		if errors.Is(err, serviceif.ErrObjectAlreadyExists) {
 			ctx.JSON(http.StatusConflict, gin.H{"error": "object already exists", "code": "CONFLICT"})
 			return
@@ -161,6 +165,41 @@ func (h *UploadHandler) UploadObject(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, result)
 }
 
+// nextFilePart は multipart/form-data のボディを読み進め、file パートを返す。他のパートは読み捨てる。
+func nextFilePart(req *http.Request) (*multipart.Part, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// declaredFileSize はパートの Content-Length か X-Upload-Size ヘッダで申告されたファイルのサイズを返す。
+// どちらもない場合は -1 を返す。
+func declaredFileSize(req *http.Request, part *multipart.Part) (int64, error) {
+	v := part.Header.Get("Content-Length")
+	if v == "" {
+		v = req.Header.Get("X-Upload-Size")
+	}
+	if v == "" {
+		return -1, nil
+	}
+	size, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("invalid file size")
+	}
+	return size, nil
+}
+
 func (h *UploadHandler) CreateDirectory(ctx *gin.Context) {
 	bucketName := ctx.Param("bucketName")
 	if bucketName == "" {
@@ -29,12 +29,17 @@ func (h *UploadProgressHandler) GetUploadProgress(ctx *gin.Context) {
 		return
 	}
 
+	streamProgress(ctx, h.store, uploadID)
+}
+
+// streamProgress は id の進捗イベントを SSE で配信する。complete / error イベントを送ると終了する。
+func streamProgress(ctx *gin.Context, store *progress.UploadProgressStore, id string) {
 	ctx.Header("Content-Type", "text/event-stream")
 	ctx.Header("Cache-Control", "no-cache")
 	ctx.Header("Connection", "keep-alive")
 	ctx.Header("X-Accel-Buffering", "no")
 
-	eventCh, unsubscribe := h.store.Subscribe(uploadID)
+	eventCh, unsubscribe := store.Subscribe(id)
 	defer unsubscribe()
 
 	clientGone := ctx.Request.Context().Done()
@@ -0,0 +1,220 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+
+	"r2manager/domain"
+)
+
+// ErrUpstreamUnavailable は縮退中に S3 へのリクエストを送らずに失敗させたことを示す。
+var ErrUpstreamUnavailable error = unavailableError{}
+
+type unavailableError struct{}
+
+func (unavailableError) Error() string { return "upstream unavailable (degraded mode)" }
+
+// RetryableError は SDK のリトライを抑止する。縮退中は再試行しても送信されないため。
+func (unavailableError) RetryableError() bool { return false }
+
+// Monitor は S3 への接続状態を追跡する状態機械。
+//
+//	online   --(連続 failureThreshold 回失敗)--> degraded
+//	degraded --(バックオフ経過後のリクエスト)--> probing
+//	probing  --(成功)--> online
+//	probing  --(失敗)--> degraded (バックオフを倍にする)
+//
+// degraded の間は S3 へのリクエストを送らずに ErrUpstreamUnavailable を返す。
+// probing の間は確認中のリクエスト 1 件だけを送り、結果が返らないままバックオフを過ぎた場合は次の 1 件で確認し直す。
+type Monitor struct {
+	failureThreshold int
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	// now はテストで時刻を差し替えるために使う
+	now func() time.Time
+
+	mu                  sync.Mutex
+	mode                domain.UpstreamMode
+	consecutiveFailures int
+	backoff             time.Duration
+	lastError           string
+	lastFailureAt       time.Time
+	lastSuccessAt       time.Time
+	degradedSince       time.Time
+	nextProbeAt         time.Time
+	// probeDeadline を過ぎても確認中のリクエストの結果が返らない場合は、別のリクエストで確認する
+	probeDeadline time.Time
+}
+
+func NewMonitor(failureThreshold int, initialBackoff, maxBackoff time.Duration) *Monitor {
+	return &Monitor{
+		failureThreshold: max(failureThreshold, 1),
+		initialBackoff:   initialBackoff,
+		maxBackoff:       max(maxBackoff, initialBackoff),
+		now:              time.Now,
+		mode:             domain.UpstreamOnline,
+	}
+}
+
+// Allow はリクエストを S3 に送ってよいかを返す。縮退中はバックオフ経過後の 1 件だけを許可する。
+func (m *Monitor) Allow() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	switch m.mode {
+	case domain.UpstreamOnline:
+		return true
+	case domain.UpstreamDegraded:
+		if now.Before(m.nextProbeAt) {
+			return false
+		}
+		m.mode = domain.UpstreamProbing
+		log.Printf("upstream probing: backoff=%s", m.backoff)
+	default:
+		// 復旧確認中のリクエストが完了するまで他のリクエストは待たせずに失敗させる
+		if now.Before(m.probeDeadline) {
+			return false
+		}
+		log.Printf("upstream probe timed out, probing again: backoff=%s", m.backoff)
+	}
+	m.probeDeadline = now.Add(max(m.backoff, m.initialBackoff))
+	return true
+}
+
+func (m *Monitor) ReportSuccess() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mode != domain.UpstreamOnline {
+		log.Printf("upstream recovered: degraded for %s", time.Since(m.degradedSince).Round(time.Second))
+	}
+	m.mode = domain.UpstreamOnline
+	m.consecutiveFailures = 0
+	m.backoff = 0
+	m.lastSuccessAt = m.now().UTC()
+	m.degradedSince = time.Time{}
+	m.nextProbeAt = time.Time{}
+	m.probeDeadline = time.Time{}
+}
+
+func (m *Monitor) ReportFailure(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now().UTC()
+	m.consecutiveFailures++
+	m.lastError = err.Error()
+	m.lastFailureAt = now
+
+	switch m.mode {
+	case domain.UpstreamOnline:
+		if m.consecutiveFailures < m.failureThreshold {
+			return
+		}
+		m.mode = domain.UpstreamDegraded
+		m.degradedSince = now
+		m.backoff = m.initialBackoff
+		log.Printf("upstream degraded: %d consecutive failures: %v", m.consecutiveFailures, err)
+	case domain.UpstreamProbing:
+		m.mode = domain.UpstreamDegraded
+		m.backoff = min(m.backoff*2, m.maxBackoff)
+		log.Printf("upstream probe failed: %v", err)
+	default:
+		return
+	}
+	m.nextProbeAt = now.Add(m.backoff)
+}
+
+func (m *Monitor) Status() domain.UpstreamStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return domain.UpstreamStatus{
+		Mode:                m.mode,
+		ConsecutiveFailures: m.consecutiveFailures,
+		LastError:           m.lastError,
+		LastFailureAt:       timePtr(m.lastFailureAt),
+		LastSuccessAt:       timePtr(m.lastSuccessAt),
+		DegradedSince:       timePtr(m.degradedSince),
+		NextProbeAt:         timePtr(m.nextProbeAt),
+	}
+}
+
+// WrapHTTPClient は S3 クライアントの HTTP 通信を監視し、縮退中はリクエストを送らずに失敗させる。
+func (m *Monitor) WrapHTTPClient(client aws.HTTPClient) aws.HTTPClient {
+	return &monitoredClient{client: client, monitor: m}
+}
+
+type monitoredClient struct {
+	client  aws.HTTPClient
+	monitor *Monitor
+}
+
+func (c *monitoredClient) Do(req *http.Request) (*http.Response, error) {
+	if !c.monitor.Allow() {
+		return nil, ErrUpstreamUnavailable
+	}
+
+	resp, err := c.client.Do(req)
+	switch {
+	case err != nil:
+		// クライアント側のキャンセルは上流の障害ではない
+		if req.Context().Err() != nil {
+			c.monitor.release()
+			return nil, err
+		}
+		c.monitor.ReportFailure(err)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		c.monitor.ReportFailure(errors.New(resp.Status))
+	default:
+		c.monitor.ReportSuccess()
+	}
+	return resp, err
+}
+
+// release は復旧確認のリクエストが結果を得ずに終わった場合に、次のリクエストで再度確認できるよう戻す。
+func (m *Monitor) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mode == domain.UpstreamProbing {
+		m.mode = domain.UpstreamDegraded
+	}
+}
+
+// IsUpstreamFailure は err が S3 に到達できない・S3 側の障害によるものかを返す。
+// 404 や 403 など S3 が正常に応答したエラーは含まない。
+func IsUpstreamFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrUpstreamUnavailable) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
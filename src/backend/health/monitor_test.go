@@ -0,0 +1,198 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+)
+
+// fakeClock は Monitor の時刻を手動で進める。
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestMonitor(threshold int, initialBackoff, maxBackoff time.Duration) (*Monitor, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewMonitor(threshold, initialBackoff, maxBackoff)
+	m.now = clock.now
+	return m, clock
+}
+
+func TestMonitor_StateTransitions(t *testing.T) {
+	m, clock := newTestMonitor(2, time.Second, 4*time.Second)
+	boom := errors.New("boom")
+
+	// online: しきい値に達するまでは送り続ける
+	if !m.Allow() {
+		t.Fatal("expected requests to be allowed while online")
+	}
+	m.ReportFailure(boom)
+	if got := m.Status(); got.Mode != domain.UpstreamOnline || got.ConsecutiveFailures != 1 {
+		t.Fatalf("expected online with 1 failure, got %+v", got)
+	}
+
+	// degraded: 連続して失敗したらバックオフの間は送らない
+	m.ReportFailure(boom)
+	if got := m.Status(); got.Mode != domain.UpstreamDegraded || got.DegradedSince == nil || got.NextProbeAt == nil {
+		t.Fatalf("expected degraded, got %+v", got)
+	}
+	if m.Allow() {
+		t.Fatal("expected requests to be rejected during backoff")
+	}
+
+	// probing: バックオフが過ぎたら 1 件だけ確認のために送る
+	clock.advance(time.Second)
+	if !m.Allow() {
+		t.Fatal("expected a probe after the backoff")
+	}
+	if got := m.Status(); got.Mode != domain.UpstreamProbing {
+		t.Fatalf("expected probing, got %s", got.Mode)
+	}
+	if m.Allow() {
+		t.Fatal("expected other requests to be rejected while probing")
+	}
+
+	// 確認に失敗したらバックオフを倍にして degraded に戻る
+	m.ReportFailure(boom)
+	if got := m.Status(); got.Mode != domain.UpstreamDegraded || !got.NextProbeAt.Equal(clock.now().Add(2*time.Second)) {
+		t.Fatalf("expected degraded with a doubled backoff, got %+v", got)
+	}
+	clock.advance(time.Second)
+	if m.Allow() {
+		t.Fatal("expected requests to be rejected until the doubled backoff passes")
+	}
+
+	// 確認に成功したら online に戻る
+	clock.advance(time.Second)
+	if !m.Allow() {
+		t.Fatal("expected a probe after the doubled backoff")
+	}
+	m.ReportSuccess()
+	got := m.Status()
+	if got.Mode != domain.UpstreamOnline || got.ConsecutiveFailures != 0 || got.DegradedSince != nil || got.NextProbeAt != nil {
+		t.Fatalf("expected recovery to reset the state, got %+v", got)
+	}
+	if !m.Allow() || !m.Allow() {
+		t.Error("expected requests to be allowed after recovery")
+	}
+}
+
+func TestMonitor_BackoffIsCapped(t *testing.T) {
+	m, clock := newTestMonitor(1, time.Second, 3*time.Second)
+	boom := errors.New("boom")
+
+	m.ReportFailure(boom)
+	for _, want := range []time.Duration{2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		clock.advance(time.Hour)
+		if !m.Allow() {
+			t.Fatal("expected a probe after the backoff")
+		}
+		m.ReportFailure(boom)
+		if got := m.Status().NextProbeAt; !got.Equal(clock.now().Add(want)) {
+			t.Errorf("expected next probe in %s, got %s", want, got.Sub(clock.now()))
+		}
+	}
+}
+
+func TestMonitor_ProbesAgainWhenProbeDoesNotFinish(t *testing.T) {
+	m, clock := newTestMonitor(1, time.Second, time.Minute)
+	m.ReportFailure(errors.New("boom"))
+	clock.advance(time.Second)
+
+	if !m.Allow() {
+		t.Fatal("expected a probe after the backoff")
+	}
+	if m.Allow() {
+		t.Fatal("expected other requests to be rejected while the probe is in flight")
+	}
+
+	// 確認中のリクエストの結果が返らなくても、次の 1 件で確認し直す
+	clock.advance(time.Second)
+	if !m.Allow() {
+		t.Fatal("expected another probe once the previous one timed out")
+	}
+	if m.Allow() {
+		t.Error("expected only a single probe at a time")
+	}
+}
+
+func TestMonitor_ReleaseAllowsNextProbe(t *testing.T) {
+	m, clock := newTestMonitor(1, time.Second, time.Minute)
+	m.ReportFailure(errors.New("boom"))
+	clock.advance(time.Second)
+
+	if !m.Allow() {
+		t.Fatal("expected a probe after the backoff")
+	}
+	m.release()
+	if got := m.Status().Mode; got != domain.UpstreamDegraded {
+		t.Fatalf("expected degraded after release, got %s", got)
+	}
+	if !m.Allow() {
+		t.Error("expected the next request to probe after a released probe")
+	}
+}
+
+type fakeHTTPClient struct {
+	status int
+	err    error
+	calls  int
+}
+
+func (c *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &http.Response{StatusCode: c.status, Body: http.NoBody}, nil
+}
+
+func TestMonitoredClient(t *testing.T) {
+	m, clock := newTestMonitor(2, time.Second, time.Minute)
+	upstream := &fakeHTTPClient{status: http.StatusServiceUnavailable}
+	client := m.WrapHTTPClient(upstream)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	// 5xx は障害として数え、しきい値に達したら送らずに失敗させる
+	for range 2 {
+		if _, err := client.Do(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := client.Do(req); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("expected ErrUpstreamUnavailable while degraded, got %v", err)
+	}
+	if upstream.calls != 2 {
+		t.Errorf("expected no request to be sent while degraded, got %d calls", upstream.calls)
+	}
+
+	// クライアント側のキャンセルは障害として数えず、確認をやり直せるようにする
+	clock.advance(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	upstream.err = context.Canceled
+	if _, err := client.Do(req.WithContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the canceled error, got %v", err)
+	}
+	if got := m.Status(); got.Mode != domain.UpstreamDegraded || got.ConsecutiveFailures != 2 {
+		t.Fatalf("expected a canceled probe not to count as a failure, got %+v", got)
+	}
+
+	// 4xx は S3 が応答しているため復旧とみなす
+	upstream.err = nil
+	upstream.status = http.StatusNotFound
+	if _, err := client.Do(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := m.Status().Mode; got != domain.UpstreamOnline {
+		t.Errorf("expected online after a successful probe, got %s", got)
+	}
+}
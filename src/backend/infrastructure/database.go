@@ -18,15 +18,144 @@ CREATE TABLE IF NOT EXISTS cache_entries (
     cache_path   TEXT NOT NULL,
     cached_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
     expires_at   DATETIME NOT NULL,
+    last_accessed_at DATETIME,
+    hit_count    INTEGER NOT NULL DEFAULT 0,
+    priority     REAL NOT NULL DEFAULT 0,
+    sha256       TEXT NOT NULL DEFAULT '',
+    disk_size    INTEGER NOT NULL DEFAULT 0,
+    encoding     TEXT NOT NULL DEFAULT '',
     PRIMARY KEY (bucket_name, object_key)
 );
 CREATE INDEX IF NOT EXISTS idx_cache_entries_expires_at ON cache_entries(expires_at);
 CREATE TABLE IF NOT EXISTS bucket_settings (
     bucket_name TEXT NOT NULL PRIMARY KEY,
-    public_url  TEXT NOT NULL DEFAULT ''
+    public_url  TEXT NOT NULL DEFAULT '',
+    cache_ttl_seconds   INTEGER NOT NULL DEFAULT 0,
+    cache_max_size      INTEGER NOT NULL DEFAULT 0,
+    cache_never         INTEGER NOT NULL DEFAULT 0,
+    cache_pinned        INTEGER NOT NULL DEFAULT 0,
+    cache_include_types TEXT NOT NULL DEFAULT '',
+    cache_exclude_types TEXT NOT NULL DEFAULT ''
 );
+CREATE TABLE IF NOT EXISTS resumable_uploads (
+    id            TEXT NOT NULL PRIMARY KEY,
+    bucket_name   TEXT NOT NULL,
+    object_key    TEXT NOT NULL,
+    content_type  TEXT NOT NULL DEFAULT 'application/octet-stream',
+    overwrite     INTEGER NOT NULL DEFAULT 0,
+    upload_length INTEGER NOT NULL,
+    upload_offset INTEGER NOT NULL DEFAULT 0,
+    s3_upload_id  TEXT NOT NULL DEFAULT '',
+    etag          TEXT NOT NULL DEFAULT '',
+    created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at  DATETIME
+);
+CREATE TABLE IF NOT EXISTS resumable_upload_parts (
+    upload_id   TEXT NOT NULL,
+    part_number INTEGER NOT NULL,
+    etag        TEXT NOT NULL,
+    size        INTEGER NOT NULL,
+    PRIMARY KEY (upload_id, part_number)
+);
+CREATE TABLE IF NOT EXISTS presigned_urls (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    bucket_name         TEXT NOT NULL,
+    object_key          TEXT NOT NULL,
+    method              TEXT NOT NULL,
+    issued_by           TEXT NOT NULL DEFAULT '',
+    content_disposition TEXT NOT NULL DEFAULT '',
+    issued_at           DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at          DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_presigned_urls_issued_at ON presigned_urls(issued_at);
+CREATE TABLE IF NOT EXISTS list_cache_entries (
+    kind        TEXT NOT NULL,
+    cache_key   TEXT NOT NULL,
+    bucket_name TEXT NOT NULL DEFAULT '',
+    payload     TEXT NOT NULL,
+    expires_at  DATETIME NOT NULL,
+    PRIMARY KEY (kind, cache_key)
+);
+CREATE INDEX IF NOT EXISTS idx_list_cache_entries_bucket ON list_cache_entries(kind, bucket_name);
+CREATE INDEX IF NOT EXISTS idx_list_cache_entries_expires_at ON list_cache_entries(expires_at);
+CREATE TABLE IF NOT EXISTS connections (
+    name                 TEXT NOT NULL PRIMARY KEY,
+    account_id           TEXT NOT NULL DEFAULT '',
+    endpoint             TEXT NOT NULL DEFAULT '',
+    region               TEXT NOT NULL DEFAULT '',
+    use_path_style       INTEGER NOT NULL DEFAULT 0,
+    ca_bundle            TEXT NOT NULL DEFAULT '',
+    insecure_skip_verify INTEGER NOT NULL DEFAULT 0,
+    credentials_ref      TEXT NOT NULL DEFAULT '',
+    default_bucket       TEXT NOT NULL DEFAULT '',
+    created_at           DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at           DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS transfer_jobs (
+    id                 TEXT NOT NULL PRIMARY KEY,
+    source_connection  TEXT NOT NULL,
+    source_bucket      TEXT NOT NULL,
+    source_prefix      TEXT NOT NULL DEFAULT '',
+    dest_connection    TEXT NOT NULL,
+    dest_bucket        TEXT NOT NULL,
+    dest_prefix        TEXT NOT NULL DEFAULT '',
+    include_patterns   TEXT NOT NULL DEFAULT '',
+    exclude_patterns   TEXT NOT NULL DEFAULT '',
+    skip_same_etag     INTEGER NOT NULL DEFAULT 0,
+    dry_run            INTEGER NOT NULL DEFAULT 0,
+    concurrency        INTEGER NOT NULL DEFAULT 1,
+    mode               TEXT NOT NULL DEFAULT '',
+    status             TEXT NOT NULL,
+    total              INTEGER NOT NULL DEFAULT 0,
+    copied             INTEGER NOT NULL DEFAULT 0,
+    skipped            INTEGER NOT NULL DEFAULT 0,
+    failed             INTEGER NOT NULL DEFAULT 0,
+    bytes_total        INTEGER NOT NULL DEFAULT 0,
+    bytes_copied       INTEGER NOT NULL DEFAULT 0,
+    checkpoint         TEXT NOT NULL DEFAULT '',
+    checkpoint_copied       INTEGER NOT NULL DEFAULT 0,
+    checkpoint_skipped      INTEGER NOT NULL DEFAULT 0,
+    checkpoint_bytes_copied INTEGER NOT NULL DEFAULT 0,
+    failures           TEXT NOT NULL DEFAULT '[]',
+    error              TEXT NOT NULL DEFAULT '',
+    created_at         DATETIME NOT NULL,
+    started_at         DATETIME,
+    finished_at        DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_transfer_jobs_status ON transfer_jobs(status);
 `
 
+// columnMigrations は既存のデータベースに後から追加した列。
+// CREATE TABLE IF NOT EXISTS では既存のテーブルに列が追加されないため、起動時に不足分を追加する。
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"cache_entries", "last_accessed_at", "DATETIME"},
+	{"cache_entries", "hit_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"cache_entries", "priority", "REAL NOT NULL DEFAULT 0"},
+	{"cache_entries", "sha256", "TEXT NOT NULL DEFAULT ''"},
+	{"cache_entries", "disk_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"cache_entries", "encoding", "TEXT NOT NULL DEFAULT ''"},
+	{"bucket_settings", "cache_ttl_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"bucket_settings", "cache_max_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"bucket_settings", "cache_never", "INTEGER NOT NULL DEFAULT 0"},
+	{"bucket_settings", "cache_pinned", "INTEGER NOT NULL DEFAULT 0"},
+	{"bucket_settings", "cache_include_types", "TEXT NOT NULL DEFAULT ''"},
+	{"bucket_settings", "cache_exclude_types", "TEXT NOT NULL DEFAULT ''"},
+	{"transfer_jobs", "checkpoint_copied", "INTEGER NOT NULL DEFAULT 0"},
+	{"transfer_jobs", "checkpoint_skipped", "INTEGER NOT NULL DEFAULT 0"},
+	{"transfer_jobs", "checkpoint_bytes_copied", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// columnBackfills は列を追加した直後に既存の行へ値を設定する SQL。
+var columnBackfills = map[string]string{
+	// 圧縮に対応する前のキャッシュファイルは非圧縮のため、ディスク上のサイズは論理サイズと同じ
+	"cache_entries.disk_size": "UPDATE cache_entries SET disk_size = size",
+}
+
 func NewSQLiteDB(dbPath string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
@@ -43,5 +172,50 @@ func NewSQLiteDB(dbPath string) (*sql.DB, error) {
 		return nil, errors.Wrap(err, "failed to run schema migration")
 	}
 
+	if err := migrateColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return db, nil
 }
+
+func migrateColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		exists, err := columnExists(db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + m.table + " ADD COLUMN " + m.column + " " + m.definition); err != nil {
+			return errors.Wrapf(err, "failed to add column %s.%s", m.table, m.column)
+		}
+		if backfill, ok := columnBackfills[m.table+"."+m.column]; ok {
+			if _, err := db.Exec(backfill); err != nil {
+				return errors.Wrapf(err, "failed to backfill column %s.%s", m.table, m.column)
+			}
+		}
+	}
+	return nil
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to query columns of %s", table)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, errors.Wrapf(err, "failed to scan columns of %s", table)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -8,6 +8,8 @@ import (
 
 	appconfig "r2manager/config"
 	"r2manager/di"
+	"r2manager/domain"
+	"r2manager/health"
 	"r2manager/infrastructure"
 	"r2manager/progress"
 	"r2manager/repository"
@@ -20,7 +22,11 @@ func main() {
 		log.Fatal(err)
 	}
 
-	s3Client, err := appconfig.NewS3Client(context.Background(), r2cfg)
+	// Upstream health monitor
+	healthCfg := appconfig.LoadHealthConfigFromEnv()
+	monitor := health.NewMonitor(healthCfg.FailureThreshold, healthCfg.InitialBackoff, healthCfg.MaxBackoff)
+
+	s3Client, err := appconfig.NewS3Client(context.Background(), r2cfg, monitor)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -42,38 +48,45 @@ func main() {
 	defer db.Close()
 
 	// List cache (shared between buckets and objects)
-	listCache := repository.NewListCacheRepository()
+	listCache := repository.NewListCacheRepository(repository.WithListCacheDB(db))
 
 	// Upload config
 	uploadCfg := appconfig.LoadUploadConfigFromEnv()
 
+	if err := os.MkdirAll(uploadCfg.ResumableDir, 0755); err != nil {
+		log.Fatalf("failed to create resumable upload directory: %v", err)
+	}
+
+	// Presign config
+	presignCfg := appconfig.LoadPresignConfigFromEnv()
+
 	// Progress store
 	progressStore := progress.NewUploadProgressStore()
 
-	// DI wiring
-	bh := di.CreateBucketsHandler(s3Client, listCache)
-	oh := di.CreateObjectsHandler(s3Client, db, cacheCfg, listCache)
-	ch := di.CreateContentHandler(s3Client, db, cacheCfg)
-	cah := di.CreateCacheHandler(db, cacheCfg, listCache)
-	sh := di.CreateSettingsHandler(db)
-	uh := di.CreateUploadHandler(s3Client, listCache, uploadCfg, progressStore)
-	uph := di.CreateUploadProgressHandler(progressStore)
-
-	// Start background cache cleanup
-	var opts []repository.CacheOption
-	if cacheCfg.MaxCacheSize > 0 {
-		opts = append(opts, repository.WithMaxCacheSize(cacheCfg.MaxCacheSize))
-	}
-	cacheRepo := repository.NewCacheRepository(db, cacheCfg.CacheDir, cacheCfg.TTL, opts...)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	cacheRepo.StartCleanupLoop(ctx, cacheCfg.CleanupInterval)
+
+	// Connections: the env-based default plus connections stored in SQLite, each with its own DB and cache directory
+	connCfg := appconfig.LoadConnectionConfigFromEnv()
+	registry := di.NewConnectionRegistry(ctx, db, connCfg, cacheCfg, uploadCfg, presignCfg, healthCfg, progressStore)
+	handlers := registry.RegisterDefault(r2cfg, s3Client, monitor, db, listCache)
+	connectionsHandler := di.CreateConnectionsHandler(registry, r2cfg)
+
+	// Transfer jobs between buckets and connections
+	transferCfg := appconfig.LoadTransferConfigFromEnv()
+	transferHandler := di.CreateTransferHandler(registry, db, transferCfg, progressStore)
+
+	// Start background cache cleanup, reconcile and persisted list cache cleanup
+	di.StartCacheMaintenance(ctx, domain.DefaultConnectionName, db, cacheCfg, listCache)
 
 	// Start progress store cleanup
 	progressStore.StartCleanupLoop(ctx)
 
+	// Resume transfer jobs interrupted by the previous shutdown
+	transferHandler.ResumeUnfinished(ctx)
+
 	// Start server
-	r := router.NewRouter(bh, oh, ch, cah, sh, uh, uph)
+	r := router.NewRouter(handlers, connectionsHandler, transferHandler)
 	if err := r.Run(":8080"); err != nil {
 		log.Fatalf("failed to start server: %v", err)
 	}
@@ -85,6 +85,20 @@ func (s *UploadProgressStore) Register(uploadID string) {
 	}
 }
 
+// RegisterIfAbsent はエントリが存在しない場合のみ作成する。
+// 再起動を跨いで再開されるアップロードのように、既存の subscriber を維持したい場合に使用する。
+func (s *UploadProgressStore) RegisterIfAbsent(uploadID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[uploadID]; ok {
+		return
+	}
+	s.entries[uploadID] = &uploadEntry{
+		uploadID:  uploadID,
+		createdAt: time.Now(),
+	}
+}
+
 // Publish は進捗イベントをストアに記録し、全subscriberに配信する。
 func (s *UploadProgressStore) Publish(uploadID string, event domain.UploadEvent) {
 	s.mu.RLock()
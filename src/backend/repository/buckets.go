@@ -3,10 +3,14 @@ package repository
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/pkg/errors"
 
 	"r2manager/domain"
+	serviceif "r2manager/service/interface"
 )
 
 type BucketRepository struct {
@@ -37,3 +41,265 @@ func (r *BucketRepository) GetBuckets(ctx context.Context) ([]domain.Bucket, err
 
 	return buckets, nil
 }
+
+// CreateBucket はクライアントのリージョンにバケットを作成する。
+// AWS S3 では us-east-1 以外のリージョンに LocationConstraint が必要なため、R2 の "auto" と us-east-1 以外で指定する。
+func (r *BucketRepository) CreateBucket(ctx context.Context, bucketName string) error {
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	}
+	if region := r.client.Options().Region; region != "" && region != "auto" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(region),
+		}
+	}
+
+	if _, err := r.client.CreateBucket(ctx, input); err != nil {
+		switch bucketErrorCode(err) {
+		case "BucketAlreadyExists", "BucketAlreadyOwnedByYou":
+			return serviceif.ErrBucketAlreadyExists
+		}
+		return errors.Wrap(err, "failed to CreateBucket")
+	}
+	return nil
+}
+
+func (r *BucketRepository) DeleteBucket(ctx context.Context, bucketName string) error {
+	_, err := r.client.DeleteBucket(ctx, &s3.DeleteBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		switch bucketErrorCode(err) {
+		case "NoSuchBucket":
+			return serviceif.ErrBucketNotFound
+		case "BucketNotEmpty":
+			return serviceif.ErrBucketNotEmpty
+		}
+		return errors.Wrap(err, "failed to DeleteBucket")
+	}
+	return nil
+}
+
+// AbortMultipartUploads はバケットの完了していないマルチパートアップロードをすべて中止し、中止した数を返す。
+// 途中のパートが残っているとバケットを削除できないため、削除の前に呼ぶ。
+func (r *BucketRepository) AbortMultipartUploads(ctx context.Context, bucketName string) (int, error) {
+	aborted := 0
+	paginator := s3.NewListMultipartUploadsPaginator(r.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucketName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			if bucketErrorCode(err) == "NoSuchBucket" {
+				return aborted, serviceif.ErrBucketNotFound
+			}
+			return aborted, errors.Wrap(err, "failed to ListMultipartUploads")
+		}
+		for _, upload := range page.Uploads {
+			_, err := r.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucketName),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			// 並行して完了・中止されたアップロードは無視する
+			if err != nil && bucketErrorCode(err) != "NoSuchUpload" {
+				return aborted, errors.Wrap(err, "failed to AbortMultipartUpload")
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
+}
+
+func (r *BucketRepository) GetBucketCORS(ctx context.Context, bucketName string) ([]domain.CORSRule, error) {
+	output, err := r.client.GetBucketCors(ctx, &s3.GetBucketCorsInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		switch bucketErrorCode(err) {
+		case "NoSuchCORSConfiguration":
+			return []domain.CORSRule{}, nil
+		case "NoSuchBucket":
+			return nil, serviceif.ErrBucketNotFound
+		}
+		return nil, errors.Wrap(err, "failed to GetBucketCors")
+	}
+
+	rules := make([]domain.CORSRule, 0, len(output.CORSRules))
+	for _, c := range output.CORSRules {
+		rule := domain.CORSRule{
+			AllowedOrigins: c.AllowedOrigins,
+			AllowedMethods: c.AllowedMethods,
+			AllowedHeaders: c.AllowedHeaders,
+			ExposeHeaders:  c.ExposeHeaders,
+		}
+		if c.ID != nil {
+			rule.ID = *c.ID
+		}
+		if c.MaxAgeSeconds != nil {
+			rule.MaxAgeSeconds = *c.MaxAgeSeconds
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (r *BucketRepository) PutBucketCORS(ctx context.Context, bucketName string, rules []domain.CORSRule) error {
+	if len(rules) == 0 {
+		_, err := r.client.DeleteBucketCors(ctx, &s3.DeleteBucketCorsInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			if bucketErrorCode(err) == "NoSuchBucket" {
+				return serviceif.ErrBucketNotFound
+			}
+			return errors.Wrap(err, "failed to DeleteBucketCors")
+		}
+		return nil
+	}
+
+	corsRules := make([]types.CORSRule, 0, len(rules))
+	for _, rule := range rules {
+		c := types.CORSRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			ExposeHeaders:  rule.ExposeHeaders,
+		}
+		if rule.ID != "" {
+			c.ID = aws.String(rule.ID)
+		}
+		if rule.MaxAgeSeconds > 0 {
+			c.MaxAgeSeconds = aws.Int32(rule.MaxAgeSeconds)
+		}
+		corsRules = append(corsRules, c)
+	}
+
+	_, err := r.client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+		Bucket:            aws.String(bucketName),
+		CORSConfiguration: &types.CORSConfiguration{CORSRules: corsRules},
+	})
+	if err != nil {
+		if bucketErrorCode(err) == "NoSuchBucket" {
+			return serviceif.ErrBucketNotFound
+		}
+		return errors.Wrap(err, "failed to PutBucketCors")
+	}
+	return nil
+}
+
+func (r *BucketRepository) GetBucketLifecycle(ctx context.Context, bucketName string) ([]domain.LifecycleRule, error) {
+	output, err := r.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		switch bucketErrorCode(err) {
+		case "NoSuchLifecycleConfiguration":
+			return []domain.LifecycleRule{}, nil
+		case "NoSuchBucket":
+			return nil, serviceif.ErrBucketNotFound
+		}
+		return nil, errors.Wrap(err, "failed to GetBucketLifecycleConfiguration")
+	}
+
+	rules := make([]domain.LifecycleRule, 0, len(output.Rules))
+	for _, l := range output.Rules {
+		rule := domain.LifecycleRule{
+			Enabled: l.Status == types.ExpirationStatusEnabled,
+		}
+		if l.ID != nil {
+			rule.ID = *l.ID
+		}
+		// 旧形式の Prefix 要素にも対応する
+		if l.Filter != nil && l.Filter.Prefix != nil {
+			rule.Prefix = *l.Filter.Prefix
+		} else if l.Prefix != nil {
+			rule.Prefix = *l.Prefix
+		}
+		if e := l.Expiration; e != nil {
+			if e.Days != nil {
+				rule.ExpirationDays = *e.Days
+			}
+			rule.ExpirationDate = e.Date
+		}
+		if a := l.AbortIncompleteMultipartUpload; a != nil && a.DaysAfterInitiation != nil {
+			rule.AbortIncompleteMultipartUploadDays = *a.DaysAfterInitiation
+		}
+		for _, t := range l.Transitions {
+			transition := domain.LifecycleTransition{StorageClass: string(t.StorageClass)}
+			if t.Days != nil {
+				transition.Days = *t.Days
+			}
+			rule.Transitions = append(rule.Transitions, transition)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (r *BucketRepository) PutBucketLifecycle(ctx context.Context, bucketName string, rules []domain.LifecycleRule) error {
+	if len(rules) == 0 {
+		_, err := r.client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			if bucketErrorCode(err) == "NoSuchBucket" {
+				return serviceif.ErrBucketNotFound
+			}
+			return errors.Wrap(err, "failed to DeleteBucketLifecycle")
+		}
+		return nil
+	}
+
+	lifecycleRules := make([]types.LifecycleRule, 0, len(rules))
+	for _, rule := range rules {
+		l := types.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Status: types.ExpirationStatusDisabled,
+			Filter: &types.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)},
+		}
+		if rule.Enabled {
+			l.Status = types.ExpirationStatusEnabled
+		}
+		if rule.ExpirationDays > 0 {
+			l.Expiration = &types.LifecycleExpiration{Days: aws.Int32(rule.ExpirationDays)}
+		} else if rule.ExpirationDate != nil {
+			l.Expiration = &types.LifecycleExpiration{Date: rule.ExpirationDate}
+		}
+		if rule.AbortIncompleteMultipartUploadDays > 0 {
+			l.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int32(rule.AbortIncompleteMultipartUploadDays),
+			}
+		}
+		for _, t := range rule.Transitions {
+			l.Transitions = append(l.Transitions, types.Transition{
+				Days:         aws.Int32(t.Days),
+				StorageClass: types.TransitionStorageClass(t.StorageClass),
+			})
+		}
+		lifecycleRules = append(lifecycleRules, l)
+	}
+
+	_, err := r.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucketName),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: lifecycleRules},
+	})
+	if err != nil {
+		if bucketErrorCode(err) == "NoSuchBucket" {
+			return serviceif.ErrBucketNotFound
+		}
+		return errors.Wrap(err, "failed to PutBucketLifecycleConfiguration")
+	}
+	return nil
+}
+
+// bucketErrorCode は S3 のエラーコードを返す。API のエラーでない場合は空文字列を返す。
+func bucketErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
@@ -24,33 +25,83 @@ type CacheRepository struct {
 	cacheDirAbs  string
 	ttl          time.Duration
 	maxCacheSize int64
+
+	// inflight は書き込み中のストリーミングキャッシュ。同じオブジェクトへの後続リクエストはここに合流する
+	inflightMu sync.Mutex
+	inflight   map[string]*inflightDownload
+
+	counters cacheCounters
+
+	policy        EvictionPolicy
+	bucketWeights map[string]float64
+
+	// staleRetention は有効期限切れのエントリを再検証のために残しておく期間
+	staleRetention time.Duration
+
+	// policies はバケットごとのキャッシュ設定 (bucket_settings)。読み込んだ設定は policyCache に保持する
+	policies    *SettingsRepository
+	policyCache *policyCache
+
+	// reconcileMu は Reconcile の同時実行を防ぐ
+	reconcileMu sync.Mutex
+
+	// compression はキャッシュファイルの圧縮設定。nil の場合は圧縮しない
+	compression *cacheCompression
+
+	// memory は小さなエントリをメモリ上に保持する層。nil の場合は使わない
+	memory *memoryTier
 }
 
 var (
-	once sync.Once
-	repo *CacheRepository
+	reposMu sync.Mutex
+	// repos はキャッシュディレクトリの絶対パスごとのインスタンス。同じディレクトリを使うハンドラ間で進行中のダウンロードなどを共有する
+	repos = make(map[string]*CacheRepository)
 )
 
 func NewCacheRepository(db *sql.DB, cacheDir string, ttl time.Duration, opts ...CacheOption) *CacheRepository {
-	once.Do(func() {
-		absDir, err := filepath.Abs(cacheDir)
-		if err != nil {
-			// Fallback to the provided cacheDir if Abs fails for some reason.
-			absDir = cacheDir
-		}
-		repo = &CacheRepository{
-			db:          db,
-			cacheDir:    cacheDir,
-			cacheDirAbs: absDir,
-			ttl:         ttl,
-		}
-		for _, opt := range opts {
-			opt(repo)
-		}
-	})
+	absDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		// Fallback to the provided cacheDir if Abs fails for some reason.
+		absDir = cacheDir
+	}
+
+	reposMu.Lock()
+	defer reposMu.Unlock()
+
+	if repo, ok := repos[absDir]; ok {
+		return repo
+	}
+	repo := &CacheRepository{
+		db:          db,
+		cacheDir:    cacheDir,
+		cacheDirAbs: absDir,
+		ttl:         ttl,
+		inflight:    make(map[string]*inflightDownload),
+		policy:      fifoPolicy{},
+		policies:    NewSettingsRepository(db),
+		policyCache: newPolicyCache(),
+	}
+	for _, opt := range opts {
+		opt(repo)
+	}
+	repo.initPolicy()
+	repos[absDir] = repo
 	return repo
 }
 
+// ReleaseCacheRepository は cacheDir のインスタンスを破棄する。削除した接続のキャッシュに使う。
+// 以降の NewCacheRepository は新しいインスタンスを作成する。
+func ReleaseCacheRepository(cacheDir string) {
+	absDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		absDir = cacheDir
+	}
+
+	reposMu.Lock()
+	defer reposMu.Unlock()
+	delete(repos, absDir)
+}
+
 type CacheOption func(*CacheRepository)
 
 func WithMaxCacheSize(size int64) CacheOption {
@@ -59,32 +110,166 @@ func WithMaxCacheSize(size int64) CacheOption {
 	}
 }
 
-func (r *CacheRepository) Lookup(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error) {
+// WithStaleRetention は有効期限切れのエントリを削除せずに残す期間を設定する。
+// 残したエントリは再検証で変更がなければそのまま再利用される。
+func WithStaleRetention(d time.Duration) CacheOption {
+	return func(r *CacheRepository) {
+		r.staleRetention = d
+	}
+}
+
+// initPolicy は優先度を使うポリシーの状態を保存済みのエントリから復元する。
+func (r *CacheRepository) initPolicy() {
+	p, ok := r.policy.(priorityPolicy)
+	if !ok {
+		return
+	}
+	var minPriority float64
+	if err := r.db.QueryRow(`SELECT COALESCE(MIN(priority), 0) FROM cache_entries`).Scan(&minPriority); err != nil {
+		log.Printf("warning: failed to load cache priorities: %v", err)
+		return
+	}
+	p.Init(minPriority)
+}
+
+// Lookup は有効期限内のエントリを検索し、キャッシュファイルを開いたボディとともに返す。呼び出し側はボディを閉じること。
+// キャッシュファイルが失われている場合はミスとして扱う。
+func (r *CacheRepository) Lookup(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, io.ReadCloser, error) {
+	if entry, body := r.lookupMemory(bucketName, objectKey); entry != nil {
+		return entry, body, nil
+	}
+	var load *memoryLoad
+	if r.memory != nil {
+		load = r.memory.beginLoad(bucketName, r.cachePath(bucketName, objectKey))
+		defer r.memory.endLoad(load)
+	}
+
 	row := r.db.QueryRowContext(ctx,
-		`SELECT bucket_name, object_key, content_type, size, etag, cache_path, cached_at, expires_at
+		`SELECT bucket_name, object_key, content_type, size, disk_size, encoding, etag, cache_path, cached_at, expires_at, last_accessed_at, hit_count
 		 FROM cache_entries
 		 WHERE bucket_name = ? AND object_key = ? AND expires_at > ?`,
 		bucketName, objectKey, time.Now().UTC(),
 	)
 
+	entry, err := scanCacheEntry(row)
+	if err == sql.ErrNoRows {
+		r.counters.misses.Add(1)
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to lookup cache entry")
+	}
+
+	// キャッシュファイルが失われていればヒットとして数えず、S3 から取得し直させる
+	f, err := os.Open(entry.CachePath)
+	if err != nil {
+		r.counters.misses.Add(1)
+		return nil, nil, nil
+	}
+
+	r.counters.hits.Add(1)
+	if err := r.touch(ctx, entry); err != nil {
+		log.Printf("warning: failed to record cache access: bucket=%s key=%s: %v", bucketName, objectKey, err)
+	}
+	r.promoteToMemory(entry, load, f)
+
+	return entry, f, nil
+}
+
+// LookupStale は有効期限切れのエントリも含めて検索する。期限切れのエントリは再検証に使う。
+func (r *CacheRepository) LookupStale(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT bucket_name, object_key, content_type, size, disk_size, encoding, etag, cache_path, cached_at, expires_at, last_accessed_at, hit_count
+		 FROM cache_entries
+		 WHERE bucket_name = ? AND object_key = ?`,
+		bucketName, objectKey,
+	)
+
+	entry, err := scanCacheEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to lookup cache entry")
+	}
+
+	return entry, nil
+}
+
+// Extend は再検証でオブジェクトが変更されていないと確認できたエントリの有効期限を延長する。
+// 再検証中に別の内容で上書きされていた場合は延長せず nil を返す。
+func (r *CacheRepository) Extend(ctx context.Context, bucketName, objectKey, etag string) (*domain.CacheEntry, error) {
+	expiresAt := r.expiresAt(r.bucketPolicy(ctx, bucketName), time.Now().UTC())
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE cache_entries SET expires_at = ? WHERE bucket_name = ? AND object_key = ? AND etag = ?`,
+		expiresAt, bucketName, objectKey, etag,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to extend cache entry")
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, nil
+	}
+	r.forgetMemory(r.cachePath(bucketName, objectKey))
+
+	r.counters.revalidations.Add(1)
+	return r.LookupStale(ctx, bucketName, objectKey)
+}
+
+// touch はキャッシュヒット時に最終アクセス日時とヒット数を更新する。
+func (r *CacheRepository) touch(ctx context.Context, entry *domain.CacheEntry) error {
+	now := time.Now().UTC()
+
+	var err error
+	if priority, ok := r.entryPriority(entry.BucketName, entry.DiskSize); ok {
+		_, err = r.db.ExecContext(ctx,
+			`UPDATE cache_entries SET last_accessed_at = ?, hit_count = hit_count + 1, priority = ? WHERE bucket_name = ? AND object_key = ?`,
+			now, priority, entry.BucketName, entry.ObjectKey,
+		)
+	} else {
+		_, err = r.db.ExecContext(ctx,
+			`UPDATE cache_entries SET last_accessed_at = ?, hit_count = hit_count + 1 WHERE bucket_name = ? AND object_key = ?`,
+			now, entry.BucketName, entry.ObjectKey,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	entry.LastAccessedAt = now
+	entry.HitCount++
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCacheEntry(row rowScanner) (*domain.CacheEntry, error) {
 	var entry domain.CacheEntry
+	var lastAccessedAt sql.NullTime
 	err := row.Scan(
 		&entry.BucketName,
 		&entry.ObjectKey,
 		&entry.ContentType,
 		&entry.Size,
+		&entry.DiskSize,
+		&entry.Encoding,
 		&entry.ETag,
 		&entry.CachePath,
 		&entry.CachedAt,
 		&entry.ExpiresAt,
+		&lastAccessedAt,
+		&entry.HitCount,
 	)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to lookup cache entry")
+		return nil, err
+	}
+	if lastAccessedAt.Valid {
+		entry.LastAccessedAt = lastAccessedAt.Time
+	} else {
+		entry.LastAccessedAt = entry.CachedAt
 	}
-
 	return &entry, nil
 }
 
@@ -95,63 +280,141 @@ func (r *CacheRepository) Store(ctx context.Context, bucketName, objectKey strin
 		return nil, errors.Wrap(err, "failed to create cache directory")
 	}
 
-	tmpPath := cachePath + ".tmp"
-	f, err := os.Create(tmpPath)
+	f, err := createTempCacheFile(cachePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create temp cache file")
+		return nil, err
 	}
+	tmpPath := f.Name()
 
-	if _, err := io.Copy(f, body); err != nil {
+	// チェックサムはディスク上の内容 (圧縮する場合は圧縮後) に対して計算する
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+	encoding := r.compressionFor(contentType, size)
+	if encoding != "" {
+		err = encodeTo(w, body, encoding)
+	} else if _, copyErr := io.Copy(w, body); copyErr != nil {
+		err = errors.Wrap(copyErr, "failed to write cache file")
+	}
+	if err != nil {
 		f.Close()
-		os.Remove(tmpPath)
-		return nil, errors.Wrap(err, "failed to write cache file")
+		removeCacheFile(tmpPath)
+		return nil, err
 	}
 	if err := f.Close(); err != nil {
-		os.Remove(tmpPath)
+		removeCacheFile(tmpPath)
 		return nil, errors.Wrap(err, "failed to close cache file")
 	}
 
-	if err := os.Rename(tmpPath, cachePath); err != nil {
-		os.Remove(tmpPath)
+	return r.commit(ctx, bucketName, objectKey, cachePath, contentType, etag, storedFile{
+		tmpPath:  tmpPath,
+		size:     size,
+		checksum: hex.EncodeToString(hash.Sum(nil)),
+		encoding: encoding,
+	})
+}
+
+// createTempCacheFile はキャッシュパスと同じディレクトリに一時ファイルを作成する。
+// 同じオブジェクトを並行して書き込んでも互いのファイルを壊さないよう、名前は毎回一意にする。
+func createTempCacheFile(cachePath string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(cachePath), filepath.Base(cachePath)+".*.tmp")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp cache file")
+	}
+	return f, nil
+}
+
+// removeCacheFile はキャッシュファイルを削除する。既に存在しない場合は何もせず、
+// それ以外の失敗はファイルが孤立して残るためログに記録する（Reconcile で後から回収する）。
+func removeCacheFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("warning: failed to remove cache file %s: %v", path, err)
+	}
+}
+
+// storedFile は書き込み済みの一時ファイル。
+type storedFile struct {
+	tmpPath string
+	// size はオブジェクトの論理サイズ
+	size int64
+	// checksum はファイル内容の SHA-256 で、整合性の検査に使う
+	checksum string
+	// encoding はファイルの圧縮形式。圧縮していない場合は空
+	encoding string
+}
+
+// removeEntryFile は削除したエントリのキャッシュファイルを削除し、メモリ上のキャッシュからも取り除く。
+func (r *CacheRepository) removeEntryFile(path string) {
+	r.forgetMemory(path)
+	removeCacheFile(path)
+}
+
+// commit は書き込み済みの一時ファイルをキャッシュパスに移動し、cache_entries に登録する。
+func (r *CacheRepository) commit(ctx context.Context, bucketName, objectKey, cachePath, contentType, etag string, file storedFile) (*domain.CacheEntry, error) {
+	if err := os.Rename(file.tmpPath, cachePath); err != nil {
+		removeCacheFile(file.tmpPath)
 		return nil, errors.Wrap(err, "failed to rename cache file")
 	}
 
+	// 追い出しの判定にはディスク上のサイズを使う
+	diskSize := file.size
+	if info, err := os.Stat(cachePath); err == nil {
+		diskSize = info.Size()
+	}
+
 	now := time.Now().UTC()
-	expiresAt := now.Add(r.ttl)
+	expiresAt := r.expiresAt(r.bucketPolicy(ctx, bucketName), now)
 
-	_, err = r.db.ExecContext(ctx,
-		`INSERT INTO cache_entries (bucket_name, object_key, content_type, size, etag, cache_path, cached_at, expires_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	priority, _ := r.entryPriority(bucketName, diskSize)
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO cache_entries (bucket_name, object_key, content_type, size, disk_size, encoding, etag, cache_path, cached_at, expires_at, last_accessed_at, hit_count, priority, sha256)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
 		 ON CONFLICT(bucket_name, object_key) DO UPDATE SET
 		   content_type = excluded.content_type,
 		   size = excluded.size,
+		   disk_size = excluded.disk_size,
+		   encoding = excluded.encoding,
 		   etag = excluded.etag,
 		   cache_path = excluded.cache_path,
 		   cached_at = excluded.cached_at,
-		   expires_at = excluded.expires_at`,
-		bucketName, objectKey, contentType, size, etag, cachePath, now, expiresAt,
+		   expires_at = excluded.expires_at,
+		   last_accessed_at = excluded.last_accessed_at,
+		   hit_count = 0,
+		   priority = excluded.priority,
+		   sha256 = excluded.sha256`,
+		bucketName, objectKey, contentType, file.size, diskSize, file.encoding, etag, cachePath, now, expiresAt, now, priority, file.checksum,
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to upsert cache entry")
 	}
+	// 上書きした場合に古い内容をメモリから返さないよう、ファイルと行を置き換えた後に取り除く
+	r.forgetMemory(cachePath)
 
 	if _, err := r.Evict(ctx); err != nil {
 		log.Printf("cache eviction error: %v", err)
 	}
 
 	return &domain.CacheEntry{
-		BucketName:  bucketName,
-		ObjectKey:   objectKey,
-		ContentType: contentType,
-		Size:        size,
-		ETag:        etag,
-		CachePath:   cachePath,
-		CachedAt:    now,
-		ExpiresAt:   expiresAt,
+		BucketName:     bucketName,
+		ObjectKey:      objectKey,
+		ContentType:    contentType,
+		Size:           file.size,
+		DiskSize:       diskSize,
+		Encoding:       file.encoding,
+		ETag:           etag,
+		CachePath:      cachePath,
+		CachedAt:       now,
+		ExpiresAt:      expiresAt,
+		LastAccessedAt: now,
 	}, nil
 }
 
 func (r *CacheRepository) OpenCacheFile(cachePath string) (io.ReadCloser, error) {
+	if r.memory != nil {
+		if body, ok := r.memory.open(cachePath); ok {
+			return body, nil
+		}
+	}
 	f, err := os.Open(cachePath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open cache file")
@@ -160,6 +423,11 @@ func (r *CacheRepository) OpenCacheFile(cachePath string) (io.ReadCloser, error)
 }
 
 func (r *CacheRepository) InvalidateByETags(ctx context.Context, bucketName string, currentETags map[string]string) (int, error) {
+	r.invalidateInflight(func(d *inflightDownload) bool {
+		s3ETag, exists := currentETags[d.objectKey]
+		return d.bucketName == bucketName && exists && s3ETag != d.content.ETag
+	})
+
 	rows, err := r.db.QueryContext(ctx,
 		`SELECT object_key, etag, cache_path FROM cache_entries WHERE bucket_name = ?`,
 		bucketName,
@@ -196,63 +464,96 @@ func (r *CacheRepository) InvalidateByETags(ctx context.Context, bucketName stri
 		if err != nil {
 			return 0, errors.Wrap(err, "failed to delete stale cache entry")
 		}
-		os.Remove(entry.cachePath)
+		r.removeEntryFile(entry.cachePath)
 	}
 
 	return len(stale), nil
 }
 
 func (r *CacheRepository) Evict(ctx context.Context) (int, error) {
-	if r.maxCacheSize <= 0 {
+	policies, err := r.allBucketPolicies(ctx)
+	if err != nil {
+		return 0, err
+	}
+	bucketLimits := make(map[string]int64)
+	for bucketName, p := range policies {
+		if p != nil && p.MaxSize > 0 && !p.Pinned {
+			bucketLimits[bucketName] = p.MaxSize
+		}
+	}
+	if r.maxCacheSize <= 0 && len(bucketLimits) == 0 {
 		return 0, nil
 	}
+	r.flushMemoryHits(ctx)
 
 	var totalSize int64
-	if err := r.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(size), 0) FROM cache_entries`).Scan(&totalSize); err != nil {
+	if err := r.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(disk_size), 0) FROM cache_entries`).Scan(&totalSize); err != nil {
 		return 0, errors.Wrap(err, "failed to get total cache size")
 	}
-
-	if totalSize <= r.maxCacheSize {
+	if len(bucketLimits) == 0 && totalSize <= r.maxCacheSize {
 		return 0, nil
 	}
 
 	rows, err := r.db.QueryContext(ctx,
-		`SELECT bucket_name, object_key, size, cache_path FROM cache_entries ORDER BY cached_at ASC`,
+		`SELECT bucket_name, object_key, disk_size, cache_path, cached_at, last_accessed_at, hit_count, priority FROM cache_entries`,
 	)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to query cache entries for eviction")
 	}
 	defer rows.Close()
 
-	type evictEntry struct {
-		bucketName string
-		objectKey  string
-		size       int64
-		cachePath  string
-	}
-	var toEvict []evictEntry
-
-	for rows.Next() && totalSize > r.maxCacheSize {
-		var e evictEntry
-		if err := rows.Scan(&e.bucketName, &e.objectKey, &e.size, &e.cachePath); err != nil {
+	var candidates []EvictionCandidate
+	bucketSizes := make(map[string]int64)
+	for rows.Next() {
+		var c EvictionCandidate
+		var lastAccessedAt sql.NullTime
+		if err := rows.Scan(&c.BucketName, &c.ObjectKey, &c.Size, &c.CachePath, &c.CachedAt, &lastAccessedAt, &c.HitCount, &c.Priority); err != nil {
 			return 0, errors.Wrap(err, "failed to scan cache entry for eviction")
 		}
-		toEvict = append(toEvict, e)
-		totalSize -= e.size
+		if lastAccessedAt.Valid {
+			c.LastAccessedAt = lastAccessedAt.Time
+		}
+		// 固定したバケットのエントリは追い出さない
+		if p := policies[c.BucketName]; p != nil && p.Pinned {
+			continue
+		}
+		bucketSizes[c.BucketName] += c.Size
+		candidates = append(candidates, c)
 	}
 	if err := rows.Err(); err != nil {
 		return 0, errors.Wrap(err, "failed to iterate cache entries for eviction")
 	}
 
+	r.sortEvictionCandidates(candidates)
+
+	// 全体の上限とバケットごとの上限のどちらかを超えている間、追い出す順に削除する
+	var toEvict []EvictionCandidate
+	for _, c := range candidates {
+		overTotal := r.maxCacheSize > 0 && totalSize > r.maxCacheSize
+		limit, hasLimit := bucketLimits[c.BucketName]
+		overBucket := hasLimit && bucketSizes[c.BucketName] > limit
+		if !overTotal && !overBucket {
+			continue
+		}
+		toEvict = append(toEvict, c)
+		totalSize -= c.Size
+		bucketSizes[c.BucketName] -= c.Size
+	}
+
+	pp, hasPriority := r.policy.(priorityPolicy)
 	for _, e := range toEvict {
 		_, err := r.db.ExecContext(ctx,
 			`DELETE FROM cache_entries WHERE bucket_name = ? AND object_key = ?`,
-			e.bucketName, e.objectKey,
+			e.BucketName, e.ObjectKey,
 		)
 		if err != nil {
 			return 0, errors.Wrap(err, "failed to delete evicted cache entry")
 		}
-		os.Remove(e.cachePath)
+		r.removeEntryFile(e.CachePath)
+		r.counters.evictions.Add(1)
+		if hasPriority {
+			pp.Evicted(e.Priority)
+		}
 	}
 
 	return len(toEvict), nil
@@ -261,7 +562,7 @@ func (r *CacheRepository) Evict(ctx context.Context) (int, error) {
 func (r *CacheRepository) CleanupExpired(ctx context.Context) (int, error) {
 	rows, err := r.db.QueryContext(ctx,
 		`SELECT bucket_name, object_key, cache_path FROM cache_entries WHERE expires_at <= ?`,
-		time.Now().UTC(),
+		time.Now().UTC().Add(-r.staleRetention),
 	)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to query expired cache entries")
@@ -294,7 +595,8 @@ func (r *CacheRepository) CleanupExpired(ctx context.Context) (int, error) {
 		if err != nil {
 			return 0, errors.Wrap(err, "failed to delete expired cache entry")
 		}
-		os.Remove(e.cachePath)
+		r.removeEntryFile(e.cachePath)
+		r.counters.expirations.Add(1)
 	}
 
 	return len(expired), nil
@@ -318,6 +620,7 @@ func (r *CacheRepository) StartCleanupLoop(ctx context.Context, interval time.Du
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
+				r.flushMemoryHits(ctx)
 				totalDeleted := 0
 
 				deleted, err := r.CleanupExpired(ctx)
@@ -398,6 +701,8 @@ func sanitizeBucketName(bucketName string) string {
 }
 
 func (r *CacheRepository) ClearAll(ctx context.Context) (int64, error) {
+	r.invalidateInflight(func(*inflightDownload) bool { return true })
+
 	rows, err := r.db.QueryContext(ctx, `SELECT cache_path FROM cache_entries`)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to query cache entries")
@@ -420,9 +725,12 @@ func (r *CacheRepository) ClearAll(ctx context.Context) (int64, error) {
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to delete all cache entries")
 	}
+	if r.memory != nil {
+		r.memory.clear()
+	}
 
 	for _, path := range paths {
-		os.Remove(path)
+		r.removeEntryFile(path)
 	}
 
 	affected, _ := result.RowsAffected()
@@ -430,6 +738,8 @@ func (r *CacheRepository) ClearAll(ctx context.Context) (int64, error) {
 }
 
 func (r *CacheRepository) ClearByBucket(ctx context.Context, bucketName string) (int64, error) {
+	r.invalidateInflight(func(d *inflightDownload) bool { return d.bucketName == bucketName })
+
 	rows, err := r.db.QueryContext(ctx,
 		`SELECT cache_path FROM cache_entries WHERE bucket_name = ?`,
 		bucketName,
@@ -460,7 +770,7 @@ func (r *CacheRepository) ClearByBucket(ctx context.Context, bucketName string)
 	}
 
 	for _, path := range paths {
-		os.Remove(path)
+		r.removeEntryFile(path)
 	}
 
 	affected, _ := result.RowsAffected()
@@ -468,6 +778,8 @@ func (r *CacheRepository) ClearByBucket(ctx context.Context, bucketName string)
 }
 
 func (r *CacheRepository) ClearByKey(ctx context.Context, bucketName, objectKey string) (int64, error) {
+	r.invalidateInflight(func(d *inflightDownload) bool { return d.bucketName == bucketName && d.objectKey == objectKey })
+
 	row := r.db.QueryRowContext(ctx,
 		`SELECT cache_path FROM cache_entries WHERE bucket_name = ? AND object_key = ?`,
 		bucketName, objectKey,
@@ -489,8 +801,36 @@ func (r *CacheRepository) ClearByKey(ctx context.Context, bucketName, objectKey
 		return 0, errors.Wrap(err, "failed to delete cache entry")
 	}
 
-	os.Remove(cachePath)
+	r.removeEntryFile(cachePath)
 
 	affected, _ := result.RowsAffected()
 	return affected, nil
 }
+
+// UpdateMetadata はメタデータを書き換えたオブジェクトのエントリに新しい Content-Type と ETag を反映する。
+// 本文は変わらないためキャッシュファイルはそのまま使う。ただしエントリの ETag が書き換え前の oldETag と一致しない場合は、
+// 別の版の本文に新しい ETag を付けないよう削除する。バケットの設定で対象外の Content-Type になった場合も削除する。
+func (r *CacheRepository) UpdateMetadata(ctx context.Context, bucketName, objectKey, contentType, oldETag, newETag string) (int64, error) {
+	if policy := r.bucketPolicy(ctx, bucketName); policy != nil && !policy.AllowsContentType(contentType) {
+		return r.ClearByKey(ctx, bucketName, objectKey)
+	}
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE cache_entries SET content_type = ?, etag = ? WHERE bucket_name = ? AND object_key = ? AND etag = ?`,
+		contentType, newETag, bucketName, objectKey, oldETag,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to update cache entry")
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		// エントリがないか、書き換え前とは別の版の本文を持っている
+		return r.ClearByKey(ctx, bucketName, objectKey)
+	}
+
+	// メモリ上のエントリは古い Content-Type を持つため取り除く。次にヒットしたときに読み込み直される
+	r.forgetMemory(r.cachePath(bucketName, objectKey))
+
+	return affected, nil
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// キャッシュファイルの圧縮形式。値は HTTP の Content-Encoding と同じ
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// DefaultCompressibleTypes は圧縮の対象にする既定の Content-Type。
+// 画像や動画などは既に圧縮されているため含めない。
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/x-ndjson",
+	"application/xml",
+	"application/javascript",
+	"application/x-javascript",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// 展開したボディをシーク可能にするときにメモリ上に展開するサイズの上限。これより大きい場合は一時ファイルに展開する
+const maxInMemoryDecodeSize = 8 * 1024 * 1024
+
+type cacheCompression struct {
+	encoding     string
+	contentTypes []string
+	minSize      int64
+}
+
+// WithCompression は contentTypes に一致し、minSize バイト以上のオブジェクトを encoding で圧縮して保存するよう設定する。
+// contentTypes が空の場合は DefaultCompressibleTypes を使う。
+func WithCompression(encoding string, contentTypes []string, minSize int64) CacheOption {
+	return func(r *CacheRepository) {
+		if len(contentTypes) == 0 {
+			contentTypes = DefaultCompressibleTypes
+		}
+		r.compression = &cacheCompression{
+			encoding:     encoding,
+			contentTypes: contentTypes,
+			minSize:      minSize,
+		}
+	}
+}
+
+// ParseEncoding は設定値を圧縮形式に変換する。空や "none" の場合は圧縮しない ("" を返す)。
+func ParseEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "none":
+		return "", nil
+	case EncodingGzip:
+		return EncodingGzip, nil
+	case EncodingZstd:
+		return EncodingZstd, nil
+	default:
+		return "", errors.Errorf("unknown cache compression: %s", name)
+	}
+}
+
+// compressionFor はオブジェクトを保存する際の圧縮形式を返す。圧縮しない場合は空。
+// size が不明 (負の値) の場合は最小サイズの判定を行わない。
+func (r *CacheRepository) compressionFor(contentType string, size int64) string {
+	c := r.compression
+	if c == nil || c.encoding == "" {
+		return ""
+	}
+	if size >= 0 && size < c.minSize {
+		return ""
+	}
+	if !domain.MatchContentType(c.contentTypes, contentType) {
+		return ""
+	}
+	return c.encoding
+}
+
+func newEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case EncodingZstd:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd encoder")
+		}
+		return enc, nil
+	default:
+		return nil, errors.Errorf("unsupported cache encoding: %s", encoding)
+	}
+}
+
+func newDecoder(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		dec, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create gzip decoder")
+		}
+		return dec, nil
+	case EncodingZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd decoder")
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, errors.Errorf("unsupported cache encoding: %s", encoding)
+	}
+}
+
+// decodedBody は圧縮されたキャッシュファイルを展開しながら読み出す。Close で元のファイルも閉じる
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
+}
+
+// DecodeContent は encoding で圧縮されたボディを展開するリーダーを返す。
+// Accept-Encoding で圧縮形式を受け付けないクライアントに返す場合に使う。
+func (r *CacheRepository) DecodeContent(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	dec, err := newDecoder(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &decodedBody{ReadCloser: dec, body: body}, nil
+}
+
+// DecodeSeekableContent は encoding で圧縮されたボディを展開し、シーク可能なボディとして返す。
+// Range リクエストに展開後のバイト位置で応答するために使う。size は展開後のサイズで、
+// maxInMemoryDecodeSize 以下ならメモリ上に、それより大きい場合は一時ファイルに展開する。
+func (r *CacheRepository) DecodeSeekableContent(body io.ReadCloser, encoding string, size int64) (io.ReadCloser, error) {
+	dec, err := r.DecodeContent(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	if size <= maxInMemoryDecodeSize {
+		data, err := io.ReadAll(dec)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode cache file")
+		}
+		return &memoryBody{Reader: bytes.NewReader(data)}, nil
+	}
+
+	// キャッシュディレクトリに置くと整合性チェックで不明なファイルとして扱われるため、OS の一時ディレクトリに作る
+	f, err := os.CreateTemp("", "r2manager-decoded-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp file for decoded content")
+	}
+	decoded := &tempFileBody{File: f}
+	if _, err := io.Copy(f, dec); err != nil {
+		decoded.Close()
+		return nil, errors.Wrap(err, "failed to decode cache file")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		decoded.Close()
+		return nil, errors.Wrap(err, "failed to rewind decoded content")
+	}
+	return decoded, nil
+}
+
+// tempFileBody は展開した一時ファイルを読み出すボディ。Close でファイルを削除する
+type tempFileBody struct {
+	*os.File
+}
+
+func (b *tempFileBody) Close() error {
+	err := b.File.Close()
+	removeCacheFile(b.File.Name())
+	return err
+}
+
+// compressFile は非圧縮で書き込んだ src を encoding で圧縮した一時ファイルを cachePath と同じディレクトリに作成し、
+// そのパスと内容の SHA-256 を返す。圧縮しても小さくならない場合は ok が false になり、一時ファイルは残さない。
+func compressFile(src, cachePath, encoding string) (tmpPath, checksum string, ok bool, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", "", false, errors.Wrap(err, "failed to open cache file")
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return "", "", false, errors.Wrap(err, "failed to stat cache file")
+	}
+
+	out, err := createTempCacheFile(cachePath)
+	if err != nil {
+		return "", "", false, err
+	}
+	tmpPath = out.Name()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(out, hash)}
+	if err := encodeTo(counter, in, encoding); err != nil {
+		out.Close()
+		removeCacheFile(tmpPath)
+		return "", "", false, err
+	}
+	if err := out.Close(); err != nil {
+		removeCacheFile(tmpPath)
+		return "", "", false, errors.Wrap(err, "failed to close compressed cache file")
+	}
+
+	if counter.n >= info.Size() {
+		removeCacheFile(tmpPath)
+		return "", "", false, nil
+	}
+	return tmpPath, hex.EncodeToString(hash.Sum(nil)), true, nil
+}
+
+// encodeTo は src を encoding で圧縮して w に書き込む。
+func encodeTo(w io.Writer, src io.Reader, encoding string) error {
+	enc, err := newEncoder(w, encoding)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(enc, src); err != nil {
+		enc.Close()
+		return errors.Wrap(err, "failed to write compressed cache file")
+	}
+	if err := enc.Close(); err != nil {
+		return errors.Wrap(err, "failed to flush compressed cache file")
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	EvictionFIFO = "fifo"
+	EvictionLRU  = "lru"
+	EvictionLFU  = "lfu"
+	EvictionGDS  = "gds"
+)
+
+// EvictionCandidate は追い出し順序の判定に使うキャッシュエントリの情報。
+type EvictionCandidate struct {
+	BucketName string
+	ObjectKey  string
+	// Size はキャッシュファイルのディスク上のサイズ
+	Size           int64
+	CachePath      string
+	CachedAt       time.Time
+	LastAccessedAt time.Time
+	HitCount       int64
+	Priority       float64
+}
+
+// EvictionPolicy はキャッシュの追い出し順序を決める。
+// Score が小さいエントリから追い出される。weight はバケットごとの重みで、大きいほど残りやすくなる。
+type EvictionPolicy interface {
+	Name() string
+	Score(c EvictionCandidate, weight float64, now time.Time) float64
+}
+
+// priorityPolicy は追い出し時の状態を持ち、エントリごとの優先度を cache_entries.priority に保存するポリシー。
+type priorityPolicy interface {
+	// Priority は保存・アクセス時点のエントリの優先度を返す
+	Priority(size int64, weight float64) float64
+	// Evicted は追い出したエントリの優先度を受け取る
+	Evicted(priority float64)
+	// Init は保存済みの優先度から内部状態を復元する
+	Init(minPriority float64)
+}
+
+// NewEvictionPolicy は名前に対応する追い出しポリシーを返す。
+func NewEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", EvictionFIFO:
+		return fifoPolicy{}, nil
+	case EvictionLRU:
+		return lruPolicy{}, nil
+	case EvictionLFU:
+		return lfuPolicy{}, nil
+	case EvictionGDS:
+		return &greedyDualSizePolicy{}, nil
+	default:
+		return nil, errors.Errorf("unknown eviction policy: %s", name)
+	}
+}
+
+// fifoPolicy はキャッシュされた順に追い出す。
+type fifoPolicy struct{}
+
+func (fifoPolicy) Name() string { return EvictionFIFO }
+
+func (fifoPolicy) Score(c EvictionCandidate, weight float64, now time.Time) float64 {
+	return -now.Sub(c.CachedAt).Seconds() / weight
+}
+
+// lruPolicy は最後にアクセスされてからの時間が長いものから追い出す。
+type lruPolicy struct{}
+
+func (lruPolicy) Name() string { return EvictionLRU }
+
+func (lruPolicy) Score(c EvictionCandidate, weight float64, now time.Time) float64 {
+	lastAccess := c.LastAccessedAt
+	if lastAccess.IsZero() {
+		lastAccess = c.CachedAt
+	}
+	return -now.Sub(lastAccess).Seconds() / weight
+}
+
+// lfuPolicy はヒット数の少ないものから追い出す。
+type lfuPolicy struct{}
+
+func (lfuPolicy) Name() string { return EvictionLFU }
+
+func (lfuPolicy) Score(c EvictionCandidate, weight float64, now time.Time) float64 {
+	return float64(c.HitCount+1) * weight
+}
+
+// greedyDualSizePolicy は GreedyDual-Size によるサイズを考慮した追い出しを行う。
+// 優先度は L + weight/size で、小さいファイルほど残りやすい。L は最後に追い出したエントリの優先度で、
+// 長くアクセスされていないエントリの優先度が相対的に下がっていく。
+type greedyDualSizePolicy struct {
+	mu        sync.Mutex
+	inflation float64
+}
+
+func (p *greedyDualSizePolicy) Name() string { return EvictionGDS }
+
+func (p *greedyDualSizePolicy) Score(c EvictionCandidate, weight float64, now time.Time) float64 {
+	return c.Priority
+}
+
+func (p *greedyDualSizePolicy) Priority(size int64, weight float64) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inflation + weight/float64(max(size, 1))
+}
+
+func (p *greedyDualSizePolicy) Evicted(priority float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inflation = math.Max(p.inflation, priority)
+}
+
+func (p *greedyDualSizePolicy) Init(minPriority float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inflation = math.Max(p.inflation, minPriority)
+}
+
+// WithEvictionPolicy は追い出しポリシーを設定する。指定しない場合は FIFO。
+func WithEvictionPolicy(policy EvictionPolicy) CacheOption {
+	return func(r *CacheRepository) {
+		r.policy = policy
+	}
+}
+
+// WithBucketWeights はバケットごとの重みを設定する。指定のないバケットの重みは 1。
+func WithBucketWeights(weights map[string]float64) CacheOption {
+	return func(r *CacheRepository) {
+		r.bucketWeights = weights
+	}
+}
+
+func (r *CacheRepository) bucketWeight(bucketName string) float64 {
+	if w, ok := r.bucketWeights[bucketName]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// entryPriority はポリシーが優先度を使う場合に、保存・アクセス時点の優先度を返す。
+func (r *CacheRepository) entryPriority(bucketName string, size int64) (float64, bool) {
+	p, ok := r.policy.(priorityPolicy)
+	if !ok {
+		return 0, false
+	}
+	return p.Priority(size, r.bucketWeight(bucketName)), true
+}
+
+// sortEvictionCandidates は追い出す順に並べ替える。スコアが同じ場合は古いものを先に追い出す。
+func (r *CacheRepository) sortEvictionCandidates(candidates []EvictionCandidate) {
+	now := time.Now().UTC()
+	scores := make([]float64, len(candidates))
+	order := make([]int, len(candidates))
+	for i, c := range candidates {
+		scores[i] = r.policy.Score(c, r.bucketWeight(c.BucketName), now)
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if scores[i] != scores[j] {
+			return scores[i] < scores[j]
+		}
+		return candidates[i].CachedAt.Before(candidates[j].CachedAt)
+	})
+
+	sorted := make([]EvictionCandidate, len(candidates))
+	for n, i := range order {
+		sorted[n] = candidates[i]
+	}
+	copy(candidates, sorted)
+}
@@ -0,0 +1,303 @@
+package repository
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"r2manager/domain"
+)
+
+// memoryTier はディスクキャッシュの手前に置く、小さなオブジェクト用のメモリ上のキャッシュ。
+// ヒットした場合は SQLite の検索とファイルのオープンを省略できる。キーはキャッシュファイルのパス。
+type memoryTier struct {
+	maxSize       int64
+	maxObjectSize int64
+
+	mu    sync.Mutex
+	lru   *list.List // 先頭が最近使われたエントリ
+	items map[string]*list.Element
+	size  int64
+	// loads はディスクから読み込み中のエントリ。読み込んでいる間に無効化されたデータを登録しないために使う
+	loads map[*memoryLoad]struct{}
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// memoryLoad はメモリに載せるためにディスクから読み込んでいるエントリ。
+// 読み込みの間に同じパスやバケットが無効化されると stale になり、読み込んだデータは登録しない。
+type memoryLoad struct {
+	bucketName string
+	cachePath  string
+	stale      bool
+}
+
+type memoryItem struct {
+	entry domain.CacheEntry
+	data  []byte
+	// pendingHits は cache_entries にまだ反映していないヒット数
+	pendingHits int64
+}
+
+// WithMemoryTier は maxObjectSize バイト以下のエントリを、合計 maxSize バイトまでメモリ上にも保持するよう設定する。
+func WithMemoryTier(maxSize, maxObjectSize int64) CacheOption {
+	return func(r *CacheRepository) {
+		if maxSize <= 0 || maxObjectSize <= 0 {
+			return
+		}
+		r.memory = &memoryTier{
+			maxSize:       maxSize,
+			maxObjectSize: min(maxObjectSize, maxSize),
+			lru:           list.New(),
+			items:         make(map[string]*list.Element),
+			loads:         make(map[*memoryLoad]struct{}),
+		}
+	}
+}
+
+// lookup は有効期限内のエントリとそのデータを読み出すボディを返し、ヒット数を記録する。
+func (m *memoryTier) lookup(cachePath string, now time.Time) (*domain.CacheEntry, *memoryBody, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[cachePath]
+	if !ok {
+		m.misses.Add(1)
+		return nil, nil, false
+	}
+	item := el.Value.(*memoryItem)
+	if !now.Before(item.entry.ExpiresAt) {
+		m.removeElement(el)
+		m.misses.Add(1)
+		return nil, nil, false
+	}
+
+	m.lru.MoveToFront(el)
+	item.entry.HitCount++
+	item.entry.LastAccessedAt = now
+	item.pendingHits++
+	m.hits.Add(1)
+
+	entry := item.entry
+	return &entry, &memoryBody{Reader: bytes.NewReader(item.data)}, true
+}
+
+// open はメモリ上のデータを読み出すボディを返す。シーク可能なため Range リクエストにも使える。
+func (m *memoryTier) open(cachePath string) (*memoryBody, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[cachePath]
+	if !ok {
+		return nil, false
+	}
+	return &memoryBody{Reader: bytes.NewReader(el.Value.(*memoryItem).data)}, true
+}
+
+// beginLoad はディスクからの読み込みを開始したことを記録する。読み込む前に呼び、add に渡す。終わったら endLoad を呼ぶ。
+func (m *memoryTier) beginLoad(bucketName, cachePath string) *memoryLoad {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	load := &memoryLoad{bucketName: bucketName, cachePath: cachePath}
+	m.loads[load] = struct{}{}
+	return load
+}
+
+func (m *memoryTier) endLoad(load *memoryLoad) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.loads, load)
+}
+
+// invalidateLoads は match に一致する読み込み中のエントリを stale にする。m.mu を保持した状態で呼ぶ。
+func (m *memoryTier) invalidateLoads(match func(*memoryLoad) bool) {
+	for load := range m.loads {
+		if match(load) {
+			load.stale = true
+		}
+	}
+}
+
+// add はエントリを登録し、予算を超えた分を最も使われていないものから追い出す。
+// load を開始してから同じエントリが無効化された場合は、読み込んだデータが古い可能性があるため登録しない。
+func (m *memoryTier) add(entry domain.CacheEntry, data []byte, load *memoryLoad) {
+	size := int64(len(data))
+	if size > m.maxObjectSize {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if load.stale {
+		return
+	}
+	if el, ok := m.items[entry.CachePath]; ok {
+		m.removeElement(el)
+	}
+	m.items[entry.CachePath] = m.lru.PushFront(&memoryItem{entry: entry, data: data})
+	m.size += size
+
+	for m.size > m.maxSize {
+		el := m.lru.Back()
+		if el == nil {
+			break
+		}
+		m.removeElement(el)
+		m.evictions.Add(1)
+	}
+}
+
+func (m *memoryTier) remove(cachePath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.invalidateLoads(func(load *memoryLoad) bool { return load.cachePath == cachePath })
+	if el, ok := m.items[cachePath]; ok {
+		m.removeElement(el)
+	}
+}
+
+func (m *memoryTier) removeBucket(bucketName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.invalidateLoads(func(load *memoryLoad) bool { return load.bucketName == bucketName })
+	for _, el := range m.items {
+		if el.Value.(*memoryItem).entry.BucketName == bucketName {
+			m.removeElement(el)
+		}
+	}
+}
+
+func (m *memoryTier) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.invalidateLoads(func(*memoryLoad) bool { return true })
+	m.lru.Init()
+	m.items = make(map[string]*list.Element)
+	m.size = 0
+}
+
+// removeElement は m.mu を保持した状態で呼び出すこと。
+func (m *memoryTier) removeElement(el *list.Element) {
+	item := m.lru.Remove(el).(*memoryItem)
+	delete(m.items, item.entry.CachePath)
+	m.size -= int64(len(item.data))
+}
+
+// memoryHits は cache_entries に反映していないヒット
+type memoryHits struct {
+	entry domain.CacheEntry
+	hits  int64
+}
+
+// drainHits は反映していないヒットを取り出してリセットする。
+func (m *memoryTier) drainHits() []memoryHits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []memoryHits
+	for _, el := range m.items {
+		item := el.Value.(*memoryItem)
+		if item.pendingHits == 0 {
+			continue
+		}
+		pending = append(pending, memoryHits{entry: item.entry, hits: item.pendingHits})
+		item.pendingHits = 0
+	}
+	return pending
+}
+
+func (m *memoryTier) stats() *domain.MemoryCacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return &domain.MemoryCacheStats{
+		EntryCount:    int64(len(m.items)),
+		TotalSize:     m.size,
+		MaxSize:       m.maxSize,
+		MaxObjectSize: m.maxObjectSize,
+		Hits:          m.hits.Load(),
+		Misses:        m.misses.Load(),
+		Evictions:     m.evictions.Load(),
+	}
+}
+
+// memoryBody はメモリ上のキャッシュを読み出すボディ。
+type memoryBody struct {
+	*bytes.Reader
+}
+
+func (b *memoryBody) Close() error {
+	return nil
+}
+
+// lookupMemory はメモリ上のキャッシュを検索し、エントリとデータを読み出すボディを返す。
+// メモリ層が無効な場合やヒットしない場合は nil を返す。
+func (r *CacheRepository) lookupMemory(bucketName, objectKey string) (*domain.CacheEntry, io.ReadCloser) {
+	if r.memory == nil {
+		return nil, nil
+	}
+	entry, body, ok := r.memory.lookup(r.cachePath(bucketName, objectKey), time.Now().UTC())
+	if !ok {
+		return nil, nil
+	}
+	return entry, body
+}
+
+// promoteToMemory はディスクでヒットした小さなエントリのキャッシュファイルを、開いた f からメモリに読み込む。
+// f の読み出し位置は変えない。
+func (r *CacheRepository) promoteToMemory(entry *domain.CacheEntry, load *memoryLoad, f *os.File) {
+	if r.memory == nil || entry.DiskSize > r.memory.maxObjectSize {
+		return
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, entry.DiskSize))
+	if err != nil {
+		return
+	}
+	r.memory.add(*entry, data, load)
+}
+
+// forgetMemory はメモリ上のキャッシュからエントリを取り除く。
+func (r *CacheRepository) forgetMemory(cachePath string) {
+	if r.memory != nil {
+		r.memory.remove(cachePath)
+	}
+}
+
+// flushMemoryHits はメモリ上でヒットした回数と最終アクセス日時を cache_entries に反映する。
+// LRU や LFU などの追い出しポリシーがメモリ上でよく使われているエントリを追い出さないよう、追い出しの前に呼び出す。
+func (r *CacheRepository) flushMemoryHits(ctx context.Context) {
+	if r.memory == nil {
+		return
+	}
+	for _, h := range r.memory.drainHits() {
+		e := h.entry
+		var err error
+		if priority, ok := r.entryPriority(e.BucketName, e.DiskSize); ok {
+			_, err = r.db.ExecContext(ctx,
+				`UPDATE cache_entries SET last_accessed_at = ?, hit_count = hit_count + ?, priority = ? WHERE bucket_name = ? AND object_key = ?`,
+				e.LastAccessedAt, h.hits, priority, e.BucketName, e.ObjectKey,
+			)
+		} else {
+			_, err = r.db.ExecContext(ctx,
+				`UPDATE cache_entries SET last_accessed_at = ?, hit_count = hit_count + ? WHERE bucket_name = ? AND object_key = ?`,
+				e.LastAccessedAt, h.hits, e.BucketName, e.ObjectKey,
+			)
+		}
+		if err != nil {
+			log.Printf("warning: failed to record memory cache access: bucket=%s key=%s: %v", e.BucketName, e.ObjectKey, err)
+		}
+	}
+}
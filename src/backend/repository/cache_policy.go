@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// pinnedExpiresAt は固定したバケットのエントリに設定する有効期限。期限切れとして削除されることはない
+var pinnedExpiresAt = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// policyCache は bucket_settings から読み込んだバケットごとのキャッシュ設定をメモリに保持する。
+// 取得や保存のたびに SQLite を検索しないようにし、設定を変更したときに forget で破棄する。
+type policyCache struct {
+	mu sync.Mutex
+	// policies の値が nil のバケットは設定がないことを読み込み済み
+	policies map[string]*domain.BucketCachePolicy
+	// all が true の場合、policies は全バケットの設定を読み込んだもの。含まれないバケットは設定がない
+	all bool
+	// generation は破棄のたびに増える。読み込んでいる間に破棄された設定を保持しないために使う
+	generation uint64
+}
+
+func newPolicyCache() *policyCache {
+	return &policyCache{policies: make(map[string]*domain.BucketCachePolicy)}
+}
+
+func (c *policyCache) get(bucketName string) (*domain.BucketCachePolicy, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if policy, ok := c.policies[bucketName]; ok || c.all {
+		return policy, c.generation, true
+	}
+	return nil, c.generation, false
+}
+
+func (c *policyCache) set(bucketName string, policy *domain.BucketCachePolicy, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.policies[bucketName] = policy
+	}
+}
+
+func (c *policyCache) getAll() (map[string]*domain.BucketCachePolicy, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.all {
+		return nil, c.generation, false
+	}
+	return maps.Clone(c.policies), c.generation, true
+}
+
+func (c *policyCache) setAll(policies map[string]*domain.BucketCachePolicy, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.policies = maps.Clone(policies)
+		c.all = true
+	}
+}
+
+func (c *policyCache) forget(bucketName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.policies, bucketName)
+	c.all = false
+}
+
+// GetBucketCachePolicy はバケットのキャッシュ設定を返す。設定がない場合は nil を返す。
+// 読み込んだ設定はメモリに保持し、ApplyBucketPolicy や ForgetBucketPolicy で破棄するまで使い回す。
+func (r *CacheRepository) GetBucketCachePolicy(ctx context.Context, bucketName string) (*domain.BucketCachePolicy, error) {
+	policy, generation, ok := r.policyCache.get(bucketName)
+	if ok {
+		return policy, nil
+	}
+	policy, err := r.policies.GetBucketCachePolicy(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	r.policyCache.set(bucketName, policy, generation)
+	return policy, nil
+}
+
+// allBucketPolicies はバケット名をキーにした全バケットのキャッシュ設定を返す。
+func (r *CacheRepository) allBucketPolicies(ctx context.Context) (map[string]*domain.BucketCachePolicy, error) {
+	policies, generation, ok := r.policyCache.getAll()
+	if ok {
+		return policies, nil
+	}
+	policies, err := r.policies.GetAllBucketCachePolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.policyCache.setAll(policies, generation)
+	return policies, nil
+}
+
+// ForgetBucketPolicy はメモリに保持しているバケットのキャッシュ設定を破棄し、次の取得で読み込み直させる。
+// bucket_settings を変更・削除したときに呼ぶ。
+func (r *CacheRepository) ForgetBucketPolicy(bucketName string) {
+	r.policyCache.forget(bucketName)
+}
+
+// bucketPolicy はバケットのキャッシュ設定を返す。設定がない場合や取得に失敗した場合は nil（全体の設定に従う）。
+func (r *CacheRepository) bucketPolicy(ctx context.Context, bucketName string) *domain.BucketCachePolicy {
+	policy, err := r.GetBucketCachePolicy(ctx, bucketName)
+	if err != nil {
+		log.Printf("warning: failed to load bucket cache policy: bucket=%s: %v", bucketName, err)
+		return nil
+	}
+	return policy
+}
+
+// expiresAt はバケットの設定に従って、cachedAt にキャッシュしたエントリの有効期限を返す。
+func (r *CacheRepository) expiresAt(policy *domain.BucketCachePolicy, cachedAt time.Time) time.Time {
+	switch {
+	case policy == nil:
+		return cachedAt.Add(r.ttl)
+	case policy.Pinned:
+		return pinnedExpiresAt
+	case policy.TTLSeconds > 0:
+		return cachedAt.Add(time.Duration(policy.TTLSeconds) * time.Second)
+	default:
+		return cachedAt.Add(r.ttl)
+	}
+}
+
+// ApplyBucketPolicy は変更したバケットの設定を既存のエントリに反映する。
+// キャッシュしない設定や対象外になった Content-Type のエントリは削除し、残りは有効期限を設定し直す。
+func (r *CacheRepository) ApplyBucketPolicy(ctx context.Context, bucketName string, policy *domain.BucketCachePolicy) (int64, error) {
+	r.ForgetBucketPolicy(bucketName)
+	if policy != nil && policy.NeverCache {
+		return r.ClearByBucket(ctx, bucketName)
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT object_key, content_type, cache_path, cached_at FROM cache_entries WHERE bucket_name = ?`,
+		bucketName,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to query cache entries")
+	}
+	defer rows.Close()
+
+	type bucketEntry struct {
+		objectKey   string
+		contentType string
+		cachePath   string
+		cachedAt    time.Time
+	}
+	var entries []bucketEntry
+	for rows.Next() {
+		var e bucketEntry
+		if err := rows.Scan(&e.objectKey, &e.contentType, &e.cachePath, &e.cachedAt); err != nil {
+			return 0, errors.Wrap(err, "failed to scan cache entry")
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err, "failed to iterate cache entries")
+	}
+
+	var deleted int64
+	for _, e := range entries {
+		if !policy.AllowsContentType(e.contentType) {
+			if _, err := r.db.ExecContext(ctx,
+				`DELETE FROM cache_entries WHERE bucket_name = ? AND object_key = ?`,
+				bucketName, e.objectKey,
+			); err != nil {
+				return deleted, errors.Wrap(err, "failed to delete cache entry")
+			}
+			r.removeEntryFile(e.cachePath)
+			deleted++
+			continue
+		}
+
+		if _, err := r.db.ExecContext(ctx,
+			`UPDATE cache_entries SET expires_at = ? WHERE bucket_name = ? AND object_key = ?`,
+			r.expiresAt(policy, e.cachedAt), bucketName, e.objectKey,
+		); err != nil {
+			return deleted, errors.Wrap(err, "failed to update cache entry expiry")
+		}
+	}
+
+	// メモリ上のエントリは設定変更前の有効期限を持っているため取り除く
+	if r.memory != nil {
+		r.memory.removeBucket(bucketName)
+	}
+
+	evicted, err := r.Evict(ctx)
+	if err != nil {
+		return deleted, err
+	}
+
+	return deleted + int64(evicted), nil
+}
@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// ErrReconcileInProgress は別の突き合わせが実行中の場合に返す。
+var ErrReconcileInProgress = errors.New("cache reconciliation is already in progress")
+
+// DefaultReconcileGracePeriod は突き合わせで触らない、最近更新されたファイルの猶予期間。
+// 一時ファイルのリネームから cache_entries への登録までの間のファイルを孤立と誤認しないために使う。
+const DefaultReconcileGracePeriod = time.Minute
+
+// maxReconcileFixes は突き合わせ結果に保持する修正内容の最大件数
+const maxReconcileFixes = 1000
+
+var (
+	// cacheFileName はキャッシュファイル名 (オブジェクトキーの SHA-256)
+	cacheFileName = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	// tempCacheFileName は createTempCacheFile が作成する一時ファイル名
+	tempCacheFileName = regexp.MustCompile(`^[0-9a-f]{64}\.[0-9]+\.tmp$`)
+)
+
+type ReconcileOptions struct {
+	// Verify が true の場合、キャッシュファイルのサイズと SHA-256 を記録と照合し、一致しないエントリを削除する
+	Verify bool
+	// GracePeriod より最近に更新されたファイルは書き込み中の可能性があるため対象外にする
+	GracePeriod time.Duration
+}
+
+type reconcileEntry struct {
+	bucketName string
+	objectKey  string
+	cachePath  string
+	// diskSize はキャッシュファイルのディスク上のサイズ (圧縮している場合は圧縮後)
+	diskSize int64
+	checksum string
+	cachedAt time.Time
+}
+
+// Reconcile はキャッシュディレクトリと cache_entries を突き合わせ、
+// 行のないキャッシュファイルと残った一時ファイルを削除し、ファイルのない行を削除する。
+// 削除に失敗したファイルなど、修正できなかったものは結果の Errors に含める。
+func (r *CacheRepository) Reconcile(ctx context.Context, opts ReconcileOptions) (*domain.CacheReconcileReport, error) {
+	if !r.reconcileMu.TryLock() {
+		return nil, ErrReconcileInProgress
+	}
+	defer r.reconcileMu.Unlock()
+
+	report := &domain.CacheReconcileReport{
+		StartedAt: time.Now().UTC(),
+		Verify:    opts.Verify,
+		Fixes:     []domain.CacheReconcileFix{},
+		Errors:    []string{},
+	}
+	cutoff := report.StartedAt.Add(-opts.GracePeriod)
+
+	entries, err := r.reconcileEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.ScannedEntries = len(entries)
+
+	known := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		known[e.cachePath] = true
+	}
+	if err := r.removeOrphanFiles(ctx, known, cutoff, report); err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := r.reconcileEntry(ctx, e, opts.Verify, report); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+func (r *CacheRepository) reconcileEntries(ctx context.Context) ([]reconcileEntry, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT bucket_name, object_key, cache_path, disk_size, sha256, cached_at FROM cache_entries`,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query cache entries")
+	}
+	defer rows.Close()
+
+	var entries []reconcileEntry
+	for rows.Next() {
+		var e reconcileEntry
+		if err := rows.Scan(&e.bucketName, &e.objectKey, &e.cachePath, &e.diskSize, &e.checksum, &e.cachedAt); err != nil {
+			return nil, errors.Wrap(err, "failed to scan cache entry")
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to iterate cache entries")
+	}
+	return entries, nil
+}
+
+// removeOrphanFiles はキャッシュディレクトリ (<cacheDir>/<bucket>/<file>) を走査し、
+// known に含まれないキャッシュファイルと、書き込み中でない一時ファイルを削除する。
+// キャッシュが作成しない名前のファイルには触れない。
+func (r *CacheRepository) removeOrphanFiles(ctx context.Context, known map[string]bool, cutoff time.Time, report *domain.CacheReconcileReport) error {
+	buckets, err := os.ReadDir(r.cacheDirAbs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read cache directory")
+	}
+
+	inflight := r.inflightTempPaths()
+
+	for _, b := range buckets {
+		if !b.IsDir() {
+			continue
+		}
+		dir := filepath.Join(r.cacheDirAbs, b.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to read cache directory %s: %v", dir, err))
+			continue
+		}
+
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !f.Type().IsRegular() {
+				continue
+			}
+
+			var kind string
+			switch name := f.Name(); {
+			case cacheFileName.MatchString(name):
+				kind = domain.CacheReconcileOrphanFile
+			case tempCacheFileName.MatchString(name):
+				kind = domain.CacheReconcileTempFile
+			default:
+				continue
+			}
+			report.ScannedFiles++
+
+			path := filepath.Join(dir, f.Name())
+			if known[path] || inflight[path] {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				// 走査中に削除されたファイル
+				continue
+			}
+			if info.ModTime().After(cutoff) {
+				continue
+			}
+
+			if err := os.Remove(path); err != nil {
+				if !os.IsNotExist(err) {
+					report.Errors = append(report.Errors, fmt.Sprintf("failed to remove %s: %v", path, err))
+				}
+				continue
+			}
+			if kind == domain.CacheReconcileTempFile {
+				report.TempFiles++
+			} else {
+				report.OrphanFiles++
+			}
+			report.FreedBytes += info.Size()
+			addReconcileFix(report, domain.CacheReconcileFix{Kind: kind, Path: path, Size: info.Size()})
+		}
+	}
+	return nil
+}
+
+// inflightTempPaths は書き込み中のストリーミングキャッシュの一時ファイルを返す。
+func (r *CacheRepository) inflightTempPaths() map[string]bool {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+
+	paths := make(map[string]bool, len(r.inflight))
+	for _, d := range r.inflight {
+		paths[d.tmpPath] = true
+	}
+	return paths
+}
+
+// reconcileEntry は行に対応するキャッシュファイルを確認し、ファイルがない行や、
+// verify の場合に内容が記録と一致しないエントリを削除する。
+func (r *CacheRepository) reconcileEntry(ctx context.Context, e reconcileEntry, verify bool, report *domain.CacheReconcileReport) error {
+	info, err := os.Stat(e.cachePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to stat cache file %s", e.cachePath)
+		}
+		deleted, err := r.deleteReconciledEntry(ctx, e)
+		if err != nil || !deleted {
+			return err
+		}
+		report.DanglingEntries++
+		addReconcileFix(report, domain.CacheReconcileFix{
+			Kind:       domain.CacheReconcileDanglingEntry,
+			BucketName: e.bucketName,
+			ObjectKey:  e.objectKey,
+			Size:       e.diskSize,
+		})
+		return nil
+	}
+	if !verify {
+		return nil
+	}
+
+	reason := ""
+	if info.Size() != e.diskSize {
+		reason = fmt.Sprintf("size mismatch: recorded %d, actual %d", e.diskSize, info.Size())
+	} else if e.checksum != "" {
+		sum, err := fileSHA256(e.cachePath)
+		if err != nil {
+			return err
+		}
+		if sum != e.checksum {
+			reason = "sha256 mismatch"
+		}
+	}
+	report.VerifiedEntries++
+	if reason == "" {
+		return nil
+	}
+
+	deleted, err := r.deleteReconciledEntry(ctx, e)
+	if err != nil || !deleted {
+		return err
+	}
+	r.removeEntryFile(e.cachePath)
+	report.CorruptEntries++
+	report.FreedBytes += info.Size()
+	addReconcileFix(report, domain.CacheReconcileFix{
+		Kind:       domain.CacheReconcileCorruptEntry,
+		Path:       e.cachePath,
+		BucketName: e.bucketName,
+		ObjectKey:  e.objectKey,
+		Size:       info.Size(),
+		Reason:     reason,
+	})
+	return nil
+}
+
+// deleteReconciledEntry は読み込んだ時点から更新されていない場合だけ行を削除する。
+// 突き合わせ中に同じオブジェクトが再度キャッシュされた場合は、新しい行とファイルを残す。
+func (r *CacheRepository) deleteReconciledEntry(ctx context.Context, e reconcileEntry) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM cache_entries WHERE bucket_name = ? AND object_key = ? AND cache_path = ? AND cached_at = ?`,
+		e.bucketName, e.objectKey, e.cachePath, e.cachedAt,
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete cache entry")
+	}
+	affected, _ := res.RowsAffected()
+	return affected > 0, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open cache file")
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", errors.Wrap(err, "failed to read cache file")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func addReconcileFix(report *domain.CacheReconcileReport, fix domain.CacheReconcileFix) {
+	if len(report.Fixes) >= maxReconcileFixes {
+		report.FixesTruncated = true
+		return
+	}
+	report.Fixes = append(report.Fixes, fix)
+}
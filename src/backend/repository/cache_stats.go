@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// cacheCounters はサーバー起動時からのキャッシュの利用状況
+type cacheCounters struct {
+	hits        atomic.Int64
+	misses      atomic.Int64
+	evictions   atomic.Int64
+	expirations atomic.Int64
+	// revalidations は再検証で変更がなく、有効期限を延長した回数
+	revalidations atomic.Int64
+}
+
+type CacheEntryFilter struct {
+	BucketName string
+	// Prefix はオブジェクトキーの前方一致条件
+	Prefix string
+	Limit  int
+	Offset int
+}
+
+// Stats はキャッシュ全体とバケットごとの件数・サイズを集計する。
+func (r *CacheRepository) Stats(ctx context.Context) (*domain.ContentCacheStats, error) {
+	stats := &domain.ContentCacheStats{
+		MaxSize:        r.maxCacheSize,
+		EvictionPolicy: r.policy.Name(),
+		TTLSeconds:     int64(r.ttl / time.Second),
+		Hits:           r.counters.hits.Load(),
+		Misses:         r.counters.misses.Load(),
+		Evictions:      r.counters.evictions.Load(),
+		Expirations:    r.counters.expirations.Load(),
+		Buckets:        []domain.BucketCacheStats{},
+	}
+	if r.memory != nil {
+		stats.Memory = r.memory.stats()
+	}
+
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(disk_size), 0), COALESCE(SUM(size), 0) FROM cache_entries`,
+	).Scan(&stats.EntryCount, &stats.TotalSize, &stats.LogicalSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to aggregate cache entries")
+	}
+
+	// MIN/MAX の結果は列の型情報を持たず time.Time に変換されないため、並べ替えて取得する
+	if stats.OldestCachedAt, err = r.cachedAtEdge(ctx, "ASC"); err != nil {
+		return nil, err
+	}
+	if stats.NewestCachedAt, err = r.cachedAtEdge(ctx, "DESC"); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT bucket_name, COUNT(*), COALESCE(SUM(disk_size), 0), COALESCE(SUM(size), 0) FROM cache_entries GROUP BY bucket_name ORDER BY bucket_name`,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to aggregate cache entries by bucket")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b domain.BucketCacheStats
+		if err := rows.Scan(&b.BucketName, &b.EntryCount, &b.TotalSize, &b.LogicalSize); err != nil {
+			return nil, errors.Wrap(err, "failed to scan bucket cache stats")
+		}
+		stats.Buckets = append(stats.Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to iterate bucket cache stats")
+	}
+
+	return stats, nil
+}
+
+// ListEntries は条件に一致するキャッシュエントリを新しい順に返す。total はページングを無視した件数。
+func (r *CacheRepository) ListEntries(ctx context.Context, filter CacheEntryFilter) ([]domain.CacheEntry, int64, error) {
+	var conditions []string
+	var args []any
+	if filter.BucketName != "" {
+		conditions = append(conditions, "bucket_name = ?")
+		args = append(args, filter.BucketName)
+	}
+	if filter.Prefix != "" {
+		conditions = append(conditions, "substr(object_key, 1, ?) = ?")
+		args = append(args, len(filter.Prefix), filter.Prefix)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM cache_entries`+where, args...).Scan(&total); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to count cache entries")
+	}
+
+	query := `SELECT bucket_name, object_key, content_type, size, disk_size, encoding, etag, cache_path, cached_at, expires_at, last_accessed_at, hit_count FROM cache_entries` +
+		where + " ORDER BY cached_at DESC, bucket_name, object_key LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to query cache entries")
+	}
+	defer rows.Close()
+
+	entries := []domain.CacheEntry{}
+	for rows.Next() {
+		e, err := scanCacheEntry(rows)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to scan cache entry")
+		}
+		entries = append(entries, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to iterate cache entries")
+	}
+
+	return entries, total, nil
+}
+
+func (r *CacheRepository) cachedAtEdge(ctx context.Context, order string) (*time.Time, error) {
+	var t time.Time
+	err := r.db.QueryRowContext(ctx, `SELECT cached_at FROM cache_entries ORDER BY cached_at `+order+` LIMIT 1`).Scan(&t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query cache entry time")
+	}
+	return &t, nil
+}
@@ -0,0 +1,349 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+var errDownloadAbandoned = errors.New("cache download abandoned by all readers")
+
+// inflightDownload は S3 からキャッシュファイルへ書き込み中のダウンロード。
+// 書き込みと並行して、書き込み済みの範囲を複数のリーダーが読み出せる。
+type inflightDownload struct {
+	key        string
+	bucketName string
+	objectKey  string
+	tmpPath    string
+	body       io.ReadCloser
+	content    domain.ObjectContent
+	// hash は書き込んだ内容の SHA-256
+	hash hash.Hash
+
+	// file は一時ファイルを読み出し用に開いたもの。リネーム後も同じ fd から読める
+	file *os.File
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	written   int64
+	done      bool
+	err       error
+	readers   int
+	refs      int
+	abandoned bool
+
+	// invalidated はダウンロード中にキャッシュが削除されたことを示す。r.inflightMu で保護する。
+	// 無効化されたダウンロードはキャッシュに登録せず、後続のリクエストも合流させない
+	invalidated bool
+}
+
+// StreamStore は content.Body をキャッシュファイルに書き込みつつ、書き込まれたデータを
+// 逐次読み出せるリーダーを返す。クライアントへの送信はダウンロード完了を待たずに始められる。
+// 同じオブジェクトのダウンロードが既に進行中の場合、content.Body は閉じて進行中のものに合流する。
+// 全てのリーダーが完了前に閉じられた場合はダウンロードを中断し、一時ファイルを削除する。
+func (r *CacheRepository) StreamStore(bucketName, objectKey string, content *domain.ObjectContent) (*domain.ObjectContent, error) {
+	key := inflightKey(bucketName, objectKey)
+
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+
+	if d, ok := r.inflight[key]; ok {
+		if attached, ok := d.attach(); ok {
+			content.Body.Close()
+			return attached, nil
+		}
+	}
+
+	cachePath := r.cachePath(bucketName, objectKey)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		content.Body.Close()
+		return nil, errors.Wrap(err, "failed to create cache directory")
+	}
+
+	w, err := createTempCacheFile(cachePath)
+	if err != nil {
+		content.Body.Close()
+		return nil, err
+	}
+	f, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		removeCacheFile(w.Name())
+		content.Body.Close()
+		return nil, errors.Wrap(err, "failed to open temp cache file")
+	}
+
+	d := &inflightDownload{
+		key:        key,
+		bucketName: bucketName,
+		objectKey:  objectKey,
+		tmpPath:    w.Name(),
+		body:       content.Body,
+		content: domain.ObjectContent{
+			ContentType:  content.ContentType,
+			Size:         content.Size,
+			ETag:         content.ETag,
+			LastModified: content.LastModified,
+		},
+		hash: sha256.New(),
+		file: f,
+		refs: 1, // ダウンロード処理自身の参照
+	}
+	d.cond = sync.NewCond(&d.mu)
+	r.inflight[key] = d
+
+	attached, _ := d.attach()
+	go r.download(d, w, bucketName, objectKey, cachePath)
+
+	return attached, nil
+}
+
+// InFlight は進行中のダウンロードがあれば、それに合流したリーダーを返す。
+func (r *CacheRepository) InFlight(bucketName, objectKey string) (*domain.ObjectContent, bool) {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+
+	d, ok := r.inflight[inflightKey(bucketName, objectKey)]
+	if !ok {
+		return nil, false
+	}
+	return d.attach()
+}
+
+func (r *CacheRepository) download(d *inflightDownload, w *os.File, bucketName, objectKey, cachePath string) {
+	buf := make([]byte, 32*1024)
+	var err error
+	for {
+		n, readErr := d.body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				err = errors.Wrap(writeErr, "failed to write cache file")
+				break
+			}
+			d.hash.Write(buf[:n])
+			d.mu.Lock()
+			d.written += int64(n)
+			d.cond.Broadcast()
+			d.mu.Unlock()
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			err = errors.Wrap(readErr, "failed to read object body")
+			break
+		}
+	}
+	d.body.Close()
+
+	if closeErr := w.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "failed to close cache file")
+	}
+
+	d.mu.Lock()
+	if d.abandoned {
+		err = errDownloadAbandoned
+	}
+	if err == nil && d.content.Size > 0 && d.written != d.content.Size {
+		err = errors.Errorf("cache file size mismatch: expected %d, got %d", d.content.Size, d.written)
+	}
+	written := d.written
+	d.mu.Unlock()
+
+	switch {
+	case err == nil && r.inflightInvalidated(d):
+		// ダウンロード中に削除されたキャッシュは古い内容の可能性があるため登録しない
+		removeCacheFile(d.tmpPath)
+	case err == nil:
+		file := r.compressStreamed(d, cachePath, written)
+		// リーダーは fd を保持しているため、リネームや DB 登録に失敗しても読み出しは継続できる
+		entry, commitErr := r.commit(context.Background(), bucketName, objectKey, cachePath, d.content.ContentType, d.content.ETag, file)
+		if commitErr != nil {
+			log.Printf("warning: failed to commit streamed cache: bucket=%s key=%s: %v", bucketName, objectKey, commitErr)
+		} else if r.inflightInvalidated(d) {
+			// 登録している間に削除された場合は、削除の後に登録した行を取り除く
+			r.discardCommitted(bucketName, objectKey, entry.CachedAt)
+		}
+	default:
+		removeCacheFile(d.tmpPath)
+		if err != errDownloadAbandoned {
+			log.Printf("streamed cache download failed: bucket=%s key=%s: %v", bucketName, objectKey, err)
+		}
+	}
+
+	r.inflightMu.Lock()
+	if r.inflight[d.key] == d {
+		delete(r.inflight, d.key)
+	}
+	r.inflightMu.Unlock()
+
+	d.mu.Lock()
+	d.done = true
+	d.err = err
+	d.cond.Broadcast()
+	d.mu.Unlock()
+	d.release()
+}
+
+// compressStreamed は書き込みが完了した一時ファイルを、設定に従って圧縮したファイルに置き換える。
+// リーダーは非圧縮のファイルを読み続けられるよう、ダウンロード中は圧縮せず完了後にまとめて圧縮する。
+// 圧縮に失敗した場合や小さくならない場合は非圧縮のファイルをそのまま使う。
+func (r *CacheRepository) compressStreamed(d *inflightDownload, cachePath string, written int64) storedFile {
+	plain := storedFile{
+		tmpPath:  d.tmpPath,
+		size:     written,
+		checksum: hex.EncodeToString(d.hash.Sum(nil)),
+	}
+
+	encoding := r.compressionFor(d.content.ContentType, written)
+	if encoding == "" {
+		return plain
+	}
+	tmpPath, checksum, ok, err := compressFile(d.tmpPath, cachePath, encoding)
+	if err != nil {
+		log.Printf("warning: failed to compress streamed cache %s: %v", cachePath, err)
+		return plain
+	}
+	if !ok {
+		return plain
+	}
+
+	removeCacheFile(d.tmpPath)
+	return storedFile{
+		tmpPath:  tmpPath,
+		size:     written,
+		checksum: checksum,
+		encoding: encoding,
+	}
+}
+
+// invalidateInflight は match に一致する進行中のダウンロードを無効化する。
+// キャッシュを削除する処理は、行を削除する前に呼び出すこと。
+func (r *CacheRepository) invalidateInflight(match func(*inflightDownload) bool) {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+
+	for _, d := range r.inflight {
+		if match(d) {
+			d.invalidated = true
+		}
+	}
+}
+
+func (r *CacheRepository) inflightInvalidated(d *inflightDownload) bool {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+	return d.invalidated
+}
+
+// discardCommitted は cachedAt に登録したエントリがまだ残っていれば削除する。
+// その後に別のダウンロードが登録したエントリは残す。
+func (r *CacheRepository) discardCommitted(bucketName, objectKey string, cachedAt time.Time) {
+	result, err := r.db.Exec(
+		`DELETE FROM cache_entries WHERE bucket_name = ? AND object_key = ? AND cached_at = ?`,
+		bucketName, objectKey, cachedAt,
+	)
+	if err != nil {
+		log.Printf("warning: failed to discard invalidated streamed cache: bucket=%s key=%s: %v", bucketName, objectKey, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		r.removeEntryFile(r.cachePath(bucketName, objectKey))
+	}
+}
+
+// attach は新しいリーダーを作成する。既に中断されたダウンロードや無効化されたダウンロードには合流できない。
+// r.inflightMu を保持した状態で呼び出すこと。
+func (d *inflightDownload) attach() (*domain.ObjectContent, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.abandoned || d.invalidated {
+		return nil, false
+	}
+	d.readers++
+	d.refs++
+
+	content := d.content
+	content.Body = &inflightReader{d: d}
+	return &content, true
+}
+
+func (d *inflightDownload) detach() {
+	d.mu.Lock()
+	d.readers--
+	abandon := d.readers == 0 && !d.done
+	if abandon {
+		d.abandoned = true
+	}
+	d.mu.Unlock()
+
+	if abandon {
+		// S3 のレスポンスボディを閉じてダウンロードを止める
+		d.body.Close()
+	}
+	d.release()
+}
+
+func (d *inflightDownload) release() {
+	d.mu.Lock()
+	d.refs--
+	closeFile := d.refs == 0
+	d.mu.Unlock()
+
+	if closeFile {
+		d.file.Close()
+	}
+}
+
+type inflightReader struct {
+	d      *inflightDownload
+	pos    int64
+	closed sync.Once
+}
+
+func (rd *inflightReader) Read(p []byte) (int, error) {
+	d := rd.d
+
+	d.mu.Lock()
+	for rd.pos >= d.written && !d.done {
+		d.cond.Wait()
+	}
+	written, err := d.written, d.err
+	d.mu.Unlock()
+
+	// ダウンロードに失敗した場合、途中までのデータは不完全なのでエラーにする
+	if err != nil {
+		return 0, err
+	}
+	if rd.pos >= written {
+		return 0, io.EOF
+	}
+
+	n := int(min(int64(len(p)), written-rd.pos))
+	n, readErr := d.file.ReadAt(p[:n], rd.pos)
+	rd.pos += int64(n)
+	if readErr == io.EOF && n > 0 {
+		readErr = nil
+	}
+	return n, readErr
+}
+
+func (rd *inflightReader) Close() error {
+	rd.closed.Do(rd.d.detach)
+	return nil
+}
+
+func inflightKey(bucketName, objectKey string) string {
+	return bucketName + "\x00" + objectKey
+}
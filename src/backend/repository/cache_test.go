@@ -1,16 +1,20 @@
 package repository
 
 import (
+	"container/list"
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"sync"
+	"strings"
 	"testing"
 	"time"
 
 	_ "modernc.org/sqlite"
+
+	"r2manager/domain"
 )
 
 func setupTestDB(t *testing.T) (*sql.DB, string) {
@@ -40,9 +44,33 @@ func setupTestDB(t *testing.T) (*sql.DB, string) {
 	    cache_path   TEXT NOT NULL,
 	    cached_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	    expires_at   DATETIME NOT NULL,
+	    last_accessed_at DATETIME,
+	    hit_count    INTEGER NOT NULL DEFAULT 0,
+	    priority     REAL NOT NULL DEFAULT 0,
+	    sha256       TEXT NOT NULL DEFAULT '',
+	    disk_size    INTEGER NOT NULL DEFAULT 0,
+	    encoding     TEXT NOT NULL DEFAULT '',
 	    PRIMARY KEY (bucket_name, object_key)
 	);
 	CREATE INDEX IF NOT EXISTS idx_cache_entries_expires_at ON cache_entries(expires_at);
+	CREATE TABLE IF NOT EXISTS bucket_settings (
+	    bucket_name TEXT NOT NULL PRIMARY KEY,
+	    public_url  TEXT NOT NULL DEFAULT '',
+	    cache_ttl_seconds   INTEGER NOT NULL DEFAULT 0,
+	    cache_max_size      INTEGER NOT NULL DEFAULT 0,
+	    cache_never         INTEGER NOT NULL DEFAULT 0,
+	    cache_pinned        INTEGER NOT NULL DEFAULT 0,
+	    cache_include_types TEXT NOT NULL DEFAULT '',
+	    cache_exclude_types TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS list_cache_entries (
+	    kind        TEXT NOT NULL,
+	    cache_key   TEXT NOT NULL,
+	    bucket_name TEXT NOT NULL DEFAULT '',
+	    payload     TEXT NOT NULL,
+	    expires_at  DATETIME NOT NULL,
+	    PRIMARY KEY (kind, cache_key)
+	);
 	`
 	if _, err := db.Exec(schema); err != nil {
 		t.Fatalf("failed to run schema: %v", err)
@@ -52,12 +80,22 @@ func setupTestDB(t *testing.T) (*sql.DB, string) {
 }
 
 func newTestCacheRepo(db *sql.DB, cacheDir string, ttl time.Duration, opts ...CacheOption) *CacheRepository {
-	// Reset singleton for each test
-	once = sync.Once{}
-	repo = nil
+	// Reset shared instances for each test
+	reposMu.Lock()
+	repos = make(map[string]*CacheRepository)
+	reposMu.Unlock()
 	return NewCacheRepository(db, cacheDir, ttl, opts...)
 }
 
+// lookupEntry は Lookup でエントリを検索し、開いたキャッシュファイルは閉じる。
+func lookupEntry(r *CacheRepository, ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error) {
+	entry, body, err := r.Lookup(ctx, bucketName, objectKey)
+	if body != nil {
+		body.Close()
+	}
+	return entry, err
+}
+
 func insertTestEntry(t *testing.T, db *sql.DB, bucketName, objectKey, cachePath string, expiresAt time.Time) {
 	t.Helper()
 	insertTestEntryWithSize(t, db, bucketName, objectKey, cachePath, 100, expiresAt, time.Now().UTC())
@@ -66,15 +104,49 @@ func insertTestEntry(t *testing.T, db *sql.DB, bucketName, objectKey, cachePath
 func insertTestEntryWithSize(t *testing.T, db *sql.DB, bucketName, objectKey, cachePath string, size int64, expiresAt, cachedAt time.Time) {
 	t.Helper()
 	_, err := db.Exec(
-		`INSERT INTO cache_entries (bucket_name, object_key, content_type, size, etag, cache_path, cached_at, expires_at)
-		 VALUES (?, ?, 'text/plain', ?, 'etag1', ?, ?, ?)`,
-		bucketName, objectKey, size, cachePath, cachedAt, expiresAt,
+		`INSERT INTO cache_entries (bucket_name, object_key, content_type, size, disk_size, etag, cache_path, cached_at, expires_at)
+		 VALUES (?, ?, 'text/plain', ?, ?, 'etag1', ?, ?, ?)`,
+		bucketName, objectKey, size, size, cachePath, cachedAt, expiresAt,
+	)
+	if err != nil {
+		t.Fatalf("failed to insert test entry: %v", err)
+	}
+}
+
+func insertTestEntryWithAccess(t *testing.T, db *sql.DB, bucketName, objectKey string, size int64, cachedAt, lastAccessedAt time.Time, hitCount int64) {
+	t.Helper()
+	_, err := db.Exec(
+		`INSERT INTO cache_entries (bucket_name, object_key, content_type, size, disk_size, etag, cache_path, cached_at, expires_at, last_accessed_at, hit_count)
+		 VALUES (?, ?, 'text/plain', ?, ?, 'etag1', ?, ?, ?, ?, ?)`,
+		bucketName, objectKey, size, size, "/tmp/"+objectKey, cachedAt, cachedAt.Add(time.Hour), lastAccessedAt, hitCount,
 	)
 	if err != nil {
 		t.Fatalf("failed to insert test entry: %v", err)
 	}
 }
 
+func entryExists(t *testing.T, db *sql.DB, bucketName, objectKey string) bool {
+	t.Helper()
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM cache_entries WHERE bucket_name = ? AND object_key = ?",
+		bucketName, objectKey,
+	).Scan(&count)
+	if err != nil {
+		t.Fatalf("failed to query entry: %v", err)
+	}
+	return count > 0
+}
+
+func mustEvictionPolicy(t *testing.T, name string) EvictionPolicy {
+	t.Helper()
+	policy, err := NewEvictionPolicy(name)
+	if err != nil {
+		t.Fatalf("NewEvictionPolicy(%q) failed: %v", name, err)
+	}
+	return policy
+}
+
 func countEntries(t *testing.T, db *sql.DB) int {
 	t.Helper()
 	var count int
@@ -534,3 +606,1132 @@ func TestStartCleanupLoop_RunsVacuumAfterDeletion(t *testing.T) {
 			sizeBeforeCleanup.Size(), sizeAfterCleanup.Size())
 	}
 }
+
+func TestLookup_RecordsAccess(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute)
+
+	now := time.Now().UTC()
+	insertTestEntryWithAccess(t, db, "bucket1", "key1", 100, now.Add(-30*time.Minute), now.Add(-30*time.Minute), 0)
+	cachePath := filepath.Join(tmpDir, "key1")
+	if err := os.WriteFile(cachePath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE cache_entries SET cache_path = ?", cachePath); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if _, err := lookupEntry(r, context.Background(), "bucket1", "key1"); err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+	}
+
+	var hitCount int64
+	var lastAccessedAt time.Time
+	err := db.QueryRow(
+		"SELECT hit_count, last_accessed_at FROM cache_entries WHERE bucket_name = ? AND object_key = ?",
+		"bucket1", "key1",
+	).Scan(&hitCount, &lastAccessedAt)
+	if err != nil {
+		t.Fatalf("failed to query entry: %v", err)
+	}
+
+	if hitCount != 3 {
+		t.Errorf("expected hit_count 3, got %d", hitCount)
+	}
+	if lastAccessedAt.Before(now) {
+		t.Errorf("expected last_accessed_at to be updated, got %v", lastAccessedAt)
+	}
+}
+
+func TestLookup_MissingCacheFileIsMiss(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	cachePath := filepath.Join(tmpDir, "missing")
+	insertTestEntryWithSize(t, db, "bucket1", "key1", cachePath, 100, now.Add(time.Hour), now)
+
+	entry, err := lookupEntry(r, ctx, "bucket1", "key1")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if entry != nil {
+		t.Errorf("expected no entry when the cache file is missing, got %+v", entry)
+	}
+
+	stats, err := r.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Hits != 0 || stats.Misses != 1 {
+		t.Errorf("expected 0 hits and 1 miss, got hits=%d misses=%d", stats.Hits, stats.Misses)
+	}
+	var hitCount int64
+	if err := db.QueryRow("SELECT hit_count FROM cache_entries WHERE object_key = ?", "key1").Scan(&hitCount); err != nil {
+		t.Fatalf("failed to query entry: %v", err)
+	}
+	if hitCount != 0 {
+		t.Errorf("expected hit_count 0, got %d", hitCount)
+	}
+
+	// ファイルを開ける場合はヒットとして数える
+	if err := os.WriteFile(cachePath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := lookupEntry(r, ctx, "bucket1", "key1"); err != nil || entry == nil {
+		t.Fatalf("expected a hit, got entry=%v err=%v", entry, err)
+	}
+	if stats, _ := r.Stats(ctx); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("expected 1 hit and 1 miss, got hits=%d misses=%d", stats.Hits, stats.Misses)
+	}
+}
+
+func TestEvict_LRU_EvictsLeastRecentlyAccessed(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute, WithMaxCacheSize(200), WithEvictionPolicy(mustEvictionPolicy(t, EvictionLRU)))
+
+	now := time.Now().UTC()
+
+	// "old" was cached first but accessed most recently
+	insertTestEntryWithAccess(t, db, "bucket1", "old", 100, now.Add(-3*time.Hour), now.Add(-1*time.Minute), 1)
+	insertTestEntryWithAccess(t, db, "bucket1", "mid", 100, now.Add(-2*time.Hour), now.Add(-2*time.Hour), 0)
+	insertTestEntryWithAccess(t, db, "bucket1", "new", 100, now.Add(-1*time.Hour), now.Add(-30*time.Minute), 1)
+
+	evicted, err := r.Evict(context.Background())
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+
+	if evicted != 1 {
+		t.Errorf("expected 1 evicted, got %d", evicted)
+	}
+	if entryExists(t, db, "bucket1", "mid") {
+		t.Error("expected least recently accessed entry to be evicted")
+	}
+	if !entryExists(t, db, "bucket1", "old") {
+		t.Error("expected recently accessed entry to remain")
+	}
+}
+
+func TestEvict_LFU_EvictsLeastFrequentlyUsed(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute, WithMaxCacheSize(200), WithEvictionPolicy(mustEvictionPolicy(t, EvictionLFU)))
+
+	now := time.Now().UTC()
+
+	insertTestEntryWithAccess(t, db, "bucket1", "popular", 100, now.Add(-3*time.Hour), now.Add(-2*time.Hour), 50)
+	insertTestEntryWithAccess(t, db, "bucket1", "rare", 100, now.Add(-1*time.Hour), now.Add(-1*time.Minute), 1)
+	insertTestEntryWithAccess(t, db, "bucket1", "some", 100, now.Add(-2*time.Hour), now.Add(-1*time.Hour), 5)
+
+	evicted, err := r.Evict(context.Background())
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+
+	if evicted != 1 {
+		t.Errorf("expected 1 evicted, got %d", evicted)
+	}
+	if entryExists(t, db, "bucket1", "rare") {
+		t.Error("expected least frequently used entry to be evicted")
+	}
+	if !entryExists(t, db, "bucket1", "popular") {
+		t.Error("expected frequently used entry to remain")
+	}
+}
+
+func TestEvict_GDS_EvictsLargeEntriesFirst(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute, WithMaxCacheSize(1100), WithEvictionPolicy(mustEvictionPolicy(t, EvictionGDS)))
+
+	ctx := context.Background()
+
+	// The small entry is cached first, so FIFO would evict it
+	if _, err := r.Store(ctx, "bucket1", "small", strings.NewReader(strings.Repeat("a", 100)), "text/plain", 100, "e1"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, err := r.Store(ctx, "bucket1", "large", strings.NewReader(strings.Repeat("b", 1000)), "text/plain", 1000, "e2"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, err := r.Store(ctx, "bucket1", "medium", strings.NewReader(strings.Repeat("c", 500)), "text/plain", 500, "e3"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	if entryExists(t, db, "bucket1", "large") {
+		t.Error("expected large entry to be evicted")
+	}
+	if !entryExists(t, db, "bucket1", "small") || !entryExists(t, db, "bucket1", "medium") {
+		t.Error("expected small and medium entries to remain")
+	}
+}
+
+func TestEvict_GDS_InflationAgesOutIdleEntries(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute, WithMaxCacheSize(240), WithEvictionPolicy(mustEvictionPolicy(t, EvictionGDS)))
+
+	ctx := context.Background()
+	store := func(key string, size int) {
+		t.Helper()
+		if _, err := r.Store(ctx, "bucket1", key, strings.NewReader(strings.Repeat("a", size)), "text/plain", int64(size), "e"); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	// Without inflation "idle" (1/45) would outrank the later 50-byte entries (1/50)
+	store("idle", 45)
+	store("big", 200) // evicts "big" itself (lowest priority), raising L
+	if entryExists(t, db, "bucket1", "big") {
+		t.Fatal("expected big entry to be evicted")
+	}
+
+	// Entries stored after the inflation outrank the idle entry
+	store("fresh1", 50)
+	store("fresh2", 50)
+	store("fresh3", 50)
+	store("fresh4", 50)
+	store("fresh5", 50)
+
+	if entryExists(t, db, "bucket1", "idle") {
+		t.Error("expected idle entry to be evicted before newer entries")
+	}
+}
+
+func TestEvict_BucketWeightProtectsEntries(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute,
+		WithMaxCacheSize(200),
+		WithBucketWeights(map[string]float64{"important": 10}),
+	)
+
+	now := time.Now().UTC()
+
+	// The oldest entry belongs to a heavily weighted bucket
+	insertTestEntryWithAccess(t, db, "important", "logo", 100, now.Add(-3*time.Hour), now.Add(-3*time.Hour), 0)
+	insertTestEntryWithAccess(t, db, "bucket1", "a", 100, now.Add(-2*time.Hour), now.Add(-2*time.Hour), 0)
+	insertTestEntryWithAccess(t, db, "bucket1", "b", 100, now.Add(-1*time.Hour), now.Add(-1*time.Hour), 0)
+
+	evicted, err := r.Evict(context.Background())
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+
+	if evicted != 1 {
+		t.Errorf("expected 1 evicted, got %d", evicted)
+	}
+	if !entryExists(t, db, "important", "logo") {
+		t.Error("expected weighted bucket entry to remain")
+	}
+	if entryExists(t, db, "bucket1", "a") {
+		t.Error("expected oldest unweighted entry to be evicted")
+	}
+}
+
+func TestNewEvictionPolicy(t *testing.T) {
+	for _, name := range []string{"", "fifo", "LRU", "lfu", "gds"} {
+		if _, err := NewEvictionPolicy(name); err != nil {
+			t.Errorf("NewEvictionPolicy(%q) failed: %v", name, err)
+		}
+	}
+
+	if _, err := NewEvictionPolicy("random"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func setTestBucketPolicy(t *testing.T, db *sql.DB, bucketName string, policy domain.BucketCachePolicy) {
+	t.Helper()
+	if err := NewSettingsRepository(db).UpsertBucketCachePolicy(context.Background(), bucketName, policy); err != nil {
+		t.Fatalf("failed to set bucket cache policy: %v", err)
+	}
+}
+
+func TestStore_UsesBucketTTL(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+
+	setTestBucketPolicy(t, db, "short", domain.BucketCachePolicy{TTLSeconds: 60})
+	setTestBucketPolicy(t, db, "pinned", domain.BucketCachePolicy{Pinned: true})
+
+	ctx := context.Background()
+	short, err := r.Store(ctx, "short", "a.txt", strings.NewReader("a"), "text/plain", 1, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if ttl := short.ExpiresAt.Sub(short.CachedAt); ttl != time.Minute {
+		t.Errorf("expected bucket TTL of 1m, got %v", ttl)
+	}
+
+	pinned, err := r.Store(ctx, "pinned", "b.txt", strings.NewReader("b"), "text/plain", 1, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if !pinned.ExpiresAt.Equal(pinnedExpiresAt) {
+		t.Errorf("expected pinned entry never to expire, got %v", pinned.ExpiresAt)
+	}
+
+	other, err := r.Store(ctx, "other", "c.txt", strings.NewReader("c"), "text/plain", 1, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if ttl := other.ExpiresAt.Sub(other.CachedAt); ttl != 10*time.Minute {
+		t.Errorf("expected default TTL of 10m, got %v", ttl)
+	}
+}
+
+func TestEvict_BucketMaxSize(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+
+	setTestBucketPolicy(t, db, "limited", domain.BucketCachePolicy{MaxSize: 250})
+
+	now := time.Now().UTC()
+	insertTestEntryWithAccess(t, db, "limited", "a", 100, now.Add(-3*time.Hour), now, 0)
+	insertTestEntryWithAccess(t, db, "limited", "b", 100, now.Add(-2*time.Hour), now, 0)
+	insertTestEntryWithAccess(t, db, "limited", "c", 100, now.Add(-1*time.Hour), now, 0)
+	insertTestEntryWithAccess(t, db, "other", "old", 1000, now.Add(-4*time.Hour), now, 0)
+
+	evicted, err := r.Evict(context.Background())
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+
+	if evicted != 1 {
+		t.Errorf("expected 1 evicted, got %d", evicted)
+	}
+	if entryExists(t, db, "limited", "a") {
+		t.Error("expected oldest entry of the limited bucket to be evicted")
+	}
+	if !entryExists(t, db, "other", "old") {
+		t.Error("expected entries of other buckets to remain")
+	}
+}
+
+func TestEvict_SkipsPinnedBuckets(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithMaxCacheSize(250))
+
+	setTestBucketPolicy(t, db, "pinned", domain.BucketCachePolicy{Pinned: true})
+
+	now := time.Now().UTC()
+	insertTestEntryWithAccess(t, db, "pinned", "logo", 100, now.Add(-3*time.Hour), now, 0)
+	insertTestEntryWithAccess(t, db, "bucket1", "a", 100, now.Add(-2*time.Hour), now, 0)
+	insertTestEntryWithAccess(t, db, "bucket1", "b", 100, now.Add(-1*time.Hour), now, 0)
+
+	if _, err := r.Evict(context.Background()); err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+
+	if !entryExists(t, db, "pinned", "logo") {
+		t.Error("expected pinned entry to remain")
+	}
+	if entryExists(t, db, "bucket1", "a") {
+		t.Error("expected oldest unpinned entry to be evicted")
+	}
+}
+
+func TestApplyBucketPolicy(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+
+	ctx := context.Background()
+	for _, e := range []struct{ key, contentType string }{
+		{"a.png", "image/png"},
+		{"b.mp4", "video/mp4"},
+	} {
+		if _, err := r.Store(ctx, "bucket1", e.key, strings.NewReader("x"), e.contentType, 1, "etag"); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	policy := &domain.BucketCachePolicy{Pinned: true, ExcludeContentTypes: []string{"video/*"}}
+	deleted, err := r.ApplyBucketPolicy(ctx, "bucket1", policy)
+	if err != nil {
+		t.Fatalf("ApplyBucketPolicy failed: %v", err)
+	}
+
+	if deleted != 1 {
+		t.Errorf("expected 1 deleted, got %d", deleted)
+	}
+	if entryExists(t, db, "bucket1", "b.mp4") {
+		t.Error("expected excluded content type to be deleted")
+	}
+	entry, err := lookupEntry(r, ctx, "bucket1", "a.png")
+	if err != nil || entry == nil {
+		t.Fatalf("expected remaining entry, got %v (err=%v)", entry, err)
+	}
+	if !entry.ExpiresAt.Equal(pinnedExpiresAt) {
+		t.Errorf("expected pinned expiry, got %v", entry.ExpiresAt)
+	}
+
+	deleted, err = r.ApplyBucketPolicy(ctx, "bucket1", &domain.BucketCachePolicy{NeverCache: true})
+	if err != nil {
+		t.Fatalf("ApplyBucketPolicy failed: %v", err)
+	}
+	if deleted != 1 || countEntries(t, db) != 0 {
+		t.Errorf("expected all entries to be cleared, deleted=%d remaining=%d", deleted, countEntries(t, db))
+	}
+}
+
+func TestReconcile_RemovesOrphansAndDanglingEntries(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+
+	ctx := context.Background()
+	kept, err := r.Store(ctx, "bucket1", "kept.txt", strings.NewReader("kept"), "text/plain", 4, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	missing, err := r.Store(ctx, "bucket1", "missing.txt", strings.NewReader("missing"), "text/plain", 7, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := os.Remove(missing.CachePath); err != nil {
+		t.Fatalf("failed to remove cache file: %v", err)
+	}
+
+	orphan := r.cachePath("bucket1", "orphan.txt")
+	temp := r.cachePath("bucket1", "crashed.txt") + ".123.tmp"
+	unrelated := filepath.Join(filepath.Dir(orphan), "README")
+	for _, path := range []string{orphan, temp, unrelated} {
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	report, err := r.Reconcile(ctx, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if report.OrphanFiles != 1 || report.TempFiles != 1 || report.DanglingEntries != 1 {
+		t.Errorf("unexpected report: orphan=%d temp=%d dangling=%d", report.OrphanFiles, report.TempFiles, report.DanglingEntries)
+	}
+	if report.FreedBytes != 8 {
+		t.Errorf("expected 8 bytes freed, got %d", report.FreedBytes)
+	}
+	if len(report.Fixes) != 3 || len(report.Errors) != 0 {
+		t.Errorf("expected 3 fixes and no errors, got %+v", report)
+	}
+	for _, path := range []string{orphan, temp} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", path)
+		}
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("expected unrelated file to be kept: %v", err)
+	}
+	if _, err := os.Stat(kept.CachePath); err != nil {
+		t.Errorf("expected cached file to be kept: %v", err)
+	}
+	if entryExists(t, db, "bucket1", "missing.txt") {
+		t.Error("expected dangling entry to be deleted")
+	}
+	if !entryExists(t, db, "bucket1", "kept.txt") {
+		t.Error("expected valid entry to be kept")
+	}
+}
+
+func TestReconcile_SkipsRecentFiles(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+
+	temp := r.cachePath("bucket1", "writing.txt") + ".123.tmp"
+	if err := os.MkdirAll(filepath.Dir(temp), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(temp, []byte("data"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	report, err := r.Reconcile(context.Background(), ReconcileOptions{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if report.TempFiles != 0 {
+		t.Errorf("expected recent temp file to be skipped, got %d removed", report.TempFiles)
+	}
+	if _, err := os.Stat(temp); err != nil {
+		t.Errorf("expected recent temp file to be kept: %v", err)
+	}
+}
+
+func TestReconcile_VerifyDeletesCorruptEntries(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+
+	ctx := context.Background()
+	var paths []string
+	for _, key := range []string{"ok.txt", "truncated.txt", "tampered.txt"} {
+		entry, err := r.Store(ctx, "bucket1", key, strings.NewReader("content"), "text/plain", 7, "etag")
+		if err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		paths = append(paths, entry.CachePath)
+	}
+	if err := os.WriteFile(paths[1], []byte("con"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(paths[2], []byte("CONTENT"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	report, err := r.Reconcile(ctx, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if report.CorruptEntries != 0 || report.VerifiedEntries != 0 {
+		t.Errorf("expected no verification without Verify, got %+v", report)
+	}
+
+	report, err = r.Reconcile(ctx, ReconcileOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if report.VerifiedEntries != 3 || report.CorruptEntries != 2 {
+		t.Errorf("expected 3 verified and 2 corrupt, got verified=%d corrupt=%d", report.VerifiedEntries, report.CorruptEntries)
+	}
+	if !entryExists(t, db, "bucket1", "ok.txt") {
+		t.Error("expected intact entry to be kept")
+	}
+	for i, key := range []string{"truncated.txt", "tampered.txt"} {
+		if entryExists(t, db, "bucket1", key) {
+			t.Errorf("expected corrupt entry %s to be deleted", key)
+		}
+		if _, err := os.Stat(paths[i+1]); !os.IsNotExist(err) {
+			t.Errorf("expected corrupt file %s to be removed", key)
+		}
+	}
+}
+
+func TestStore_CompressesMatchingContent(t *testing.T) {
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			db, tmpDir := setupTestDB(t)
+			r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithCompression(encoding, nil, 16))
+
+			ctx := context.Background()
+			data := strings.Repeat(`{"key":"value"},`, 256)
+			entry, err := r.Store(ctx, "bucket1", "data.json", strings.NewReader(data), "application/json", int64(len(data)), "etag")
+			if err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if entry.Encoding != encoding || entry.Size != int64(len(data)) || entry.DiskSize >= entry.Size {
+				t.Fatalf("expected compressed entry, got encoding=%q size=%d disk_size=%d", entry.Encoding, entry.Size, entry.DiskSize)
+			}
+
+			looked, body, err := r.Lookup(ctx, "bucket1", "data.json")
+			if err != nil || looked == nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+			if looked.Encoding != encoding || looked.DiskSize != entry.DiskSize {
+				t.Errorf("expected stored encoding and disk size, got %q %d", looked.Encoding, looked.DiskSize)
+			}
+
+			if err != nil {
+				t.Fatalf("OpenCacheFile failed: %v", err)
+			}
+			decoded, err := r.DecodeContent(body, looked.Encoding)
+			if err != nil {
+				t.Fatalf("DecodeContent failed: %v", err)
+			}
+			defer decoded.Close()
+			got, err := io.ReadAll(decoded)
+			if err != nil {
+				t.Fatalf("failed to read decoded content: %v", err)
+			}
+			if string(got) != data {
+				t.Error("decoded content does not match the original")
+			}
+
+			report, err := r.Reconcile(ctx, ReconcileOptions{Verify: true})
+			if err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			if report.CorruptEntries != 0 {
+				t.Errorf("expected compressed entry to verify, got %+v", report.Fixes)
+			}
+		})
+	}
+}
+
+func TestStore_SkipsCompressionForOtherContent(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithCompression(EncodingGzip, nil, 16))
+
+	ctx := context.Background()
+	image, err := r.Store(ctx, "bucket1", "a.png", strings.NewReader(strings.Repeat("x", 64)), "image/png", 64, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	small, err := r.Store(ctx, "bucket1", "small.txt", strings.NewReader("tiny"), "text/plain", 4, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	for _, e := range []*domain.CacheEntry{image, small} {
+		if e.Encoding != "" || e.DiskSize != e.Size {
+			t.Errorf("expected %s to be stored uncompressed, got encoding=%q disk_size=%d", e.ObjectKey, e.Encoding, e.DiskSize)
+		}
+	}
+}
+
+func TestStreamStore_CompressesAfterDownload(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithCompression(EncodingZstd, nil, 0))
+
+	data := strings.Repeat("line of log output\n", 512)
+	streamed, err := r.StreamStore("bucket1", "app.log", &domain.ObjectContent{
+		Body:        io.NopCloser(strings.NewReader(data)),
+		ContentType: "text/plain",
+		Size:        int64(len(data)),
+		ETag:        "etag",
+	})
+	if err != nil {
+		t.Fatalf("StreamStore failed: %v", err)
+	}
+	got, err := io.ReadAll(streamed.Body)
+	streamed.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to read streamed content: %v", err)
+	}
+	if string(got) != data {
+		t.Error("streamed content should be uncompressed")
+	}
+
+	var entry *domain.CacheEntry
+	for range 100 {
+		if entry, _ = r.LookupStale(context.Background(), "bucket1", "app.log"); entry != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if entry == nil {
+		t.Fatal("expected streamed content to be cached")
+	}
+	if entry.Encoding != EncodingZstd || entry.DiskSize >= entry.Size {
+		t.Errorf("expected compressed entry, got encoding=%q size=%d disk_size=%d", entry.Encoding, entry.Size, entry.DiskSize)
+	}
+}
+
+func TestStreamStore_SkipsCommitWhenClearedDuringDownload(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	cacheDir := filepath.Join(tmpDir, "cache")
+	r := newTestCacheRepo(db, cacheDir, 10*time.Minute)
+
+	pr, pw := io.Pipe()
+	streamed, err := r.StreamStore("bucket1", "a.txt", &domain.ObjectContent{Body: pr, ContentType: "text/plain", Size: 10, ETag: "old"})
+	if err != nil {
+		t.Fatalf("StreamStore failed: %v", err)
+	}
+
+	pw.Write([]byte("01234"))
+	if _, err := r.ClearByKey(context.Background(), "bucket1", "a.txt"); err != nil {
+		t.Fatalf("ClearByKey failed: %v", err)
+	}
+	// 無効化されたダウンロードには合流させない
+	if _, ok := r.InFlight("bucket1", "a.txt"); ok {
+		t.Error("expected an invalidated download not to be joined")
+	}
+	pw.Write([]byte("56789"))
+	pw.Close()
+
+	// 読み出し中のリーダーは最後まで読める
+	got, err := io.ReadAll(streamed.Body)
+	streamed.Body.Close()
+	if err != nil || string(got) != "0123456789" {
+		t.Fatalf("expected the reader to get the whole body, got %q err=%v", got, err)
+	}
+
+	for range 100 {
+		r.inflightMu.Lock()
+		n := len(r.inflight)
+		r.inflightMu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := countEntries(t, db); n != 0 {
+		t.Errorf("expected the invalidated download not to be cached, got %d entries", n)
+	}
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "bucket1", "*"))
+	if len(files) != 0 {
+		t.Errorf("expected the temp file to be removed, got %v", files)
+	}
+}
+
+func TestDiscardCommitted_KeepsNewerEntry(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+	ctx := context.Background()
+
+	first, err := r.Store(ctx, "bucket1", "a.txt", strings.NewReader("old"), "text/plain", 3, "old")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, err := r.Store(ctx, "bucket1", "a.txt", strings.NewReader("new"), "text/plain", 3, "new"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	// 後から登録されたエントリは削除しない
+	r.discardCommitted("bucket1", "a.txt", first.CachedAt)
+	entry, _ := r.LookupStale(ctx, "bucket1", "a.txt")
+	if entry == nil || entry.ETag != "new" {
+		t.Fatalf("expected the newer entry to remain, got %+v", entry)
+	}
+
+	r.discardCommitted("bucket1", "a.txt", entry.CachedAt)
+	if n := countEntries(t, db); n != 0 {
+		t.Errorf("expected the entry to be discarded, got %d entries", n)
+	}
+}
+
+func TestEvict_UsesDiskSize(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute,
+		WithCompression(EncodingGzip, nil, 0), WithMaxCacheSize(1024))
+
+	ctx := context.Background()
+	for i := range 3 {
+		data := strings.Repeat("a", 4096)
+		if _, err := r.Store(ctx, "bucket1", fmt.Sprintf("%d.txt", i), strings.NewReader(data), "text/plain", int64(len(data)), "etag"); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	if n := countEntries(t, db); n != 3 {
+		t.Errorf("expected compressed entries to fit within the limit, got %d entries", n)
+	}
+}
+
+func TestMemoryTier_ServesSmallEntriesFromMemory(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithMemoryTier(1024, 16))
+
+	ctx := context.Background()
+	small, err := r.Store(ctx, "bucket1", "icon.png", strings.NewReader("small"), "image/png", 5, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	large, err := r.Store(ctx, "bucket1", "photo.jpg", strings.NewReader(strings.Repeat("x", 32)), "image/jpeg", 32, "etag")
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	// 1 回目はディスクからヒットし、小さなエントリだけメモリに読み込まれる
+	for _, key := range []string{"icon.png", "photo.jpg"} {
+		if entry, err := lookupEntry(r, ctx, "bucket1", key); err != nil || entry == nil {
+			t.Fatalf("Lookup(%s) failed: %v", key, err)
+		}
+	}
+
+	// メモリ上のエントリはファイルを開かずに読み出せる
+	for _, path := range []string{small.CachePath, large.CachePath} {
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("failed to remove cache file: %v", err)
+		}
+	}
+	entry, body, err := r.Lookup(ctx, "bucket1", "icon.png")
+	if err != nil || entry == nil {
+		t.Fatalf("expected memory hit, got %v (err=%v)", entry, err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if string(got) != "small" {
+		t.Errorf("expected content from memory, got %q", got)
+	}
+	if _, err := r.OpenCacheFile(large.CachePath); err == nil {
+		t.Error("expected large entry not to be held in memory")
+	}
+
+	stats, err := r.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Memory == nil || stats.Memory.EntryCount != 1 || stats.Memory.TotalSize != 5 || stats.Memory.Hits != 1 {
+		t.Errorf("unexpected memory stats: %+v", stats.Memory)
+	}
+
+	// メモリ上のヒットは追い出しの前に cache_entries に反映される
+	r.flushMemoryHits(ctx)
+	var hitCount int64
+	if err := db.QueryRow(`SELECT hit_count FROM cache_entries WHERE object_key = 'icon.png'`).Scan(&hitCount); err != nil {
+		t.Fatalf("failed to query hit count: %v", err)
+	}
+	if hitCount != 2 {
+		t.Errorf("expected 2 recorded hits, got %d", hitCount)
+	}
+}
+
+func TestMemoryTier_CoherentWithInvalidation(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithMemoryTier(1024, 64))
+
+	ctx := context.Background()
+	load := func(bucketName, key string) {
+		t.Helper()
+		if _, err := r.Store(ctx, bucketName, key, strings.NewReader("v1"), "text/plain", 2, "etag1"); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		if entry, err := lookupEntry(r, ctx, bucketName, key); err != nil || entry == nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+	}
+	inMemory := func(bucketName, key string) bool {
+		_, ok := r.memory.open(r.cachePath(bucketName, key))
+		return ok
+	}
+
+	load("bucket1", "a.txt")
+	if _, err := r.ClearByKey(ctx, "bucket1", "a.txt"); err != nil {
+		t.Fatalf("ClearByKey failed: %v", err)
+	}
+	if inMemory("bucket1", "a.txt") {
+		t.Error("expected ClearByKey to drop the memory entry")
+	}
+
+	load("bucket1", "b.txt")
+	load("bucket2", "c.txt")
+	if _, err := r.ClearByBucket(ctx, "bucket1"); err != nil {
+		t.Fatalf("ClearByBucket failed: %v", err)
+	}
+	if inMemory("bucket1", "b.txt") || !inMemory("bucket2", "c.txt") {
+		t.Error("expected ClearByBucket to drop only the bucket's memory entries")
+	}
+
+	if _, err := r.InvalidateByETags(ctx, "bucket2", map[string]string{"c.txt": "etag2"}); err != nil {
+		t.Fatalf("InvalidateByETags failed: %v", err)
+	}
+	if inMemory("bucket2", "c.txt") {
+		t.Error("expected InvalidateByETags to drop the changed memory entry")
+	}
+
+	load("bucket1", "d.txt")
+	if _, err := r.Store(ctx, "bucket1", "d.txt", strings.NewReader("v2"), "text/plain", 2, "etag2"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if inMemory("bucket1", "d.txt") {
+		t.Error("expected overwriting to drop the memory entry")
+	}
+
+	load("bucket1", "e.txt")
+	if _, err := r.ClearAll(ctx); err != nil {
+		t.Fatalf("ClearAll failed: %v", err)
+	}
+	if stats := r.memory.stats(); stats.EntryCount != 0 || stats.TotalSize != 0 {
+		t.Errorf("expected ClearAll to empty the memory tier, got %+v", stats)
+	}
+}
+
+func TestDecodeSeekableContent(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithCompression(EncodingGzip, nil, 16))
+	ctx := context.Background()
+
+	// メモリ上に展開する大きさと、一時ファイルに展開する大きさの両方を確かめる
+	for _, size := range []int{4096, maxInMemoryDecodeSize + 1} {
+		data := strings.Repeat("0123456789abcdef", size/16+1)[:size]
+		key := fmt.Sprintf("data-%d.txt", size)
+		if _, err := r.Store(ctx, "bucket1", key, strings.NewReader(data), "text/plain", int64(size), "etag"); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		entry, body, err := r.Lookup(ctx, "bucket1", key)
+		if err != nil || entry == nil || entry.Encoding != EncodingGzip {
+			t.Fatalf("expected compressed entry, got %+v (err=%v)", entry, err)
+		}
+
+		decoded, err := r.DecodeSeekableContent(body, entry.Encoding, entry.Size)
+		if err != nil {
+			t.Fatalf("DecodeSeekableContent failed: %v", err)
+		}
+		rs, ok := decoded.(io.ReadSeeker)
+		if !ok {
+			t.Fatalf("expected a seekable body for size %d, got %T", size, decoded)
+		}
+		if _, err := rs.Seek(int64(size-10), io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		tail, err := io.ReadAll(rs)
+		if err != nil {
+			t.Fatalf("failed to read decoded content: %v", err)
+		}
+		if string(tail) != data[size-10:] {
+			t.Errorf("expected tail %q, got %q", data[size-10:], tail)
+		}
+
+		tempFile, isTemp := decoded.(*tempFileBody)
+		decoded.Close()
+		if isTemp {
+			if _, err := os.Stat(tempFile.Name()); !os.IsNotExist(err) {
+				t.Errorf("expected decoded temp file to be removed on close")
+			}
+		} else if size > maxInMemoryDecodeSize {
+			t.Errorf("expected a temp file for size %d, got %T", size, decoded)
+		}
+	}
+}
+
+func TestUpdateMetadata_KeepsBodyWithNewContentType(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute, WithMemoryTier(1024, 64))
+
+	setTestBucketPolicy(t, db, "images", domain.BucketCachePolicy{IncludeContentTypes: []string{"image/"}})
+
+	ctx := context.Background()
+	if _, err := r.Store(ctx, "bucket1", "a.bin", strings.NewReader("data"), "application/octet-stream", 4, "etag1"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	// メモリに読み込ませておく
+	if entry, err := lookupEntry(r, ctx, "bucket1", "a.bin"); err != nil || entry == nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+
+	if n, err := r.UpdateMetadata(ctx, "bucket1", "a.bin", "image/png", "etag1", "etag2"); err != nil || n != 1 {
+		t.Fatalf("UpdateMetadata = %d, %v", n, err)
+	}
+	entry, body, err := r.Lookup(ctx, "bucket1", "a.bin")
+	if err != nil || entry == nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if entry.ContentType != "image/png" || entry.ETag != "etag2" {
+		t.Errorf("expected updated metadata, got content_type=%q etag=%q", entry.ContentType, entry.ETag)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if string(got) != "data" {
+		t.Errorf("expected cached body to be kept, got %q", got)
+	}
+
+	// バケットの設定で対象外になった Content-Type のエントリは削除する
+	if _, err := r.Store(ctx, "images", "b.png", strings.NewReader("png"), "image/png", 3, "etag1"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, err := r.UpdateMetadata(ctx, "images", "b.png", "text/plain", "etag1", "etag2"); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if entryExists(t, db, "images", "b.png") {
+		t.Error("expected entry with an excluded content type to be removed")
+	}
+}
+
+func TestUpdateMetadata_ClearsEntryWithDifferentETag(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+
+	ctx := context.Background()
+	// 書き換え前に別の版の本文がキャッシュされている
+	if _, err := r.Store(ctx, "bucket1", "a.bin", strings.NewReader("old"), "application/octet-stream", 3, "etag0"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	n, err := r.UpdateMetadata(ctx, "bucket1", "a.bin", "text/plain", "etag1", "etag2")
+	if err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected the stale entry to be cleared, got %d", n)
+	}
+	if entryExists(t, db, "bucket1", "a.bin") {
+		t.Error("expected entry with a different etag to be removed instead of taking the new etag")
+	}
+}
+
+func TestMemoryTier_EvictsLeastRecentlyUsed(t *testing.T) {
+	m := newTestMemoryTier(10)
+	expires := time.Now().Add(time.Hour)
+	add := func(path string, size int) {
+		load := m.beginLoad("bucket", path)
+		defer m.endLoad(load)
+		m.add(domain.CacheEntry{CachePath: path, ExpiresAt: expires}, make([]byte, size), load)
+	}
+
+	add("a", 4)
+	add("b", 4)
+	m.lookup("a", time.Now())
+	add("c", 4)
+
+	if _, ok := m.open("b"); ok {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	for _, path := range []string{"a", "c"} {
+		if _, ok := m.open(path); !ok {
+			t.Errorf("expected %s to remain", path)
+		}
+	}
+	if stats := m.stats(); stats.TotalSize != 8 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func newTestMemoryTier(maxSize int64) *memoryTier {
+	return &memoryTier{maxSize: maxSize, maxObjectSize: maxSize, lru: list.New(), items: make(map[string]*list.Element), loads: make(map[*memoryLoad]struct{})}
+}
+
+func TestMemoryTier_SkipsDataInvalidatedWhileLoading(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	entry := func(bucket, path string) domain.CacheEntry {
+		return domain.CacheEntry{BucketName: bucket, CachePath: path, ExpiresAt: expires}
+	}
+
+	tests := []struct {
+		name       string
+		invalidate func(m *memoryTier)
+		wantAdded  bool
+	}{
+		{name: "same path", invalidate: func(m *memoryTier) { m.remove("b1/a") }},
+		{name: "same bucket", invalidate: func(m *memoryTier) { m.removeBucket("b1") }},
+		{name: "clear", invalidate: func(m *memoryTier) { m.clear() }},
+		// 関係のないエントリへの書き込みでは読み込みを取り消さない
+		{name: "other path", invalidate: func(m *memoryTier) { m.remove("b1/other") }, wantAdded: true},
+		{name: "other bucket", invalidate: func(m *memoryTier) { m.removeBucket("b2") }, wantAdded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMemoryTier(10)
+			load := m.beginLoad("b1", "b1/a")
+			tt.invalidate(m)
+			m.add(entry("b1", "b1/a"), make([]byte, 1), load)
+			m.endLoad(load)
+
+			if _, ok := m.open("b1/a"); ok != tt.wantAdded {
+				t.Errorf("expected added=%v, got %v", tt.wantAdded, ok)
+			}
+			if len(m.loads) != 0 {
+				t.Errorf("expected finished loads to be forgotten, got %d", len(m.loads))
+			}
+		})
+	}
+
+	// 無効化の後に始めた読み込みは登録する
+	m := newTestMemoryTier(10)
+	m.remove("b1/a")
+	load := m.beginLoad("b1", "b1/a")
+	m.add(entry("b1", "b1/a"), make([]byte, 1), load)
+	m.endLoad(load)
+	if _, ok := m.open("b1/a"); !ok {
+		t.Error("expected a load started after invalidation to be added")
+	}
+}
+
+func TestNewCacheRepository_SharesInstancePerCacheDir(t *testing.T) {
+	db1, dir1 := setupTestDB(t)
+	db2, dir2 := setupTestDB(t)
+	r1 := newTestCacheRepo(db1, dir1, time.Hour)
+
+	if got := NewCacheRepository(db1, dir1, time.Hour); got != r1 {
+		t.Error("expected the same instance for the same cache directory")
+	}
+	r2 := NewCacheRepository(db2, dir2, time.Hour)
+	if r2 == r1 || r2.db != db2 {
+		t.Error("expected a separate instance with its own DB for another cache directory")
+	}
+
+	// キャッシュは接続ごとのディレクトリと DB に分かれ、同じバケット名でも混ざらない
+	ctx := context.Background()
+	if _, err := r1.Store(ctx, "assets", "a.txt", strings.NewReader("one"), "text/plain", 3, `"1"`); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if entry, err := lookupEntry(r2, ctx, "assets", "a.txt"); err != nil || entry != nil {
+		t.Errorf("expected no entry in the other cache, got %v, %v", entry, err)
+	}
+
+	ReleaseCacheRepository(dir2)
+	if got := NewCacheRepository(db2, dir2, time.Hour); got == r2 {
+		t.Error("expected a new instance after ReleaseCacheRepository")
+	}
+}
+
+func TestCacheRepository_CachesBucketPolicies(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+	settings := NewSettingsRepository(db)
+	ctx := context.Background()
+
+	if err := settings.UpsertBucketCachePolicy(ctx, "bucket1", domain.BucketCachePolicy{TTLSeconds: 60}); err != nil {
+		t.Fatalf("UpsertBucketCachePolicy: %v", err)
+	}
+	if policy, err := r.GetBucketCachePolicy(ctx, "bucket1"); err != nil || policy == nil || policy.TTLSeconds != 60 {
+		t.Fatalf("expected the stored policy, got %+v, %v", policy, err)
+	}
+	if policy, err := r.GetBucketCachePolicy(ctx, "bucket2"); err != nil || policy != nil {
+		t.Fatalf("expected no policy for bucket2, got %+v, %v", policy, err)
+	}
+
+	// 読み込んだ設定は破棄するまで SQLite を検索せずに使い回す
+	if _, err := db.Exec(`UPDATE bucket_settings SET cache_ttl_seconds = 120 WHERE bucket_name = 'bucket1'`); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.UpsertBucketCachePolicy(ctx, "bucket2", domain.BucketCachePolicy{NeverCache: true}); err != nil {
+		t.Fatalf("UpsertBucketCachePolicy: %v", err)
+	}
+	if policy, _ := r.GetBucketCachePolicy(ctx, "bucket1"); policy.TTLSeconds != 60 {
+		t.Errorf("expected the cached policy, got ttl=%d", policy.TTLSeconds)
+	}
+	if policy, _ := r.GetBucketCachePolicy(ctx, "bucket2"); policy != nil {
+		t.Errorf("expected the cached absence of a policy, got %+v", policy)
+	}
+
+	// 設定を反映すると読み込み直す
+	if _, err := r.ApplyBucketPolicy(ctx, "bucket2", &domain.BucketCachePolicy{NeverCache: true}); err != nil {
+		t.Fatalf("ApplyBucketPolicy: %v", err)
+	}
+	if policy, _ := r.GetBucketCachePolicy(ctx, "bucket2"); policy == nil || !policy.NeverCache {
+		t.Errorf("expected the updated policy after ApplyBucketPolicy, got %+v", policy)
+	}
+
+	// 削除したバケットの設定も破棄する
+	if err := settings.DeleteBucketSettings(ctx, "bucket1"); err != nil {
+		t.Fatalf("DeleteBucketSettings: %v", err)
+	}
+	r.ForgetBucketPolicy("bucket1")
+	if policy, _ := r.GetBucketCachePolicy(ctx, "bucket1"); policy != nil {
+		t.Errorf("expected no policy after deleting the settings, got %+v", policy)
+	}
+}
+
+func TestCacheRepository_CachesAllBucketPolicies(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	r := newTestCacheRepo(db, filepath.Join(tmpDir, "cache"), 10*time.Minute)
+	settings := NewSettingsRepository(db)
+	ctx := context.Background()
+
+	if err := settings.UpsertBucketCachePolicy(ctx, "bucket1", domain.BucketCachePolicy{MaxSize: 100}); err != nil {
+		t.Fatalf("UpsertBucketCachePolicy: %v", err)
+	}
+	policies, err := r.allBucketPolicies(ctx)
+	if err != nil || len(policies) != 1 || policies["bucket1"].MaxSize != 100 {
+		t.Fatalf("expected all policies, got %+v, %v", policies, err)
+	}
+
+	// 全バケットを読み込んだ後は、含まれないバケットは設定がないものとして扱う
+	if err := settings.UpsertBucketCachePolicy(ctx, "bucket2", domain.BucketCachePolicy{MaxSize: 200}); err != nil {
+		t.Fatalf("UpsertBucketCachePolicy: %v", err)
+	}
+	if policy, _ := r.GetBucketCachePolicy(ctx, "bucket2"); policy != nil {
+		t.Errorf("expected bucket2 to be unknown until forgotten, got %+v", policy)
+	}
+
+	r.ForgetBucketPolicy("bucket2")
+	if policies, _ := r.allBucketPolicies(ctx); len(policies) != 2 {
+		t.Errorf("expected all policies to be reloaded, got %+v", policies)
+	}
+	if policy, _ := r.GetBucketCachePolicy(ctx, "bucket2"); policy == nil || policy.MaxSize != 200 {
+		t.Errorf("expected the reloaded policy, got %+v", policy)
+	}
+}
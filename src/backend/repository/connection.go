@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+type ConnectionRepository struct {
+	db *sql.DB
+}
+
+func NewConnectionRepository(db *sql.DB) *ConnectionRepository {
+	return &ConnectionRepository{db: db}
+}
+
+const connectionColumns = `name, account_id, endpoint, region, use_path_style, ca_bundle, insecure_skip_verify, credentials_ref, default_bucket, created_at, updated_at`
+
+func scanConnection(row rowScanner) (*domain.Connection, error) {
+	var c domain.Connection
+	err := row.Scan(&c.Name, &c.AccountID, &c.Endpoint, &c.Region, &c.UsePathStyle, &c.CABundle, &c.InsecureSkipVerify,
+		&c.CredentialsRef, &c.DefaultBucket, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *ConnectionRepository) ListConnections(ctx context.Context) ([]domain.Connection, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+connectionColumns+` FROM connections ORDER BY name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query connections")
+	}
+	defer rows.Close()
+
+	var conns []domain.Connection
+	for rows.Next() {
+		c, err := scanConnection(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan connection")
+		}
+		conns = append(conns, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to iterate connections")
+	}
+
+	return conns, nil
+}
+
+// GetConnection は接続設定を返す。存在しない場合は nil を返す。
+func (r *ConnectionRepository) GetConnection(ctx context.Context, name string) (*domain.Connection, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+connectionColumns+` FROM connections WHERE name = ?`, name)
+
+	c, err := scanConnection(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query connection")
+	}
+	return c, nil
+}
+
+// CreateConnection は接続設定を追加する。同じ名前の接続が既にある場合は false を返す。
+func (r *ConnectionRepository) CreateConnection(ctx context.Context, conn *domain.Connection) (bool, error) {
+	now := time.Now().UTC()
+	res, err := r.db.ExecContext(ctx,
+		`INSERT INTO connections (`+connectionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(name) DO NOTHING`,
+		conn.Name, conn.AccountID, conn.Endpoint, conn.Region, conn.UsePathStyle, conn.CABundle, conn.InsecureSkipVerify,
+		conn.CredentialsRef, conn.DefaultBucket, now, now,
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to insert connection")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get affected rows")
+	}
+	if n == 0 {
+		return false, nil
+	}
+	conn.CreatedAt = now
+	conn.UpdatedAt = now
+	return true, nil
+}
+
+// UpdateConnection は接続設定を置き換える。接続が存在しない場合は false を返す。
+func (r *ConnectionRepository) UpdateConnection(ctx context.Context, conn *domain.Connection) (bool, error) {
+	now := time.Now().UTC()
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE connections SET account_id = ?, endpoint = ?, region = ?, use_path_style = ?, ca_bundle = ?,
+		 insecure_skip_verify = ?, credentials_ref = ?, default_bucket = ?, updated_at = ? WHERE name = ?`,
+		conn.AccountID, conn.Endpoint, conn.Region, conn.UsePathStyle, conn.CABundle,
+		conn.InsecureSkipVerify, conn.CredentialsRef, conn.DefaultBucket, now, conn.Name,
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to update connection")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get affected rows")
+	}
+	conn.UpdatedAt = now
+	return n > 0, nil
+}
+
+// DeleteConnection は接続設定を削除する。接続が存在しない場合は false を返す。
+func (r *ConnectionRepository) DeleteConnection(ctx context.Context, name string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM connections WHERE name = ?`, name)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete connection")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get affected rows")
+	}
+	return n > 0, nil
+}
@@ -2,12 +2,17 @@ package repository
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	"github.com/pkg/errors"
 
 	"r2manager/domain"
+	serviceif "r2manager/service/interface"
 )
 
 type ContentRepository struct {
@@ -27,6 +32,60 @@ func (r *ContentRepository) GetContent(ctx context.Context, bucketName, objectKe
 		return nil, errors.Wrap(err, "failed to GetObject")
 	}
 
+	return toObjectContent(output), nil
+}
+
+// GetContentRange は Range ヘッダを指定して GetObject し、指定範囲のみを取得する。
+func (r *ContentRepository) GetContentRange(ctx context.Context, bucketName, objectKey, rangeHeader string) (*domain.ObjectContent, error) {
+	output, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+		Range:  aws.String(rangeHeader),
+	})
+	if err != nil {
+		var respErr smithy.APIError
+		if errors.As(err, &respErr) && respErr.ErrorCode() == "InvalidRange" {
+			return nil, serviceif.ErrInvalidRange
+		}
+		return nil, errors.Wrap(err, "failed to GetObject")
+	}
+
+	content := toObjectContent(output)
+	if output.ContentRange != nil {
+		content.ContentRange = *output.ContentRange
+	}
+
+	return content, nil
+}
+
+// GetContentIfNoneMatch は If-None-Match を指定して GetObject する。
+// オブジェクトが etag から変更されていない場合は serviceif.ErrNotModified を返す。
+func (r *ContentRepository) GetContentIfNoneMatch(ctx context.Context, bucketName, objectKey, etag string) (*domain.ObjectContent, error) {
+	output, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(objectKey),
+		IfNoneMatch: aws.String(etag),
+	})
+	if err != nil {
+		if isNotModified(err) {
+			return nil, serviceif.ErrNotModified
+		}
+		return nil, errors.Wrap(err, "failed to GetObject")
+	}
+
+	return toObjectContent(output), nil
+}
+
+func isNotModified(err error) bool {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotModified"
+}
+
+func toObjectContent(output *s3.GetObjectOutput) *domain.ObjectContent {
 	contentType := "application/octet-stream"
 	if output.ContentType != nil {
 		contentType = *output.ContentType
@@ -42,10 +101,16 @@ func (r *ContentRepository) GetContent(ctx context.Context, bucketName, objectKe
 		etag = *output.ETag
 	}
 
+	var lastModified time.Time
+	if output.LastModified != nil {
+		lastModified = *output.LastModified
+	}
+
 	return &domain.ObjectContent{
-		Body:        output.Body,
-		ContentType: contentType,
-		Size:        size,
-		ETag:        etag,
-	}, nil
+		Body:         output.Body,
+		ContentType:  contentType,
+		Size:         size,
+		ETag:         etag,
+		LastModified: lastModified,
+	}
 }
@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+const (
+	// UploadPartCopy 1パートあたりのサイズ
+	copyPartSize int64 = 512 * 1024 * 1024
+	// CopyObject で一度にコピーできるサイズの上限
+	maxCopyObjectSize int64 = 5 * 1024 * 1024 * 1024
+)
+
+type CopyRepository struct {
+	client *s3.Client
+}
+
+func NewCopyRepository(client *s3.Client) *CopyRepository {
+	return &CopyRepository{client: client}
+}
+
+// CopyObject は CopyObject API でサーバーサイドコピーを行う。
+// overwrite が false の場合は IfNoneMatch: "*" を指定し、コピー先が存在すれば ErrObjectAlreadyExists を返す。
+func (r *CopyRepository) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) (string, error) {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(copySource(srcBucket, srcKey)),
+	}
+	if !overwrite {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	output, err := r.client.CopyObject(ctx, input)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return "", serviceif.ErrObjectAlreadyExists
+		}
+		return "", errors.Wrap(err, "failed to CopyObject")
+	}
+
+	etag := ""
+	if output.CopyObjectResult != nil && output.CopyObjectResult.ETag != nil {
+		etag = *output.CopyObjectResult.ETag
+	}
+
+	return etag, nil
+}
+
+// MultipartCopyObject は UploadPartCopy を使って 5GiB を超えるオブジェクトをコピーする。
+// パートごとに onProgress を呼び出し、失敗時はマルチパートアップロードを中断する。
+func (r *CopyRepository) MultipartCopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, size int64, overwrite bool, onProgress serviceif.ProgressCallback) (string, error) {
+	// UploadPartCopy はメタデータを引き継がないため、コピー元から取得して設定する
+	head, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to HeadObject")
+	}
+
+	return r.multipartCopy(ctx, srcBucket, srcKey, &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(dstBucket),
+		Key:                aws.String(dstKey),
+		ContentType:        head.ContentType,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		Metadata:           head.Metadata,
+	}, size, "", overwrite, onProgress)
+}
+
+// ReplaceMetadata はオブジェクトを自身にコピーし、メタデータを meta の内容に置き換える。
+// コピー中に書き換えられたオブジェクトを上書きしないよう、meta.ETag と一致する場合のみコピーする。
+func (r *CopyRepository) ReplaceMetadata(ctx context.Context, bucketName string, meta domain.ObjectMetadata) (string, error) {
+	if meta.Size > maxCopyObjectSize {
+		return r.multipartCopy(ctx, bucketName, meta.Key, &s3.CreateMultipartUploadInput{
+			Bucket:             aws.String(bucketName),
+			Key:                aws.String(meta.Key),
+			ContentType:        optionalString(meta.ContentType),
+			CacheControl:       optionalString(meta.CacheControl),
+			ContentDisposition: optionalString(meta.ContentDisposition),
+			ContentEncoding:    optionalString(meta.ContentEncoding),
+			Metadata:           meta.Metadata,
+		}, meta.Size, meta.ETag, true, nil)
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:             aws.String(bucketName),
+		Key:                aws.String(meta.Key),
+		CopySource:         aws.String(copySource(bucketName, meta.Key)),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		ContentType:        optionalString(meta.ContentType),
+		CacheControl:       optionalString(meta.CacheControl),
+		ContentDisposition: optionalString(meta.ContentDisposition),
+		ContentEncoding:    optionalString(meta.ContentEncoding),
+		Metadata:           meta.Metadata,
+	}
+	if meta.ETag != "" {
+		input.CopySourceIfMatch = aws.String(meta.ETag)
+	}
+
+	output, err := r.client.CopyObject(ctx, input)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return "", serviceif.ErrObjectModified
+		}
+		if isNotFound(err) {
+			return "", serviceif.ErrObjectNotFound
+		}
+		return "", errors.Wrap(err, "failed to CopyObject")
+	}
+
+	etag := ""
+	if output.CopyObjectResult != nil && output.CopyObjectResult.ETag != nil {
+		etag = *output.CopyObjectResult.ETag
+	}
+
+	return etag, nil
+}
+
+// multipartCopy は create の内容でマルチパートアップロードを開始し、コピー元を UploadPartCopy でコピーする。
+// ifMatch が空でない場合、コピー元の ETag が一致しなければ ErrObjectModified を返す。
+func (r *CopyRepository) multipartCopy(ctx context.Context, srcBucket, srcKey string, create *s3.CreateMultipartUploadInput, size int64, ifMatch string, overwrite bool, onProgress serviceif.ProgressCallback) (string, error) {
+	dstBucket, dstKey := *create.Bucket, *create.Key
+
+	created, err := r.client.CreateMultipartUpload(ctx, create)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to CreateMultipartUpload")
+	}
+	uploadID := created.UploadId
+
+	abort := func() {
+		// 呼び出し元のコンテキストがキャンセルされていても中断できるようにする
+		_, abortErr := r.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(dstBucket),
+			Key:      aws.String(dstKey),
+			UploadId: uploadID,
+		})
+		if abortErr != nil {
+			log.Printf("warning: failed to abort multipart copy: bucket=%s key=%s: %v", dstBucket, dstKey, abortErr)
+		}
+	}
+
+	source := copySource(srcBucket, srcKey)
+	var parts []types.CompletedPart
+	var copied int64
+	for partNumber := int32(1); copied < size; partNumber++ {
+		end := min(copied+copyPartSize, size) - 1
+		input := &s3.UploadPartCopyInput{
+			Bucket:          aws.String(dstBucket),
+			Key:             aws.String(dstKey),
+			UploadId:        uploadID,
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", copied, end)),
+		}
+		if ifMatch != "" {
+			input.CopySourceIfMatch = aws.String(ifMatch)
+		}
+		output, err := r.client.UploadPartCopy(ctx, input)
+		if err != nil {
+			abort()
+			if ifMatch != "" && isPreconditionFailed(err) {
+				return "", serviceif.ErrObjectModified
+			}
+			return "", errors.Wrap(err, "failed to UploadPartCopy")
+		}
+
+		part := types.CompletedPart{PartNumber: aws.Int32(partNumber)}
+		if output.CopyPartResult != nil {
+			part.ETag = output.CopyPartResult.ETag
+		}
+		parts = append(parts, part)
+
+		copied = end + 1
+		if onProgress != nil {
+			onProgress(copied)
+		}
+	}
+
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(dstBucket),
+		Key:             aws.String(dstKey),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}
+	if !overwrite {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	output, err := r.client.CompleteMultipartUpload(ctx, input)
+	if err != nil {
+		abort()
+		if isPreconditionFailed(err) {
+			return "", serviceif.ErrObjectAlreadyExists
+		}
+		return "", errors.Wrap(err, "failed to CompleteMultipartUpload")
+	}
+
+	etag := ""
+	if output.ETag != nil {
+		etag = *output.ETag
+	}
+
+	return etag, nil
+}
+
+// optionalString は空文字列を nil にする。nil の項目はヘッダを送らないため、メタデータから削除される。
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// copySource は CopySource ヘッダ用に "bucket/key" をURLエンコードした文字列を返す。
+// キー内の "/" はそのまま残す。
+func copySource(bucketName, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucketName + "/" + strings.Join(segments, "/")
+}
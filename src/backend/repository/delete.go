@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// DeleteObjects API が一度に受け付けるキーの上限
+const deleteObjectsBatchSize = 1000
+
+type DeleteRepository struct {
+	client *s3.Client
+}
+
+func NewDeleteRepository(client *s3.Client) *DeleteRepository {
+	return &DeleteRepository{client: client}
+}
+
+func (r *DeleteRepository) DeleteObject(ctx context.Context, bucketName, key string) error {
+	_, err := r.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to DeleteObject")
+	}
+	return nil
+}
+
+// DeleteObjects は keys を 1000 件ずつに分割して DeleteObjects を呼び出し、
+// キーごとの成否をまとめて返す。
+func (r *DeleteRepository) DeleteObjects(ctx context.Context, bucketName string, keys []string) (*domain.DeleteObjectsResult, error) {
+	result := &domain.DeleteObjectsResult{
+		Deleted: make([]string, 0, len(keys)),
+		Errors:  []domain.DeleteError{},
+	}
+
+	for start := 0; start < len(keys); start += deleteObjectsBatchSize {
+		end := min(start+deleteObjectsBatchSize, len(keys))
+
+		identifiers := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			identifiers = append(identifiers, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		output, err := r.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &types.Delete{
+				Objects: identifiers,
+				Quiet:   aws.Bool(false),
+			},
+		})
+		if err != nil {
+			return result, errors.Wrap(err, "failed to DeleteObjects")
+		}
+
+		for _, d := range output.Deleted {
+			if d.Key != nil {
+				result.Deleted = append(result.Deleted, *d.Key)
+			}
+		}
+		for _, e := range output.Errors {
+			deleteErr := domain.DeleteError{}
+			if e.Key != nil {
+				deleteErr.Key = *e.Key
+			}
+			if e.Code != nil {
+				deleteErr.Code = *e.Code
+			}
+			if e.Message != nil {
+				deleteErr.Message = *e.Message
+			}
+			result.Errors = append(result.Errors, deleteErr)
+		}
+	}
+
+	return result, nil
+}
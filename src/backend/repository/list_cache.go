@@ -1,30 +1,61 @@
 package repository
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/patrickmn/go-cache"
 
 	"r2manager/domain"
+	serviceif "r2manager/service/interface"
 )
 
 const (
 	BucketsCacheTTL = 60 * time.Minute
 	ObjectsCacheTTL = 10 * time.Minute
 	CleanupInterval = 30 * time.Minute
+	// LastKnownTTL は S3 に接続できない場合に返す、最後に取得できた一覧の保持期間
+	LastKnownTTL = 24 * time.Hour
 )
 
 type ListCacheRepository struct {
 	buckets *cache.Cache
 	objects *cache.Cache
+
+	// lastBuckets / lastObjects は TTL 切れや一覧単位の無効化では消えずに残る最後に取得できた一覧。
+	// 内容が変わったと分かっているキーを含む一覧は InvalidateObjectKeys で破棄する
+	lastBuckets *cache.Cache
+	lastObjects *cache.Cache
+
+	// store が設定されている場合、一覧を SQLite にも書き込み再起動後も使えるようにする
+	store *listCacheStore
 }
 
-func NewListCacheRepository() *ListCacheRepository {
-	return &ListCacheRepository{
-		buckets: cache.New(BucketsCacheTTL, CleanupInterval),
-		objects: cache.New(ObjectsCacheTTL, CleanupInterval),
+func NewListCacheRepository(opts ...ListCacheOption) *ListCacheRepository {
+	r := &ListCacheRepository{
+		buckets:     cache.New(BucketsCacheTTL, CleanupInterval),
+		objects:     cache.New(ObjectsCacheTTL, CleanupInterval),
+		lastBuckets: cache.New(LastKnownTTL, CleanupInterval),
+		lastObjects: cache.New(LastKnownTTL, CleanupInterval),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.store != nil {
+		loaded, err := r.store.load(context.Background(), r)
+		if err != nil {
+			log.Printf("warning: failed to load persisted list cache: %v", err)
+		} else if loaded > 0 {
+			log.Printf("loaded %d persisted list cache entries", loaded)
+		}
 	}
+
+	return r
 }
 
 const bucketsCacheKey = "buckets"
@@ -40,18 +71,65 @@ func (r *ListCacheRepository) GetBuckets() ([]domain.Bucket, bool) {
 
 func (r *ListCacheRepository) SetBuckets(buckets []domain.Bucket) {
 	r.buckets.Set(bucketsCacheKey, buckets, cache.DefaultExpiration)
+	r.lastBuckets.Set(bucketsCacheKey, buckets, cache.DefaultExpiration)
+	if r.store != nil {
+		r.store.save(listCacheKindBuckets, bucketsCacheKey, "", buckets, BucketsCacheTTL)
+		r.store.save(listCacheKindLastBuckets, bucketsCacheKey, "", buckets, LastKnownTTL)
+	}
+}
+
+// GetLastKnownBuckets は無効化や TTL 切れに関わらず、最後に取得できたバケット一覧を返す。
+func (r *ListCacheRepository) GetLastKnownBuckets() ([]domain.Bucket, bool) {
+	if val, found := r.lastBuckets.Get(bucketsCacheKey); found {
+		if buckets, ok := val.([]domain.Bucket); ok {
+			return buckets, true
+		}
+	}
+	return nil, false
 }
 
 func (r *ListCacheRepository) InvalidateBuckets() {
 	r.buckets.Delete(bucketsCacheKey)
+	if r.store != nil {
+		r.store.delete(listCacheKindBuckets, bucketsCacheKey)
+	}
 }
 
-func objectsCacheKey(bucketName, prefix string) string {
-	return fmt.Sprintf("%s:%s", bucketName, prefix)
+// objectsCacheKey はページ単位でキャッシュできるよう、区切り文字とページングのパラメータもキーに含める。
+// 各要素はキーに現れない NUL 文字で区切る。
+func objectsCacheKey(bucketName string, params serviceif.ListObjectsParams) string {
+	return fmt.Sprintf("%s:%s\x00%s\x00%s\x00%s\x00%d\x00%t",
+		bucketName, params.Prefix, params.Delimiter, params.ContinuationToken, params.StartAfter, params.MaxKeys, params.All)
 }
 
-func (r *ListCacheRepository) GetObjects(bucketName, prefix string) (*domain.ListObjectsResult, bool) {
-	key := objectsCacheKey(bucketName, prefix)
+// parseObjectsCacheKey は objectsCacheKey で作ったキーからバケット名とパラメータを取り出す。
+func parseObjectsCacheKey(key string) (string, serviceif.ListObjectsParams, bool) {
+	bucketName, rest, ok := strings.Cut(key, ":")
+	if !ok {
+		return "", serviceif.ListObjectsParams{}, false
+	}
+	fields := strings.Split(rest, "\x00")
+	if len(fields) != 6 {
+		return "", serviceif.ListObjectsParams{}, false
+	}
+
+	maxKeys, err := strconv.ParseInt(fields[4], 10, 32)
+	if err != nil {
+		return "", serviceif.ListObjectsParams{}, false
+	}
+	params := serviceif.ListObjectsParams{
+		Prefix:            fields[0],
+		Delimiter:         fields[1],
+		ContinuationToken: fields[2],
+		StartAfter:        fields[3],
+		MaxKeys:           int32(maxKeys),
+		All:               fields[5] == "true",
+	}
+	return bucketName, params, true
+}
+
+func (r *ListCacheRepository) GetObjects(bucketName string, params serviceif.ListObjectsParams) (*domain.ListObjectsResult, bool) {
+	key := objectsCacheKey(bucketName, params)
 	if val, found := r.objects.Get(key); found {
 		if result, ok := val.(*domain.ListObjectsResult); ok {
 			return result, true
@@ -60,9 +138,24 @@ func (r *ListCacheRepository) GetObjects(bucketName, prefix string) (*domain.Lis
 	return nil, false
 }
 
-func (r *ListCacheRepository) SetObjects(bucketName, prefix string, result *domain.ListObjectsResult) {
-	key := objectsCacheKey(bucketName, prefix)
+func (r *ListCacheRepository) SetObjects(bucketName string, params serviceif.ListObjectsParams, result *domain.ListObjectsResult) {
+	key := objectsCacheKey(bucketName, params)
 	r.objects.Set(key, result, cache.DefaultExpiration)
+	r.lastObjects.Set(key, result, cache.DefaultExpiration)
+	if r.store != nil {
+		r.store.save(listCacheKindObjects, key, bucketName, result, ObjectsCacheTTL)
+		r.store.save(listCacheKindLastObjects, key, bucketName, result, LastKnownTTL)
+	}
+}
+
+// GetLastKnownObjects は無効化や TTL 切れに関わらず、最後に取得できたオブジェクト一覧を返す。
+func (r *ListCacheRepository) GetLastKnownObjects(bucketName string, params serviceif.ListObjectsParams) (*domain.ListObjectsResult, bool) {
+	if val, found := r.lastObjects.Get(objectsCacheKey(bucketName, params)); found {
+		if result, ok := val.(*domain.ListObjectsResult); ok {
+			return result, true
+		}
+	}
+	return nil, false
 }
 
 func (r *ListCacheRepository) InvalidateObjects(bucketName string) {
@@ -72,13 +165,46 @@ func (r *ListCacheRepository) InvalidateObjects(bucketName string) {
 			r.objects.Delete(key)
 		}
 	}
+	if r.store != nil {
+		r.store.deleteBucket(listCacheKindObjects, bucketName)
+	}
 }
 
 func (r *ListCacheRepository) InvalidateAllObjects() {
 	r.objects.Flush()
+	if r.store != nil {
+		r.store.deleteKind(listCacheKindObjects)
+	}
 }
 
 func (r *ListCacheRepository) InvalidateAll() {
 	r.buckets.Flush()
 	r.objects.Flush()
+	r.lastBuckets.Flush()
+	r.lastObjects.Flush()
+	if r.store != nil {
+		r.store.deleteKind(listCacheKindBuckets, listCacheKindObjects, listCacheKindLastBuckets, listCacheKindLastObjects)
+	}
+}
+
+func (r *ListCacheRepository) Stats() *domain.ListCacheStats {
+	stats := &domain.ListCacheStats{
+		Buckets: domain.ListCacheTypeStats{
+			ItemCount:  r.buckets.ItemCount(),
+			TTLSeconds: int64(BucketsCacheTTL / time.Second),
+		},
+		Objects: domain.ListCacheTypeStats{
+			TTLSeconds: int64(ObjectsCacheTTL / time.Second),
+		},
+		ObjectsByBucket: make(map[string]int),
+	}
+
+	// Items は期限切れの項目を含まない
+	for key := range r.objects.Items() {
+		bucketName, _, _ := strings.Cut(key, ":")
+		stats.ObjectsByBucket[bucketName]++
+		stats.Objects.ItemCount++
+	}
+
+	return stats
 }
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// list_cache_entries.kind の値。無効化される一覧と、S3 に接続できない場合に返す最後に取得できた一覧を区別する。
+const (
+	listCacheKindBuckets     = "buckets"
+	listCacheKindObjects     = "objects"
+	listCacheKindLastBuckets = "last_buckets"
+	listCacheKindLastObjects = "last_objects"
+)
+
+// listCacheStore は一覧キャッシュを SQLite に書き込み、再起動後に復元する。
+type listCacheStore struct {
+	db *sql.DB
+}
+
+type ListCacheOption func(*ListCacheRepository)
+
+// WithListCacheDB は一覧キャッシュを SQLite にも書き込み、起動時に有効期限内のものを読み込むよう設定する。
+func WithListCacheDB(db *sql.DB) ListCacheOption {
+	return func(r *ListCacheRepository) {
+		r.store = &listCacheStore{db: db}
+	}
+}
+
+func (s *listCacheStore) save(kind, key, bucketName string, value any, ttl time.Duration) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("warning: failed to encode list cache: kind=%s bucket=%s: %v", kind, bucketName, err)
+		return
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO list_cache_entries (kind, cache_key, bucket_name, payload, expires_at)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(kind, cache_key) DO UPDATE SET
+		   bucket_name = excluded.bucket_name,
+		   payload = excluded.payload,
+		   expires_at = excluded.expires_at`,
+		kind, key, bucketName, string(payload), time.Now().UTC().Add(ttl),
+	)
+	if err != nil {
+		log.Printf("warning: failed to persist list cache: kind=%s bucket=%s: %v", kind, bucketName, err)
+	}
+}
+
+func (s *listCacheStore) delete(kind, key string) {
+	if _, err := s.db.Exec(`DELETE FROM list_cache_entries WHERE kind = ? AND cache_key = ?`, kind, key); err != nil {
+		log.Printf("warning: failed to delete persisted list cache: kind=%s: %v", kind, err)
+	}
+}
+
+func (s *listCacheStore) deleteBucket(kind, bucketName string) {
+	if _, err := s.db.Exec(`DELETE FROM list_cache_entries WHERE kind = ? AND bucket_name = ?`, kind, bucketName); err != nil {
+		log.Printf("warning: failed to delete persisted list cache: kind=%s bucket=%s: %v", kind, bucketName, err)
+	}
+}
+
+func (s *listCacheStore) deleteKind(kinds ...string) {
+	for _, kind := range kinds {
+		if _, err := s.db.Exec(`DELETE FROM list_cache_entries WHERE kind = ?`, kind); err != nil {
+			log.Printf("warning: failed to delete persisted list cache: kind=%s: %v", kind, err)
+		}
+	}
+}
+
+// cleanupExpired は有効期限切れの行を削除する。
+func (s *listCacheStore) cleanupExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM list_cache_entries WHERE expires_at <= ?`, time.Now().UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired list cache entries")
+	}
+	return res.RowsAffected()
+}
+
+// load は有効期限内の行を、残りの有効期限を引き継いでメモリ上のキャッシュに読み込む。
+func (s *listCacheStore) load(ctx context.Context, r *ListCacheRepository) (int, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT kind, cache_key, bucket_name, payload, expires_at FROM list_cache_entries WHERE expires_at > ?`,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to query list cache entries")
+	}
+	defer rows.Close()
+
+	loaded := 0
+	for rows.Next() {
+		var kind, key, bucketName, payload string
+		var expiresAt time.Time
+		if err := rows.Scan(&kind, &key, &bucketName, &payload, &expiresAt); err != nil {
+			return loaded, errors.Wrap(err, "failed to scan list cache entry")
+		}
+
+		ttl := time.Until(expiresAt)
+		if ttl <= 0 {
+			continue
+		}
+
+		var target *cache.Cache
+		var value any
+		switch kind {
+		case listCacheKindBuckets, listCacheKindLastBuckets:
+			var buckets []domain.Bucket
+			err = json.Unmarshal([]byte(payload), &buckets)
+			value = buckets
+			target = r.buckets
+			if kind == listCacheKindLastBuckets {
+				target = r.lastBuckets
+			}
+		case listCacheKindObjects, listCacheKindLastObjects:
+			var result domain.ListObjectsResult
+			err = json.Unmarshal([]byte(payload), &result)
+			value = &result
+			target = r.objects
+			if kind == listCacheKindLastObjects {
+				target = r.lastObjects
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("warning: skipping undecodable list cache entry: kind=%s bucket=%s: %v", kind, bucketName, err)
+			continue
+		}
+
+		target.Set(key, value, ttl)
+		loaded++
+	}
+
+	return loaded, rows.Err()
+}
+
+// StartCleanupLoop は SQLite に残った有効期限切れの一覧キャッシュを定期的に削除する。
+// メモリ上のキャッシュは go-cache が削除するため、永続化していない場合は何もしない。
+func (r *ListCacheRepository) StartCleanupLoop(ctx context.Context, interval time.Duration) {
+	if r.store == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := r.store.cleanupExpired(ctx)
+				if err != nil {
+					log.Printf("list cache cleanup error: %v", err)
+					continue
+				}
+				if deleted > 0 {
+					log.Printf("list cache cleanup: deleted %d expired entries", deleted)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+func TestListCacheRepository_PersistsAcrossRestart(t *testing.T) {
+	db, _ := setupTestDB(t)
+
+	params := serviceif.ListObjectsParams{Prefix: "photos/", MaxKeys: 100}
+	buckets := []domain.Bucket{{Name: "bucket-a", CreationDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}}
+	objects := &domain.ListObjectsResult{
+		Objects: []domain.Object{{Key: "photos/a.jpg", Size: 10, ETag: `"etag"`}},
+		Prefix:  "photos/",
+	}
+
+	first := NewListCacheRepository(WithListCacheDB(db))
+	first.SetBuckets(buckets)
+	first.SetObjects("bucket-a", params, objects)
+
+	restarted := NewListCacheRepository(WithListCacheDB(db))
+
+	gotBuckets, ok := restarted.GetBuckets()
+	if !ok || len(gotBuckets) != 1 || gotBuckets[0].Name != "bucket-a" || !gotBuckets[0].CreationDate.Equal(buckets[0].CreationDate) {
+		t.Fatalf("expected persisted buckets, got %v (found=%v)", gotBuckets, ok)
+	}
+	gotObjects, ok := restarted.GetObjects("bucket-a", params)
+	if !ok || len(gotObjects.Objects) != 1 || gotObjects.Objects[0].Key != "photos/a.jpg" {
+		t.Fatalf("expected persisted objects, got %v (found=%v)", gotObjects, ok)
+	}
+	if _, ok := restarted.GetLastKnownObjects("bucket-a", params); !ok {
+		t.Fatal("expected persisted last known objects")
+	}
+}
+
+func TestListCacheRepository_InvalidationIsPersisted(t *testing.T) {
+	db, _ := setupTestDB(t)
+
+	params := serviceif.ListObjectsParams{MaxKeys: 100}
+	first := NewListCacheRepository(WithListCacheDB(db))
+	first.SetBuckets([]domain.Bucket{{Name: "bucket-a"}})
+	first.SetObjects("bucket-a", params, &domain.ListObjectsResult{})
+	first.SetObjects("bucket-b", params, &domain.ListObjectsResult{})
+	first.InvalidateBuckets()
+	first.InvalidateObjects("bucket-a")
+
+	restarted := NewListCacheRepository(WithListCacheDB(db))
+
+	if _, ok := restarted.GetBuckets(); ok {
+		t.Error("expected invalidated buckets not to be reloaded")
+	}
+	if _, ok := restarted.GetObjects("bucket-a", params); ok {
+		t.Error("expected invalidated objects not to be reloaded")
+	}
+	if _, ok := restarted.GetObjects("bucket-b", params); !ok {
+		t.Error("expected objects of other buckets to be reloaded")
+	}
+	// 最後に取得できた一覧は無効化の対象外
+	if _, ok := restarted.GetLastKnownBuckets(); !ok {
+		t.Error("expected last known buckets to survive invalidation")
+	}
+}
+
+func TestListCacheRepository_ExpiredEntriesAreNotLoaded(t *testing.T) {
+	db, _ := setupTestDB(t)
+
+	store := &listCacheStore{db: db}
+	store.save(listCacheKindBuckets, bucketsCacheKey, "", []domain.Bucket{{Name: "old"}}, -time.Minute)
+
+	restarted := NewListCacheRepository(WithListCacheDB(db))
+	if _, ok := restarted.GetBuckets(); ok {
+		t.Fatal("expected expired buckets not to be loaded")
+	}
+
+	deleted, err := store.cleanupExpired(context.Background())
+	if err != nil {
+		t.Fatalf("cleanupExpired failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 expired row deleted, got %d", deleted)
+	}
+}
+
+func TestListCacheRepository_DelimiterIsPartOfKey(t *testing.T) {
+	r := NewListCacheRepository()
+
+	flat := serviceif.ListObjectsParams{Prefix: "photos/"}
+	folder := serviceif.ListObjectsParams{Prefix: "photos/", Delimiter: "/"}
+	r.SetObjects("bucket-a", flat, &domain.ListObjectsResult{Objects: []domain.Object{{Key: "photos/2024/a.jpg"}}})
+
+	if _, ok := r.GetObjects("bucket-a", folder); ok {
+		t.Fatal("expected folder listing not to collide with flat listing")
+	}
+}
+
+func TestListCacheRepository_AddObjectUpdatesAffectedListings(t *testing.T) {
+	r := NewListCacheRepository()
+
+	root := serviceif.ListObjectsParams{Delimiter: "/"}
+	photos := serviceif.ListObjectsParams{Prefix: "photos/", Delimiter: "/"}
+	flat := serviceif.ListObjectsParams{Prefix: "photos/"}
+	docs := serviceif.ListObjectsParams{Prefix: "docs/", Delimiter: "/"}
+	paged := serviceif.ListObjectsParams{Prefix: "photos/", Delimiter: "/", MaxKeys: 1}
+	all := serviceif.ListObjectsParams{Prefix: "photos/", All: true}
+
+	r.SetObjects("bucket-a", root, &domain.ListObjectsResult{Objects: []domain.Object{
+		{Key: "readme.txt", ETag: `"r"`},
+		{Key: "docs/"},
+	}})
+	r.SetObjects("bucket-a", photos, &domain.ListObjectsResult{Objects: []domain.Object{
+		{Key: "photos/a.jpg", ETag: `"a"`},
+		{Key: "photos/c.jpg", ETag: `"c"`},
+		{Key: "photos/2023/"},
+	}})
+	r.SetObjects("bucket-a", flat, &domain.ListObjectsResult{Objects: []domain.Object{
+		{Key: "photos/2023/x.jpg", ETag: `"x"`},
+	}})
+	r.SetObjects("bucket-a", docs, &domain.ListObjectsResult{Objects: []domain.Object{
+		{Key: "docs/manual.pdf", ETag: `"m"`},
+	}})
+	r.SetObjects("bucket-a", paged, &domain.ListObjectsResult{
+		Objects:     []domain.Object{{Key: "photos/a.jpg", ETag: `"a"`}},
+		IsTruncated: true,
+	})
+	r.SetObjects("bucket-a", all, &domain.ListObjectsResult{Objects: []domain.Object{
+		{Key: "photos/a.jpg", ETag: `"a"`},
+	}})
+
+	r.AddObject("bucket-a", domain.Object{Key: "photos/b.jpg", Size: 3, ETag: `"b"`})
+	r.AddObject("bucket-a", domain.Object{Key: "photos/2024/y.jpg", ETag: `"y"`})
+
+	keysOf := func(params serviceif.ListObjectsParams) []string {
+		t.Helper()
+		result, ok := r.GetObjects("bucket-a", params)
+		if !ok {
+			t.Fatalf("expected listing %+v to be cached", params)
+		}
+		keys := make([]string, 0, len(result.Objects))
+		for _, o := range result.Objects {
+			keys = append(keys, o.Key)
+		}
+		return keys
+	}
+
+	if got := strings.Join(keysOf(photos), ","); got != "photos/a.jpg,photos/b.jpg,photos/c.jpg,photos/2023/,photos/2024/" {
+		t.Errorf("unexpected folder listing: %s", got)
+	}
+	if got := strings.Join(keysOf(flat), ","); got != "photos/2023/x.jpg,photos/2024/y.jpg,photos/b.jpg" {
+		t.Errorf("unexpected flat listing: %s", got)
+	}
+	// 親の階層では既存のフォルダにまとめられるため変化しない
+	if got := strings.Join(keysOf(root), ","); got != "readme.txt,docs/,photos/" {
+		t.Errorf("unexpected root listing: %s", got)
+	}
+	if got := strings.Join(keysOf(docs), ","); got != "docs/manual.pdf" {
+		t.Errorf("unrelated listing should be untouched: %s", got)
+	}
+	if _, ok := r.GetObjects("bucket-a", paged); ok {
+		t.Error("expected paginated listing to be dropped")
+	}
+	if _, ok := r.GetObjects("bucket-a", all); ok {
+		t.Error("expected listing of all pages to be dropped")
+	}
+}
+
+func TestListCacheRepository_InvalidateObjectKeys(t *testing.T) {
+	r := NewListCacheRepository()
+
+	photos := serviceif.ListObjectsParams{Prefix: "photos/", Delimiter: "/"}
+	docs := serviceif.ListObjectsParams{Prefix: "docs/", Delimiter: "/"}
+	r.SetObjects("bucket-a", photos, &domain.ListObjectsResult{})
+	r.SetObjects("bucket-a", docs, &domain.ListObjectsResult{})
+	r.SetObjects("bucket-b", photos, &domain.ListObjectsResult{})
+
+	r.InvalidateObjectKeys("bucket-a", []string{"photos/a.jpg"})
+
+	if _, ok := r.GetObjects("bucket-a", photos); ok {
+		t.Error("expected affected listing to be dropped")
+	}
+	if _, ok := r.GetLastKnownObjects("bucket-a", photos); ok {
+		t.Error("expected affected last known listing to be dropped")
+	}
+	if _, ok := r.GetObjects("bucket-a", docs); !ok {
+		t.Error("expected unrelated prefix to be kept")
+	}
+	if _, ok := r.GetLastKnownObjects("bucket-a", docs); !ok {
+		t.Error("expected unrelated last known listing to be kept")
+	}
+	if _, ok := r.GetObjects("bucket-b", photos); !ok {
+		t.Error("expected other bucket to be kept")
+	}
+	if _, ok := r.GetLastKnownObjects("bucket-b", photos); !ok {
+		t.Error("expected last known listing of other bucket to be kept")
+	}
+}
+
+func TestListCacheRepository_InvalidateObjectKeysIsPersisted(t *testing.T) {
+	db, _ := setupTestDB(t)
+
+	photos := serviceif.ListObjectsParams{Prefix: "photos/"}
+	first := NewListCacheRepository(WithListCacheDB(db))
+	first.SetObjects("bucket-a", photos, &domain.ListObjectsResult{})
+	first.InvalidateObjectKeys("bucket-a", []string{"photos/a.jpg"})
+
+	restarted := NewListCacheRepository(WithListCacheDB(db))
+	if _, ok := restarted.GetLastKnownObjects("bucket-a", photos); ok {
+		t.Error("expected invalidated last known listing not to be restored")
+	}
+}
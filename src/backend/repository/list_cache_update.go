@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// AddObject はオブジェクトの作成・上書きを、そのキーを含みうる一覧（プレフィックスが一致する一覧）にだけ反映する。
+// 1 ページに収まっている一覧はその場で更新し、ページングされていて位置を決められない一覧や全ページをまとめた一覧は破棄する。
+func (r *ListCacheRepository) AddObject(bucketName string, obj domain.Object) {
+	r.updateObjectListings(r.objects, listCacheKindObjects, bucketName, obj.Key, true, func(result *domain.ListObjectsResult, params serviceif.ListObjectsParams) (*domain.ListObjectsResult, bool) {
+		return addToListing(result, params, obj)
+	})
+	r.updateObjectListings(r.lastObjects, listCacheKindLastObjects, bucketName, obj.Key, false, func(result *domain.ListObjectsResult, params serviceif.ListObjectsParams) (*domain.ListObjectsResult, bool) {
+		return addToListing(result, params, obj)
+	})
+}
+
+// InvalidateObjectKeys は keys のいずれかを含みうる一覧だけを破棄する。
+// 削除やコピーのように結果の一覧を組み立てられない変更で使う。
+// S3 に接続できない場合に古い一覧を返さないよう、最後に取得できた一覧からも破棄する。
+func (r *ListCacheRepository) InvalidateObjectKeys(bucketName string, keys []string) {
+	r.deleteObjectListings(r.objects, listCacheKindObjects, bucketName, keys)
+	r.deleteObjectListings(r.lastObjects, listCacheKindLastObjects, bucketName, keys)
+}
+
+// deleteObjectListings は bucketName の一覧のうち、プレフィックスが keys のいずれかに一致するものを破棄する。
+func (r *ListCacheRepository) deleteObjectListings(c *cache.Cache, kind, bucketName string, keys []string) {
+	for key := range c.Items() {
+		b, params, ok := parseObjectsCacheKey(key)
+		if !ok || b != bucketName {
+			continue
+		}
+		if !slices.ContainsFunc(keys, func(k string) bool { return strings.HasPrefix(k, params.Prefix) }) {
+			continue
+		}
+		c.Delete(key)
+		if r.store != nil {
+			r.store.delete(kind, key)
+		}
+	}
+}
+
+// updateObjectListings は bucketName の一覧のうち、プレフィックスが objectKey に一致するものに update を適用する。
+// update が false を返した一覧は drop が true なら破棄し、false ならそのまま残す。
+func (r *ListCacheRepository) updateObjectListings(c *cache.Cache, kind, bucketName, objectKey string, drop bool, update func(*domain.ListObjectsResult, serviceif.ListObjectsParams) (*domain.ListObjectsResult, bool)) {
+	for key, item := range c.Items() {
+		b, params, ok := parseObjectsCacheKey(key)
+		if !ok || b != bucketName || !strings.HasPrefix(objectKey, params.Prefix) {
+			continue
+		}
+		result, ok := item.Object.(*domain.ListObjectsResult)
+		if !ok {
+			continue
+		}
+
+		updated, ok := update(result, params)
+		if !ok {
+			if drop {
+				c.Delete(key)
+				if r.store != nil {
+					r.store.delete(kind, key)
+				}
+			}
+			continue
+		}
+		if updated == result {
+			continue
+		}
+
+		// 有効期限は元の一覧のものを引き継ぐ
+		ttl := time.Until(time.Unix(0, item.Expiration))
+		if ttl <= 0 {
+			continue
+		}
+		c.Set(key, updated, ttl)
+		if r.store != nil {
+			r.store.save(kind, key, bucketName, updated, ttl)
+		}
+	}
+}
+
+// addToListing は一覧に obj を反映した新しい一覧を返す。変更が不要な場合は result をそのまま返す。
+// 続きのページがある一覧は obj がどのページに入るか分からないため false を返す。
+// 全ページをまとめた一覧 (All) も、取得時の件数の上限などを再現できないため false を返して取得し直させる。
+func addToListing(result *domain.ListObjectsResult, params serviceif.ListObjectsParams, obj domain.Object) (*domain.ListObjectsResult, bool) {
+	if params.All || result.IsTruncated || params.ContinuationToken != "" || params.StartAfter != "" {
+		return nil, false
+	}
+
+	// 区切り文字を指定した一覧では、下位の階層のキーは CommonPrefixes（フォルダ）として現れる
+	entry := obj
+	isPrefix := false
+	if params.Delimiter != "" {
+		rest := obj.Key[len(params.Prefix):]
+		if i := strings.Index(rest, params.Delimiter); i >= 0 {
+			entry = domain.Object{Key: params.Prefix + rest[:i+len(params.Delimiter)]}
+			isPrefix = true
+		}
+	}
+
+	if i := slices.IndexFunc(result.Objects, func(o domain.Object) bool { return o.Key == entry.Key }); i >= 0 {
+		if isPrefix {
+			return result, true
+		}
+		updated := *result
+		updated.Objects = slices.Clone(result.Objects)
+		updated.Objects[i] = entry
+		return &updated, true
+	}
+
+	// 1 ページの件数が上限に達している場合、追加すると溢れた要素が次のページに移る
+	if params.MaxKeys > 0 && len(result.Objects) >= int(params.MaxKeys) {
+		return nil, false
+	}
+
+	// 一覧は Contents をキー順に並べた後に CommonPrefixes をキー順に並べている
+	contentsEnd := slices.IndexFunc(result.Objects, func(o domain.Object) bool { return isCommonPrefix(o, params.Delimiter) })
+	if contentsEnd < 0 {
+		contentsEnd = len(result.Objects)
+	}
+	start, end := 0, contentsEnd
+	if isPrefix {
+		start, end = contentsEnd, len(result.Objects)
+	}
+	pos := start + sortSearchKey(result.Objects[start:end], entry.Key)
+
+	updated := *result
+	updated.Objects = slices.Insert(slices.Clone(result.Objects), pos, entry)
+	return &updated, true
+}
+
+// isCommonPrefix は一覧の要素が CommonPrefixes 由来のフォルダかどうかを返す。
+// フォルダはキー以外の属性を持たないため、実体のあるフォルダマーカーとは ETag で区別できる。
+func isCommonPrefix(o domain.Object, delimiter string) bool {
+	return delimiter != "" && o.ETag == "" && o.LastModified.IsZero() && strings.HasSuffix(o.Key, delimiter)
+}
+
+func sortSearchKey(objects []domain.Object, key string) int {
+	pos, _ := slices.BinarySearchFunc(objects, key, func(o domain.Object, key string) int {
+		return strings.Compare(o.Key, key)
+	})
+	return pos
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	"github.com/pkg/errors"
 
 	"r2manager/domain"
@@ -29,6 +30,15 @@ func (r *ObjectRepository) GetObjects(ctx context.Context, bucketName string, pa
 	if params.Delimiter != "" {
 		input.Delimiter = aws.String(params.Delimiter)
 	}
+	if params.ContinuationToken != "" {
+		input.ContinuationToken = aws.String(params.ContinuationToken)
+	}
+	if params.StartAfter != "" {
+		input.StartAfter = aws.String(params.StartAfter)
+	}
+	if params.MaxKeys > 0 {
+		input.MaxKeys = aws.Int32(params.MaxKeys)
+	}
 
 	output, err := r.client.ListObjectsV2(ctx, input)
 	if err != nil {
@@ -80,3 +90,119 @@ func (r *ObjectRepository) GetObjects(ctx context.Context, bucketName string, pa
 
 	return result, nil
 }
+
+// ListAllObjects は prefix 配下の全オブジェクトを継続トークンを辿って取得する。
+// フォルダ単位の一括操作で使用するため、区切り文字は指定しない。
+func (r *ObjectRepository) ListAllObjects(ctx context.Context, bucketName, prefix string) ([]domain.Object, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+
+	var objects []domain.Object
+	paginator := s3.NewListObjectsV2Paginator(r.client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to ListObjectsV2")
+		}
+		for _, o := range output.Contents {
+			obj := domain.Object{}
+			if o.Key != nil {
+				obj.Key = *o.Key
+			}
+			if o.Size != nil {
+				obj.Size = *o.Size
+			}
+			if o.LastModified != nil {
+				obj.LastModified = *o.LastModified
+			}
+			if o.ETag != nil {
+				obj.ETag = *o.ETag
+			}
+			objects = append(objects, obj)
+		}
+	}
+
+	return objects, nil
+}
+
+func (r *ObjectRepository) HeadObject(ctx context.Context, bucketName, key string) (*domain.Object, error) {
+	output, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, serviceif.ErrObjectNotFound
+		}
+		return nil, errors.Wrap(err, "failed to HeadObject")
+	}
+
+	obj := &domain.Object{Key: key}
+	if output.ContentLength != nil {
+		obj.Size = *output.ContentLength
+	}
+	if output.LastModified != nil {
+		obj.LastModified = *output.LastModified
+	}
+	if output.ETag != nil {
+		obj.ETag = *output.ETag
+	}
+
+	return obj, nil
+}
+
+// HeadObjectMetadata は HeadObject でオブジェクトのメタデータを取得する。
+func (r *ObjectRepository) HeadObjectMetadata(ctx context.Context, bucketName, key string) (*domain.ObjectMetadata, error) {
+	output, err := r.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, serviceif.ErrObjectNotFound
+		}
+		return nil, errors.Wrap(err, "failed to HeadObject")
+	}
+
+	meta := &domain.ObjectMetadata{Key: key, Metadata: output.Metadata}
+	if output.ContentLength != nil {
+		meta.Size = *output.ContentLength
+	}
+	if output.LastModified != nil {
+		meta.LastModified = *output.LastModified
+	}
+	if output.ETag != nil {
+		meta.ETag = *output.ETag
+	}
+	if output.ContentType != nil {
+		meta.ContentType = *output.ContentType
+	}
+	if output.CacheControl != nil {
+		meta.CacheControl = *output.CacheControl
+	}
+	if output.ContentDisposition != nil {
+		meta.ContentDisposition = *output.ContentDisposition
+	}
+	if output.ContentEncoding != nil {
+		meta.ContentEncoding = *output.ContentEncoding
+	}
+	if meta.Metadata == nil {
+		meta.Metadata = map[string]string{}
+	}
+
+	return meta, nil
+}
+
+// isNotFound は HeadObject / GetObject の 404 を判定する。
+// HEAD はレスポンスボディを持たないため、エラーコードは "NotFound" になる。
+func isNotFound(err error) bool {
+	var respErr smithy.APIError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.ErrorCode() == "NotFound" || respErr.ErrorCode() == "NoSuchKey"
+}
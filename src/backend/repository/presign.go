@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+type PresignRepository struct {
+	client *s3.PresignClient
+}
+
+func NewPresignRepository(client *s3.PresignClient) *PresignRepository {
+	return &PresignRepository{client: client}
+}
+
+func (r *PresignRepository) PresignGetObject(ctx context.Context, bucketName, key, contentDisposition string, expires time.Duration) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}
+	if contentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(contentDisposition)
+	}
+
+	req, err := r.client.PresignGetObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to PresignGetObject")
+	}
+
+	return req.URL, nil
+}
+
+func (r *PresignRepository) PresignPutObject(ctx context.Context, bucketName, key, contentType string, expires time.Duration) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	req, err := r.client.PresignPutObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to PresignPutObject")
+	}
+
+	return req.URL, nil
+}
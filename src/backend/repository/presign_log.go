@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type PresignLogRepository struct {
+	db *sql.DB
+}
+
+func NewPresignLogRepository(db *sql.DB) *PresignLogRepository {
+	return &PresignLogRepository{db: db}
+}
+
+func (r *PresignLogRepository) Insert(ctx context.Context, record *domain.PresignRecord) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO presigned_urls (bucket_name, object_key, method, issued_by, content_disposition, issued_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		record.BucketName, record.ObjectKey, record.Method, record.IssuedBy, record.ContentDisposition, record.IssuedAt, record.ExpiresAt,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to insert presigned url record")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get presigned url record id")
+	}
+
+	return id, nil
+}
+
+func (r *PresignLogRepository) List(ctx context.Context, filter serviceif.PresignRecordFilter) ([]domain.PresignRecord, error) {
+	var conditions []string
+	var args []any
+	if filter.BucketName != "" {
+		conditions = append(conditions, "bucket_name = ?")
+		args = append(args, filter.BucketName)
+	}
+	if filter.ActiveOnly {
+		conditions = append(conditions, "expires_at > ?")
+		args = append(args, time.Now().UTC())
+	}
+
+	query := `SELECT id, bucket_name, object_key, method, issued_by, content_disposition, issued_at, expires_at FROM presigned_urls`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY issued_at DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query presigned url records")
+	}
+	defer rows.Close()
+
+	records := []domain.PresignRecord{}
+	for rows.Next() {
+		var rec domain.PresignRecord
+		if err := rows.Scan(
+			&rec.ID,
+			&rec.BucketName,
+			&rec.ObjectKey,
+			&rec.Method,
+			&rec.IssuedBy,
+			&rec.ContentDisposition,
+			&rec.IssuedAt,
+			&rec.ExpiresAt,
+		); err != nil {
+			return nil, errors.Wrap(err, "failed to scan presigned url record")
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to iterate presigned url records")
+	}
+
+	return records, nil
+}
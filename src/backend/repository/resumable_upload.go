@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// ResumableUploadRepository は再開可能アップロードの状態を SQLite に、
+// S3 へ送信前のパートデータをステージングファイルに保持する。
+type ResumableUploadRepository struct {
+	db         *sql.DB
+	stagingDir string
+}
+
+func NewResumableUploadRepository(db *sql.DB, stagingDir string) *ResumableUploadRepository {
+	return &ResumableUploadRepository{db: db, stagingDir: stagingDir}
+}
+
+func (r *ResumableUploadRepository) Create(ctx context.Context, upload *domain.ResumableUpload) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO resumable_uploads (id, bucket_name, object_key, content_type, overwrite, upload_length, upload_offset, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)`,
+		upload.ID, upload.BucketName, upload.ObjectKey, upload.ContentType, upload.Overwrite, upload.Length, upload.CreatedAt, upload.UpdatedAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert resumable upload")
+	}
+	return nil
+}
+
+const resumableUploadColumns = `id, bucket_name, object_key, content_type, overwrite, upload_length, upload_offset, s3_upload_id, etag, created_at, updated_at, completed_at`
+
+func (r *ResumableUploadRepository) Get(ctx context.Context, id string) (*domain.ResumableUpload, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+resumableUploadColumns+` FROM resumable_uploads WHERE id = ?`,
+		id,
+	)
+
+	u, err := scanResumableUpload(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query resumable upload")
+	}
+	return u, nil
+}
+
+// ListUpdatedBefore は before より前から更新されていないアップロードを返す。
+func (r *ResumableUploadRepository) ListUpdatedBefore(ctx context.Context, before time.Time) ([]domain.ResumableUpload, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+resumableUploadColumns+` FROM resumable_uploads WHERE updated_at < ? ORDER BY updated_at ASC`,
+		before,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query resumable uploads")
+	}
+	defer rows.Close()
+
+	var uploads []domain.ResumableUpload
+	for rows.Next() {
+		u, err := scanResumableUpload(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan resumable upload")
+		}
+		uploads = append(uploads, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to iterate resumable uploads")
+	}
+
+	return uploads, nil
+}
+
+func scanResumableUpload(row rowScanner) (*domain.ResumableUpload, error) {
+	var u domain.ResumableUpload
+	var completedAt sql.NullTime
+	err := row.Scan(
+		&u.ID,
+		&u.BucketName,
+		&u.ObjectKey,
+		&u.ContentType,
+		&u.Overwrite,
+		&u.Length,
+		&u.Offset,
+		&u.S3UploadID,
+		&u.ETag,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		u.CompletedAt = &completedAt.Time
+	}
+
+	return &u, nil
+}
+
+func (r *ResumableUploadRepository) UpdateOffset(ctx context.Context, id string, offset int64) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE resumable_uploads SET upload_offset = ?, updated_at = ? WHERE id = ?`,
+		offset, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to update resumable upload offset")
+	}
+	return nil
+}
+
+func (r *ResumableUploadRepository) SetS3UploadID(ctx context.Context, id, s3UploadID string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE resumable_uploads SET s3_upload_id = ?, updated_at = ? WHERE id = ?`,
+		s3UploadID, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to update resumable upload s3 upload id")
+	}
+	return nil
+}
+
+func (r *ResumableUploadRepository) MarkCompleted(ctx context.Context, id, etag string) error {
+	now := time.Now().UTC()
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE resumable_uploads SET etag = ?, completed_at = ?, updated_at = ? WHERE id = ?`,
+		etag, now, now, id,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to mark resumable upload completed")
+	}
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM resumable_upload_parts WHERE upload_id = ?`, id); err != nil {
+		return errors.Wrap(err, "failed to delete resumable upload parts")
+	}
+	r.RemoveStaging(id)
+
+	return nil
+}
+
+func (r *ResumableUploadRepository) AddPart(ctx context.Context, id string, part domain.UploadPart) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO resumable_upload_parts (upload_id, part_number, etag, size) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(upload_id, part_number) DO UPDATE SET etag = excluded.etag, size = excluded.size`,
+		id, part.PartNumber, part.ETag, part.Size,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert resumable upload part")
+	}
+	return nil
+}
+
+func (r *ResumableUploadRepository) ListParts(ctx context.Context, id string) ([]domain.UploadPart, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT part_number, etag, size FROM resumable_upload_parts WHERE upload_id = ? ORDER BY part_number ASC`,
+		id,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query resumable upload parts")
+	}
+	defer rows.Close()
+
+	var parts []domain.UploadPart
+	for rows.Next() {
+		var p domain.UploadPart
+		if err := rows.Scan(&p.PartNumber, &p.ETag, &p.Size); err != nil {
+			return nil, errors.Wrap(err, "failed to scan resumable upload part")
+		}
+		parts = append(parts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to iterate resumable upload parts")
+	}
+
+	return parts, nil
+}
+
+func (r *ResumableUploadRepository) Delete(ctx context.Context, id string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM resumable_upload_parts WHERE upload_id = ?`, id); err != nil {
+		return errors.Wrap(err, "failed to delete resumable upload parts")
+	}
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM resumable_uploads WHERE id = ?`, id); err != nil {
+		return errors.Wrap(err, "failed to delete resumable upload")
+	}
+	r.RemoveStaging(id)
+
+	return nil
+}
+
+// WriteStaging はステージングファイルを stagedSize に切り詰めた上で、末尾に body から最大 limit バイト書き込む。
+// 書き込み途中でエラーになった場合も、書き込めたバイト数を返す。
+func (r *ResumableUploadRepository) WriteStaging(id string, stagedSize int64, body io.Reader, limit int64) (int64, error) {
+	if err := os.MkdirAll(r.stagingDir, 0755); err != nil {
+		return 0, errors.Wrap(err, "failed to create staging directory")
+	}
+
+	f, err := os.OpenFile(r.stagingPath(id), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open staging file")
+	}
+	defer f.Close()
+
+	// 前回の書き込みがクラッシュ等で DB に記録されなかった分を捨てる
+	if err := f.Truncate(stagedSize); err != nil {
+		return 0, errors.Wrap(err, "failed to truncate staging file")
+	}
+	if _, err := f.Seek(stagedSize, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "failed to seek staging file")
+	}
+
+	n, copyErr := io.CopyN(f, body, limit)
+	if err := f.Sync(); err != nil {
+		return 0, errors.Wrap(err, "failed to sync staging file")
+	}
+	if copyErr != nil && copyErr != io.EOF {
+		return n, errors.Wrap(copyErr, "failed to write staging file")
+	}
+
+	return n, nil
+}
+
+// OpenStaging はステージングファイルを先頭から size バイト分だけ読み出せる形で開く。
+func (r *ResumableUploadRepository) OpenStaging(id string, size int64) (io.ReadSeekCloser, error) {
+	f, err := os.Open(r.stagingPath(id))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open staging file")
+	}
+	return &sectionReadCloser{SectionReader: io.NewSectionReader(f, 0, size), f: f}, nil
+}
+
+func (r *ResumableUploadRepository) RemoveStaging(id string) {
+	os.Remove(r.stagingPath(id))
+}
+
+func (r *ResumableUploadRepository) stagingPath(id string) string {
+	return filepath.Join(r.stagingDir, filepath.Base(id)+".part")
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (s *sectionReadCloser) Close() error {
+	return s.f.Close()
+}
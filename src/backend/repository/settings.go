@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -17,8 +18,39 @@ func NewSettingsRepository(db *sql.DB) *SettingsRepository {
 	return &SettingsRepository{db: db}
 }
 
+const bucketSettingsColumns = `bucket_name, public_url, cache_ttl_seconds, cache_max_size, cache_never, cache_pinned, cache_include_types, cache_exclude_types`
+
+func scanBucketSettings(row rowScanner) (*domain.BucketSettings, error) {
+	var s domain.BucketSettings
+	var p domain.BucketCachePolicy
+	var includeTypes, excludeTypes string
+	err := row.Scan(&s.BucketName, &s.PublicUrl, &p.TTLSeconds, &p.MaxSize, &p.NeverCache, &p.Pinned, &includeTypes, &excludeTypes)
+	if err != nil {
+		return nil, err
+	}
+	p.IncludeContentTypes = splitContentTypes(includeTypes)
+	p.ExcludeContentTypes = splitContentTypes(excludeTypes)
+	s.CachePolicy = &p
+	return &s, nil
+}
+
+// Content-Type のリストはカンマ区切りで保存する
+func splitContentTypes(s string) []string {
+	types := []string{}
+	for t := range strings.SplitSeq(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func joinContentTypes(types []string) string {
+	return strings.Join(splitContentTypes(strings.Join(types, ",")), ",")
+}
+
 func (r *SettingsRepository) GetAllBucketSettings(ctx context.Context) ([]domain.BucketSettings, error) {
-	rows, err := r.db.QueryContext(ctx, `SELECT bucket_name, public_url FROM bucket_settings`)
+	rows, err := r.db.QueryContext(ctx, `SELECT `+bucketSettingsColumns+` FROM bucket_settings`)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to query bucket settings")
 	}
@@ -26,11 +58,11 @@ func (r *SettingsRepository) GetAllBucketSettings(ctx context.Context) ([]domain
 
 	var settings []domain.BucketSettings
 	for rows.Next() {
-		var s domain.BucketSettings
-		if err := rows.Scan(&s.BucketName, &s.PublicUrl); err != nil {
+		s, err := scanBucketSettings(rows)
+		if err != nil {
 			return nil, errors.Wrap(err, "failed to scan bucket settings")
 		}
-		settings = append(settings, s)
+		settings = append(settings, *s)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, errors.Wrap(err, "failed to iterate bucket settings")
@@ -41,12 +73,11 @@ func (r *SettingsRepository) GetAllBucketSettings(ctx context.Context) ([]domain
 
 func (r *SettingsRepository) GetBucketSettings(ctx context.Context, bucketName string) (*domain.BucketSettings, error) {
 	row := r.db.QueryRowContext(ctx,
-		`SELECT bucket_name, public_url FROM bucket_settings WHERE bucket_name = ?`,
+		`SELECT `+bucketSettingsColumns+` FROM bucket_settings WHERE bucket_name = ?`,
 		bucketName,
 	)
 
-	var s domain.BucketSettings
-	err := row.Scan(&s.BucketName, &s.PublicUrl)
+	s, err := scanBucketSettings(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -54,7 +85,29 @@ func (r *SettingsRepository) GetBucketSettings(ctx context.Context, bucketName s
 		return nil, errors.Wrap(err, "failed to query bucket settings")
 	}
 
-	return &s, nil
+	return s, nil
+}
+
+// GetBucketCachePolicy はバケットのキャッシュ設定を返す。設定がない場合は nil を返す。
+func (r *SettingsRepository) GetBucketCachePolicy(ctx context.Context, bucketName string) (*domain.BucketCachePolicy, error) {
+	s, err := r.GetBucketSettings(ctx, bucketName)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return s.CachePolicy, nil
+}
+
+// GetAllBucketCachePolicies はバケット名をキーにした全バケットのキャッシュ設定を返す。
+func (r *SettingsRepository) GetAllBucketCachePolicies(ctx context.Context) (map[string]*domain.BucketCachePolicy, error) {
+	settings, err := r.GetAllBucketSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]*domain.BucketCachePolicy, len(settings))
+	for _, s := range settings {
+		policies[s.BucketName] = s.CachePolicy
+	}
+	return policies, nil
 }
 
 func (r *SettingsRepository) UpsertBucketSettings(ctx context.Context, bucketName, publicUrl string) error {
@@ -70,6 +123,28 @@ func (r *SettingsRepository) UpsertBucketSettings(ctx context.Context, bucketNam
 	return nil
 }
 
+const upsertBucketCachePolicySQL = `INSERT INTO bucket_settings (bucket_name, cache_ttl_seconds, cache_max_size, cache_never, cache_pinned, cache_include_types, cache_exclude_types)
+ VALUES (?, ?, ?, ?, ?, ?, ?)
+ ON CONFLICT(bucket_name) DO UPDATE SET
+   cache_ttl_seconds = excluded.cache_ttl_seconds,
+   cache_max_size = excluded.cache_max_size,
+   cache_never = excluded.cache_never,
+   cache_pinned = excluded.cache_pinned,
+   cache_include_types = excluded.cache_include_types,
+   cache_exclude_types = excluded.cache_exclude_types`
+
+func (r *SettingsRepository) UpsertBucketCachePolicy(ctx context.Context, bucketName string, policy domain.BucketCachePolicy) error {
+	_, err := r.db.ExecContext(ctx, upsertBucketCachePolicySQL,
+		bucketName, policy.TTLSeconds, policy.MaxSize, policy.NeverCache, policy.Pinned,
+		joinContentTypes(policy.IncludeContentTypes), joinContentTypes(policy.ExcludeContentTypes),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to upsert bucket cache policy")
+	}
+
+	return nil
+}
+
 func (r *SettingsRepository) BulkUpsertBucketSettings(ctx context.Context, settings []domain.BucketSettings) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -86,10 +161,23 @@ func (r *SettingsRepository) BulkUpsertBucketSettings(ctx context.Context, setti
 	}
 	defer stmt.Close()
 
+	policyStmt, err := tx.PrepareContext(ctx, upsertBucketCachePolicySQL)
+	if err != nil {
+		return errors.Wrap(err, "failed to prepare statement")
+	}
+	defer policyStmt.Close()
+
 	for _, s := range settings {
 		if _, err := stmt.ExecContext(ctx, s.BucketName, s.PublicUrl); err != nil {
 			return errors.Wrap(err, "failed to upsert bucket settings")
 		}
+		if p := s.CachePolicy; p != nil {
+			_, err := policyStmt.ExecContext(ctx, s.BucketName, p.TTLSeconds, p.MaxSize, p.NeverCache, p.Pinned,
+				joinContentTypes(p.IncludeContentTypes), joinContentTypes(p.ExcludeContentTypes))
+			if err != nil {
+				return errors.Wrap(err, "failed to upsert bucket cache policy")
+			}
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -98,3 +186,11 @@ func (r *SettingsRepository) BulkUpsertBucketSettings(ctx context.Context, setti
 
 	return nil
 }
+
+// DeleteBucketSettings は削除したバケットの設定を消す。設定がなくてもエラーにしない。
+func (r *SettingsRepository) DeleteBucketSettings(ctx context.Context, bucketName string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM bucket_settings WHERE bucket_name = ?`, bucketName); err != nil {
+		return errors.Wrap(err, "failed to delete bucket settings")
+	}
+	return nil
+}
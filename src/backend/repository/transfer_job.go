@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+// TransferJobRepository は転送ジョブの設定と再開用のチェックポイントを保存する。
+type TransferJobRepository struct {
+	db *sql.DB
+}
+
+func NewTransferJobRepository(db *sql.DB) *TransferJobRepository {
+	return &TransferJobRepository{db: db}
+}
+
+const transferJobColumns = `id, source_connection, source_bucket, source_prefix, dest_connection, dest_bucket, dest_prefix,
+	include_patterns, exclude_patterns, skip_same_etag, dry_run, concurrency, mode, status,
+	total, copied, skipped, failed, bytes_total, bytes_copied, checkpoint,
+	checkpoint_copied, checkpoint_skipped, checkpoint_bytes_copied, failures, error, created_at, started_at, finished_at`
+
+func scanTransferJob(row rowScanner) (*domain.TransferJob, error) {
+	var j domain.TransferJob
+	var include, exclude, failures string
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&j.ID, &j.Source.Connection, &j.Source.Bucket, &j.Source.Prefix,
+		&j.Destination.Connection, &j.Destination.Bucket, &j.Destination.Prefix,
+		&include, &exclude, &j.SkipSameETag, &j.DryRun, &j.Concurrency, &j.Mode, &j.Status,
+		&j.Total, &j.Copied, &j.Skipped, &j.Failed, &j.BytesTotal, &j.BytesCopied, &j.Checkpoint,
+		&j.CheckpointCopied, &j.CheckpointSkipped, &j.CheckpointBytesCopied, &failures, &j.Error,
+		&j.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.Include = splitPatterns(include)
+	j.Exclude = splitPatterns(exclude)
+	if err := json.Unmarshal([]byte(failures), &j.Failures); err != nil {
+		return nil, errors.Wrap(err, "failed to decode transfer failures")
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+// glob のパターンはカンマを含みうるため改行区切りで保存する
+func splitPatterns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// SaveJob はジョブを保存する。同じ ID のジョブがある場合は状態とチェックポイントを更新する。
+func (r *TransferJobRepository) SaveJob(ctx context.Context, job *domain.TransferJob) error {
+	failures, err := json.Marshal(job.Failures)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode transfer failures")
+	}
+	if job.Failures == nil {
+		failures = []byte("[]")
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		`INSERT INTO transfer_jobs (`+transferJobColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET mode = excluded.mode, status = excluded.status,
+		   total = excluded.total, copied = excluded.copied, skipped = excluded.skipped, failed = excluded.failed,
+		   bytes_total = excluded.bytes_total, bytes_copied = excluded.bytes_copied, checkpoint = excluded.checkpoint,
+		   checkpoint_copied = excluded.checkpoint_copied, checkpoint_skipped = excluded.checkpoint_skipped,
+		   checkpoint_bytes_copied = excluded.checkpoint_bytes_copied,
+		   failures = excluded.failures, error = excluded.error, started_at = excluded.started_at, finished_at = excluded.finished_at`,
+		job.ID, job.Source.Connection, job.Source.Bucket, job.Source.Prefix,
+		job.Destination.Connection, job.Destination.Bucket, job.Destination.Prefix,
+		strings.Join(job.Include, "\n"), strings.Join(job.Exclude, "\n"), job.SkipSameETag, job.DryRun, job.Concurrency, job.Mode, job.Status,
+		job.Total, job.Copied, job.Skipped, job.Failed, job.BytesTotal, job.BytesCopied, job.Checkpoint,
+		job.CheckpointCopied, job.CheckpointSkipped, job.CheckpointBytesCopied, string(failures), job.Error,
+		job.CreatedAt, job.StartedAt, job.FinishedAt,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to save transfer job")
+	}
+	return nil
+}
+
+// GetJob はジョブを返す。存在しない場合は nil を返す。
+func (r *TransferJobRepository) GetJob(ctx context.Context, id string) (*domain.TransferJob, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+transferJobColumns+` FROM transfer_jobs WHERE id = ?`, id)
+
+	j, err := scanTransferJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query transfer job")
+	}
+	return j, nil
+}
+
+// ListJobs は保存しているジョブを作成順に返す。
+func (r *TransferJobRepository) ListJobs(ctx context.Context) ([]domain.TransferJob, error) {
+	return r.queryJobs(ctx, `SELECT `+transferJobColumns+` FROM transfer_jobs ORDER BY created_at`)
+}
+
+// ListUnfinishedJobs は前回の実行中に終了しなかったジョブを返す。
+func (r *TransferJobRepository) ListUnfinishedJobs(ctx context.Context) ([]domain.TransferJob, error) {
+	return r.queryJobs(ctx, `SELECT `+transferJobColumns+` FROM transfer_jobs WHERE status IN (?, ?) ORDER BY created_at`,
+		domain.TransferQueued, domain.TransferRunning)
+}
+
+func (r *TransferJobRepository) queryJobs(ctx context.Context, query string, args ...any) ([]domain.TransferJob, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query transfer jobs")
+	}
+	defer rows.Close()
+
+	var jobs []domain.TransferJob
+	for rows.Next() {
+		j, err := scanTransferJob(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan transfer job")
+		}
+		jobs = append(jobs, *j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to iterate transfer jobs")
+	}
+	return jobs, nil
+}
+
+// DeleteFinishedBefore は before より前に終了したジョブを削除する。
+func (r *TransferJobRepository) DeleteFinishedBefore(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM transfer_jobs WHERE finished_at IS NOT NULL AND finished_at < ?`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete finished transfer jobs")
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+	"r2manager/infrastructure"
+)
+
+func TestTransferJobRepository_PatternsRoundTrip(t *testing.T) {
+	db, err := infrastructure.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo := NewTransferJobRepository(db)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+	}{
+		{name: "empty"},
+		{name: "single", include: []string{"*.png"}},
+		{name: "commas and spaces", include: []string{"{a,b}/*.jpg", "with space/**"}, exclude: []string{"tmp/", "a,b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &domain.TransferJob{
+				ID:          tt.name,
+				Source:      domain.TransferLocation{Connection: "default", Bucket: "src"},
+				Destination: domain.TransferLocation{Connection: "default", Bucket: "dst"},
+				Include:     tt.include,
+				Exclude:     tt.exclude,
+				Status:      domain.TransferQueued,
+				CreatedAt:   time.Now().UTC(),
+			}
+			if err := repo.SaveJob(ctx, job); err != nil {
+				t.Fatalf("SaveJob: %v", err)
+			}
+
+			got, err := repo.GetJob(ctx, tt.name)
+			if err != nil || got == nil {
+				t.Fatalf("GetJob: %v (found=%v)", err, got != nil)
+			}
+			if !reflect.DeepEqual(got.Include, tt.include) || !reflect.DeepEqual(got.Exclude, tt.exclude) {
+				t.Errorf("expected include=%q exclude=%q, got include=%q exclude=%q", tt.include, tt.exclude, got.Include, got.Exclude)
+			}
+		})
+	}
+}
+
+func TestTransferJobRepository_SavesCheckpointTally(t *testing.T) {
+	db, err := infrastructure.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo := NewTransferJobRepository(db)
+	ctx := context.Background()
+
+	job := &domain.TransferJob{
+		ID:                    "job",
+		Status:                domain.TransferRunning,
+		Copied:                5,
+		Failed:                1,
+		Checkpoint:            "b",
+		CheckpointCopied:      2,
+		CheckpointSkipped:     1,
+		CheckpointBytesCopied: 20,
+		Failures:              []domain.TransferFailure{{Key: "c", Message: "boom"}},
+		CreatedAt:             time.Now().UTC(),
+	}
+	if err := repo.SaveJob(ctx, job); err != nil {
+		t.Fatalf("SaveJob: %v", err)
+	}
+	job.Status = domain.TransferCanceled
+	job.Checkpoint = "c"
+	job.CheckpointCopied = 3
+	if err := repo.SaveJob(ctx, job); err != nil {
+		t.Fatalf("SaveJob (update): %v", err)
+	}
+
+	got, err := repo.GetJob(ctx, "job")
+	if err != nil || got == nil {
+		t.Fatalf("GetJob: %v (found=%v)", err, got != nil)
+	}
+	if got.Status != domain.TransferCanceled || got.Checkpoint != "c" || got.Copied != 5 || got.Failed != 1 {
+		t.Errorf("unexpected job state: %+v", got)
+	}
+	if got.CheckpointCopied != 3 || got.CheckpointSkipped != 1 || got.CheckpointBytesCopied != 20 {
+		t.Errorf("unexpected checkpoint tally: copied=%d skipped=%d bytes=%d", got.CheckpointCopied, got.CheckpointSkipped, got.CheckpointBytesCopied)
+	}
+	if len(got.Failures) != 1 || got.Failures[0].Key != "c" {
+		t.Errorf("unexpected failures: %v", got.Failures)
+	}
+}
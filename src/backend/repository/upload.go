@@ -6,9 +6,11 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	"github.com/pkg/errors"
 
+	"r2manager/domain"
 	serviceif "r2manager/service/interface"
 )
 
@@ -20,12 +22,16 @@ func NewUploadRepository(client *s3.Client) *UploadRepository {
 	return &UploadRepository{client: client}
 }
 
-func (r *UploadRepository) PutObject(ctx context.Context, bucketName, key, contentType string, body io.ReadSeeker) (string, error) {
+func (r *UploadRepository) PutObject(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.ReadSeeker) (string, error) {
 	input := &s3.PutObjectInput{
-		Bucket:      aws.String(bucketName),
-		Key:         aws.String(key),
-		ContentType: aws.String(contentType),
-		Body:        body,
+		Bucket:             aws.String(bucketName),
+		Key:                aws.String(meta.Key),
+		ContentType:        aws.String(meta.ContentType),
+		CacheControl:       optionalString(meta.CacheControl),
+		ContentDisposition: optionalString(meta.ContentDisposition),
+		ContentEncoding:    optionalString(meta.ContentEncoding),
+		Metadata:           meta.Metadata,
+		Body:               body,
 	}
 
 	output, err := r.client.PutObject(ctx, input)
@@ -41,20 +47,22 @@ func (r *UploadRepository) PutObject(ctx context.Context, bucketName, key, conte
 	return etag, nil
 }
 
-func (r *UploadRepository) PutObjectIfNotExists(ctx context.Context, bucketName, key, contentType string, body io.ReadSeeker) (string, error) {
+func (r *UploadRepository) PutObjectIfNotExists(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.ReadSeeker) (string, error) {
 	input := &s3.PutObjectInput{
-		Bucket:      aws.String(bucketName),
-		Key:         aws.String(key),
-		ContentType: aws.String(contentType),
-		Body:        body,
-		IfNoneMatch: aws.String("*"),
+		Bucket:             aws.String(bucketName),
+		Key:                aws.String(meta.Key),
+		ContentType:        aws.String(meta.ContentType),
+		CacheControl:       optionalString(meta.CacheControl),
+		ContentDisposition: optionalString(meta.ContentDisposition),
+		ContentEncoding:    optionalString(meta.ContentEncoding),
+		Metadata:           meta.Metadata,
+		Body:               body,
+		IfNoneMatch:        aws.String("*"),
 	}
 
 	output, err := r.client.PutObject(ctx, input)
 	if err != nil {
-		// R2: 412 PreconditionFailed はオブジェクトが既に存在することを示す
-		var respErr smithy.APIError
-		if errors.As(err, &respErr) && respErr.ErrorCode() == "PreconditionFailed" {
+		if isPreconditionFailed(err) {
 			return "", serviceif.ErrObjectAlreadyExists
 		}
 		return "", errors.Wrap(err, "failed to PutObject")
@@ -67,3 +75,99 @@ func (r *UploadRepository) PutObjectIfNotExists(ctx context.Context, bucketName,
 
 	return etag, nil
 }
+
+// isPreconditionFailed は IfNoneMatch: "*" 指定時の 412 PreconditionFailed を判定する。
+// R2 ではオブジェクトが既に存在することを示す。
+func isPreconditionFailed(err error) bool {
+	var respErr smithy.APIError
+	return errors.As(err, &respErr) && respErr.ErrorCode() == "PreconditionFailed"
+}
+
+func (r *UploadRepository) CreateMultipartUpload(ctx context.Context, bucketName string, meta domain.ObjectMetadata) (string, error) {
+	output, err := r.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(bucketName),
+		Key:                aws.String(meta.Key),
+		ContentType:        aws.String(meta.ContentType),
+		CacheControl:       optionalString(meta.CacheControl),
+		ContentDisposition: optionalString(meta.ContentDisposition),
+		ContentEncoding:    optionalString(meta.ContentEncoding),
+		Metadata:           meta.Metadata,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to CreateMultipartUpload")
+	}
+	if output.UploadId == nil {
+		return "", errors.New("CreateMultipartUpload returned no upload ID")
+	}
+
+	return *output.UploadId, nil
+}
+
+func (r *UploadRepository) UploadPart(ctx context.Context, bucketName, key, uploadID string, partNumber int32, body io.ReadSeeker) (string, error) {
+	output, err := r.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(bucketName),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+		Body:       body,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to UploadPart %d", partNumber)
+	}
+
+	etag := ""
+	if output.ETag != nil {
+		etag = *output.ETag
+	}
+
+	return etag, nil
+}
+
+// CompleteMultipartUpload はパートを結合してオブジェクトを確定する。
+// parts はパート番号の昇順で渡すこと。
+func (r *UploadRepository) CompleteMultipartUpload(ctx context.Context, bucketName, key, uploadID string, parts []domain.UploadPart, overwrite bool) (string, error) {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		})
+	}
+
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	if !overwrite {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	output, err := r.client.CompleteMultipartUpload(ctx, input)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return "", serviceif.ErrObjectAlreadyExists
+		}
+		return "", errors.Wrap(err, "failed to CompleteMultipartUpload")
+	}
+
+	etag := ""
+	if output.ETag != nil {
+		etag = *output.ETag
+	}
+
+	return etag, nil
+}
+
+func (r *UploadRepository) AbortMultipartUpload(ctx context.Context, bucketName, key, uploadID string) error {
+	_, err := r.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to AbortMultipartUpload")
+	}
+	return nil
+}
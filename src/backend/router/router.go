@@ -1,18 +1,16 @@
 package router
 
 import (
-	"os"
-	"strings"
-
 	"github.com/gin-gonic/gin"
 
+	appconfig "r2manager/config"
 	"r2manager/handler"
 )
 
-func NewRouter(bucketsHandler *handler.BucketsHandler, objectsHandler *handler.ObjectsHandler, contentHandler *handler.ContentHandler, cacheHandler *handler.CacheHandler, settingsHandler *handler.SettingsHandler, uploadHandler *handler.UploadHandler, uploadProgressHandler *handler.UploadProgressHandler) *gin.Engine {
+func NewRouter(handlers *handler.Handlers, connectionsHandler *handler.ConnectionsHandler, transferHandler *handler.TransferHandler) *gin.Engine {
 	r := gin.Default()
 
-	trustedIPList := getTrustedIPList()
+	trustedIPList := appconfig.LoadTrustedProxiesFromEnv()
 	if len(trustedIPList) > 0 {
 		r.SetTrustedProxies(trustedIPList)
 	} else {
@@ -21,45 +19,75 @@ func NewRouter(bucketsHandler *handler.BucketsHandler, objectsHandler *handler.O
 
 	api := r.Group("/api/v1")
 	{
-		api.GET("/buckets", bucketsHandler.GetBuckets)
-		api.GET("/buckets/:bucketName/objects", objectsHandler.GetObjects)
-		api.GET("/buckets/:bucketName/content/*key", contentHandler.GetContent)
-
-		api.DELETE("/cache/content", cacheHandler.ClearContentCache)
-		api.DELETE("/cache/api", cacheHandler.ClearAPICache)
-
-		api.GET("/settings/buckets", settingsHandler.GetAllBucketSettings)
-		api.PUT("/settings/buckets", settingsHandler.BulkUpdateBucketSettings)
-		api.GET("/settings/buckets/:bucketName", settingsHandler.GetBucketSettings)
-		api.PUT("/settings/buckets/:bucketName", settingsHandler.UpdateBucketSettings)
+		api.GET("/connections", connectionsHandler.GetConnections)
+		api.POST("/connections", connectionsHandler.CreateConnection)
+		api.GET("/connections/:conn", connectionsHandler.GetConnection)
+		api.PUT("/connections/:conn", connectionsHandler.UpdateConnection)
+		api.DELETE("/connections/:conn", connectionsHandler.DeleteConnection)
 
-		api.PUT("/buckets/:bucketName/objects/*key", uploadHandler.UploadObject)
-		api.POST("/buckets/:bucketName/directories", uploadHandler.CreateDirectory)
+		// 転送ジョブは転送元・転送先の接続をリクエストで指定する
+		api.POST("/transfers", transferHandler.CreateTransferJob)
+		api.GET("/transfers", transferHandler.GetTransferJobs)
+		api.GET("/transfers/:jobId", transferHandler.GetTransferJob)
+		api.DELETE("/transfers/:jobId", transferHandler.CancelTransferJob)
+		api.POST("/transfers/:jobId/resume", transferHandler.ResumeTransferJob)
+		api.GET("/transfers/:jobId/progress", transferHandler.GetTransferProgress)
 
-		api.GET("/uploads/:uploadId/progress", uploadProgressHandler.GetUploadProgress)
+		// 接続を指定しない API は環境変数で設定した既定の接続を使う
+		registerRoutes(api, func(*gin.Context) *handler.Handlers { return handlers })
+		registerRoutes(api.Group("/connections/:conn", connectionsHandler.UseConnection), handler.ConnectionHandlers)
 	}
 
 	return r
 }
 
-func getTrustedIPList() []string {
-	env := os.Getenv("env")
-	if env == "dev" {
-		return []string{"192.168.0.0/24", "127.0.0.1"}
-	}
-	rawIpList := os.Getenv("IP_LIST")
-	if rawIpList != "" {
-		ipList := strings.Split(rawIpList, ",")
-		results := []string{}
-		for _, ip := range ipList {
-			trimmed := strings.TrimSpace(ip)
-			if trimmed == "" {
-				continue
-			}
-			results = append(results, trimmed)
-		}
-		return results
-	}
+// registerRoutes は接続ごとの API を登録する。h はリクエストの接続のハンドラ一式を返す。
+func registerRoutes(api *gin.RouterGroup, h func(*gin.Context) *handler.Handlers) {
+	api.GET("/health", func(c *gin.Context) { h(c).Health.GetHealth(c) })
+
+	api.GET("/buckets", func(c *gin.Context) { h(c).Buckets.GetBuckets(c) })
+	api.POST("/buckets", func(c *gin.Context) { h(c).Buckets.CreateBucket(c) })
+	api.DELETE("/buckets/:bucketName", func(c *gin.Context) { h(c).Buckets.DeleteBucket(c) })
+	api.GET("/buckets/:bucketName/cors", func(c *gin.Context) { h(c).Buckets.GetBucketCORS(c) })
+	api.PUT("/buckets/:bucketName/cors", func(c *gin.Context) { h(c).Buckets.UpdateBucketCORS(c) })
+	api.GET("/buckets/:bucketName/lifecycle", func(c *gin.Context) { h(c).Buckets.GetBucketLifecycle(c) })
+	api.PUT("/buckets/:bucketName/lifecycle", func(c *gin.Context) { h(c).Buckets.UpdateBucketLifecycle(c) })
+	api.GET("/buckets/:bucketName/objects", func(c *gin.Context) { h(c).Objects.GetObjects(c) })
+	api.GET("/buckets/:bucketName/content/*key", func(c *gin.Context) { h(c).Content.GetContent(c) })
+
+	api.DELETE("/cache/content", func(c *gin.Context) { h(c).Cache.ClearContentCache(c) })
+	api.GET("/cache/content/stats", func(c *gin.Context) { h(c).Cache.GetContentCacheStats(c) })
+	api.GET("/cache/content/entries", func(c *gin.Context) { h(c).Cache.GetContentCacheEntries(c) })
+	api.POST("/cache/content/reconcile", func(c *gin.Context) { h(c).Cache.ReconcileContentCache(c) })
+	api.DELETE("/cache/api", func(c *gin.Context) { h(c).Cache.ClearAPICache(c) })
+	api.GET("/cache/api/stats", func(c *gin.Context) { h(c).Cache.GetAPICacheStats(c) })
+	api.POST("/cache/prefetch", func(c *gin.Context) { h(c).Prefetch.CreatePrefetchJob(c) })
+	api.GET("/cache/prefetch", func(c *gin.Context) { h(c).Prefetch.GetPrefetchJobs(c) })
+	api.GET("/cache/prefetch/:jobId", func(c *gin.Context) { h(c).Prefetch.GetPrefetchJob(c) })
+	api.DELETE("/cache/prefetch/:jobId", func(c *gin.Context) { h(c).Prefetch.CancelPrefetchJob(c) })
+	api.GET("/cache/prefetch/:jobId/progress", func(c *gin.Context) { h(c).Prefetch.GetPrefetchProgress(c) })
+
+	api.GET("/settings/buckets", func(c *gin.Context) { h(c).Settings.GetAllBucketSettings(c) })
+	api.PUT("/settings/buckets", func(c *gin.Context) { h(c).Settings.BulkUpdateBucketSettings(c) })
+	api.GET("/settings/buckets/:bucketName", func(c *gin.Context) { h(c).Settings.GetBucketSettings(c) })
+	api.PUT("/settings/buckets/:bucketName", func(c *gin.Context) { h(c).Settings.UpdateBucketSettings(c) })
+	api.PUT("/settings/buckets/:bucketName/cache-policy", func(c *gin.Context) { h(c).Settings.UpdateBucketCachePolicy(c) })
+
+	api.PUT("/buckets/:bucketName/objects/*key", func(c *gin.Context) { h(c).Upload.UploadObject(c) })
+	api.POST("/buckets/:bucketName/directories", func(c *gin.Context) { h(c).Upload.CreateDirectory(c) })
+	api.DELETE("/buckets/:bucketName/objects/*key", func(c *gin.Context) { h(c).Delete.DeleteObject(c) })
+	api.POST("/buckets/:bucketName/copy", func(c *gin.Context) { h(c).Copy.CopyObjects(c) })
+	api.POST("/buckets/:bucketName/move", func(c *gin.Context) { h(c).Copy.MoveObjects(c) })
+	api.GET("/buckets/:bucketName/metadata/*key", func(c *gin.Context) { h(c).Metadata.GetObjectMetadata(c) })
+	api.PUT("/buckets/:bucketName/metadata/*key", func(c *gin.Context) { h(c).Metadata.UpdateObjectMetadata(c) })
+	api.POST("/buckets/:bucketName/presign", func(c *gin.Context) { h(c).Presign.CreatePresignedURL(c) })
+	api.GET("/presigned-urls", func(c *gin.Context) { h(c).Presign.GetPresignedURLs(c) })
+
+	api.GET("/uploads/:uploadId/progress", func(c *gin.Context) { h(c).UploadProgress.GetUploadProgress(c) })
 
-	return []string{}
+	api.OPTIONS("/uploads", func(c *gin.Context) { h(c).ResumableUpload.Options(c) })
+	api.POST("/uploads", func(c *gin.Context) { h(c).ResumableUpload.CreateUpload(c) })
+	api.HEAD("/uploads/:uploadId", func(c *gin.Context) { h(c).ResumableUpload.GetUploadOffset(c) })
+	api.PATCH("/uploads/:uploadId", func(c *gin.Context) { h(c).ResumableUpload.PatchUpload(c) })
+	api.DELETE("/uploads/:uploadId", func(c *gin.Context) { h(c).ResumableUpload.TerminateUpload(c) })
 }
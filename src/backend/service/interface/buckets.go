@@ -3,23 +3,58 @@ package serviceif
 import (
 	"context"
 
+	"github.com/pkg/errors"
+
 	"r2manager/domain"
 )
 
+var (
+	ErrBucketNotFound      = errors.New("bucket not found")
+	ErrBucketAlreadyExists = errors.New("bucket already exists")
+	// ErrBucketNotEmpty はオブジェクトが残っているバケットを force なしで削除しようとしたことを示す
+	ErrBucketNotEmpty       = errors.New("bucket is not empty")
+	ErrInvalidBucketRequest = errors.New("invalid bucket request")
+)
+
 type BucketRepository interface {
 	GetBuckets(ctx context.Context) ([]domain.Bucket, error)
+	CreateBucket(ctx context.Context, bucketName string) error
+	DeleteBucket(ctx context.Context, bucketName string) error
+	// AbortMultipartUploads は完了していないマルチパートアップロードをすべて中止し、中止した数を返す
+	AbortMultipartUploads(ctx context.Context, bucketName string) (int, error)
+	// GetBucketCORS / GetBucketLifecycle は設定がない場合に空のルールを返す
+	GetBucketCORS(ctx context.Context, bucketName string) ([]domain.CORSRule, error)
+	// PutBucketCORS / PutBucketLifecycle は rules が空の場合に設定を削除する
+	PutBucketCORS(ctx context.Context, bucketName string, rules []domain.CORSRule) error
+	GetBucketLifecycle(ctx context.Context, bucketName string) ([]domain.LifecycleRule, error)
+	PutBucketLifecycle(ctx context.Context, bucketName string, rules []domain.LifecycleRule) error
 }
 
 type BucketService interface {
-	GetBuckets(ctx context.Context) ([]domain.Bucket, error)
+	GetBuckets(ctx context.Context) (*domain.ListBucketsResult, error)
+	CreateBucket(ctx context.Context, bucketName string) (*domain.Bucket, error)
+	// DeleteBucket は空のバケットを削除する。force が true の場合は先に全オブジェクトを削除する。
+	// 途中まで削除してから失敗した場合は、それまでの結果を err とともに返す
+	DeleteBucket(ctx context.Context, bucketName string, force bool) (*domain.DeleteBucketResult, error)
+	GetBucketCORS(ctx context.Context, bucketName string) (*domain.BucketCORS, error)
+	UpdateBucketCORS(ctx context.Context, bucketName string, cors domain.BucketCORS) (*domain.BucketCORS, error)
+	GetBucketLifecycle(ctx context.Context, bucketName string) (*domain.BucketLifecycle, error)
+	UpdateBucketLifecycle(ctx context.Context, bucketName string, lifecycle domain.BucketLifecycle) (*domain.BucketLifecycle, error)
 }
 
 type ListCacheRepository interface {
 	GetBuckets() ([]domain.Bucket, bool)
 	SetBuckets(buckets []domain.Bucket)
 	InvalidateBuckets()
-	GetObjects(bucketName, prefix string) (*domain.ListObjectsResult, bool)
-	SetObjects(bucketName, prefix string, result *domain.ListObjectsResult)
+	GetObjects(bucketName string, params ListObjectsParams) (*domain.ListObjectsResult, bool)
+	SetObjects(bucketName string, params ListObjectsParams, result *domain.ListObjectsResult)
 	InvalidateObjects(bucketName string)
+	// AddObject は作成・上書きしたオブジェクトを、そのキーを含みうる一覧にだけ反映する
+	AddObject(bucketName string, obj domain.Object)
+	// InvalidateObjectKeys は keys のいずれかを含みうる一覧だけを破棄する
+	InvalidateObjectKeys(bucketName string, keys []string)
 	InvalidateAll()
+	// GetLastKnownBuckets / GetLastKnownObjects は S3 に接続できない場合に返す、最後に取得できた一覧
+	GetLastKnownBuckets() ([]domain.Bucket, bool)
+	GetLastKnownObjects(bucketName string, params ListObjectsParams) (*domain.ListObjectsResult, bool)
 }
@@ -0,0 +1,43 @@
+package serviceif
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+var (
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrConnectionExists   = errors.New("connection already exists")
+	ErrInvalidConnection  = errors.New("invalid connection")
+	// ErrConnectionReadOnly は環境変数で設定した既定の接続を API から変更しようとしたことを示す
+	ErrConnectionReadOnly = errors.New("connection is configured by environment variables")
+	// ErrConnectionBusy は削除した同じ名前の接続が、処理中のリクエストを終えて閉じるのを待っていることを示す
+	ErrConnectionBusy = errors.New("connection is being removed")
+)
+
+type ConnectionRepository interface {
+	ListConnections(ctx context.Context) ([]domain.Connection, error)
+	GetConnection(ctx context.Context, name string) (*domain.Connection, error)
+	CreateConnection(ctx context.Context, conn *domain.Connection) (bool, error)
+	UpdateConnection(ctx context.Context, conn *domain.Connection) (bool, error)
+	DeleteConnection(ctx context.Context, name string) (bool, error)
+}
+
+// ConnectionRuntime は接続ごとに作成した S3 クライアントやキャッシュを管理する
+type ConnectionRuntime interface {
+	// Reload は変更した接続設定を次のリクエストから使わせる。targetChanged が true の場合は保存済みのキャッシュを破棄する
+	Reload(name string, targetChanged bool)
+	// Remove は削除した接続のデータベースとキャッシュを破棄する
+	Remove(name string) error
+}
+
+type ConnectionService interface {
+	ListConnections(ctx context.Context) ([]domain.Connection, error)
+	GetConnection(ctx context.Context, name string) (*domain.Connection, error)
+	CreateConnection(ctx context.Context, conn domain.Connection) (*domain.Connection, error)
+	UpdateConnection(ctx context.Context, conn domain.Connection) (*domain.Connection, error)
+	DeleteConnection(ctx context.Context, name string) error
+}
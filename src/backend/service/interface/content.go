@@ -4,20 +4,58 @@ import (
 	"context"
 	"io"
 
+	"github.com/pkg/errors"
+
 	"r2manager/domain"
 )
 
+var ErrInvalidRange = errors.New("requested range not satisfiable")
+
+// ErrNotModified は条件付き取得でオブジェクトが変更されていなかったことを示す
+var ErrNotModified = errors.New("object not modified")
+
 type ContentRepository interface {
 	GetContent(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error)
+	GetContentRange(ctx context.Context, bucketName, objectKey, rangeHeader string) (*domain.ObjectContent, error)
+	GetContentIfNoneMatch(ctx context.Context, bucketName, objectKey, etag string) (*domain.ObjectContent, error)
 }
 
 type CacheRepository interface {
-	Lookup(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error)
+	// Lookup は有効期限内のエントリとキャッシュファイルを開いたボディを返す。ヒットしない場合はどちらも nil
+	Lookup(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, io.ReadCloser, error)
+	// LookupStale は有効期限切れのエントリも含めて検索する。ヒット数などは記録しない
+	LookupStale(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error)
+	// Extend は ETag が一致する場合にエントリの有効期限を延長する
+	Extend(ctx context.Context, bucketName, objectKey, etag string) (*domain.CacheEntry, error)
 	Store(ctx context.Context, bucketName, objectKey string, body io.Reader, contentType string, size int64, etag string) (*domain.CacheEntry, error)
 	OpenCacheFile(cachePath string) (io.ReadCloser, error)
+	// DecodeContent は圧縮されたキャッシュファイルのボディを展開するリーダーを返す
+	DecodeContent(body io.ReadCloser, encoding string) (io.ReadCloser, error)
+	// DecodeSeekableContent は圧縮されたキャッシュファイルのボディを展開し、シーク可能なボディとして返す。size は展開後のサイズ
+	DecodeSeekableContent(body io.ReadCloser, encoding string, size int64) (io.ReadCloser, error)
 	InvalidateByETags(ctx context.Context, bucketName string, currentETags map[string]string) (int, error)
+	ClearByKey(ctx context.Context, bucketName, objectKey string) (int64, error)
+	ClearByBucket(ctx context.Context, bucketName string) (int64, error)
+	// UpdateMetadata はメタデータを書き換えたオブジェクトのエントリに新しい Content-Type と ETag を反映する。
+	// エントリの ETag が oldETag と一致しない場合はエントリを削除する
+	UpdateMetadata(ctx context.Context, bucketName, objectKey, contentType, oldETag, newETag string) (int64, error)
+	// StreamStore は content.Body をキャッシュに書き込みつつ、書き込み済みのデータを読み出せるボディを返す
+	StreamStore(bucketName, objectKey string, content *domain.ObjectContent) (*domain.ObjectContent, error)
+	// InFlight は進行中のダウンロードがあれば、それに合流したボディを返す
+	InFlight(bucketName, objectKey string) (*domain.ObjectContent, bool)
+	// ApplyBucketPolicy は変更したバケットのキャッシュ設定を既存のエントリに反映する
+	ApplyBucketPolicy(ctx context.Context, bucketName string, policy *domain.BucketCachePolicy) (int64, error)
+	// ForgetBucketPolicy は保持しているバケットのキャッシュ設定を破棄する。削除したバケットの設定を消したときに呼ぶ
+	ForgetBucketPolicy(bucketName string)
+}
+
+type GetContentOptions struct {
+	// Range はクライアントの Range ヘッダ。キャッシュミス時は単一範囲に限り GetObject へそのまま渡す
+	Range string
+	// AcceptEncoding はクライアントの Accept-Encoding ヘッダ。圧縮されたキャッシュをそのまま返せるかの判定に使う
+	AcceptEncoding string
 }
 
 type ContentService interface {
-	GetContent(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error)
+	GetContent(ctx context.Context, bucketName, objectKey string, opts GetContentOptions) (*domain.ObjectContent, error)
 }
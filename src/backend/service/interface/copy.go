@@ -0,0 +1,38 @@
+package serviceif
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+var ErrInvalidDestination = errors.New("invalid copy destination")
+
+// CopyProgressCallback はコピー・移動の進捗コールバック関数型。
+// 対象の総バイト数はフォルダ内の一覧取得後に確定するため、処理済みバイト数と合わせて渡す。
+type CopyProgressCallback func(bytesProcessed, totalBytes int64)
+
+type CopyRepository interface {
+	CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) (string, error)
+	MultipartCopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, size int64, overwrite bool, onProgress ProgressCallback) (string, error)
+	// ReplaceMetadata はオブジェクトを自身にコピーしてメタデータを meta の内容に置き換え、新しい ETag を返す。
+	// meta.ETag と一致しない場合は ErrObjectModified を返す
+	ReplaceMetadata(ctx context.Context, bucketName string, meta domain.ObjectMetadata) (string, error)
+}
+
+type CopyParams struct {
+	SourceBucket      string
+	SourceKey         string
+	DestinationBucket string
+	DestinationKey    string
+	// Recursive が true の場合、SourceKey / DestinationKey をフォルダとして扱う
+	Recursive bool
+	Overwrite bool
+}
+
+type CopyService interface {
+	Copy(ctx context.Context, params CopyParams, onProgress CopyProgressCallback) (*domain.CopyObjectsResult, error)
+	Move(ctx context.Context, params CopyParams, onProgress CopyProgressCallback) (*domain.CopyObjectsResult, error)
+}
@@ -0,0 +1,18 @@
+package serviceif
+
+import (
+	"context"
+
+	"r2manager/domain"
+)
+
+type DeleteRepository interface {
+	DeleteObject(ctx context.Context, bucketName, key string) error
+	DeleteObjects(ctx context.Context, bucketName string, keys []string) (*domain.DeleteObjectsResult, error)
+}
+
+type DeleteService interface {
+	DeleteObject(ctx context.Context, bucketName, key string) (*domain.DeleteObjectsResult, error)
+	// DeletePrefix は途中で失敗した場合も、それまでの結果を err とともに返す
+	DeletePrefix(ctx context.Context, bucketName, prefix string) (*domain.DeleteObjectsResult, error)
+}
@@ -3,18 +3,43 @@ package serviceif
 import (
 	"context"
 
+	"github.com/pkg/errors"
+
 	"r2manager/domain"
 )
 
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrObjectModified はメタデータの変更中にオブジェクトが書き換えられたことを示す
+	ErrObjectModified  = errors.New("object was modified during the update")
+	ErrInvalidMetadata = errors.New("invalid object metadata")
+)
+
 type ListObjectsParams struct {
-	Prefix    string
-	Delimiter string
+	Prefix            string
+	Delimiter         string
+	ContinuationToken string
+	StartAfter        string
+	// MaxKeys が 0 の場合は S3 のデフォルト（1000件）を使用する
+	MaxKeys int32
+	// All が true の場合、継続トークンをサーバー側で辿って全件を返す（上限あり）
+	All bool
 }
 
 type ObjectRepository interface {
 	GetObjects(ctx context.Context, bucketName string, params ListObjectsParams) (*domain.ListObjectsResult, error)
+	ListAllObjects(ctx context.Context, bucketName, prefix string) ([]domain.Object, error)
+	HeadObject(ctx context.Context, bucketName, key string) (*domain.Object, error)
+	HeadObjectMetadata(ctx context.Context, bucketName, key string) (*domain.ObjectMetadata, error)
 }
 
 type ObjectService interface {
 	GetObjects(ctx context.Context, bucketName string, params ListObjectsParams) (*domain.ListObjectsResult, error)
 }
+
+type MetadataService interface {
+	GetMetadata(ctx context.Context, bucketName, key string) (*domain.ObjectMetadata, error)
+	UpdateMetadata(ctx context.Context, bucketName, key string, update domain.ObjectMetadataUpdate) (*domain.ObjectMetadata, error)
+	// UpdatePrefixMetadata は prefix 配下の全オブジェクトに同じ変更を適用する
+	UpdatePrefixMetadata(ctx context.Context, bucketName, prefix string, update domain.ObjectMetadataUpdate) (*domain.UpdateMetadataResult, error)
+}
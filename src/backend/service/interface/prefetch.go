@@ -0,0 +1,40 @@
+package serviceif
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+var (
+	ErrPrefetchJobNotFound    = errors.New("prefetch job not found")
+	ErrInvalidPrefetchRequest = errors.New("invalid prefetch request")
+)
+
+type PrefetchParams struct {
+	BucketName    string
+	Prefix        string
+	ContentTypes  []string
+	MaxObjectSize int64
+	MaxCount      int
+}
+
+// PrefetchCacheRepository はプリフェッチで使うキャッシュの操作
+type PrefetchCacheRepository interface {
+	LookupStale(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error)
+	Store(ctx context.Context, bucketName, objectKey string, body io.Reader, contentType string, size int64, etag string) (*domain.CacheEntry, error)
+	Stats(ctx context.Context) (*domain.ContentCacheStats, error)
+}
+
+// PrefetchUpdateCallback はジョブの状態が変わるたびに、その時点のジョブの写しを受け取る
+type PrefetchUpdateCallback func(job domain.PrefetchJob)
+
+type PrefetchService interface {
+	Enqueue(params PrefetchParams, onUpdate PrefetchUpdateCallback) (*domain.PrefetchJob, error)
+	GetJob(id string) (*domain.PrefetchJob, error)
+	ListJobs() []domain.PrefetchJob
+	Cancel(id string) error
+}
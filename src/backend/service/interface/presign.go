@@ -0,0 +1,46 @@
+package serviceif
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+var ErrInvalidPresignRequest = errors.New("invalid presign request")
+
+type PresignRepository interface {
+	PresignGetObject(ctx context.Context, bucketName, key, contentDisposition string, expires time.Duration) (string, error)
+	PresignPutObject(ctx context.Context, bucketName, key, contentType string, expires time.Duration) (string, error)
+}
+
+type PresignRecordFilter struct {
+	BucketName string
+	// ActiveOnly が true の場合、有効期限内のものだけを返す
+	ActiveOnly bool
+	Limit      int
+	Offset     int
+}
+
+type PresignLogRepository interface {
+	Insert(ctx context.Context, record *domain.PresignRecord) (int64, error)
+	List(ctx context.Context, filter PresignRecordFilter) ([]domain.PresignRecord, error)
+}
+
+type PresignParams struct {
+	BucketName         string
+	ObjectKey          string
+	Method             string
+	ContentType        string
+	ContentDisposition string
+	// Expires が 0 の場合はサーバーのデフォルト値を使用する
+	Expires  time.Duration
+	IssuedBy string
+}
+
+type PresignService interface {
+	Presign(ctx context.Context, params PresignParams) (*domain.PresignedURL, error)
+	ListRecords(ctx context.Context, filter PresignRecordFilter) ([]domain.PresignRecord, error)
+}
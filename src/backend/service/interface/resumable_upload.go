@@ -0,0 +1,50 @@
+package serviceif
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadLocked         = errors.New("upload is locked by another request")
+	ErrUploadTooLarge       = errors.New("upload too large")
+	ErrUploadExceedsLength  = errors.New("upload exceeds Upload-Length")
+)
+
+type ResumableUploadRepository interface {
+	Create(ctx context.Context, upload *domain.ResumableUpload) error
+	Get(ctx context.Context, id string) (*domain.ResumableUpload, error)
+	// ListUpdatedBefore は before より前から更新されていないアップロードを返す
+	ListUpdatedBefore(ctx context.Context, before time.Time) ([]domain.ResumableUpload, error)
+	UpdateOffset(ctx context.Context, id string, offset int64) error
+	SetS3UploadID(ctx context.Context, id, s3UploadID string) error
+	MarkCompleted(ctx context.Context, id, etag string) error
+	AddPart(ctx context.Context, id string, part domain.UploadPart) error
+	ListParts(ctx context.Context, id string) ([]domain.UploadPart, error)
+	Delete(ctx context.Context, id string) error
+	WriteStaging(id string, stagedSize int64, body io.Reader, limit int64) (int64, error)
+	OpenStaging(id string, size int64) (io.ReadSeekCloser, error)
+}
+
+type CreateResumableUploadParams struct {
+	BucketName  string
+	ObjectKey   string
+	ContentType string
+	Length      int64
+	Overwrite   bool
+}
+
+type ResumableUploadService interface {
+	MaxSize() int64
+	Create(ctx context.Context, params CreateResumableUploadParams) (*domain.ResumableUpload, error)
+	Get(ctx context.Context, id string) (*domain.ResumableUpload, error)
+	Append(ctx context.Context, id string, offset int64, body io.Reader, onProgress ProgressCallback) (*domain.ResumableUpload, error)
+	Terminate(ctx context.Context, id string) error
+}
@@ -3,19 +3,31 @@ package serviceif
 import (
 	"context"
 
+	"github.com/pkg/errors"
+
 	"r2manager/domain"
 )
 
+var ErrInvalidCachePolicy = errors.New("invalid cache policy")
+
 type SettingsRepository interface {
 	GetAllBucketSettings(ctx context.Context) ([]domain.BucketSettings, error)
 	GetBucketSettings(ctx context.Context, bucketName string) (*domain.BucketSettings, error)
 	UpsertBucketSettings(ctx context.Context, bucketName, publicUrl string) error
+	UpsertBucketCachePolicy(ctx context.Context, bucketName string, policy domain.BucketCachePolicy) error
 	BulkUpsertBucketSettings(ctx context.Context, settings []domain.BucketSettings) error
+	DeleteBucketSettings(ctx context.Context, bucketName string) error
+}
+
+// BucketCachePolicyRepository はバケットごとのキャッシュ設定を取得する。設定がない場合は nil を返す
+type BucketCachePolicyRepository interface {
+	GetBucketCachePolicy(ctx context.Context, bucketName string) (*domain.BucketCachePolicy, error)
 }
 
 type SettingsService interface {
 	GetAllBucketSettings(ctx context.Context) ([]domain.BucketSettings, error)
 	GetBucketSettings(ctx context.Context, bucketName string) (*domain.BucketSettings, error)
 	UpdateBucketPublicUrl(ctx context.Context, bucketName, publicUrl string) error
+	UpdateBucketCachePolicy(ctx context.Context, bucketName string, policy domain.BucketCachePolicy) error
 	BulkUpdateBucketSettings(ctx context.Context, settings []domain.BucketSettings) error
 }
@@ -0,0 +1,72 @@
+package serviceif
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+)
+
+var (
+	ErrTransferJobNotFound    = errors.New("transfer job not found")
+	ErrInvalidTransferRequest = errors.New("invalid transfer request")
+	// ErrTransferJobNotResumable は実行中・完了済みのジョブを再開しようとしたことを示す
+	ErrTransferJobNotResumable = errors.New("transfer job cannot be resumed")
+	// ErrTransferJobNotRunning は終了済みのジョブを中断しようとしたことを示す
+	ErrTransferJobNotRunning = errors.New("transfer job is not running")
+)
+
+type TransferParams struct {
+	Source       domain.TransferLocation
+	Destination  domain.TransferLocation
+	Include      []string
+	Exclude      []string
+	SkipSameETag bool
+	DryRun       bool
+	// Concurrency が 0 の場合は既定値を使う
+	Concurrency int
+}
+
+type TransferJobRepository interface {
+	SaveJob(ctx context.Context, job *domain.TransferJob) error
+	GetJob(ctx context.Context, id string) (*domain.TransferJob, error)
+	ListJobs(ctx context.Context) ([]domain.TransferJob, error)
+	ListUnfinishedJobs(ctx context.Context) ([]domain.TransferJob, error)
+	DeleteFinishedBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// TransferEndpoint は転送に使う、1 つの接続のリポジトリ
+type TransferEndpoint struct {
+	Objects ObjectRepository
+	Content ContentRepository
+	Copies  CopyRepository
+	// Uploads は転送元から取得したボディを、サイズに応じてマルチパートでアップロードする
+	Uploads   UploadService
+	Cache     CacheRepository
+	ListCache ListCacheRepository
+	// Release は使い終わったら呼ぶ。削除した接続のデータベースは、取得したすべての Release が呼ばれてから閉じる
+	Release func()
+}
+
+// TransferConnections は接続名から転送に使うリポジトリを取得する
+type TransferConnections interface {
+	Endpoint(ctx context.Context, name string) (*TransferEndpoint, error)
+	// SharesCredentials は 2 つの接続が同じエンドポイントと認証情報を使い、サーバー側でコピーできるかを返す
+	SharesCredentials(ctx context.Context, a, b string) (bool, error)
+}
+
+// TransferUpdateCallback はジョブの状態が変わるたびに、その時点のジョブの写しを受け取る
+type TransferUpdateCallback func(job domain.TransferJob)
+
+type TransferService interface {
+	Enqueue(ctx context.Context, params TransferParams, onUpdate TransferUpdateCallback) (*domain.TransferJob, error)
+	GetJob(ctx context.Context, id string) (*domain.TransferJob, error)
+	ListJobs(ctx context.Context) ([]domain.TransferJob, error)
+	Cancel(ctx context.Context, id string) error
+	// Resume は失敗・中断したジョブをチェックポイントから再開する
+	Resume(ctx context.Context, id string, onUpdate TransferUpdateCallback) (*domain.TransferJob, error)
+	// ResumeUnfinished は前回の実行中に終了しなかったジョブを再開する
+	ResumeUnfinished(ctx context.Context, onUpdate TransferUpdateCallback) (int, error)
+}
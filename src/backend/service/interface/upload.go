@@ -5,6 +5,8 @@ import (
 	"io"
 
 	"github.com/pkg/errors"
+
+	"r2manager/domain"
 )
 
 var ErrObjectAlreadyExists = errors.New("object already exists")
@@ -14,8 +16,13 @@ var ErrObjectAlreadyExists = errors.New("object already exists")
 type ProgressCallback func(bytesProcessed int64)
 
 type UploadRepository interface {
-	PutObject(ctx context.Context, bucketName, key, contentType string, body io.ReadSeeker) (string, error)
-	PutObjectIfNotExists(ctx context.Context, bucketName, key, contentType string, body io.ReadSeeker) (string, error)
+	// PutObject・PutObjectIfNotExists・CreateMultipartUpload は meta.Key に meta の Content-Type などのヘッダとユーザー定義のメタデータを付けて作成する
+	PutObject(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.ReadSeeker) (string, error)
+	PutObjectIfNotExists(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.ReadSeeker) (string, error)
+	CreateMultipartUpload(ctx context.Context, bucketName string, meta domain.ObjectMetadata) (string, error)
+	UploadPart(ctx context.Context, bucketName, key, uploadID string, partNumber int32, body io.ReadSeeker) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucketName, key, uploadID string, parts []domain.UploadPart, overwrite bool) (string, error)
+	AbortMultipartUpload(ctx context.Context, bucketName, key, uploadID string) error
 }
 
 type UploadResult struct {
@@ -25,6 +32,9 @@ type UploadResult struct {
 }
 
 type UploadService interface {
+	// UploadObject は body をアップロードする。size が負の場合はサイズが分からないものとして、読み込みながら送信方法を決める
 	UploadObject(ctx context.Context, bucketName, key, contentType string, body io.Reader, size int64, overwrite bool, onProgress ProgressCallback) (*UploadResult, error)
+	// UploadObjectWithMetadata は Content-Type 以外のヘッダとユーザー定義のメタデータも meta の内容で作成する
+	UploadObjectWithMetadata(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.Reader, size int64, overwrite bool, onProgress ProgressCallback) (*UploadResult, error)
 	CreateDirectory(ctx context.Context, bucketName, path string) (*UploadResult, error)
 }
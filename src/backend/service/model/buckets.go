@@ -3,25 +3,54 @@ package service
 import (
 	"context"
 	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"r2manager/domain"
+	"r2manager/health"
 	serviceif "r2manager/service/interface"
 )
 
+const (
+	// S3 の 1 バケットあたりのルール数の上限
+	maxCORSRules      = 100
+	maxLifecycleRules = 1000
+
+	// purgePageSize は強制削除で 1 回に一覧・削除するオブジェクト数
+	purgePageSize = 1000
+)
+
+// S3 のバケット名の規則。".." を含む名前と IP アドレス形式の名前も使えない
+var (
+	bucketNamePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	ipAddressLikePattern = regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+$`)
+)
+
+// CORS ルールで指定できるメソッド
+var allowedCORSMethods = []string{"GET", "PUT", "POST", "DELETE", "HEAD"}
+
 type BucketService struct {
-	repo      serviceif.BucketRepository
-	listCache serviceif.ListCacheRepository
+	repo         serviceif.BucketRepository
+	objectRepo   serviceif.ObjectRepository
+	deleteRepo   serviceif.DeleteRepository
+	settingsRepo serviceif.SettingsRepository
+	cacheRepo    serviceif.CacheRepository
+	listCache    serviceif.ListCacheRepository
 }
 
-func NewBucketService(repo serviceif.BucketRepository, listCache serviceif.ListCacheRepository) *BucketService {
-	return &BucketService{repo: repo, listCache: listCache}
+func NewBucketService(repo serviceif.BucketRepository, objectRepo serviceif.ObjectRepository, deleteRepo serviceif.DeleteRepository, settingsRepo serviceif.SettingsRepository, cacheRepo serviceif.CacheRepository, listCache serviceif.ListCacheRepository) *BucketService {
+	return &BucketService{repo: repo, objectRepo: objectRepo, deleteRepo: deleteRepo, settingsRepo: settingsRepo, cacheRepo: cacheRepo, listCache: listCache}
 }
 
-func (s *BucketService) GetBuckets(ctx context.Context) ([]domain.Bucket, error) {
+func (s *BucketService) GetBuckets(ctx context.Context) (*domain.ListBucketsResult, error) {
 	// Check cache first
 	if buckets, found := s.listCache.GetBuckets(); found {
 		log.Printf("cache hit: buckets (%d items)", len(buckets))
-		return buckets, nil
+		return &domain.ListBucketsResult{Buckets: buckets}, nil
 	}
 
 	log.Printf("cache miss: buckets")
@@ -29,6 +58,13 @@ func (s *BucketService) GetBuckets(ctx context.Context) ([]domain.Bucket, error)
 	// Fetch from R2
 	buckets, err := s.repo.GetBuckets(ctx)
 	if err != nil {
+		// R2 に接続できない場合は最後に取得できた一覧を返す
+		if health.IsUpstreamFailure(err) {
+			if last, found := s.listCache.GetLastKnownBuckets(); found {
+				log.Printf("upstream unavailable, serving last known buckets (%d items): %v", len(last), err)
+				return &domain.ListBucketsResult{Buckets: last, Stale: true}, nil
+			}
+		}
 		return nil, err
 	}
 
@@ -36,5 +72,237 @@ func (s *BucketService) GetBuckets(ctx context.Context) ([]domain.Bucket, error)
 	s.listCache.SetBuckets(buckets)
 	log.Printf("cache stored: buckets (%d items)", len(buckets))
 
-	return buckets, nil
+	return &domain.ListBucketsResult{Buckets: buckets}, nil
+}
+
+func (s *BucketService) CreateBucket(ctx context.Context, bucketName string) (*domain.Bucket, error) {
+	if err := validateBucketName(bucketName); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.CreateBucket(ctx, bucketName); err != nil {
+		return nil, err
+	}
+
+	s.listCache.InvalidateBuckets()
+	log.Printf("created bucket: bucket=%s", bucketName)
+
+	return &domain.Bucket{Name: bucketName, CreationDate: time.Now()}, nil
+}
+
+// DeleteBucket はバケットを削除し、バケットの設定・コンテンツキャッシュ・一覧キャッシュを破棄する。
+// force が false の場合、オブジェクトが残っていれば ErrBucketNotEmpty を返す。
+// force で途中まで削除してから失敗した場合は、それまでに削除した数を err とともに返す。
+func (s *BucketService) DeleteBucket(ctx context.Context, bucketName string, force bool) (*domain.DeleteBucketResult, error) {
+	if err := validateBucketName(bucketName); err != nil {
+		return nil, err
+	}
+
+	result := &domain.DeleteBucketResult{Bucket: bucketName}
+	// failed は途中まで削除できたオブジェクトのキャッシュを破棄し、削除した数を err とともに返す
+	failed := func(err error) (*domain.DeleteBucketResult, error) {
+		if result.PurgedObjects == 0 && result.AbortedUploads == 0 {
+			return nil, err
+		}
+		if result.PurgedObjects > 0 {
+			result.ClearedCacheEntries = s.clearCaches(ctx, bucketName)
+		}
+		return result, err
+	}
+
+	if force {
+		purged, err := s.purge(ctx, bucketName)
+		result.PurgedObjects = purged
+		if err != nil {
+			return failed(err)
+		}
+
+		// 完了していないマルチパートアップロードのパートが残っているとバケットを削除できない
+		aborted, err := s.repo.AbortMultipartUploads(ctx, bucketName)
+		result.AbortedUploads = aborted
+		if err != nil {
+			return failed(err)
+		}
+	}
+
+	if err := s.repo.DeleteBucket(ctx, bucketName); err != nil {
+		return failed(err)
+	}
+
+	s.listCache.InvalidateBuckets()
+	result.ClearedCacheEntries = s.clearCaches(ctx, bucketName)
+	if err := s.settingsRepo.DeleteBucketSettings(ctx, bucketName); err != nil {
+		log.Printf("warning: failed to delete bucket settings: bucket=%s: %v", bucketName, err)
+	}
+	s.cacheRepo.ForgetBucketPolicy(bucketName)
+
+	log.Printf("deleted bucket: bucket=%s purged=%d aborted_uploads=%d cache_entries=%d", bucketName, result.PurgedObjects, result.AbortedUploads, result.ClearedCacheEntries)
+
+	return result, nil
+}
+
+// purge はバケットの全オブジェクトを一覧のページごとに削除し、削除できた数を返す。
+// 全件の一覧を先に取得しないため、オブジェクトが多いバケットでもメモリを使い切らない。
+// 失敗した場合もそれまでに削除できた数を返す。
+func (s *BucketService) purge(ctx context.Context, bucketName string) (int, error) {
+	purged := 0
+	params := serviceif.ListObjectsParams{MaxKeys: purgePageSize}
+	for {
+		page, err := s.objectRepo.GetObjects(ctx, bucketName, params)
+		if err != nil {
+			return purged, errors.Wrap(err, "failed to list objects for purge")
+		}
+
+		if len(page.Objects) > 0 {
+			keys := make([]string, 0, len(page.Objects))
+			for _, obj := range page.Objects {
+				keys = append(keys, obj.Key)
+			}
+
+			deleted, err := s.deleteRepo.DeleteObjects(ctx, bucketName, keys)
+			if deleted != nil {
+				purged += len(deleted.Deleted)
+			}
+			if err != nil {
+				return purged, errors.Wrap(err, "failed to purge bucket")
+			}
+			if len(deleted.Errors) > 0 {
+				return purged, errors.Wrapf(serviceif.ErrBucketNotEmpty, "failed to delete %d objects (first: %s: %s)",
+					len(deleted.Errors), deleted.Errors[0].Key, deleted.Errors[0].Message)
+			}
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return purged, nil
+		}
+		params.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// clearCaches はバケットのオブジェクト一覧とコンテンツキャッシュを破棄し、破棄したエントリ数を返す。
+func (s *BucketService) clearCaches(ctx context.Context, bucketName string) int64 {
+	s.listCache.InvalidateObjects(bucketName)
+	cleared, err := s.cacheRepo.ClearByBucket(ctx, bucketName)
+	if err != nil {
+		log.Printf("warning: failed to clear content cache: bucket=%s: %v", bucketName, err)
+	}
+	return cleared
+}
+
+func (s *BucketService) GetBucketCORS(ctx context.Context, bucketName string) (*domain.BucketCORS, error) {
+	rules, err := s.repo.GetBucketCORS(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.BucketCORS{Rules: rules}, nil
+}
+
+// UpdateBucketCORS はバケットの CORS ルールを置き換える。ルールが空の場合は設定を削除する。
+func (s *BucketService) UpdateBucketCORS(ctx context.Context, bucketName string, cors domain.BucketCORS) (*domain.BucketCORS, error) {
+	if err := validateCORSRules(cors.Rules); err != nil {
+		return nil, err
+	}
+	if err := s.repo.PutBucketCORS(ctx, bucketName, cors.Rules); err != nil {
+		return nil, err
+	}
+
+	log.Printf("updated bucket cors: bucket=%s rules=%d", bucketName, len(cors.Rules))
+	if cors.Rules == nil {
+		cors.Rules = []domain.CORSRule{}
+	}
+	return &cors, nil
+}
+
+func (s *BucketService) GetBucketLifecycle(ctx context.Context, bucketName string) (*domain.BucketLifecycle, error) {
+	rules, err := s.repo.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.BucketLifecycle{Rules: rules}, nil
+}
+
+// UpdateBucketLifecycle はバケットのライフサイクルルールを置き換える。ルールが空の場合は設定を削除する。
+func (s *BucketService) UpdateBucketLifecycle(ctx context.Context, bucketName string, lifecycle domain.BucketLifecycle) (*domain.BucketLifecycle, error) {
+	if err := validateLifecycleRules(lifecycle.Rules); err != nil {
+		return nil, err
+	}
+	if err := s.repo.PutBucketLifecycle(ctx, bucketName, lifecycle.Rules); err != nil {
+		return nil, err
+	}
+
+	log.Printf("updated bucket lifecycle: bucket=%s rules=%d", bucketName, len(lifecycle.Rules))
+	if lifecycle.Rules == nil {
+		lifecycle.Rules = []domain.LifecycleRule{}
+	}
+	return &lifecycle, nil
+}
+
+func validateBucketName(bucketName string) error {
+	if !bucketNamePattern.MatchString(bucketName) || strings.Contains(bucketName, "..") || ipAddressLikePattern.MatchString(bucketName) {
+		return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "invalid bucket name %q", bucketName)
+	}
+	return nil
+}
+
+// validateCORSRules はルールを検証し、メソッド名を大文字にそろえる。
+func validateCORSRules(rules []domain.CORSRule) error {
+	if len(rules) > maxCORSRules {
+		return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "too many cors rules (max %d)", maxCORSRules)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if len(rule.AllowedOrigins) == 0 {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "cors rule %d: allowed_origins is required", i)
+		}
+		if len(rule.AllowedMethods) == 0 {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "cors rule %d: allowed_methods is required", i)
+		}
+		for j, method := range rule.AllowedMethods {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if !slices.Contains(allowedCORSMethods, method) {
+				return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "cors rule %d: unsupported method %q", i, rule.AllowedMethods[j])
+			}
+			rule.AllowedMethods[j] = method
+		}
+		if rule.MaxAgeSeconds < 0 {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "cors rule %d: max_age_seconds must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// validateLifecycleRules はルールを検証し、ストレージクラスを大文字にそろえる。
+func validateLifecycleRules(rules []domain.LifecycleRule) error {
+	if len(rules) > maxLifecycleRules {
+		return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "too many lifecycle rules (max %d)", maxLifecycleRules)
+	}
+	ids := make(map[string]bool, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		if rule.ID == "" || len(rule.ID) > 255 {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "lifecycle rule %d: id must be 1-255 characters", i)
+		}
+		if ids[rule.ID] {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "lifecycle rule %d: duplicate id %q", i, rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if rule.ExpirationDays < 0 || rule.AbortIncompleteMultipartUploadDays < 0 {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "lifecycle rule %q: days must not be negative", rule.ID)
+		}
+		if rule.ExpirationDays > 0 && rule.ExpirationDate != nil {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "lifecycle rule %q: expiration_days and expiration_date cannot both be set", rule.ID)
+		}
+		for j := range rule.Transitions {
+			t := &rule.Transitions[j]
+			t.StorageClass = strings.ToUpper(strings.TrimSpace(t.StorageClass))
+			if t.Days < 0 || t.StorageClass == "" {
+				return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "lifecycle rule %q: transition %d needs non-negative days and a storage_class", rule.ID, j)
+			}
+		}
+		if rule.ExpirationDays == 0 && rule.ExpirationDate == nil && rule.AbortIncompleteMultipartUploadDays == 0 && len(rule.Transitions) == 0 {
+			return errors.Wrapf(serviceif.ErrInvalidBucketRequest, "lifecycle rule %q: at least one action is required", rule.ID)
+		}
+	}
+	return nil
 }
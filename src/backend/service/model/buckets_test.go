@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// fakeBucketRepo は削除の呼び出し順を記録する。uploads が残っている間は BucketNotEmpty で削除に失敗する
+type fakeBucketRepo struct {
+	serviceif.BucketRepository
+	objects  *fakeObjectRepo
+	uploads  int
+	abortErr error
+	calls    []string
+}
+
+func (r *fakeBucketRepo) AbortMultipartUploads(ctx context.Context, bucketName string) (int, error) {
+	r.calls = append(r.calls, "abort")
+	if r.abortErr != nil {
+		return 0, r.abortErr
+	}
+	aborted := r.uploads
+	r.uploads = 0
+	return aborted, nil
+}
+
+func (r *fakeBucketRepo) DeleteBucket(ctx context.Context, bucketName string) error {
+	r.calls = append(r.calls, "delete")
+	if len(r.objects.objects) > 0 || r.uploads > 0 {
+		return serviceif.ErrBucketNotEmpty
+	}
+	return nil
+}
+
+type fakeDeleteRepo struct {
+	serviceif.DeleteRepository
+	objects *fakeObjectRepo
+	batches int
+}
+
+func (r *fakeDeleteRepo) DeleteObjects(ctx context.Context, bucketName string, keys []string) (*domain.DeleteObjectsResult, error) {
+	r.batches++
+	for _, key := range keys {
+		delete(r.objects.objects, key)
+	}
+	return &domain.DeleteObjectsResult{Deleted: keys}, nil
+}
+
+type fakeSettingsRepo struct {
+	serviceif.SettingsRepository
+	deleted []string
+}
+
+func (r *fakeSettingsRepo) DeleteBucketSettings(ctx context.Context, bucketName string) error {
+	r.deleted = append(r.deleted, bucketName)
+	return nil
+}
+
+type fakeBucketCacheRepo struct {
+	serviceif.CacheRepository
+	cleared   []string
+	forgotten []string
+}
+
+func (r *fakeBucketCacheRepo) ClearByBucket(ctx context.Context, bucketName string) (int64, error) {
+	r.cleared = append(r.cleared, bucketName)
+	return 2, nil
+}
+
+func (r *fakeBucketCacheRepo) ForgetBucketPolicy(bucketName string) {
+	r.forgotten = append(r.forgotten, bucketName)
+}
+
+type fakeBucketListCache struct {
+	serviceif.ListCacheRepository
+	bucketsInvalidated bool
+	objectsInvalidated []string
+}
+
+func (c *fakeBucketListCache) InvalidateBuckets() {
+	c.bucketsInvalidated = true
+}
+
+func (c *fakeBucketListCache) InvalidateObjects(bucketName string) {
+	c.objectsInvalidated = append(c.objectsInvalidated, bucketName)
+}
+
+type bucketServiceFakes struct {
+	repo      *fakeBucketRepo
+	deletes   *fakeDeleteRepo
+	settings  *fakeSettingsRepo
+	cache     *fakeBucketCacheRepo
+	listCache *fakeBucketListCache
+}
+
+func newTestBucketService(objects map[string]domain.ObjectMetadata, uploads int) (*BucketService, *bucketServiceFakes) {
+	objectRepo := &fakeObjectRepo{objects: objects}
+	f := &bucketServiceFakes{
+		repo:      &fakeBucketRepo{objects: objectRepo, uploads: uploads},
+		deletes:   &fakeDeleteRepo{objects: objectRepo},
+		settings:  &fakeSettingsRepo{},
+		cache:     &fakeBucketCacheRepo{},
+		listCache: &fakeBucketListCache{},
+	}
+	s := NewBucketService(f.repo, objectRepo, f.deletes, f.settings, f.cache, f.listCache)
+	return s, f
+}
+
+func TestDeleteBucket_ForceAbortsUploadsAndClearsCaches(t *testing.T) {
+	s, f := newTestBucketService(map[string]domain.ObjectMetadata{
+		"a.txt":     {Key: "a.txt"},
+		"dir/b.txt": {Key: "dir/b.txt"},
+	}, 3)
+
+	result, err := s.DeleteBucket(context.Background(), "my-bucket", true)
+	if err != nil {
+		t.Fatalf("DeleteBucket: %v", err)
+	}
+	want := &domain.DeleteBucketResult{Bucket: "my-bucket", PurgedObjects: 2, AbortedUploads: 3, ClearedCacheEntries: 2}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("expected %+v, got %+v", want, result)
+	}
+
+	// 完了していないマルチパートアップロードを中止してからバケットを削除する
+	if want := []string{"abort", "delete"}; !reflect.DeepEqual(f.repo.calls, want) {
+		t.Errorf("expected calls %v, got %v", want, f.repo.calls)
+	}
+
+	if !f.listCache.bucketsInvalidated {
+		t.Error("expected the bucket listing to be invalidated")
+	}
+	if want := []string{"my-bucket"}; !reflect.DeepEqual(f.listCache.objectsInvalidated, want) {
+		t.Errorf("expected object listings of %v to be invalidated, got %v", want, f.listCache.objectsInvalidated)
+	}
+	if want := []string{"my-bucket"}; !reflect.DeepEqual(f.cache.cleared, want) {
+		t.Errorf("expected content cache of %v to be cleared, got %v", want, f.cache.cleared)
+	}
+	if want := []string{"my-bucket"}; !reflect.DeepEqual(f.settings.deleted, want) {
+		t.Errorf("expected settings of %v to be deleted, got %v", want, f.settings.deleted)
+	}
+	if want := []string{"my-bucket"}; !reflect.DeepEqual(f.cache.forgotten, want) {
+		t.Errorf("expected the cache policy of %v to be forgotten, got %v", want, f.cache.forgotten)
+	}
+}
+
+func TestDeleteBucket_ForcePurgesPageByPage(t *testing.T) {
+	objects := make(map[string]domain.ObjectMetadata)
+	for i := range 2*purgePageSize + 1 {
+		key := fmt.Sprintf("obj-%05d", i)
+		objects[key] = domain.ObjectMetadata{Key: key}
+	}
+	s, f := newTestBucketService(objects, 0)
+
+	result, err := s.DeleteBucket(context.Background(), "my-bucket", true)
+	if err != nil {
+		t.Fatalf("DeleteBucket: %v", err)
+	}
+	if result.PurgedObjects != 2*purgePageSize+1 || f.deletes.batches != 3 {
+		t.Errorf("expected %d objects purged in 3 batches, got %d in %d", 2*purgePageSize+1, result.PurgedObjects, f.deletes.batches)
+	}
+}
+
+func TestDeleteBucket_ForceReturnsPartialResultOnError(t *testing.T) {
+	s, f := newTestBucketService(map[string]domain.ObjectMetadata{
+		"a.txt":     {Key: "a.txt"},
+		"dir/b.txt": {Key: "dir/b.txt"},
+	}, 1)
+	f.repo.abortErr = errors.New("upstream unavailable")
+
+	result, err := s.DeleteBucket(context.Background(), "my-bucket", true)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// 削除済みのオブジェクトの数とキャッシュの破棄を結果として返す
+	want := &domain.DeleteBucketResult{Bucket: "my-bucket", PurgedObjects: 2, ClearedCacheEntries: 2}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("expected %+v, got %+v", want, result)
+	}
+	if want := []string{"my-bucket"}; !reflect.DeepEqual(f.cache.cleared, want) {
+		t.Errorf("expected content cache of %v to be cleared, got %v", want, f.cache.cleared)
+	}
+	// バケットは削除していないため、設定は残す
+	if len(f.settings.deleted) > 0 || f.listCache.bucketsInvalidated {
+		t.Errorf("expected the bucket settings and listing to be kept, got settings=%v list=%+v", f.settings.deleted, f.listCache)
+	}
+}
+
+func TestDeleteBucket_WithoutForceKeepsStateWhenNotEmpty(t *testing.T) {
+	s, f := newTestBucketService(map[string]domain.ObjectMetadata{"a.txt": {Key: "a.txt"}}, 1)
+
+	if _, err := s.DeleteBucket(context.Background(), "my-bucket", false); !errors.Is(err, serviceif.ErrBucketNotEmpty) {
+		t.Fatalf("expected ErrBucketNotEmpty, got %v", err)
+	}
+
+	// force なしではアップロードを中止せず、キャッシュや設定も残す
+	if want := []string{"delete"}; !reflect.DeepEqual(f.repo.calls, want) {
+		t.Errorf("expected calls %v, got %v", want, f.repo.calls)
+	}
+	if f.listCache.bucketsInvalidated || len(f.cache.cleared) > 0 || len(f.settings.deleted) > 0 || len(f.cache.forgotten) > 0 {
+		t.Errorf("expected caches and settings to be kept, got list=%+v cache=%+v settings=%v",
+			f.listCache, f.cache, f.settings.deleted)
+	}
+}
+
+func TestDeleteBucket_RejectsInvalidName(t *testing.T) {
+	s, f := newTestBucketService(nil, 0)
+
+	if _, err := s.DeleteBucket(context.Background(), "Invalid_Bucket", true); !errors.Is(err, serviceif.ErrInvalidBucketRequest) {
+		t.Fatalf("expected ErrInvalidBucketRequest, got %v", err)
+	}
+	if len(f.repo.calls) > 0 {
+		t.Errorf("expected no repository calls, got %v", f.repo.calls)
+	}
+}
+
+func TestValidateCORSRules(t *testing.T) {
+	tooMany := make([]domain.CORSRule, maxCORSRules+1)
+	for i := range tooMany {
+		tooMany[i] = domain.CORSRule{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}
+	}
+
+	tests := []struct {
+		name    string
+		rules   []domain.CORSRule
+		wantErr bool
+	}{
+		{name: "empty", rules: nil},
+		{name: "valid", rules: []domain.CORSRule{{AllowedOrigins: []string{"https://example.com"}, AllowedMethods: []string{"GET", "PUT"}, MaxAgeSeconds: 3600}}},
+		{name: "too many rules", rules: tooMany, wantErr: true},
+		{name: "missing origins", rules: []domain.CORSRule{{AllowedMethods: []string{"GET"}}}, wantErr: true},
+		{name: "missing methods", rules: []domain.CORSRule{{AllowedOrigins: []string{"*"}}}, wantErr: true},
+		{name: "unsupported method", rules: []domain.CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"PATCH"}}}, wantErr: true},
+		{name: "negative max age", rules: []domain.CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, MaxAgeSeconds: -1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCORSRules(tt.rules)
+			if tt.wantErr {
+				if !errors.Is(err, serviceif.ErrInvalidBucketRequest) {
+					t.Errorf("expected ErrInvalidBucketRequest, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateCORSRules_NormalizesMethods(t *testing.T) {
+	rules := []domain.CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{" get", "Head "}}}
+	if err := validateCORSRules(rules); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"GET", "HEAD"}; !reflect.DeepEqual(rules[0].AllowedMethods, want) {
+		t.Errorf("expected methods %v, got %v", want, rules[0].AllowedMethods)
+	}
+}
+
+func TestValidateLifecycleRules(t *testing.T) {
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	tooMany := make([]domain.LifecycleRule, maxLifecycleRules+1)
+	for i := range tooMany {
+		tooMany[i] = domain.LifecycleRule{ID: fmt.Sprintf("rule-%d", i), ExpirationDays: 1}
+	}
+
+	tests := []struct {
+		name    string
+		rules   []domain.LifecycleRule
+		wantErr bool
+	}{
+		{name: "empty", rules: nil},
+		{name: "expiration days", rules: []domain.LifecycleRule{{ID: "expire", Enabled: true, ExpirationDays: 30}}},
+		{name: "expiration date", rules: []domain.LifecycleRule{{ID: "expire", ExpirationDate: &date}}},
+		{name: "abort uploads", rules: []domain.LifecycleRule{{ID: "abort", AbortIncompleteMultipartUploadDays: 7}}},
+		{name: "transition", rules: []domain.LifecycleRule{{ID: "archive", Transitions: []domain.LifecycleTransition{{Days: 30, StorageClass: "STANDARD_IA"}}}}},
+		{name: "too many rules", rules: tooMany, wantErr: true},
+		{name: "missing id", rules: []domain.LifecycleRule{{ExpirationDays: 1}}, wantErr: true},
+		{name: "long id", rules: []domain.LifecycleRule{{ID: strings.Repeat("x", 256), ExpirationDays: 1}}, wantErr: true},
+		{name: "duplicate id", rules: []domain.LifecycleRule{{ID: "dup", ExpirationDays: 1}, {ID: "dup", ExpirationDays: 2}}, wantErr: true},
+		{name: "negative days", rules: []domain.LifecycleRule{{ID: "neg", ExpirationDays: -1}}, wantErr: true},
+		{name: "negative abort days", rules: []domain.LifecycleRule{{ID: "neg", AbortIncompleteMultipartUploadDays: -1}}, wantErr: true},
+		{name: "days and date", rules: []domain.LifecycleRule{{ID: "both", ExpirationDays: 1, ExpirationDate: &date}}, wantErr: true},
+		{name: "transition without storage class", rules: []domain.LifecycleRule{{ID: "archive", Transitions: []domain.LifecycleTransition{{Days: 30}}}}, wantErr: true},
+		{name: "transition with negative days", rules: []domain.LifecycleRule{{ID: "archive", Transitions: []domain.LifecycleTransition{{Days: -1, StorageClass: "GLACIER"}}}}, wantErr: true},
+		{name: "no action", rules: []domain.LifecycleRule{{ID: "noop", Enabled: true}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLifecycleRules(tt.rules)
+			if tt.wantErr {
+				if !errors.Is(err, serviceif.ErrInvalidBucketRequest) {
+					t.Errorf("expected ErrInvalidBucketRequest, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateLifecycleRules_NormalizesStorageClass(t *testing.T) {
+	rules := []domain.LifecycleRule{{ID: "archive", Transitions: []domain.LifecycleTransition{{Days: 30, StorageClass: " standard_ia "}}}}
+	if err := validateLifecycleRules(rules); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := rules[0].Transitions[0].StorageClass; got != "STANDARD_IA" {
+		t.Errorf("expected STANDARD_IA, got %q", got)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+var (
+	// 接続名は URL のパスとディレクトリ名に使うため、小文字の英数字・ハイフン・アンダースコアに限る
+	connectionNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+	// 認証情報の参照名は環境変数の接頭辞として使う
+	credentialsRefPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+type ConnectionService struct {
+	repo    serviceif.ConnectionRepository
+	runtime serviceif.ConnectionRuntime
+	// defaultConn は環境変数で設定した既定の接続
+	defaultConn domain.Connection
+}
+
+func NewConnectionService(repo serviceif.ConnectionRepository, runtime serviceif.ConnectionRuntime, defaultConn domain.Connection) *ConnectionService {
+	defaultConn.Name = domain.DefaultConnectionName
+	defaultConn.Builtin = true
+	return &ConnectionService{repo: repo, runtime: runtime, defaultConn: defaultConn}
+}
+
+// ListConnections は既定の接続を先頭にして全ての接続を返す。
+func (s *ConnectionService) ListConnections(ctx context.Context) ([]domain.Connection, error) {
+	conns, err := s.repo.ListConnections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append([]domain.Connection{s.defaultConn}, conns...), nil
+}
+
+func (s *ConnectionService) GetConnection(ctx context.Context, name string) (*domain.Connection, error) {
+	if name == domain.DefaultConnectionName {
+		conn := s.defaultConn
+		return &conn, nil
+	}
+	conn, err := s.repo.GetConnection(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, errors.Wrap(serviceif.ErrConnectionNotFound, name)
+	}
+	return conn, nil
+}
+
+func (s *ConnectionService) CreateConnection(ctx context.Context, conn domain.Connection) (*domain.Connection, error) {
+	if conn.Name == domain.DefaultConnectionName {
+		return nil, errors.Wrap(serviceif.ErrConnectionExists, conn.Name)
+	}
+	if err := validateConnection(&conn); err != nil {
+		return nil, err
+	}
+
+	created, err := s.repo.CreateConnection(ctx, &conn)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, errors.Wrap(serviceif.ErrConnectionExists, conn.Name)
+	}
+	return &conn, nil
+}
+
+func (s *ConnectionService) UpdateConnection(ctx context.Context, conn domain.Connection) (*domain.Connection, error) {
+	if conn.Name == domain.DefaultConnectionName {
+		return nil, serviceif.ErrConnectionReadOnly
+	}
+	if err := validateConnection(&conn); err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetConnection(ctx, conn.Name)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, errors.Wrap(serviceif.ErrConnectionNotFound, conn.Name)
+	}
+
+	updated, err := s.repo.UpdateConnection(ctx, &conn)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, errors.Wrap(serviceif.ErrConnectionNotFound, conn.Name)
+	}
+	// 接続先が変わった場合、保存済みのキャッシュは別のストレージのデータのため使えない
+	s.runtime.Reload(conn.Name, !current.SameTarget(&conn))
+
+	return s.GetConnection(ctx, conn.Name)
+}
+
+// DeleteConnection は接続設定を削除し、その接続のキャッシュとデータベースも破棄する。
+func (s *ConnectionService) DeleteConnection(ctx context.Context, name string) error {
+	if name == domain.DefaultConnectionName {
+		return serviceif.ErrConnectionReadOnly
+	}
+
+	deleted, err := s.repo.DeleteConnection(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errors.Wrap(serviceif.ErrConnectionNotFound, name)
+	}
+
+	// 設定の削除は完了しているため、キャッシュの破棄に失敗しても成功として扱う
+	if err := s.runtime.Remove(name); err != nil {
+		log.Printf("warning: failed to remove data of connection %s: %v", name, err)
+	}
+	return nil
+}
+
+func validateConnection(conn *domain.Connection) error {
+	conn.Name = strings.TrimSpace(conn.Name)
+	conn.Endpoint = strings.TrimRight(strings.TrimSpace(conn.Endpoint), "/")
+	conn.CredentialsRef = strings.TrimSpace(conn.CredentialsRef)
+	conn.Builtin = false
+
+	if !connectionNamePattern.MatchString(conn.Name) {
+		return errors.Wrap(serviceif.ErrInvalidConnection, "name must be 1-63 lowercase letters, digits, '-' or '_'")
+	}
+	if conn.AccountID == "" && conn.Endpoint == "" && conn.Region == "" {
+		return errors.Wrap(serviceif.ErrInvalidConnection, "account_id, endpoint or region is required")
+	}
+	if conn.Endpoint != "" {
+		u, err := url.Parse(conn.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Wrap(serviceif.ErrInvalidConnection, "endpoint must be an http or https URL")
+		}
+	}
+	if conn.CredentialsRef != "" && !credentialsRefPattern.MatchString(conn.CredentialsRef) {
+		return errors.Wrap(serviceif.ErrInvalidConnection, "credentials_ref must be an environment variable prefix such as STAGING")
+	}
+	return nil
+}
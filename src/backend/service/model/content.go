@@ -2,51 +2,236 @@ package service
 
 import (
 	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"r2manager/domain"
+	"r2manager/health"
 	serviceif "r2manager/service/interface"
 )
 
 type ContentService struct {
 	contentRepo serviceif.ContentRepository
 	cacheRepo   serviceif.CacheRepository
+
+	// fetches は S3 から取得中のオブジェクト。同じオブジェクトへの GetObject を 1 回にまとめる
+	fetchesMu sync.Mutex
+	fetches   map[string]*contentFetch
+
+	revalidation contentRevalidation
+
+	// policies はバケットごとのキャッシュ設定。nil の場合は全てのオブジェクトをキャッシュする
+	policies serviceif.BucketCachePolicyRepository
+}
+
+// contentFetch は先行リクエストによる S3 からの取得。done は取得が開始されるか失敗した時点で閉じられる
+type contentFetch struct {
+	done chan struct{}
+	err  error
 }
 
-func NewContentService(contentRepo serviceif.ContentRepository, cacheRepo serviceif.CacheRepository) *ContentService {
-	return &ContentService{
+func NewContentService(contentRepo serviceif.ContentRepository, cacheRepo serviceif.CacheRepository, opts ...ContentOption) *ContentService {
+	s := &ContentService{
 		contentRepo: contentRepo,
 		cacheRepo:   cacheRepo,
+		fetches:     make(map[string]*contentFetch),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *ContentService) GetContent(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error) {
-	entry, err := s.cacheRepo.Lookup(ctx, bucketName, objectKey)
+type ContentOption func(*ContentService)
+
+func (s *ContentService) GetContent(ctx context.Context, bucketName, objectKey string, opts serviceif.GetContentOptions) (*domain.ObjectContent, error) {
+	content, err := s.getContent(ctx, bucketName, objectKey, opts)
+	if err != nil && health.IsUpstreamFailure(err) {
+		// R2 に接続できない場合は期限切れのキャッシュでも返す
+		if stale := s.openStale(ctx, bucketName, objectKey); stale != nil {
+			log.Printf("upstream unavailable, serving stale cache: bucket=%s key=%s: %v", bucketName, objectKey, err)
+			content, err = stale, nil
+		}
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to lookup cache")
+		return nil, err
+	}
+	return s.negotiateEncoding(content, opts)
+}
+
+func (s *ContentService) getContent(ctx context.Context, bucketName, objectKey string, opts serviceif.GetContentOptions) (*domain.ObjectContent, error) {
+	if policy := s.bucketPolicy(ctx, bucketName); policy != nil && policy.NeverCache {
+		return s.passThrough(ctx, bucketName, objectKey, opts)
 	}
 
+	entry, body, err := s.cacheRepo.Lookup(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to lookup cache")
+	}
 	if entry != nil {
-		body, err := s.cacheRepo.OpenCacheFile(entry.CachePath)
-		if err == nil {
-			return &domain.ObjectContent{
-				Body:        body,
-				ContentType: entry.ContentType,
-				Size:        entry.Size,
-				ETag:        entry.ETag,
-				CacheHit:    true,
-			}, nil
+		if s.shouldRevalidateHit(entry) {
+			s.revalidateInBackground(entry)
+		}
+		return cachedContent(entry, body, true), nil
+	}
+
+	if s.revalidation.enabled {
+		content, err := s.getStale(ctx, bucketName, objectKey, opts)
+		if err != nil {
+			return nil, err
 		}
-		// Cache file missing on disk; fall through to fetch from S3
+		if content != nil {
+			return content, nil
+		}
+	}
+
+	// 単一範囲の Range リクエストはオブジェクト全体をダウンロードせず、範囲をそのまま GetObject に渡す。
+	// 複数範囲は S3 が対応していないため、全体をキャッシュしてからハンドラ側で切り出す
+	if isSingleByteRange(opts.Range) {
+		content, err := s.contentRepo.GetContentRange(ctx, bucketName, objectKey, opts.Range)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get content range from S3")
+		}
+		return content, nil
+	}
+
+	if opts.Range != "" {
+		return s.coalesce(ctx, bucketName, objectKey, s.storeAndOpen)
 	}
+	return s.coalesce(ctx, bucketName, objectKey, s.streamFromS3)
+}
 
+// openCached はキャッシュが有効ならキャッシュファイルを開いて返す。キャッシュがなければ nil を返す。
+func (s *ContentService) openCached(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error) {
+	entry, body, err := s.cacheRepo.Lookup(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to lookup cache")
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return cachedContent(entry, body, true), nil
+}
+
+// openStale は有効期限切れのエントリも含めてキャッシュを開く。キャッシュがなければ nil を返す。
+func (s *ContentService) openStale(ctx context.Context, bucketName, objectKey string) *domain.ObjectContent {
+	entry, err := s.cacheRepo.LookupStale(ctx, bucketName, objectKey)
+	if err != nil || entry == nil {
+		return nil
+	}
+	content := s.openEntry(entry)
+	if content == nil {
+		return nil
+	}
+	content.Stale = time.Now().After(entry.ExpiresAt)
+	content.Degraded = true
+	return content
+}
+
+// openEntry はエントリのキャッシュファイルを開く。ファイルが存在しない場合は nil を返す。
+func (s *ContentService) openEntry(entry *domain.CacheEntry) *domain.ObjectContent {
+	body, err := s.cacheRepo.OpenCacheFile(entry.CachePath)
+	if err != nil {
+		return nil
+	}
+
+	return cachedContent(entry, body, true)
+}
+
+// coalesce は同じオブジェクトへの同時リクエストのうち、先行する 1 件だけに fetch を実行させる。
+// 後続のリクエストは先行リクエストの取得開始を待ち、進行中のダウンロードかキャッシュに合流する。
+func (s *ContentService) coalesce(ctx context.Context, bucketName, objectKey string, fetch func(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error)) (*domain.ObjectContent, error) {
+	key := bucketName + "\x00" + objectKey
+
+	s.fetchesMu.Lock()
+	call, waiting := s.fetches[key]
+	if !waiting {
+		call = &contentFetch{done: make(chan struct{})}
+		s.fetches[key] = call
+	}
+	s.fetchesMu.Unlock()
+
+	if !waiting {
+		content, err := fetch(ctx, bucketName, objectKey)
+
+		s.fetchesMu.Lock()
+		delete(s.fetches, key)
+		s.fetchesMu.Unlock()
+		call.err = err
+		close(call.done)
+
+		return content, err
+	}
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// 先行リクエストのクライアントが切断して取得が中断された場合は、自分で取得し直す
+	if call.err != nil && !errors.Is(call.err, context.Canceled) {
+		return nil, call.err
+	}
+
+	if content, ok := s.cacheRepo.InFlight(bucketName, objectKey); ok {
+		return content, nil
+	}
+	content, err := s.openCached(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	if content != nil {
+		return content, nil
+	}
+
+	// 先行リクエストの取得がキャッシュされずに終わった場合 (全リーダーの切断など) は自分で取得する
+	return fetch(ctx, bucketName, objectKey)
+}
+
+// streamFromS3 は S3 から取得したボディをキャッシュファイルへ書き込みつつ返す。
+func (s *ContentService) streamFromS3(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error) {
+	// 同じオブジェクトのダウンロードが進行中なら、それに合流する
+	if content, ok := s.cacheRepo.InFlight(bucketName, objectKey); ok {
+		return content, nil
+	}
+
+	// クライアントが切断してもキャッシュへの書き込みは他のリーダーのために継続できるよう、
+	// リクエストのキャンセルを引き継がない
+	content, err := s.contentRepo.GetContent(context.WithoutCancel(ctx), bucketName, objectKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get content from S3")
+	}
+	if !s.cacheable(ctx, bucketName, content) {
+		return content, nil
+	}
+
+	// キャッシュファイルへの書き込みと並行してクライアントへ送信する
+	streamed, err := s.cacheRepo.StreamStore(bucketName, objectKey, content)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store cache")
+	}
+	streamed.CacheHit = false
+
+	return streamed, nil
+}
+
+// storeAndOpen はオブジェクト全体をキャッシュしてからキャッシュファイルを開く。
+// 複数範囲の Range リクエストはシーク可能なボディが必要なため、こちらを使う。
+func (s *ContentService) storeAndOpen(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error) {
 	content, err := s.contentRepo.GetContent(ctx, bucketName, objectKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get content from S3")
 	}
+	// キャッシュの対象外の場合はそのまま返す。シークできないため、ハンドラは Range を無視して全体を返す
+	if !s.cacheable(ctx, bucketName, content) {
+		return content, nil
+	}
 
-	entry, err = s.cacheRepo.Store(ctx, bucketName, objectKey, content.Body, content.ContentType, content.Size, content.ETag)
+	entry, err := s.cacheRepo.Store(ctx, bucketName, objectKey, content.Body, content.ContentType, content.Size, content.ETag)
 	content.Body.Close()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to store cache")
@@ -57,11 +242,9 @@ func (s *ContentService) GetContent(ctx context.Context, bucketName, objectKey s
 		return nil, errors.Wrap(err, "failed to open cached file after store")
 	}
 
-	return &domain.ObjectContent{
-		Body:        body,
-		ContentType: entry.ContentType,
-		Size:        entry.Size,
-		ETag:        entry.ETag,
-		CacheHit:    false,
-	}, nil
+	return cachedContent(entry, body, false), nil
+}
+
+func isSingleByteRange(rangeHeader string) bool {
+	return strings.HasPrefix(rangeHeader, "bytes=") && !strings.Contains(rangeHeader, ",")
 }
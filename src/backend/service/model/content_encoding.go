@@ -0,0 +1,102 @@
+package service
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// cachedContent はキャッシュエントリと開いたキャッシュファイルからレスポンスの内容を組み立てる。
+// 圧縮されたキャッシュファイルは圧縮したまま返し、展開するかどうかは negotiateEncoding で決める。
+func cachedContent(entry *domain.CacheEntry, body io.ReadCloser, hit bool) *domain.ObjectContent {
+	content := &domain.ObjectContent{
+		Body:         body,
+		ContentType:  entry.ContentType,
+		Size:         entry.Size,
+		ETag:         entry.ETag,
+		LastModified: entry.CachedAt,
+		CacheHit:     hit,
+	}
+	if entry.Encoding != "" {
+		content.ContentEncoding = entry.Encoding
+		content.Size = entry.DiskSize
+		content.DecodedSize = entry.Size
+	}
+	return content
+}
+
+// negotiateEncoding は圧縮されたボディを、クライアントが圧縮形式を受け付けない場合に展開する。
+// Range はオブジェクト本来のバイト位置を指すため、Range リクエストではシーク可能な形に展開して返す。
+// 圧縮したまま返す場合は、展開した表現と区別できるよう ETag に圧縮形式を付ける。
+func (s *ContentService) negotiateEncoding(content *domain.ObjectContent, opts serviceif.GetContentOptions) (*domain.ObjectContent, error) {
+	if content.ContentEncoding == "" {
+		return content, nil
+	}
+	if opts.Range == "" && acceptsEncoding(opts.AcceptEncoding, content.ContentEncoding) {
+		content.ETag = encodedETag(content.ETag, content.ContentEncoding)
+		return content, nil
+	}
+
+	var body io.ReadCloser
+	var err error
+	if opts.Range != "" {
+		body, err = s.cacheRepo.DecodeSeekableContent(content.Body, content.ContentEncoding, content.DecodedSize)
+	} else {
+		body, err = s.cacheRepo.DecodeContent(content.Body, content.ContentEncoding)
+	}
+	if err != nil {
+		content.Body.Close()
+		return nil, errors.Wrap(err, "failed to decode cached content")
+	}
+	content.Body = body
+	content.Size = content.DecodedSize
+	content.ContentEncoding = ""
+	content.DecodedSize = 0
+	return content, nil
+}
+
+// encodedETag は圧縮した表現の ETag を返す。"abc" は "abc-gzip" に、W/"abc" は W/"abc-gzip" になる。
+func encodedETag(etag, encoding string) string {
+	if etag == "" {
+		return ""
+	}
+	if strings.HasSuffix(etag, `"`) {
+		return etag[:len(etag)-1] + "-" + encoding + `"`
+	}
+	return etag + "-" + encoding
+}
+
+// acceptsEncoding は Accept-Encoding ヘッダが encoding を受け付けるかを返す。
+// q=0 の指定は拒否として扱い、"*" は明示されていない形式に適用する。
+func acceptsEncoding(header, encoding string) bool {
+	accepted := false
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		q := 1.0
+		for param := range strings.SplitSeq(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		// 形式を明示した指定は "*" より優先する
+		if name == encoding {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
@@ -0,0 +1,38 @@
+package service
+
+import "testing"
+
+func TestEncodedETag(t *testing.T) {
+	tests := []struct {
+		etag, encoding, want string
+	}{
+		{`"abc"`, "gzip", `"abc-gzip"`},
+		{`W/"abc"`, "zstd", `W/"abc-zstd"`},
+		{`abc`, "gzip", `abc-gzip`},
+		{``, "gzip", ``},
+	}
+	for _, tt := range tests {
+		if got := encodedETag(tt.etag, tt.encoding); got != tt.want {
+			t.Errorf("encodedETag(%q, %q) = %q, want %q", tt.etag, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, encoding string
+		want             bool
+	}{
+		{"gzip, deflate, br", "gzip", true},
+		{"deflate, br", "gzip", false},
+		{"gzip;q=0", "gzip", false},
+		{"*", "zstd", true},
+		{"*, zstd;q=0", "zstd", false},
+		{"", "gzip", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.encoding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.encoding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// WithBucketCachePolicies はバケットごとのキャッシュ設定に従い、キャッシュしないバケットや Content-Type を S3 から直接返すよう設定する。
+func WithBucketCachePolicies(repo serviceif.BucketCachePolicyRepository) ContentOption {
+	return func(s *ContentService) {
+		s.policies = repo
+	}
+}
+
+// bucketPolicy はバケットのキャッシュ設定を返す。設定がない場合や取得に失敗した場合は nil（全体の設定に従う）。
+func (s *ContentService) bucketPolicy(ctx context.Context, bucketName string) *domain.BucketCachePolicy {
+	if s.policies == nil {
+		return nil
+	}
+	policy, err := s.policies.GetBucketCachePolicy(ctx, bucketName)
+	if err != nil {
+		log.Printf("warning: failed to load bucket cache policy: bucket=%s: %v", bucketName, err)
+		return nil
+	}
+	return policy
+}
+
+// cacheable は取得したオブジェクトをキャッシュしてよいかを返す。
+func (s *ContentService) cacheable(ctx context.Context, bucketName string, content *domain.ObjectContent) bool {
+	return s.bucketPolicy(ctx, bucketName).AllowsContentType(content.ContentType)
+}
+
+// passThrough はキャッシュを使わずに S3 から取得して返す。
+func (s *ContentService) passThrough(ctx context.Context, bucketName, objectKey string, opts serviceif.GetContentOptions) (*domain.ObjectContent, error) {
+	if isSingleByteRange(opts.Range) {
+		content, err := s.contentRepo.GetContentRange(ctx, bucketName, objectKey, opts.Range)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get content range from S3")
+		}
+		return content, nil
+	}
+
+	content, err := s.contentRepo.GetContent(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get content from S3")
+	}
+	return content, nil
+}
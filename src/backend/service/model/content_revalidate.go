@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// contentRevalidation はキャッシュの再検証の設定と、バックグラウンドで実行中の再検証。
+type contentRevalidation struct {
+	enabled bool
+	// everyNHits が 0 より大きい場合、有効期限内でも N 回ヒットするごとに再検証する
+	everyNHits int64
+	// staleWhileRevalidate は期限切れ後も古いキャッシュを返しつつ、バックグラウンドで再検証する期間
+	staleWhileRevalidate time.Duration
+
+	running sync.Map
+}
+
+// WithRevalidation は有効期限切れのキャッシュを破棄せず、S3 に変更がないか問い合わせてから再利用するよう設定する。
+func WithRevalidation(everyNHits int, staleWhileRevalidate time.Duration) ContentOption {
+	return func(s *ContentService) {
+		s.revalidation.enabled = true
+		s.revalidation.everyNHits = int64(max(everyNHits, 0))
+		s.revalidation.staleWhileRevalidate = staleWhileRevalidate
+	}
+}
+
+func (s *ContentService) shouldRevalidateHit(entry *domain.CacheEntry) bool {
+	every := s.revalidation.everyNHits
+	return s.revalidation.enabled && every > 0 && entry.HitCount%every == 0
+}
+
+// getStale は有効期限切れのエントリがあれば再検証して返す。エントリがなければ nil を返す。
+// stale-while-revalidate の期間内であれば古いキャッシュをそのまま返し、再検証はバックグラウンドで行う。
+func (s *ContentService) getStale(ctx context.Context, bucketName, objectKey string, opts serviceif.GetContentOptions) (*domain.ObjectContent, error) {
+	entry, err := s.cacheRepo.LookupStale(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to lookup stale cache")
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	if time.Since(entry.ExpiresAt) < s.revalidation.staleWhileRevalidate {
+		if content := s.openEntry(entry); content != nil {
+			content.Stale = true
+			s.revalidateInBackground(entry)
+			return content, nil
+		}
+		return nil, nil
+	}
+
+	return s.coalesce(ctx, bucketName, objectKey, func(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error) {
+		return s.revalidate(ctx, entry, opts)
+	})
+}
+
+// revalidate は If-None-Match 付きで GetObject し、変更がなければ有効期限を延長してキャッシュを返す。
+// 変更されていた場合は取得した新しい内容をキャッシュしつつ返す。
+func (s *ContentService) revalidate(ctx context.Context, entry *domain.CacheEntry, opts serviceif.GetContentOptions) (*domain.ObjectContent, error) {
+	content, err := s.contentRepo.GetContentIfNoneMatch(context.WithoutCancel(ctx), entry.BucketName, entry.ObjectKey, entry.ETag)
+	if errors.Is(err, serviceif.ErrNotModified) {
+		extended, err := s.cacheRepo.Extend(ctx, entry.BucketName, entry.ObjectKey, entry.ETag)
+		if err != nil {
+			return nil, err
+		}
+		if extended != nil {
+			if content := s.openEntry(extended); content != nil {
+				return content, nil
+			}
+		}
+		// 再検証中に削除・上書きされた場合は通常のキャッシュミスとして取得し直す
+		return s.streamFromS3(ctx, entry.BucketName, entry.ObjectKey)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to revalidate content")
+	}
+	if !s.cacheable(ctx, entry.BucketName, content) {
+		return content, nil
+	}
+
+	// Range リクエストにはシーク可能なボディが必要なため、全体をキャッシュしてから開く
+	if opts.Range != "" {
+		stored, err := s.cacheRepo.Store(ctx, entry.BucketName, entry.ObjectKey, content.Body, content.ContentType, content.Size, content.ETag)
+		content.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to store cache")
+		}
+		refreshed := s.openEntry(stored)
+		if refreshed == nil {
+			return nil, errors.New("failed to open cached file after store")
+		}
+		refreshed.CacheHit = false
+		return refreshed, nil
+	}
+
+	streamed, err := s.cacheRepo.StreamStore(entry.BucketName, entry.ObjectKey, content)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store cache")
+	}
+	streamed.CacheHit = false
+
+	return streamed, nil
+}
+
+// revalidateInBackground はリクエストとは独立して再検証を行う。同じオブジェクトの再検証は同時に 1 つだけ実行する。
+func (s *ContentService) revalidateInBackground(entry *domain.CacheEntry) {
+	key := entry.BucketName + "\x00" + entry.ObjectKey
+	if _, running := s.revalidation.running.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer s.revalidation.running.Delete(key)
+
+		ctx := context.Background()
+		content, err := s.contentRepo.GetContentIfNoneMatch(ctx, entry.BucketName, entry.ObjectKey, entry.ETag)
+		if errors.Is(err, serviceif.ErrNotModified) {
+			if _, err := s.cacheRepo.Extend(ctx, entry.BucketName, entry.ObjectKey, entry.ETag); err != nil {
+				log.Printf("warning: failed to extend cache entry: bucket=%s key=%s: %v", entry.BucketName, entry.ObjectKey, err)
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("warning: background revalidation failed: bucket=%s key=%s: %v", entry.BucketName, entry.ObjectKey, err)
+			return
+		}
+		defer content.Body.Close()
+		if !s.cacheable(ctx, entry.BucketName, content) {
+			return
+		}
+
+		if _, err := s.cacheRepo.Store(ctx, entry.BucketName, entry.ObjectKey, content.Body, content.ContentType, content.Size, content.ETag); err != nil {
+			log.Printf("warning: failed to refresh cache entry: bucket=%s key=%s: %v", entry.BucketName, entry.ObjectKey, err)
+			return
+		}
+		log.Printf("refreshed changed cache entry: bucket=%s key=%s", entry.BucketName, entry.ObjectKey)
+	}()
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+	"r2manager/health"
+	serviceif "r2manager/service/interface"
+)
+
+// fakeRevalidateRepo は S3 上のオブジェクトとして body と etag を返す
+type fakeRevalidateRepo struct {
+	serviceif.ContentRepository
+	body string
+	etag string
+	// modified が false の場合、If-None-Match 付きの取得は ErrNotModified を返す
+	modified bool
+	err      error
+
+	mu          sync.Mutex
+	revalidated []string
+	fetched     []string
+}
+
+func (r *fakeRevalidateRepo) GetContentIfNoneMatch(ctx context.Context, bucketName, objectKey, etag string) (*domain.ObjectContent, error) {
+	r.mu.Lock()
+	r.revalidated = append(r.revalidated, etag)
+	r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !r.modified {
+		return nil, serviceif.ErrNotModified
+	}
+	return r.content(), nil
+}
+
+func (r *fakeRevalidateRepo) GetContent(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error) {
+	r.mu.Lock()
+	r.fetched = append(r.fetched, objectKey)
+	r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.content(), nil
+}
+
+func (r *fakeRevalidateRepo) content() *domain.ObjectContent {
+	return &domain.ObjectContent{Body: io.NopCloser(strings.NewReader(r.body)), ContentType: "text/plain", Size: int64(len(r.body)), ETag: r.etag}
+}
+
+// fakeRevalidateCache は期限切れのエントリを 1 件だけ持つキャッシュ。files はキャッシュファイルの内容
+type fakeRevalidateCache struct {
+	serviceif.CacheRepository
+	entry *domain.CacheEntry
+	files map[string]string
+
+	mu       sync.Mutex
+	extended []string
+	stored   []string
+	streamed []string
+}
+
+func (c *fakeRevalidateCache) Lookup(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, io.ReadCloser, error) {
+	return nil, nil, nil
+}
+
+func (c *fakeRevalidateCache) LookupStale(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error) {
+	if c.entry == nil {
+		return nil, nil
+	}
+	entry := *c.entry
+	return &entry, nil
+}
+
+func (c *fakeRevalidateCache) Extend(ctx context.Context, bucketName, objectKey, etag string) (*domain.CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.extended = append(c.extended, etag)
+	if c.entry == nil || c.entry.ETag != etag {
+		return nil, nil
+	}
+	c.entry.ExpiresAt = time.Now().Add(time.Hour)
+	entry := *c.entry
+	return &entry, nil
+}
+
+func (c *fakeRevalidateCache) Store(ctx context.Context, bucketName, objectKey string, body io.Reader, contentType string, size int64, etag string) (*domain.CacheEntry, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stored = append(c.stored, etag)
+	path := "stored/" + objectKey
+	c.files[path] = string(data)
+	return &domain.CacheEntry{BucketName: bucketName, ObjectKey: objectKey, ContentType: contentType, Size: size, ETag: etag, CachePath: path}, nil
+}
+
+func (c *fakeRevalidateCache) StreamStore(bucketName, objectKey string, content *domain.ObjectContent) (*domain.ObjectContent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.streamed = append(c.streamed, content.ETag)
+	return content, nil
+}
+
+func (c *fakeRevalidateCache) InFlight(bucketName, objectKey string) (*domain.ObjectContent, bool) {
+	return nil, false
+}
+
+func (c *fakeRevalidateCache) OpenCacheFile(cachePath string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.files[cachePath]
+	if !ok {
+		return nil, errors.New("cache file not found")
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+func (c *fakeRevalidateCache) calls() (extended, stored, streamed []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.extended...), append([]string(nil), c.stored...), append([]string(nil), c.streamed...)
+}
+
+// newTestRevalidateService は期限切れから expiredFor が経過したエントリを持つ ContentService を作成する。
+func newTestRevalidateService(repo *fakeRevalidateRepo, expiredFor, staleWhileRevalidate time.Duration) (*ContentService, *fakeRevalidateCache) {
+	cache := &fakeRevalidateCache{
+		entry: &domain.CacheEntry{BucketName: "bucket", ObjectKey: "a.txt", ContentType: "text/plain", Size: 3, ETag: `"v1"`,
+			CachePath: "cached/a.txt", ExpiresAt: time.Now().Add(-expiredFor)},
+		files: map[string]string{"cached/a.txt": "old"},
+	}
+	return NewContentService(repo, cache, WithRevalidation(0, staleWhileRevalidate)), cache
+}
+
+func readContent(t *testing.T, content *domain.ObjectContent) string {
+	t.Helper()
+	defer content.Body.Close()
+	data, err := io.ReadAll(content.Body)
+	if err != nil {
+		t.Fatalf("failed to read content: %v", err)
+	}
+	return string(data)
+}
+
+func TestRevalidate_NotModifiedExtendsEntry(t *testing.T) {
+	repo := &fakeRevalidateRepo{}
+	s, cache := newTestRevalidateService(repo, time.Hour, 0)
+
+	content, err := s.GetContent(context.Background(), "bucket", "a.txt", serviceif.GetContentOptions{})
+	if err != nil {
+		t.Fatalf("GetContent: %v", err)
+	}
+	if got := readContent(t, content); got != "old" || !content.CacheHit || content.Stale {
+		t.Errorf("expected the cached content as a fresh hit, got %q hit=%v stale=%v", got, content.CacheHit, content.Stale)
+	}
+
+	// 変更がなければ取得し直さずに有効期限だけを延長する
+	extended, stored, streamed := cache.calls()
+	if want := []string{`"v1"`}; !reflect.DeepEqual(repo.revalidated, want) || !reflect.DeepEqual(extended, want) {
+		t.Errorf("expected revalidation and extension with %v, got revalidated=%v extended=%v", want, repo.revalidated, extended)
+	}
+	if len(stored) > 0 || len(streamed) > 0 || len(repo.fetched) > 0 {
+		t.Errorf("expected no download, got stored=%v streamed=%v fetched=%v", stored, streamed, repo.fetched)
+	}
+}
+
+func TestRevalidate_NotModifiedRefetchesReplacedEntry(t *testing.T) {
+	repo := &fakeRevalidateRepo{body: "new", etag: `"v2"`}
+	s, cache := newTestRevalidateService(repo, time.Hour, 0)
+	stale := *cache.entry
+	// 再検証中に別のリクエストが上書きした場合、Extend は ETag が一致せず何も返さない
+	cache.entry.ETag = `"v2"`
+
+	content, err := s.revalidate(context.Background(), &stale, serviceif.GetContentOptions{})
+	if err != nil {
+		t.Fatalf("revalidate: %v", err)
+	}
+	if got := readContent(t, content); got != "new" || content.CacheHit {
+		t.Errorf("expected the object to be fetched again, got %q hit=%v", got, content.CacheHit)
+	}
+	if extended, _, streamed := cache.calls(); !reflect.DeepEqual(extended, []string{`"v1"`}) || !reflect.DeepEqual(streamed, []string{`"v2"`}) {
+		t.Errorf("expected extend with the old etag and a new download, got extended=%v streamed=%v", extended, streamed)
+	}
+}
+
+func TestRevalidate_ModifiedStoresNewContent(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         serviceif.GetContentOptions
+		wantStored   []string
+		wantStreamed []string
+	}{
+		// 通常のリクエストはキャッシュへの書き込みと並行して返す
+		{name: "stream", opts: serviceif.GetContentOptions{}, wantStreamed: []string{`"v2"`}},
+		// Range リクエストはシーク可能なボディが必要なため、全体を保存してから開く
+		{name: "range", opts: serviceif.GetContentOptions{Range: "bytes=0-0,2-2"}, wantStored: []string{`"v2"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRevalidateRepo{body: "new", etag: `"v2"`, modified: true}
+			s, cache := newTestRevalidateService(repo, time.Hour, 0)
+
+			content, err := s.GetContent(context.Background(), "bucket", "a.txt", tt.opts)
+			if err != nil {
+				t.Fatalf("GetContent: %v", err)
+			}
+			if got := readContent(t, content); got != "new" || content.CacheHit || content.ETag != `"v2"` {
+				t.Errorf("expected the new content as a miss, got %q hit=%v etag=%s", got, content.CacheHit, content.ETag)
+			}
+
+			extended, stored, streamed := cache.calls()
+			if len(extended) > 0 {
+				t.Errorf("expected the entry not to be extended, got %v", extended)
+			}
+			if !reflect.DeepEqual(stored, tt.wantStored) || !reflect.DeepEqual(streamed, tt.wantStreamed) {
+				t.Errorf("expected stored=%v streamed=%v, got stored=%v streamed=%v", tt.wantStored, tt.wantStreamed, stored, streamed)
+			}
+		})
+	}
+}
+
+func TestRevalidate_ErrorFallsBackToStaleCache(t *testing.T) {
+	repo := &fakeRevalidateRepo{err: health.ErrUpstreamUnavailable}
+	s, cache := newTestRevalidateService(repo, time.Hour, 0)
+
+	// S3 に接続できない場合は期限切れのキャッシュを返す
+	content, err := s.GetContent(context.Background(), "bucket", "a.txt", serviceif.GetContentOptions{})
+	if err != nil {
+		t.Fatalf("GetContent: %v", err)
+	}
+	if got := readContent(t, content); got != "old" || !content.Stale || !content.Degraded {
+		t.Errorf("expected the stale cache in degraded mode, got %q stale=%v degraded=%v", got, content.Stale, content.Degraded)
+	}
+	if extended, stored, streamed := cache.calls(); len(extended) > 0 || len(stored) > 0 || len(streamed) > 0 {
+		t.Errorf("expected the cache to be left as is, got extended=%v stored=%v streamed=%v", extended, stored, streamed)
+	}
+
+	// S3 が応答した上でのエラーは期限切れのキャッシュで隠さない
+	repo.err = errors.New("access denied")
+	if _, err := s.GetContent(context.Background(), "bucket", "a.txt", serviceif.GetContentOptions{}); err == nil {
+		t.Error("expected the revalidation error to be returned")
+	}
+}
+
+func TestRevalidate_StaleWhileRevalidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		repo         *fakeRevalidateRepo
+		wantExtended []string
+		wantStored   []string
+	}{
+		{name: "not modified", repo: &fakeRevalidateRepo{}, wantExtended: []string{`"v1"`}},
+		{name: "modified", repo: &fakeRevalidateRepo{body: "new", etag: `"v2"`, modified: true}, wantStored: []string{`"v2"`}},
+		{name: "error", repo: &fakeRevalidateRepo{err: health.ErrUpstreamUnavailable}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, cache := newTestRevalidateService(tt.repo, time.Minute, time.Hour)
+
+			// 期間内は古いキャッシュをすぐに返し、再検証はバックグラウンドで行う
+			content, err := s.GetContent(context.Background(), "bucket", "a.txt", serviceif.GetContentOptions{})
+			if err != nil {
+				t.Fatalf("GetContent: %v", err)
+			}
+			if got := readContent(t, content); got != "old" || !content.Stale {
+				t.Errorf("expected the stale cache, got %q stale=%v", got, content.Stale)
+			}
+
+			waitRevalidated(t, s)
+			extended, stored, _ := cache.calls()
+			if !reflect.DeepEqual(extended, tt.wantExtended) || !reflect.DeepEqual(stored, tt.wantStored) {
+				t.Errorf("expected extended=%v stored=%v, got extended=%v stored=%v", tt.wantExtended, tt.wantStored, extended, stored)
+			}
+		})
+	}
+}
+
+// waitRevalidated はバックグラウンドの再検証が終わるまで待つ。
+func waitRevalidated(t *testing.T, s *ContentService) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		running := false
+		s.revalidation.running.Range(func(_, _ any) bool {
+			running = true
+			return false
+		})
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background revalidation did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// CopyObject で一度にコピーできるサイズの上限。これを超える場合は UploadPartCopy を使う
+const maxSingleCopySize int64 = 5 * 1024 * 1024 * 1024
+
+type CopyService struct {
+	repo       serviceif.CopyRepository
+	objectRepo serviceif.ObjectRepository
+	deleteRepo serviceif.DeleteRepository
+	cacheRepo  serviceif.CacheRepository
+	listCache  serviceif.ListCacheRepository
+}
+
+func NewCopyService(repo serviceif.CopyRepository, objectRepo serviceif.ObjectRepository, deleteRepo serviceif.DeleteRepository, cacheRepo serviceif.CacheRepository, listCache serviceif.ListCacheRepository) *CopyService {
+	return &CopyService{repo: repo, objectRepo: objectRepo, deleteRepo: deleteRepo, cacheRepo: cacheRepo, listCache: listCache}
+}
+
+type copyTarget struct {
+	source      domain.Object
+	destination string
+}
+
+func (s *CopyService) Copy(ctx context.Context, params serviceif.CopyParams, onProgress serviceif.CopyProgressCallback) (*domain.CopyObjectsResult, error) {
+	params, targets, err := s.resolveTargets(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.copyTargets(ctx, params, targets, onProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("copied objects: %s/%s -> %s/%s copied=%d errors=%d", params.SourceBucket, params.SourceKey, params.DestinationBucket, params.DestinationKey, len(result.Copied), len(result.Errors))
+
+	return result, nil
+}
+
+// Move はコピー後にコピー元を削除する。コピーに失敗したオブジェクトのコピー元は残す。
+func (s *CopyService) Move(ctx context.Context, params serviceif.CopyParams, onProgress serviceif.CopyProgressCallback) (*domain.CopyObjectsResult, error) {
+	params, targets, err := s.resolveTargets(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.copyTargets(ctx, params, targets, onProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceKeys := make([]string, 0, len(result.Copied))
+	destinations := make(map[string]string, len(result.Copied))
+	for _, c := range result.Copied {
+		sourceKeys = append(sourceKeys, c.SourceKey)
+		destinations[c.SourceKey] = c.DestinationKey
+	}
+
+	deleted, err := s.deleteRepo.DeleteObjects(ctx, params.SourceBucket, sourceKeys)
+	if deleted != nil {
+		s.invalidate(ctx, params.SourceBucket, deleted.Deleted)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete source objects")
+	}
+
+	// コピー元を削除できなかったオブジェクトは移動失敗として扱う
+	failed := make(map[string]bool, len(deleted.Errors))
+	for _, e := range deleted.Errors {
+		failed[e.Key] = true
+		result.Errors = append(result.Errors, domain.CopyError{
+			SourceKey:      e.Key,
+			DestinationKey: destinations[e.Key],
+			Code:           "SOURCE_DELETE_FAILED",
+			Message:        e.Message,
+		})
+	}
+	if len(failed) > 0 {
+		moved := make([]domain.CopiedObject, 0, len(result.Copied)-len(failed))
+		for _, c := range result.Copied {
+			if !failed[c.SourceKey] {
+				moved = append(moved, c)
+			}
+		}
+		result.Copied = moved
+	}
+
+	log.Printf("moved objects: %s/%s -> %s/%s moved=%d errors=%d", params.SourceBucket, params.SourceKey, params.DestinationBucket, params.DestinationKey, len(result.Copied), len(result.Errors))
+
+	return result, nil
+}
+
+// resolveTargets はパラメータを正規化し、コピー対象のオブジェクトとコピー先キーの組を返す。
+func (s *CopyService) resolveTargets(ctx context.Context, params serviceif.CopyParams) (serviceif.CopyParams, []copyTarget, error) {
+	if params.DestinationBucket == "" {
+		params.DestinationBucket = params.SourceBucket
+	}
+	params.SourceKey = sanitizeObjectPath(params.SourceKey)
+	params.DestinationKey = sanitizeObjectPath(params.DestinationKey)
+	if params.SourceKey == "" {
+		return params, nil, errors.New("invalid source key")
+	}
+	if params.DestinationKey == "" {
+		return params, nil, errors.New("invalid destination key")
+	}
+
+	sameBucket := params.SourceBucket == params.DestinationBucket
+
+	if !params.Recursive {
+		if sameBucket && params.SourceKey == params.DestinationKey {
+			return params, nil, errors.Wrap(serviceif.ErrInvalidDestination, "source and destination are the same")
+		}
+		obj, err := s.objectRepo.HeadObject(ctx, params.SourceBucket, params.SourceKey)
+		if err != nil {
+			return params, nil, err
+		}
+		return params, []copyTarget{{source: *obj, destination: params.DestinationKey}}, nil
+	}
+
+	if !strings.HasSuffix(params.SourceKey, "/") {
+		params.SourceKey = params.SourceKey + "/"
+	}
+	if !strings.HasSuffix(params.DestinationKey, "/") {
+		params.DestinationKey = params.DestinationKey + "/"
+	}
+	if sameBucket && strings.HasPrefix(params.DestinationKey, params.SourceKey) {
+		return params, nil, errors.Wrap(serviceif.ErrInvalidDestination, "destination is inside source")
+	}
+
+	objects, err := s.objectRepo.ListAllObjects(ctx, params.SourceBucket, params.SourceKey)
+	if err != nil {
+		return params, nil, errors.Wrap(err, "failed to list source objects")
+	}
+	if len(objects) == 0 {
+		return params, nil, serviceif.ErrObjectNotFound
+	}
+
+	targets := make([]copyTarget, 0, len(objects))
+	for _, obj := range objects {
+		targets = append(targets, copyTarget{
+			source:      obj,
+			destination: params.DestinationKey + strings.TrimPrefix(obj.Key, params.SourceKey),
+		})
+	}
+
+	return params, targets, nil
+}
+
+// copyTargets は対象を順にコピーする。単一オブジェクトの場合はエラーをそのまま返し、
+// フォルダの場合はオブジェクトごとの失敗を結果に含めて処理を続ける。
+func (s *CopyService) copyTargets(ctx context.Context, params serviceif.CopyParams, targets []copyTarget, onProgress serviceif.CopyProgressCallback) (*domain.CopyObjectsResult, error) {
+	var totalBytes int64
+	for _, t := range targets {
+		totalBytes += t.source.Size
+	}
+
+	result := &domain.CopyObjectsResult{
+		Copied: make([]domain.CopiedObject, 0, len(targets)),
+		Errors: []domain.CopyError{},
+	}
+
+	var copiedBytes int64
+	for _, t := range targets {
+		etag, err := s.copyOne(ctx, params, t, func(bytesProcessed int64) {
+			if onProgress != nil {
+				onProgress(copiedBytes+bytesProcessed, totalBytes)
+			}
+		})
+		if err != nil {
+			if !params.Recursive {
+				return nil, err
+			}
+			copyErr := domain.CopyError{
+				SourceKey:      t.source.Key,
+				DestinationKey: t.destination,
+				Message:        err.Error(),
+			}
+			if errors.Is(err, serviceif.ErrObjectAlreadyExists) {
+				copyErr.Code = "CONFLICT"
+			}
+			result.Errors = append(result.Errors, copyErr)
+		} else {
+			result.Copied = append(result.Copied, domain.CopiedObject{
+				SourceKey:      t.source.Key,
+				DestinationKey: t.destination,
+				Size:           t.source.Size,
+				ETag:           etag,
+			})
+		}
+
+		copiedBytes += t.source.Size
+		if onProgress != nil {
+			onProgress(copiedBytes, totalBytes)
+		}
+	}
+
+	destinationKeys := make([]string, 0, len(result.Copied))
+	for _, c := range result.Copied {
+		destinationKeys = append(destinationKeys, c.DestinationKey)
+	}
+	s.invalidate(ctx, params.DestinationBucket, destinationKeys)
+
+	return result, nil
+}
+
+func (s *CopyService) copyOne(ctx context.Context, params serviceif.CopyParams, t copyTarget, onProgress serviceif.ProgressCallback) (string, error) {
+	if t.source.Size <= maxSingleCopySize {
+		return s.repo.CopyObject(ctx, params.SourceBucket, t.source.Key, params.DestinationBucket, t.destination, params.Overwrite)
+	}
+
+	// マルチパートコピーは完了時にしか競合を検出できないため、事前に存在確認しておく
+	if !params.Overwrite {
+		_, err := s.objectRepo.HeadObject(ctx, params.DestinationBucket, t.destination)
+		if err == nil {
+			return "", serviceif.ErrObjectAlreadyExists
+		}
+		if !errors.Is(err, serviceif.ErrObjectNotFound) {
+			return "", err
+		}
+	}
+
+	return s.repo.MultipartCopyObject(ctx, params.SourceBucket, t.source.Key, params.DestinationBucket, t.destination, t.source.Size, params.Overwrite, onProgress)
+}
+
+func (s *CopyService) invalidate(ctx context.Context, bucketName string, keys []string) {
+	s.listCache.InvalidateObjectKeys(bucketName, keys)
+	for _, key := range keys {
+		if _, err := s.cacheRepo.ClearByKey(ctx, bucketName, key); err != nil {
+			log.Printf("warning: failed to clear content cache: bucket=%s key=%s: %v", bucketName, key, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type DeleteService struct {
+	repo       serviceif.DeleteRepository
+	objectRepo serviceif.ObjectRepository
+	cacheRepo  serviceif.CacheRepository
+	listCache  serviceif.ListCacheRepository
+}
+
+func NewDeleteService(repo serviceif.DeleteRepository, objectRepo serviceif.ObjectRepository, cacheRepo serviceif.CacheRepository, listCache serviceif.ListCacheRepository) *DeleteService {
+	return &DeleteService{repo: repo, objectRepo: objectRepo, cacheRepo: cacheRepo, listCache: listCache}
+}
+
+func (s *DeleteService) DeleteObject(ctx context.Context, bucketName, key string) (*domain.DeleteObjectsResult, error) {
+	key = sanitizeObjectPath(key)
+	if key == "" {
+		return nil, errors.New("invalid key")
+	}
+
+	if err := s.repo.DeleteObject(ctx, bucketName, key); err != nil {
+		return nil, errors.Wrap(err, "failed to delete object")
+	}
+
+	s.invalidate(ctx, bucketName, []string{key})
+	log.Printf("deleted object: bucket=%s key=%s", bucketName, key)
+
+	return &domain.DeleteObjectsResult{
+		Deleted: []string{key},
+		Errors:  []domain.DeleteError{},
+	}, nil
+}
+
+// DeletePrefix は prefix 配下の全オブジェクトを再帰的に削除する。
+// prefix は必ず "/" で終わるフォルダとして扱い、"foo" 指定で "foo2/" が消えることを防ぐ。
+// 途中のバッチで失敗した場合も、それまでに削除できたキーと失敗したキーを err とともに返す。
+func (s *DeleteService) DeletePrefix(ctx context.Context, bucketName, prefix string) (*domain.DeleteObjectsResult, error) {
+	prefix = sanitizeObjectPath(prefix)
+	if prefix == "" {
+		return nil, errors.New("invalid prefix")
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+
+	objects, err := s.objectRepo.ListAllObjects(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects for deletion")
+	}
+
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+
+	result, err := s.repo.DeleteObjects(ctx, bucketName, keys)
+	// 途中のバッチで失敗しても、それまでに削除できたキーのキャッシュは無効化する
+	if result != nil {
+		s.invalidate(ctx, bucketName, result.Deleted)
+	}
+	if err != nil {
+		return result, errors.Wrap(err, "failed to delete objects")
+	}
+
+	log.Printf("deleted prefix: bucket=%s prefix=%s deleted=%d errors=%d", bucketName, prefix, len(result.Deleted), len(result.Errors))
+
+	return result, nil
+}
+
+func (s *DeleteService) invalidate(ctx context.Context, bucketName string, keys []string) {
+	s.listCache.InvalidateObjectKeys(bucketName, keys)
+	for _, key := range keys {
+		if _, err := s.cacheRepo.ClearByKey(ctx, bucketName, key); err != nil {
+			log.Printf("warning: failed to clear content cache: bucket=%s key=%s: %v", bucketName, key, err)
+		}
+	}
+}
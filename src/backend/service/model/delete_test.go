@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// fakeBatchDeleteRepo は failAfter 件を削除した後のバッチで失敗する
+type fakeBatchDeleteRepo struct {
+	serviceif.DeleteRepository
+	failAfter int
+}
+
+func (r *fakeBatchDeleteRepo) DeleteObjects(ctx context.Context, bucketName string, keys []string) (*domain.DeleteObjectsResult, error) {
+	result := &domain.DeleteObjectsResult{Deleted: keys[:r.failAfter], Errors: []domain.DeleteError{}}
+	return result, errors.New("connection reset")
+}
+
+type fakeClearCacheRepo struct {
+	serviceif.CacheRepository
+	cleared []string
+}
+
+func (r *fakeClearCacheRepo) ClearByKey(ctx context.Context, bucketName, objectKey string) (int64, error) {
+	r.cleared = append(r.cleared, objectKey)
+	return 1, nil
+}
+
+func TestDeletePrefix_ReturnsPartialResultOnError(t *testing.T) {
+	objects := &fakeObjectRepo{objects: map[string]domain.ObjectMetadata{
+		"dir/a.txt": {Key: "dir/a.txt"},
+		"dir/b.txt": {Key: "dir/b.txt"},
+		"dir/c.txt": {Key: "dir/c.txt"},
+	}}
+	cache := &fakeClearCacheRepo{}
+	listCache := &fakeListCache{}
+	s := NewDeleteService(&fakeBatchDeleteRepo{failAfter: 2}, objects, cache, listCache)
+
+	result, err := s.DeletePrefix(context.Background(), "bucket", "dir")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// 削除できたキーは結果に残し、キャッシュも破棄する
+	want := []string{"dir/a.txt", "dir/b.txt"}
+	if result == nil || !reflect.DeepEqual(result.Deleted, want) {
+		t.Fatalf("expected deleted keys %v, got %+v", want, result)
+	}
+	if !reflect.DeepEqual(cache.cleared, want) || !reflect.DeepEqual(listCache.invalidatedKeys, want) {
+		t.Errorf("expected caches of %v to be cleared, got cache=%v list=%v", want, cache.cleared, listCache.invalidatedKeys)
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"log"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// S3 のユーザー定義メタデータの合計サイズの上限（キーと値のバイト数の合計）
+const maxUserMetadataSize = 2 * 1024
+
+// ユーザー定義メタデータのキーは x-amz-meta- に続くヘッダ名になるため、英数字と "-" "_" に限る
+var metadataKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type MetadataService struct {
+	objectRepo serviceif.ObjectRepository
+	copyRepo   serviceif.CopyRepository
+	cacheRepo  serviceif.CacheRepository
+	listCache  serviceif.ListCacheRepository
+}
+
+func NewMetadataService(objectRepo serviceif.ObjectRepository, copyRepo serviceif.CopyRepository, cacheRepo serviceif.CacheRepository, listCache serviceif.ListCacheRepository) *MetadataService {
+	return &MetadataService{objectRepo: objectRepo, copyRepo: copyRepo, cacheRepo: cacheRepo, listCache: listCache}
+}
+
+func (s *MetadataService) GetMetadata(ctx context.Context, bucketName, key string) (*domain.ObjectMetadata, error) {
+	key = sanitizeObjectPath(key)
+	if key == "" {
+		return nil, errors.Wrap(serviceif.ErrInvalidMetadata, "invalid key")
+	}
+
+	return s.objectRepo.HeadObjectMetadata(ctx, bucketName, key)
+}
+
+// UpdateMetadata はオブジェクトを自身にコピーしてメタデータを書き換え、書き換え後のメタデータを返す。
+func (s *MetadataService) UpdateMetadata(ctx context.Context, bucketName, key string, update domain.ObjectMetadataUpdate) (*domain.ObjectMetadata, error) {
+	key = sanitizeObjectPath(key)
+	if key == "" {
+		return nil, errors.Wrap(serviceif.ErrInvalidMetadata, "invalid key")
+	}
+	if err := validateMetadataUpdate(&update); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.updateOne(ctx, bucketName, key, update); err != nil {
+		return nil, err
+	}
+	s.listCache.InvalidateObjectKeys(bucketName, []string{key})
+	log.Printf("updated object metadata: bucket=%s key=%s", bucketName, key)
+
+	return s.objectRepo.HeadObjectMetadata(ctx, bucketName, key)
+}
+
+// UpdatePrefixMetadata は prefix 配下の全オブジェクトのメタデータを書き換える。
+// prefix は DeletePrefix と同様に "/" で終わるフォルダとして扱う。失敗したオブジェクトは結果に含めて処理を続ける。
+func (s *MetadataService) UpdatePrefixMetadata(ctx context.Context, bucketName, prefix string, update domain.ObjectMetadataUpdate) (*domain.UpdateMetadataResult, error) {
+	prefix = sanitizeObjectPath(prefix)
+	if prefix == "" {
+		return nil, errors.Wrap(serviceif.ErrInvalidMetadata, "invalid prefix")
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	if err := validateMetadataUpdate(&update); err != nil {
+		return nil, err
+	}
+
+	objects, err := s.objectRepo.ListAllObjects(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects for metadata update")
+	}
+
+	result := &domain.UpdateMetadataResult{
+		Updated: make([]string, 0, len(objects)),
+		Errors:  []domain.MetadataError{},
+	}
+	for _, obj := range objects {
+		// フォルダを表す空のオブジェクトは対象外
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if _, err := s.updateOne(ctx, bucketName, obj.Key, update); err != nil {
+			metaErr := domain.MetadataError{Key: obj.Key, Message: err.Error()}
+			if errors.Is(err, serviceif.ErrObjectModified) {
+				metaErr.Code = "CONFLICT"
+			}
+			result.Errors = append(result.Errors, metaErr)
+			continue
+		}
+		result.Updated = append(result.Updated, obj.Key)
+	}
+
+	s.listCache.InvalidateObjectKeys(bucketName, result.Updated)
+	log.Printf("updated object metadata: bucket=%s prefix=%s updated=%d errors=%d", bucketName, prefix, len(result.Updated), len(result.Errors))
+
+	return result, nil
+}
+
+// updateOne は現在のメタデータに変更を適用して書き換え、キャッシュ済みのエントリにも反映する。
+func (s *MetadataService) updateOne(ctx context.Context, bucketName, key string, update domain.ObjectMetadataUpdate) (string, error) {
+	meta, err := s.objectRepo.HeadObjectMetadata(ctx, bucketName, key)
+	if err != nil {
+		return "", err
+	}
+	applyMetadataUpdate(meta, update)
+
+	etag, err := s.copyRepo.ReplaceMetadata(ctx, bucketName, *meta)
+	if err != nil {
+		return "", err
+	}
+
+	// 本文は変わらないため、書き換え前と同じ版のキャッシュは破棄せず Content-Type と ETag だけ書き換える
+	if _, err := s.cacheRepo.UpdateMetadata(ctx, bucketName, key, meta.ContentType, meta.ETag, etag); err != nil {
+		log.Printf("warning: failed to update content cache metadata, clearing entry: bucket=%s key=%s: %v", bucketName, key, err)
+		if _, err := s.cacheRepo.ClearByKey(ctx, bucketName, key); err != nil {
+			log.Printf("warning: failed to clear content cache: bucket=%s key=%s: %v", bucketName, key, err)
+		}
+	}
+
+	return etag, nil
+}
+
+func applyMetadataUpdate(meta *domain.ObjectMetadata, update domain.ObjectMetadataUpdate) {
+	if update.ContentType != nil {
+		meta.ContentType = *update.ContentType
+	}
+	if update.CacheControl != nil {
+		meta.CacheControl = *update.CacheControl
+	}
+	if update.ContentDisposition != nil {
+		meta.ContentDisposition = *update.ContentDisposition
+	}
+	if update.ContentEncoding != nil {
+		meta.ContentEncoding = *update.ContentEncoding
+	}
+	if update.Metadata != nil {
+		meta.Metadata = update.Metadata
+	}
+}
+
+// validateMetadataUpdate は変更内容を検証し、前後の空白を取り除いてユーザー定義メタデータのキーを小文字にそろえる。
+func validateMetadataUpdate(update *domain.ObjectMetadataUpdate) error {
+	if update.ContentType == nil && update.CacheControl == nil && update.ContentDisposition == nil && update.ContentEncoding == nil && update.Metadata == nil {
+		return errors.Wrap(serviceif.ErrInvalidMetadata, "no changes specified")
+	}
+
+	for name, field := range map[string]*string{
+		"content_type":        update.ContentType,
+		"cache_control":       update.CacheControl,
+		"content_disposition": update.ContentDisposition,
+		"content_encoding":    update.ContentEncoding,
+	} {
+		if field == nil {
+			continue
+		}
+		*field = strings.TrimSpace(*field)
+		if !isHeaderValue(*field) {
+			return errors.Wrapf(serviceif.ErrInvalidMetadata, "%s contains invalid characters", name)
+		}
+	}
+	if update.ContentType != nil && *update.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(*update.ContentType); err != nil {
+			return errors.Wrapf(serviceif.ErrInvalidMetadata, "invalid content_type %q", *update.ContentType)
+		}
+	}
+
+	if update.Metadata == nil {
+		return nil
+	}
+	metadata := make(map[string]string, len(update.Metadata))
+	size := 0
+	for k, v := range update.Metadata {
+		k = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(k)), "x-amz-meta-")
+		v = strings.TrimSpace(v)
+		if !metadataKeyPattern.MatchString(k) {
+			return errors.Wrapf(serviceif.ErrInvalidMetadata, "invalid metadata key %q", k)
+		}
+		if !isHeaderValue(v) {
+			return errors.Wrapf(serviceif.ErrInvalidMetadata, "metadata %q contains invalid characters", k)
+		}
+		if _, dup := metadata[k]; dup {
+			return errors.Wrapf(serviceif.ErrInvalidMetadata, "duplicate metadata key %q", k)
+		}
+		metadata[k] = v
+		size += len(k) + len(v)
+	}
+	if size > maxUserMetadataSize {
+		return errors.Wrapf(serviceif.ErrInvalidMetadata, "metadata exceeds %d bytes", maxUserMetadataSize)
+	}
+	update.Metadata = metadata
+
+	return nil
+}
+
+// isHeaderValue は HTTP ヘッダの値としてそのまま送れる、制御文字を含まない ASCII 文字列かを返す。
+func isHeaderValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
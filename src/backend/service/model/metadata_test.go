@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// fakeObjectRepo はメモリ上のオブジェクトのメタデータを返す。使わないメソッドは埋め込んだ nil のインターフェースに任せる
+type fakeObjectRepo struct {
+	serviceif.ObjectRepository
+	objects map[string]domain.ObjectMetadata
+}
+
+func (r *fakeObjectRepo) HeadObjectMetadata(ctx context.Context, bucketName, key string) (*domain.ObjectMetadata, error) {
+	meta, ok := r.objects[key]
+	if !ok {
+		return nil, serviceif.ErrObjectNotFound
+	}
+	return &meta, nil
+}
+
+func (r *fakeObjectRepo) ListAllObjects(ctx context.Context, bucketName, prefix string) ([]domain.Object, error) {
+	var objects []domain.Object
+	for key, meta := range r.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, domain.Object{Key: key, Size: meta.Size, ETag: meta.ETag})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// GetObjects は MaxKeys 件ずつ、継続トークンに最後のキーを入れたページを返す
+func (r *fakeObjectRepo) GetObjects(ctx context.Context, bucketName string, params serviceif.ListObjectsParams) (*domain.ListObjectsResult, error) {
+	all, _ := r.ListAllObjects(ctx, bucketName, params.Prefix)
+	start := sort.Search(len(all), func(i int) bool { return all[i].Key > params.ContinuationToken })
+	objects := all[start:]
+	result := &domain.ListObjectsResult{Prefix: params.Prefix}
+	if params.MaxKeys > 0 && len(objects) > int(params.MaxKeys) {
+		objects = objects[:params.MaxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = objects[len(objects)-1].Key
+	}
+	result.Objects = objects
+	return result, nil
+}
+
+type fakeCopyRepo struct {
+	serviceif.CopyRepository
+	objects *fakeObjectRepo
+	// modified のキーは書き換え中に変更されたものとして ErrObjectModified を返す
+	modified map[string]bool
+	version  int
+}
+
+func (r *fakeCopyRepo) ReplaceMetadata(ctx context.Context, bucketName string, meta domain.ObjectMetadata) (string, error) {
+	if r.modified[meta.Key] {
+		return "", serviceif.ErrObjectModified
+	}
+	r.version++
+	meta.ETag = fmt.Sprintf(`"v%d"`, r.version)
+	r.objects.objects[meta.Key] = meta
+	return meta.ETag, nil
+}
+
+type metadataCacheUpdate struct {
+	key, contentType, oldETag, newETag string
+}
+
+type fakeCacheRepo struct {
+	serviceif.CacheRepository
+	updates []metadataCacheUpdate
+}
+
+func (r *fakeCacheRepo) UpdateMetadata(ctx context.Context, bucketName, objectKey, contentType, oldETag, newETag string) (int64, error) {
+	r.updates = append(r.updates, metadataCacheUpdate{key: objectKey, contentType: contentType, oldETag: oldETag, newETag: newETag})
+	return 1, nil
+}
+
+type fakeListCache struct {
+	serviceif.ListCacheRepository
+	invalidatedKeys []string
+}
+
+func (c *fakeListCache) InvalidateObjectKeys(bucketName string, keys []string) {
+	c.invalidatedKeys = append(c.invalidatedKeys, keys...)
+}
+
+func newTestMetadataService(objects map[string]domain.ObjectMetadata) (*MetadataService, *fakeCopyRepo, *fakeCacheRepo, *fakeListCache) {
+	objectRepo := &fakeObjectRepo{objects: objects}
+	copyRepo := &fakeCopyRepo{objects: objectRepo, modified: map[string]bool{}}
+	cacheRepo := &fakeCacheRepo{}
+	listCache := &fakeListCache{}
+	return NewMetadataService(objectRepo, copyRepo, cacheRepo, listCache), copyRepo, cacheRepo, listCache
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestValidateMetadataUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  domain.ObjectMetadataUpdate
+		wantErr bool
+	}{
+		{name: "no changes", update: domain.ObjectMetadataUpdate{}, wantErr: true},
+		{name: "content type", update: domain.ObjectMetadataUpdate{ContentType: strPtr("image/png")}},
+		{name: "clear content type", update: domain.ObjectMetadataUpdate{ContentType: strPtr("")}},
+		{name: "invalid content type", update: domain.ObjectMetadataUpdate{ContentType: strPtr("image/png; =")}, wantErr: true},
+		{name: "control characters", update: domain.ObjectMetadataUpdate{CacheControl: strPtr("max-age=60\r\nX-Injected: 1")}, wantErr: true},
+		{name: "non ascii", update: domain.ObjectMetadataUpdate{ContentDisposition: strPtr("attachment; filename=\"画像.png\"")}, wantErr: true},
+		{name: "empty metadata clears", update: domain.ObjectMetadataUpdate{Metadata: map[string]string{}}},
+		{name: "invalid metadata key", update: domain.ObjectMetadataUpdate{Metadata: map[string]string{"bad key": "v"}}, wantErr: true},
+		{name: "duplicate metadata key", update: domain.ObjectMetadataUpdate{Metadata: map[string]string{"Owner": "a", "x-amz-meta-owner": "b"}}, wantErr: true},
+		{name: "metadata too large", update: domain.ObjectMetadataUpdate{Metadata: map[string]string{"k": strings.Repeat("v", maxUserMetadataSize)}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMetadataUpdate(&tt.update)
+			if tt.wantErr {
+				if !errors.Is(err, serviceif.ErrInvalidMetadata) {
+					t.Errorf("expected ErrInvalidMetadata, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateMetadataUpdate_Normalizes(t *testing.T) {
+	update := domain.ObjectMetadataUpdate{
+		ContentType: strPtr("  text/plain  "),
+		Metadata:    map[string]string{" X-Amz-Meta-Owner ": " alice ", "Team": "web"},
+	}
+	if err := validateMetadataUpdate(&update); err != nil {
+		t.Fatalf("validateMetadataUpdate: %v", err)
+	}
+	if *update.ContentType != "text/plain" {
+		t.Errorf("expected trimmed content type, got %q", *update.ContentType)
+	}
+	want := map[string]string{"owner": "alice", "team": "web"}
+	if !reflect.DeepEqual(update.Metadata, want) {
+		t.Errorf("expected metadata %v, got %v", want, update.Metadata)
+	}
+}
+
+func TestApplyMetadataUpdate(t *testing.T) {
+	base := domain.ObjectMetadata{
+		Key:          "a.txt",
+		ContentType:  "text/plain",
+		CacheControl: "max-age=60",
+		Metadata:     map[string]string{"owner": "alice"},
+	}
+
+	// nil の項目は現在の値を引き継ぐ
+	meta := base
+	applyMetadataUpdate(&meta, domain.ObjectMetadataUpdate{ContentDisposition: strPtr("attachment")})
+	if meta.ContentType != "text/plain" || meta.CacheControl != "max-age=60" || meta.ContentDisposition != "attachment" || meta.Metadata["owner"] != "alice" {
+		t.Errorf("unexpected metadata after partial update: %+v", meta)
+	}
+
+	// 空文字列は削除、Metadata は丸ごと置き換える
+	meta = base
+	applyMetadataUpdate(&meta, domain.ObjectMetadataUpdate{CacheControl: strPtr(""), Metadata: map[string]string{"team": "web"}})
+	if meta.CacheControl != "" {
+		t.Errorf("expected cache control to be cleared, got %q", meta.CacheControl)
+	}
+	if !reflect.DeepEqual(meta.Metadata, map[string]string{"team": "web"}) {
+		t.Errorf("expected metadata to be replaced, got %v", meta.Metadata)
+	}
+}
+
+func TestUpdateMetadata_RejectsInvalidKey(t *testing.T) {
+	s, _, _, _ := newTestMetadataService(map[string]domain.ObjectMetadata{})
+
+	if _, err := s.UpdateMetadata(context.Background(), "bucket", "/", domain.ObjectMetadataUpdate{ContentType: strPtr("text/plain")}); !errors.Is(err, serviceif.ErrInvalidMetadata) {
+		t.Errorf("expected ErrInvalidMetadata for an empty key, got %v", err)
+	}
+	if _, err := s.UpdatePrefixMetadata(context.Background(), "bucket", "", domain.ObjectMetadataUpdate{ContentType: strPtr("text/plain")}); !errors.Is(err, serviceif.ErrInvalidMetadata) {
+		t.Errorf("expected ErrInvalidMetadata for an empty prefix, got %v", err)
+	}
+}
+
+func TestUpdatePrefixMetadata(t *testing.T) {
+	s, copyRepo, cacheRepo, listCache := newTestMetadataService(map[string]domain.ObjectMetadata{
+		"docs/":              {Key: "docs/", ContentType: "application/x-directory", ETag: `"dir"`},
+		"docs/a.txt":         {Key: "docs/a.txt", ContentType: "text/plain", ETag: `"a"`},
+		"docs/sub/":          {Key: "docs/sub/", ContentType: "application/x-directory", ETag: `"subdir"`},
+		"docs/sub/b.txt":     {Key: "docs/sub/b.txt", ContentType: "text/plain", ETag: `"b"`},
+		"docs/sub/c.txt":     {Key: "docs/sub/c.txt", ContentType: "text/plain", ETag: `"c"`},
+		"docs-other/d.txt":   {Key: "docs-other/d.txt", ContentType: "text/plain", ETag: `"d"`},
+		"unrelated/docs.txt": {Key: "unrelated/docs.txt", ContentType: "text/plain", ETag: `"e"`},
+	})
+	copyRepo.modified["docs/sub/b.txt"] = true
+
+	result, err := s.UpdatePrefixMetadata(context.Background(), "bucket", "docs", domain.ObjectMetadataUpdate{CacheControl: strPtr("no-cache")})
+	if err != nil {
+		t.Fatalf("UpdatePrefixMetadata: %v", err)
+	}
+
+	// フォルダマーカーは対象外で、書き換え中に変更されたオブジェクトは結果に含めて続ける
+	if want := []string{"docs/a.txt", "docs/sub/c.txt"}; !reflect.DeepEqual(result.Updated, want) {
+		t.Errorf("expected updated %v, got %v", want, result.Updated)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Key != "docs/sub/b.txt" || result.Errors[0].Code != "CONFLICT" {
+		t.Errorf("expected a conflict for docs/sub/b.txt, got %+v", result.Errors)
+	}
+	if got := copyRepo.objects.objects["docs/a.txt"].CacheControl; got != "no-cache" {
+		t.Errorf("expected cache control to be updated, got %q", got)
+	}
+	if got := copyRepo.objects.objects["docs-other/d.txt"].CacheControl; got != "" {
+		t.Errorf("expected objects outside the prefix to be untouched, got %q", got)
+	}
+
+	// キャッシュは書き換え前の ETag を条件に新しい ETag に置き換える
+	if len(cacheRepo.updates) != 2 || cacheRepo.updates[0].key != "docs/a.txt" || cacheRepo.updates[0].oldETag != `"a"` || cacheRepo.updates[0].newETag == `"a"` {
+		t.Errorf("unexpected cache updates: %+v", cacheRepo.updates)
+	}
+	if !reflect.DeepEqual(listCache.invalidatedKeys, result.Updated) {
+		t.Errorf("expected list cache invalidation for %v, got %v", result.Updated, listCache.invalidatedKeys)
+	}
+}
@@ -2,12 +2,17 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log"
 
 	"r2manager/domain"
+	"r2manager/health"
 	serviceif "r2manager/service/interface"
 )
 
+// all=true 指定時にサーバー側で取得するオブジェクト数の上限
+const maxListAllObjects = 10000
+
 type ObjectService struct {
 	repo      serviceif.ObjectRepository
 	cacheRepo serviceif.CacheRepository
@@ -20,9 +25,15 @@ func NewObjectService(repo serviceif.ObjectRepository, cacheRepo serviceif.Cache
 
 func (s *ObjectService) GetObjects(ctx context.Context, bucketName string, params serviceif.ListObjectsParams) (*domain.ListObjectsResult, error) {
 	cacheKey := bucketName + ":" + params.Prefix
+	if params.Delimiter != "" {
+		cacheKey = fmt.Sprintf("%s (delimiter=%q)", cacheKey, params.Delimiter)
+	}
+	if params.ContinuationToken != "" || params.StartAfter != "" || params.MaxKeys > 0 || params.All {
+		cacheKey = fmt.Sprintf("%s (token=%q start_after=%q max_keys=%d all=%t)", cacheKey, params.ContinuationToken, params.StartAfter, params.MaxKeys, params.All)
+	}
 
 	// Check list cache first
-	if result, found := s.listCache.GetObjects(bucketName, params.Prefix); found {
+	if result, found := s.listCache.GetObjects(bucketName, params); found {
 		log.Printf("cache hit: objects [%s] (%d items)", cacheKey, len(result.Objects))
 		return result, nil
 	}
@@ -30,13 +41,28 @@ func (s *ObjectService) GetObjects(ctx context.Context, bucketName string, param
 	log.Printf("cache miss: objects [%s]", cacheKey)
 
 	// Fetch from R2
-	result, err := s.repo.GetObjects(ctx, bucketName, params)
+	var result *domain.ListObjectsResult
+	var err error
+	if params.All {
+		result, err = s.getAllObjects(ctx, bucketName, params)
+	} else {
+		result, err = s.repo.GetObjects(ctx, bucketName, params)
+	}
 	if err != nil {
+		// R2 に接続できない場合は最後に取得できた一覧を返す
+		if health.IsUpstreamFailure(err) {
+			if last, found := s.listCache.GetLastKnownObjects(bucketName, params); found {
+				log.Printf("upstream unavailable, serving last known objects [%s] (%d items): %v", cacheKey, len(last.Objects), err)
+				stale := *last
+				stale.Stale = true
+				return &stale, nil
+			}
+		}
 		return nil, err
 	}
 
 	// Store in list cache
-	s.listCache.SetObjects(bucketName, params.Prefix, result)
+	s.listCache.SetObjects(bucketName, params, result)
 	log.Printf("cache stored: objects [%s] (%d items)", cacheKey, len(result.Objects))
 
 	// Build ETag map and invalidate stale content cache entries
@@ -58,3 +84,34 @@ func (s *ObjectService) GetObjects(ctx context.Context, bucketName string, param
 
 	return result, nil
 }
+
+// getAllObjects は継続トークンを辿って一覧を結合する。maxListAllObjects 件に達した場合は打ち切り、
+// IsTruncated と NextContinuationToken で続きを取得できるようにする。
+func (s *ObjectService) getAllObjects(ctx context.Context, bucketName string, params serviceif.ListObjectsParams) (*domain.ListObjectsResult, error) {
+	pageParams := params
+	pageParams.All = false
+
+	combined := &domain.ListObjectsResult{
+		Objects:   []domain.Object{},
+		Prefix:    params.Prefix,
+		Delimiter: params.Delimiter,
+	}
+
+	for {
+		page, err := s.repo.GetObjects(ctx, bucketName, pageParams)
+		if err != nil {
+			return nil, err
+		}
+		combined.Objects = append(combined.Objects, page.Objects...)
+		combined.IsTruncated = page.IsTruncated
+		combined.NextContinuationToken = page.NextContinuationToken
+
+		if !page.IsTruncated || page.NextContinuationToken == "" || len(combined.Objects) >= maxListAllObjects {
+			break
+		}
+		pageParams.ContinuationToken = page.NextContinuationToken
+		pageParams.StartAfter = ""
+	}
+
+	return combined, nil
+}
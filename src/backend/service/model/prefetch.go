@@ -0,0 +1,465 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// 終了したジョブの状態を保持する期間
+const prefetchJobRetention = time.Hour
+
+type PrefetchService struct {
+	objectRepo  serviceif.ObjectRepository
+	contentRepo serviceif.ContentRepository
+	cacheRepo   serviceif.PrefetchCacheRepository
+	policies    serviceif.BucketCachePolicyRepository
+	concurrency int
+	jobs        *PrefetchJobs
+}
+
+// PrefetchJobs は実行中・終了したプリフェッチジョブを保持する。
+// 接続設定の再読み込みでサービスを作り直しても実行中のジョブを参照・中断できるよう、サービスの外で作成して渡す。
+type PrefetchJobs struct {
+	// slot は同時に実行するジョブを 1 つに制限する。後続のジョブは queued のまま待つ
+	slot chan struct{}
+
+	mu   sync.Mutex
+	byID map[string]*prefetchJob
+
+	// running は実行中のジョブのゴルーチンを数える
+	running sync.WaitGroup
+}
+
+func NewPrefetchJobs() *PrefetchJobs {
+	return &PrefetchJobs{
+		slot: make(chan struct{}, 1),
+		byID: make(map[string]*prefetchJob),
+	}
+}
+
+// CancelAll は実行中と待機中のすべてのジョブを中断する。
+func (p *PrefetchJobs) CancelAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, j := range p.byID {
+		j.cancel()
+	}
+}
+
+// Wait は実行中のジョブがすべて終わるまで待つ。
+func (p *PrefetchJobs) Wait() {
+	p.running.Wait()
+}
+
+type PrefetchOption func(*PrefetchService)
+
+// WithPrefetchJobs は jobs でジョブを保持するよう設定する。指定しない場合はサービスごとに作成する。
+func WithPrefetchJobs(jobs *PrefetchJobs) PrefetchOption {
+	return func(s *PrefetchService) {
+		s.jobs = jobs
+	}
+}
+
+type prefetchJob struct {
+	mu       sync.Mutex
+	job      domain.PrefetchJob
+	cancel   context.CancelFunc
+	onUpdate serviceif.PrefetchUpdateCallback
+}
+
+func NewPrefetchService(objectRepo serviceif.ObjectRepository, contentRepo serviceif.ContentRepository, cacheRepo serviceif.PrefetchCacheRepository, policies serviceif.BucketCachePolicyRepository, concurrency int, opts ...PrefetchOption) *PrefetchService {
+	s := &PrefetchService{
+		objectRepo:  objectRepo,
+		contentRepo: contentRepo,
+		cacheRepo:   cacheRepo,
+		policies:    policies,
+		concurrency: max(concurrency, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.jobs == nil {
+		s.jobs = NewPrefetchJobs()
+	}
+	return s
+}
+
+// Enqueue はプリフェッチジョブを登録し、バックグラウンドで実行する。
+func (s *PrefetchService) Enqueue(params serviceif.PrefetchParams, onUpdate serviceif.PrefetchUpdateCallback) (*domain.PrefetchJob, error) {
+	if params.BucketName == "" {
+		return nil, errors.Wrap(serviceif.ErrInvalidPrefetchRequest, "bucket is required")
+	}
+	if params.MaxObjectSize < 0 || params.MaxCount < 0 {
+		return nil, errors.Wrap(serviceif.ErrInvalidPrefetchRequest, "max_object_size and max_count must not be negative")
+	}
+
+	id, err := newPrefetchJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &prefetchJob{
+		job: domain.PrefetchJob{
+			ID:            id,
+			BucketName:    params.BucketName,
+			Prefix:        params.Prefix,
+			ContentTypes:  params.ContentTypes,
+			MaxObjectSize: params.MaxObjectSize,
+			MaxCount:      params.MaxCount,
+			Status:        domain.PrefetchQueued,
+			CreatedAt:     time.Now().UTC(),
+		},
+		cancel:   cancel,
+		onUpdate: onUpdate,
+	}
+
+	s.jobs.mu.Lock()
+	s.jobs.prune()
+	s.jobs.byID[id] = j
+	s.jobs.mu.Unlock()
+
+	log.Printf("prefetch job queued: id=%s bucket=%s prefix=%s", id, params.BucketName, params.Prefix)
+	snapshot := j.update(func(*domain.PrefetchJob) {})
+
+	s.jobs.running.Add(1)
+	go s.run(ctx, j)
+
+	return &snapshot, nil
+}
+
+func (s *PrefetchService) GetJob(id string) (*domain.PrefetchJob, error) {
+	s.jobs.mu.Lock()
+	j, ok := s.jobs.byID[id]
+	s.jobs.mu.Unlock()
+	if !ok {
+		return nil, serviceif.ErrPrefetchJobNotFound
+	}
+
+	snapshot := j.snapshot()
+	return &snapshot, nil
+}
+
+// ListJobs は保持しているジョブを作成順に返す。
+func (s *PrefetchService) ListJobs() []domain.PrefetchJob {
+	s.jobs.mu.Lock()
+	jobs := make([]domain.PrefetchJob, 0, len(s.jobs.byID))
+	for _, j := range s.jobs.byID {
+		jobs = append(jobs, j.snapshot())
+	}
+	s.jobs.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].CreatedAt.Before(jobs[b].CreatedAt) })
+	return jobs
+}
+
+// Cancel は実行中または待機中のジョブを中断する。取り込み済みのキャッシュはそのまま残る。
+func (s *PrefetchService) Cancel(id string) error {
+	s.jobs.mu.Lock()
+	j, ok := s.jobs.byID[id]
+	s.jobs.mu.Unlock()
+	if !ok {
+		return serviceif.ErrPrefetchJobNotFound
+	}
+
+	j.cancel()
+	return nil
+}
+
+// prune は保持期間を過ぎた終了済みのジョブを削除する。p.mu を保持した状態で呼ぶ。
+func (p *PrefetchJobs) prune() {
+	now := time.Now()
+	for id, j := range p.byID {
+		snapshot := j.snapshot()
+		if snapshot.FinishedAt != nil && now.Sub(*snapshot.FinishedAt) > prefetchJobRetention {
+			delete(p.byID, id)
+		}
+	}
+}
+
+func (s *PrefetchService) run(ctx context.Context, j *prefetchJob) {
+	defer s.jobs.running.Done()
+	defer j.cancel()
+
+	select {
+	case s.jobs.slot <- struct{}{}:
+		defer func() { <-s.jobs.slot }()
+	case <-ctx.Done():
+		j.finish(ctx, nil)
+		return
+	}
+
+	j.update(func(job *domain.PrefetchJob) {
+		now := time.Now().UTC()
+		job.Status = domain.PrefetchRunning
+		job.StartedAt = &now
+	})
+
+	err := s.prefetch(ctx, j)
+	j.finish(ctx, err)
+}
+
+// prefetch は一覧を辿り、キャッシュの空き容量の範囲でオブジェクトを取り込む。
+func (s *PrefetchService) prefetch(ctx context.Context, j *prefetchJob) error {
+	params := j.snapshot()
+
+	policy, err := s.policies.GetBucketCachePolicy(ctx, params.BucketName)
+	if err != nil {
+		return err
+	}
+	if policy != nil && policy.NeverCache {
+		return errors.New("bucket is configured not to cache content")
+	}
+
+	objects, err := s.objectRepo.ListAllObjects(ctx, params.BucketName, params.Prefix)
+	if err != nil {
+		return errors.Wrap(err, "failed to list objects")
+	}
+
+	var candidates []domain.Object
+	for _, obj := range objects {
+		// フォルダマーカーは取り込まない
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		candidates = append(candidates, obj)
+	}
+	j.update(func(job *domain.PrefetchJob) { job.Total = len(candidates) })
+
+	capacity, err := s.newPrefetchCapacity(ctx, params.BucketName, policy)
+	if err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+	for _, obj := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if params.MaxObjectSize > 0 && obj.Size > params.MaxObjectSize {
+			j.update(func(job *domain.PrefetchJob) { job.Skipped++ })
+			continue
+		}
+		// 取り込み済みと取り込み中の数が上限に達したら残りは取り込まない
+		if params.MaxCount > 0 && capacity.taken() >= params.MaxCount {
+			j.update(func(job *domain.PrefetchJob) { job.Skipped++ })
+			continue
+		}
+		if !capacity.reserve(obj.Size) {
+			j.update(func(job *domain.PrefetchJob) { job.Skipped++ })
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			capacity.release(obj.Size, false, false)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.prefetchObject(ctx, j, params, policy, obj, capacity)
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (s *PrefetchService) prefetchObject(ctx context.Context, j *prefetchJob, params domain.PrefetchJob, policy *domain.BucketCachePolicy, obj domain.Object, capacity *prefetchCapacity) {
+	stored, cached := false, false
+	defer func() { capacity.release(obj.Size, stored || cached, stored) }()
+
+	entry, err := s.cacheRepo.LookupStale(ctx, params.BucketName, obj.Key)
+	if err == nil && entry != nil && entry.ETag == obj.ETag && time.Now().Before(entry.ExpiresAt) {
+		cached = true
+		j.update(func(job *domain.PrefetchJob) { job.Cached++ })
+		return
+	}
+
+	allows := func(contentType string) bool {
+		return (len(params.ContentTypes) == 0 || domain.MatchContentType(params.ContentTypes, contentType)) && policy.AllowsContentType(contentType)
+	}
+	// 一覧には Content-Type が含まれないため、絞り込む場合は取得する前に HeadObject で判定する
+	if len(params.ContentTypes) > 0 || policy.FiltersContentType() {
+		head, err := s.objectRepo.HeadObjectMetadata(ctx, params.BucketName, obj.Key)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("warning: prefetch failed: bucket=%s key=%s: %v", params.BucketName, obj.Key, err)
+				j.update(func(job *domain.PrefetchJob) { job.Failed++ })
+			}
+			return
+		}
+		if !allows(head.ContentType) {
+			j.update(func(job *domain.PrefetchJob) { job.Skipped++ })
+			return
+		}
+	}
+
+	content, err := s.contentRepo.GetContent(ctx, params.BucketName, obj.Key)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("warning: prefetch failed: bucket=%s key=%s: %v", params.BucketName, obj.Key, err)
+			j.update(func(job *domain.PrefetchJob) { job.Failed++ })
+		}
+		return
+	}
+	defer content.Body.Close()
+
+	// HeadObject の後に書き換えられて Content-Type が変わった場合に備え、ボディを読む前にも判定する
+	if !allows(content.ContentType) {
+		j.update(func(job *domain.PrefetchJob) { job.Skipped++ })
+		return
+	}
+
+	if _, err := s.cacheRepo.Store(ctx, params.BucketName, obj.Key, content.Body, content.ContentType, content.Size, content.ETag); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("warning: prefetch failed to store cache: bucket=%s key=%s: %v", params.BucketName, obj.Key, err)
+			j.update(func(job *domain.PrefetchJob) { job.Failed++ })
+		}
+		return
+	}
+
+	stored = true
+	j.update(func(job *domain.PrefetchJob) {
+		job.Fetched++
+		job.BytesFetched += content.Size
+	})
+}
+
+// prefetchCapacity はキャッシュの空き容量と取り込み数を、並行する取得の間で予約しながら管理する。
+type prefetchCapacity struct {
+	mu sync.Mutex
+	// free は全体とバケットの上限のうち小さい方の空き容量。-1 の場合は制限なし
+	free     int64
+	inFlight int
+	done     int
+}
+
+func (s *PrefetchService) newPrefetchCapacity(ctx context.Context, bucketName string, policy *domain.BucketCachePolicy) (*prefetchCapacity, error) {
+	stats, err := s.cacheRepo.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	free := int64(-1)
+	if stats.MaxSize > 0 {
+		free = max(stats.MaxSize-stats.TotalSize, 0)
+	}
+	if policy != nil && policy.MaxSize > 0 && !policy.Pinned {
+		var bucketSize int64
+		for _, b := range stats.Buckets {
+			if b.BucketName == bucketName {
+				bucketSize = b.TotalSize
+			}
+		}
+		bucketFree := max(policy.MaxSize-bucketSize, 0)
+		if free < 0 || bucketFree < free {
+			free = bucketFree
+		}
+	}
+
+	return &prefetchCapacity{free: free}, nil
+}
+
+// reserve は size 分の空き容量を予約する。入りきらない場合は false を返す。
+func (c *prefetchCapacity) reserve(size int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.free >= 0 {
+		if size > c.free {
+			return false
+		}
+		c.free -= size
+	}
+	c.inFlight++
+	return true
+}
+
+// release は取得の終了時に呼ぶ。counted は取り込み数に数えるか、stored は新たに容量を使ったかを表し、
+// 容量を使わなかった場合は予約した分を戻す。
+func (c *prefetchCapacity) release(size int64, counted, stored bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	if counted {
+		c.done++
+	}
+	if !stored && c.free >= 0 {
+		c.free += size
+	}
+}
+
+func (c *prefetchCapacity) taken() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done + c.inFlight
+}
+
+// update はジョブの状態を変更し、変更後の写しをコールバックに渡す。
+func (j *prefetchJob) update(fn func(job *domain.PrefetchJob)) domain.PrefetchJob {
+	j.mu.Lock()
+	fn(&j.job)
+	snapshot := j.copyLocked()
+	j.mu.Unlock()
+
+	if j.onUpdate != nil {
+		j.onUpdate(snapshot)
+	}
+	return snapshot
+}
+
+func (j *prefetchJob) snapshot() domain.PrefetchJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.copyLocked()
+}
+
+func (j *prefetchJob) copyLocked() domain.PrefetchJob {
+	snapshot := j.job
+	snapshot.ContentTypes = append([]string(nil), j.job.ContentTypes...)
+	return snapshot
+}
+
+func (j *prefetchJob) finish(ctx context.Context, err error) {
+	snapshot := j.update(func(job *domain.PrefetchJob) {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		switch {
+		case ctx.Err() != nil:
+			job.Status = domain.PrefetchCanceled
+		case err != nil:
+			job.Status = domain.PrefetchFailed
+			job.Error = err.Error()
+		default:
+			job.Status = domain.PrefetchCompleted
+		}
+	})
+
+	log.Printf("prefetch job %s: id=%s bucket=%s prefix=%s fetched=%d cached=%d skipped=%d failed=%d bytes=%d",
+		snapshot.Status, snapshot.ID, snapshot.BucketName, snapshot.Prefix,
+		snapshot.Fetched, snapshot.Cached, snapshot.Skipped, snapshot.Failed, snapshot.BytesFetched)
+}
+
+func newPrefetchJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate prefetch job id")
+	}
+	return hex.EncodeToString(b), nil
+}
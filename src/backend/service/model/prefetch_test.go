@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type fakeContentRepo struct {
+	serviceif.ContentRepository
+	objects *fakeObjectRepo
+	// block が設定されている場合、GetContent は started に通知してからコンテキストが終わるまで待つ
+	block   bool
+	started chan string
+
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (r *fakeContentRepo) GetContent(ctx context.Context, bucketName, objectKey string) (*domain.ObjectContent, error) {
+	r.mu.Lock()
+	r.fetched = append(r.fetched, objectKey)
+	r.mu.Unlock()
+
+	if r.block {
+		r.started <- objectKey
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	meta := r.objects.objects[objectKey]
+	body := strings.Repeat("x", int(meta.Size))
+	return &domain.ObjectContent{Body: io.NopCloser(strings.NewReader(body)), ContentType: meta.ContentType, Size: meta.Size, ETag: meta.ETag}, nil
+}
+
+func (r *fakeContentRepo) fetchedKeys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.fetched...)
+}
+
+type fakePrefetchCache struct {
+	maxSize int64
+
+	mu     sync.Mutex
+	stored []string
+}
+
+func (c *fakePrefetchCache) LookupStale(ctx context.Context, bucketName, objectKey string) (*domain.CacheEntry, error) {
+	return nil, nil
+}
+
+func (c *fakePrefetchCache) Store(ctx context.Context, bucketName, objectKey string, body io.Reader, contentType string, size int64, etag string) (*domain.CacheEntry, error) {
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.stored = append(c.stored, objectKey)
+	c.mu.Unlock()
+	return &domain.CacheEntry{BucketName: bucketName, ObjectKey: objectKey, Size: size, ETag: etag}, nil
+}
+
+func (c *fakePrefetchCache) Stats(ctx context.Context) (*domain.ContentCacheStats, error) {
+	return &domain.ContentCacheStats{MaxSize: c.maxSize}, nil
+}
+
+type fakePolicies struct {
+	policy *domain.BucketCachePolicy
+}
+
+func (p *fakePolicies) GetBucketCachePolicy(ctx context.Context, bucketName string) (*domain.BucketCachePolicy, error) {
+	return p.policy, nil
+}
+
+func newTestPrefetchService(objects map[string]domain.ObjectMetadata, policy *domain.BucketCachePolicy) (*PrefetchService, *fakeContentRepo, *fakePrefetchCache) {
+	objectRepo := &fakeObjectRepo{objects: objects}
+	contentRepo := &fakeContentRepo{objects: objectRepo, started: make(chan string, len(objects))}
+	cache := &fakePrefetchCache{}
+	return NewPrefetchService(objectRepo, contentRepo, cache, &fakePolicies{policy: policy}, 1), contentRepo, cache
+}
+
+// runPrefetch はジョブを登録し、終了するまで待って最後の状態を返す。
+func runPrefetch(t *testing.T, s *PrefetchService, params serviceif.PrefetchParams, whileRunning func(id string)) domain.PrefetchJob {
+	t.Helper()
+	done := make(chan domain.PrefetchJob, 1)
+	job, err := s.Enqueue(params, func(job domain.PrefetchJob) {
+		if job.Done() {
+			done <- job
+		}
+	})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if whileRunning != nil {
+		whileRunning(job.ID)
+	}
+
+	select {
+	case finished := <-done:
+		return finished
+	case <-time.After(5 * time.Second):
+		t.Fatal("prefetch job did not finish")
+		return domain.PrefetchJob{}
+	}
+}
+
+func TestPrefetchCapacity_ReserveAndRelease(t *testing.T) {
+	c := &prefetchCapacity{free: 100}
+
+	if !c.reserve(60) {
+		t.Fatal("expected 60 bytes to fit")
+	}
+	if c.reserve(50) {
+		t.Fatal("expected 50 bytes not to fit while 60 are reserved")
+	}
+	if got := c.taken(); got != 1 {
+		t.Errorf("expected 1 in flight, got %d", got)
+	}
+
+	// 取り込まなかった分は予約を戻す
+	c.release(60, false, false)
+	if c.free != 100 || c.taken() != 0 {
+		t.Errorf("expected reservation to be returned, got free=%d taken=%d", c.free, c.taken())
+	}
+
+	// 取り込んだ分は容量を使ったままにし、取り込み数に数える
+	if !c.reserve(60) {
+		t.Fatal("expected 60 bytes to fit again")
+	}
+	c.release(60, true, true)
+	if c.free != 40 || c.taken() != 1 {
+		t.Errorf("expected 40 bytes free and 1 taken, got free=%d taken=%d", c.free, c.taken())
+	}
+
+	// 取り込み済みだったオブジェクトは数えるが容量は戻す
+	if !c.reserve(40) {
+		t.Fatal("expected 40 bytes to fit")
+	}
+	c.release(40, true, false)
+	if c.free != 40 || c.taken() != 2 {
+		t.Errorf("expected 40 bytes free and 2 taken, got free=%d taken=%d", c.free, c.taken())
+	}
+
+	unlimited := &prefetchCapacity{free: -1}
+	if !unlimited.reserve(1 << 40) {
+		t.Error("expected unlimited capacity to accept any size")
+	}
+}
+
+func TestPrefetch_MaxCount(t *testing.T) {
+	objects := map[string]domain.ObjectMetadata{}
+	for _, key := range []string{"p/a", "p/b", "p/c", "p/d", "p/e"} {
+		objects[key] = domain.ObjectMetadata{Key: key, Size: 10, ContentType: "text/plain", ETag: `"` + key + `"`}
+	}
+	s, contentRepo, cache := newTestPrefetchService(objects, nil)
+
+	job := runPrefetch(t, s, serviceif.PrefetchParams{BucketName: "bucket", Prefix: "p/", MaxCount: 2}, nil)
+
+	if job.Status != domain.PrefetchCompleted || job.Fetched != 2 || job.Skipped != 3 {
+		t.Errorf("expected 2 fetched and 3 skipped, got status=%s fetched=%d skipped=%d", job.Status, job.Fetched, job.Skipped)
+	}
+	if got := contentRepo.fetchedKeys(); len(got) != 2 {
+		t.Errorf("expected only 2 objects to be downloaded, got %v", got)
+	}
+	if len(cache.stored) != 2 {
+		t.Errorf("expected 2 stored entries, got %v", cache.stored)
+	}
+}
+
+func TestPrefetch_FiltersContentTypeBeforeDownloading(t *testing.T) {
+	s, contentRepo, _ := newTestPrefetchService(map[string]domain.ObjectMetadata{
+		"a.png": {Key: "a.png", Size: 10, ContentType: "image/png", ETag: `"a"`},
+		"b.txt": {Key: "b.txt", Size: 10, ContentType: "text/plain", ETag: `"b"`},
+		"c.svg": {Key: "c.svg", Size: 10, ContentType: "image/svg+xml", ETag: `"c"`},
+	}, &domain.BucketCachePolicy{ExcludeContentTypes: []string{"image/svg+xml"}})
+
+	job := runPrefetch(t, s, serviceif.PrefetchParams{BucketName: "bucket", ContentTypes: []string{"image/*"}}, nil)
+
+	if job.Fetched != 1 || job.Skipped != 2 {
+		t.Errorf("expected 1 fetched and 2 skipped, got fetched=%d skipped=%d", job.Fetched, job.Skipped)
+	}
+	if got := contentRepo.fetchedKeys(); len(got) != 1 || got[0] != "a.png" {
+		t.Errorf("expected only a.png to be downloaded, got %v", got)
+	}
+}
+
+func TestPrefetch_Cancel(t *testing.T) {
+	s, contentRepo, cache := newTestPrefetchService(map[string]domain.ObjectMetadata{
+		"a": {Key: "a", Size: 10, ContentType: "text/plain", ETag: `"a"`},
+		"b": {Key: "b", Size: 10, ContentType: "text/plain", ETag: `"b"`},
+	}, nil)
+	contentRepo.block = true
+
+	job := runPrefetch(t, s, serviceif.PrefetchParams{BucketName: "bucket"}, func(id string) {
+		<-contentRepo.started
+		if err := s.Cancel(id); err != nil {
+			t.Errorf("Cancel: %v", err)
+		}
+	})
+
+	if job.Status != domain.PrefetchCanceled {
+		t.Errorf("expected canceled job, got %s", job.Status)
+	}
+	if job.Fetched != 0 || job.Failed != 0 || len(cache.stored) != 0 {
+		t.Errorf("expected nothing fetched or failed after cancel, got fetched=%d failed=%d stored=%v", job.Fetched, job.Failed, cache.stored)
+	}
+	if got := contentRepo.fetchedKeys(); len(got) != 1 {
+		t.Errorf("expected no further downloads after cancel, got %v", got)
+	}
+}
+
+func TestPrefetch_SharedJobsSurviveRebuild(t *testing.T) {
+	objects := map[string]domain.ObjectMetadata{
+		"a": {Key: "a", Size: 10, ContentType: "text/plain", ETag: `"a"`},
+	}
+	objectRepo := &fakeObjectRepo{objects: objects}
+	contentRepo := &fakeContentRepo{objects: objectRepo, block: true, started: make(chan string, 1)}
+	jobs := NewPrefetchJobs()
+	before := NewPrefetchService(objectRepo, contentRepo, &fakePrefetchCache{}, &fakePolicies{}, 1, WithPrefetchJobs(jobs))
+
+	// 接続設定の再読み込みで作り直したサービスからも、実行中のジョブを参照・中断できる
+	job := runPrefetch(t, before, serviceif.PrefetchParams{BucketName: "bucket"}, func(id string) {
+		<-contentRepo.started
+		after := NewPrefetchService(objectRepo, contentRepo, &fakePrefetchCache{}, &fakePolicies{}, 1, WithPrefetchJobs(jobs))
+		if _, err := after.GetJob(id); err != nil {
+			t.Errorf("Get from rebuilt service: %v", err)
+		}
+		if err := after.Cancel(id); err != nil {
+			t.Errorf("Cancel from rebuilt service: %v", err)
+		}
+	})
+
+	if job.Status != domain.PrefetchCanceled {
+		t.Errorf("expected canceled job, got %s", job.Status)
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type PresignService struct {
+	repo          serviceif.PresignRepository
+	logRepo       serviceif.PresignLogRepository
+	defaultExpiry time.Duration
+	maxExpiry     time.Duration
+}
+
+func NewPresignService(repo serviceif.PresignRepository, logRepo serviceif.PresignLogRepository, defaultExpiry, maxExpiry time.Duration) *PresignService {
+	return &PresignService{repo: repo, logRepo: logRepo, defaultExpiry: defaultExpiry, maxExpiry: maxExpiry}
+}
+
+func (s *PresignService) Presign(ctx context.Context, params serviceif.PresignParams) (*domain.PresignedURL, error) {
+	key := sanitizeObjectPath(params.ObjectKey)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return nil, errors.Wrap(serviceif.ErrInvalidPresignRequest, "invalid key")
+	}
+
+	expires := params.Expires
+	if expires == 0 {
+		expires = s.defaultExpiry
+	}
+	if expires < 0 || expires > s.maxExpiry {
+		return nil, errors.Wrap(serviceif.ErrInvalidPresignRequest, fmt.Sprintf("expiry must be between 1 and %d seconds", int64(s.maxExpiry.Seconds())))
+	}
+
+	method := strings.ToUpper(params.Method)
+	var url string
+	var err error
+	switch method {
+	case http.MethodGet:
+		url, err = s.repo.PresignGetObject(ctx, params.BucketName, key, params.ContentDisposition, expires)
+	case http.MethodPut:
+		url, err = s.repo.PresignPutObject(ctx, params.BucketName, key, params.ContentType, expires)
+	default:
+		return nil, errors.Wrap(serviceif.ErrInvalidPresignRequest, "method must be GET or PUT")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	record := &domain.PresignRecord{
+		BucketName:         params.BucketName,
+		ObjectKey:          key,
+		Method:             method,
+		IssuedBy:           params.IssuedBy,
+		ContentDisposition: params.ContentDisposition,
+		IssuedAt:           now,
+		ExpiresAt:          now.Add(expires),
+	}
+	id, err := s.logRepo.Insert(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("issued presigned url: id=%d method=%s bucket=%s key=%s expires_at=%s issued_by=%s", id, method, params.BucketName, key, record.ExpiresAt.Format(time.RFC3339), params.IssuedBy)
+
+	return &domain.PresignedURL{
+		ID:         id,
+		URL:        url,
+		Method:     method,
+		BucketName: params.BucketName,
+		ObjectKey:  key,
+		ExpiresAt:  record.ExpiresAt,
+	}, nil
+}
+
+func (s *PresignService) ListRecords(ctx context.Context, filter serviceif.PresignRecordFilter) ([]domain.PresignRecord, error) {
+	return s.logRepo.List(ctx, filter)
+}
@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type ResumableUploadService struct {
+	repo       serviceif.ResumableUploadRepository
+	uploadRepo serviceif.UploadRepository
+	listCache  serviceif.ListCacheRepository
+	partSize   int64
+	maxSize    int64
+	locks      *UploadLocks
+}
+
+// UploadLocks は同一アップロードへの PATCH が並行して処理されないようにするためのロック。
+// 接続設定の再読み込みでサービスを作り直しても処理中のアップロードを守れるよう、サービスの外で作成して渡す。
+type UploadLocks struct {
+	locks sync.Map
+}
+
+func NewUploadLocks() *UploadLocks {
+	return &UploadLocks{}
+}
+
+func (l *UploadLocks) tryLock(id string) (func(), bool) {
+	v, _ := l.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+func (l *UploadLocks) forget(id string) {
+	l.locks.Delete(id)
+}
+
+type ResumableUploadOption func(*ResumableUploadService)
+
+// WithUploadLocks は locks でアップロードごとのロックを取るよう設定する。指定しない場合はサービスごとに作成する。
+func WithUploadLocks(locks *UploadLocks) ResumableUploadOption {
+	return func(s *ResumableUploadService) {
+		s.locks = locks
+	}
+}
+
+func NewResumableUploadService(repo serviceif.ResumableUploadRepository, uploadRepo serviceif.UploadRepository, listCache serviceif.ListCacheRepository, partSize, maxSize int64, opts ...ResumableUploadOption) *ResumableUploadService {
+	s := &ResumableUploadService{
+		repo:       repo,
+		uploadRepo: uploadRepo,
+		listCache:  listCache,
+		partSize:   partSize,
+		maxSize:    maxSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.locks == nil {
+		s.locks = NewUploadLocks()
+	}
+	// パート数の上限を超えるサイズは確定できないため受け付けない
+	if limit := partSize * maxUploadParts; partSize > 0 && (s.maxSize <= 0 || s.maxSize > limit) {
+		s.maxSize = limit
+	}
+	return s
+}
+
+func (s *ResumableUploadService) MaxSize() int64 {
+	return s.maxSize
+}
+
+func (s *ResumableUploadService) Create(ctx context.Context, params serviceif.CreateResumableUploadParams) (*domain.ResumableUpload, error) {
+	key := sanitizeObjectPath(params.ObjectKey)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return nil, errors.New("invalid key")
+	}
+	if params.BucketName == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if params.Length < 0 {
+		return nil, errors.New("invalid upload length")
+	}
+	if s.maxSize > 0 && params.Length > s.maxSize {
+		return nil, serviceif.ErrUploadTooLarge
+	}
+
+	id, err := newResumableUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	upload := &domain.ResumableUpload{
+		ID:          id,
+		BucketName:  params.BucketName,
+		ObjectKey:   key,
+		ContentType: params.ContentType,
+		Overwrite:   params.Overwrite,
+		Length:      params.Length,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.repo.Create(ctx, upload); err != nil {
+		return nil, err
+	}
+
+	log.Printf("created resumable upload: id=%s bucket=%s key=%s length=%d", id, params.BucketName, key, params.Length)
+
+	// 長さ 0 のアップロードはデータを待たずに確定する
+	if params.Length == 0 {
+		return s.complete(ctx, upload)
+	}
+
+	return upload, nil
+}
+
+func (s *ResumableUploadService) Get(ctx context.Context, id string) (*domain.ResumableUpload, error) {
+	upload, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil {
+		return nil, serviceif.ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// Append は offset の位置から body を書き込む。データはステージングファイルに溜め、
+// partSize に達するごとに S3 へ UploadPart する。全データを受信した時点でアップロードを確定する。
+// 通信が途中で切れた場合も、受信できた分までオフセットを進めて保存する。
+// Upload-Length を超えるデータが送られた場合は、超えた分を書き込まずに ErrUploadExceedsLength を返し、確定しない。
+func (s *ResumableUploadService) Append(ctx context.Context, id string, offset int64, body io.Reader, onProgress serviceif.ProgressCallback) (*domain.ResumableUpload, error) {
+	unlock, ok := s.locks.tryLock(id)
+	if !ok {
+		return nil, serviceif.ErrUploadLocked
+	}
+	defer unlock()
+
+	upload, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Offset != offset {
+		return upload, serviceif.ErrUploadOffsetMismatch
+	}
+	if upload.CompletedAt != nil {
+		return upload, nil
+	}
+
+	parts, err := s.repo.ListParts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var uploadedSize int64
+	for _, p := range parts {
+		uploadedSize += p.Size
+	}
+	stagedSize := upload.Offset - uploadedSize
+
+	for {
+		// partSize に達したか全データを受信した時点でステージング分を送信する。
+		// 前回のリクエストで送信に失敗した分もここで再送される
+		if stagedSize > 0 && (stagedSize >= s.partSize || upload.Offset == upload.Length) {
+			part, err := s.uploadStagedPart(ctx, upload, int32(len(parts)+1), stagedSize)
+			if err != nil {
+				return upload, err
+			}
+			parts = append(parts, part)
+			uploadedSize += part.Size
+			stagedSize = 0
+
+			if onProgress != nil {
+				onProgress(uploadedSize)
+			}
+		}
+
+		if upload.Offset >= upload.Length {
+			break
+		}
+
+		limit := min(s.partSize-stagedSize, upload.Length-upload.Offset)
+		n, writeErr := s.repo.WriteStaging(id, stagedSize, body, limit)
+		stagedSize += n
+		upload.Offset += n
+
+		if n > 0 {
+			if err := s.repo.UpdateOffset(ctx, id, upload.Offset); err != nil {
+				return nil, err
+			}
+		}
+		if writeErr != nil {
+			return upload, writeErr
+		}
+		if n < limit {
+			// リクエストボディを読み切った
+			break
+		}
+	}
+
+	if upload.Offset < upload.Length {
+		return upload, nil
+	}
+
+	// 全データを受信した後にまだボディが残っていれば、クライアントの送信内容が壊れている
+	var extra [1]byte
+	if n, _ := io.ReadFull(body, extra[:]); n > 0 {
+		return upload, serviceif.ErrUploadExceedsLength
+	}
+
+	return s.complete(ctx, upload)
+}
+
+func (s *ResumableUploadService) uploadStagedPart(ctx context.Context, upload *domain.ResumableUpload, partNumber int32, size int64) (domain.UploadPart, error) {
+	// S3 側のマルチパートアップロードは最初のパート送信時に作成する
+	if upload.S3UploadID == "" {
+		s3UploadID, err := s.uploadRepo.CreateMultipartUpload(ctx, upload.BucketName, domain.ObjectMetadata{Key: upload.ObjectKey, ContentType: upload.ContentType})
+		if err != nil {
+			return domain.UploadPart{}, err
+		}
+		if err := s.repo.SetS3UploadID(ctx, upload.ID, s3UploadID); err != nil {
+			return domain.UploadPart{}, err
+		}
+		upload.S3UploadID = s3UploadID
+	}
+
+	staged, err := s.repo.OpenStaging(upload.ID, size)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+	defer staged.Close()
+
+	etag, err := s.uploadRepo.UploadPart(ctx, upload.BucketName, upload.ObjectKey, upload.S3UploadID, partNumber, staged)
+	if err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	part := domain.UploadPart{PartNumber: partNumber, ETag: etag, Size: size}
+	if err := s.repo.AddPart(ctx, upload.ID, part); err != nil {
+		return domain.UploadPart{}, err
+	}
+
+	return part, nil
+}
+
+func (s *ResumableUploadService) complete(ctx context.Context, upload *domain.ResumableUpload) (*domain.ResumableUpload, error) {
+	var etag string
+	var err error
+	if upload.S3UploadID == "" {
+		// 空ファイルはマルチパートアップロードにできないため、通常の PutObject で作成する
+		if upload.Overwrite {
+			etag, err = s.uploadRepo.PutObject(ctx, upload.BucketName, domain.ObjectMetadata{Key: upload.ObjectKey, ContentType: upload.ContentType}, strings.NewReader(""))
+		} else {
+			etag, err = s.uploadRepo.PutObjectIfNotExists(ctx, upload.BucketName, domain.ObjectMetadata{Key: upload.ObjectKey, ContentType: upload.ContentType}, strings.NewReader(""))
+		}
+	} else {
+		var parts []domain.UploadPart
+		parts, err = s.repo.ListParts(ctx, upload.ID)
+		if err != nil {
+			return nil, err
+		}
+		etag, err = s.uploadRepo.CompleteMultipartUpload(ctx, upload.BucketName, upload.ObjectKey, upload.S3UploadID, parts, upload.Overwrite)
+	}
+	if err != nil {
+		// 競合した場合は再試行しても成功しないため、アップロード自体を破棄する
+		if errors.Is(err, serviceif.ErrObjectAlreadyExists) {
+			s.abort(ctx, upload)
+			if deleteErr := s.repo.Delete(ctx, upload.ID); deleteErr != nil {
+				log.Printf("warning: failed to delete resumable upload: id=%s: %v", upload.ID, deleteErr)
+			}
+		}
+		return upload, errors.Wrap(err, "failed to complete upload")
+	}
+
+	if err := s.repo.MarkCompleted(ctx, upload.ID, etag); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	upload.ETag = etag
+	upload.CompletedAt = &now
+
+	s.listCache.AddObject(upload.BucketName, domain.Object{Key: upload.ObjectKey, Size: upload.Length, ETag: etag, LastModified: now})
+	log.Printf("uploaded object (resumable): id=%s bucket=%s key=%s size=%d", upload.ID, upload.BucketName, upload.ObjectKey, upload.Length)
+
+	return upload, nil
+}
+
+func (s *ResumableUploadService) Terminate(ctx context.Context, id string) error {
+	unlock, ok := s.locks.tryLock(id)
+	if !ok {
+		return serviceif.ErrUploadLocked
+	}
+	defer unlock()
+
+	upload, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if upload.CompletedAt == nil {
+		s.abort(ctx, upload)
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.locks.forget(id)
+
+	log.Printf("terminated resumable upload: id=%s", id)
+	return nil
+}
+
+// SweepExpired は ttl の間更新されていないアップロードを破棄する。完了していないものは S3 側のマルチパートアップロードも中止する。
+// 処理中のアップロードは飛ばし、破棄した数を返す。
+func (s *ResumableUploadService) SweepExpired(ctx context.Context, ttl time.Duration) (int, error) {
+	cutoff := time.Now().UTC().Add(-ttl)
+	uploads, err := s.repo.ListUpdatedBefore(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, candidate := range uploads {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		ok, err := s.sweep(ctx, candidate.ID, cutoff)
+		if err != nil {
+			log.Printf("warning: failed to remove expired resumable upload: id=%s: %v", candidate.ID, err)
+			continue
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (s *ResumableUploadService) sweep(ctx context.Context, id string, cutoff time.Time) (bool, error) {
+	unlock, ok := s.locks.tryLock(id)
+	if !ok {
+		return false, nil
+	}
+	defer unlock()
+
+	// 一覧を取得してからロックするまでの間に更新された場合は残す
+	upload, err := s.repo.Get(ctx, id)
+	if err != nil || upload == nil || !upload.UpdatedAt.Before(cutoff) {
+		return false, err
+	}
+
+	if upload.CompletedAt == nil {
+		s.abort(ctx, upload)
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return false, err
+	}
+	s.locks.forget(id)
+
+	log.Printf("removed expired resumable upload: id=%s bucket=%s key=%s", id, upload.BucketName, upload.ObjectKey)
+	return true, nil
+}
+
+// StartSweepLoop は ctx が終了するまで、interval ごとに期限切れのアップロードを破棄する。
+func (s *ResumableUploadService) StartSweepLoop(ctx context.Context, interval, ttl time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if n, err := s.SweepExpired(ctx, ttl); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("resumable upload sweep error: %v", err)
+				}
+			} else if n > 0 {
+				log.Printf("resumable upload sweep: removed %d expired uploads", n)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *ResumableUploadService) abort(ctx context.Context, upload *domain.ResumableUpload) {
+	if upload.S3UploadID == "" {
+		return
+	}
+	if err := s.uploadRepo.AbortMultipartUpload(context.WithoutCancel(ctx), upload.BucketName, upload.ObjectKey, upload.S3UploadID); err != nil {
+		log.Printf("warning: failed to abort multipart upload: id=%s: %v", upload.ID, err)
+	}
+}
+
+func newResumableUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate upload id")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+type fakeResumableRepo struct {
+	serviceif.ResumableUploadRepository
+	uploads map[string]domain.ResumableUpload
+	deleted []string
+}
+
+func (r *fakeResumableRepo) Get(ctx context.Context, id string) (*domain.ResumableUpload, error) {
+	upload, ok := r.uploads[id]
+	if !ok {
+		return nil, nil
+	}
+	return &upload, nil
+}
+
+func (r *fakeResumableRepo) ListUpdatedBefore(ctx context.Context, before time.Time) ([]domain.ResumableUpload, error) {
+	var uploads []domain.ResumableUpload
+	for _, upload := range r.uploads {
+		if upload.UpdatedAt.Before(before) {
+			uploads = append(uploads, upload)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].ID < uploads[j].ID })
+	return uploads, nil
+}
+
+func (r *fakeResumableRepo) Delete(ctx context.Context, id string) error {
+	delete(r.uploads, id)
+	r.deleted = append(r.deleted, id)
+	return nil
+}
+
+type fakeMultipartRepo struct {
+	serviceif.UploadRepository
+	aborted []string
+}
+
+func (r *fakeMultipartRepo) AbortMultipartUpload(ctx context.Context, bucketName, key, uploadID string) error {
+	r.aborted = append(r.aborted, uploadID)
+	return nil
+}
+
+func TestResumableUploadSweepExpired(t *testing.T) {
+	old := time.Now().UTC().Add(-48 * time.Hour)
+	completedAt := old
+	repo := &fakeResumableRepo{uploads: map[string]domain.ResumableUpload{
+		"abandoned": {ID: "abandoned", BucketName: "bucket", ObjectKey: "a.bin", S3UploadID: "s3-a", UpdatedAt: old},
+		"staged":    {ID: "staged", BucketName: "bucket", ObjectKey: "b.bin", UpdatedAt: old},
+		"completed": {ID: "completed", BucketName: "bucket", ObjectKey: "c.bin", S3UploadID: "s3-c", UpdatedAt: old, CompletedAt: &completedAt},
+		"locked":    {ID: "locked", BucketName: "bucket", ObjectKey: "d.bin", S3UploadID: "s3-d", UpdatedAt: old},
+		"active":    {ID: "active", BucketName: "bucket", ObjectKey: "e.bin", S3UploadID: "s3-e", UpdatedAt: time.Now().UTC()},
+	}}
+	uploadRepo := &fakeMultipartRepo{}
+	locks := NewUploadLocks()
+	s := NewResumableUploadService(repo, uploadRepo, nil, 5<<20, 0, WithUploadLocks(locks))
+
+	// 処理中のアップロードは破棄しない
+	unlock, ok := locks.tryLock("locked")
+	if !ok {
+		t.Fatal("failed to lock upload")
+	}
+	defer unlock()
+
+	removed, err := s.SweepExpired(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("SweepExpired: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("expected 3 uploads to be removed, got %d", removed)
+	}
+	for _, id := range []string{"abandoned", "staged", "completed"} {
+		if _, ok := repo.uploads[id]; ok {
+			t.Errorf("expected %s to be removed", id)
+		}
+	}
+	for _, id := range []string{"locked", "active"} {
+		if _, ok := repo.uploads[id]; !ok {
+			t.Errorf("expected %s to be kept", id)
+		}
+	}
+
+	// S3 側は完了していないマルチパートアップロードだけ中止する
+	if len(uploadRepo.aborted) != 1 || uploadRepo.aborted[0] != "s3-a" {
+		t.Errorf("expected only s3-a to be aborted, got %v", uploadRepo.aborted)
+	}
+
+	// 破棄したアップロードのロックは残さない
+	locks.locks.Range(func(key, _ any) bool {
+		if key != "locked" {
+			t.Errorf("expected the lock of %v to be forgotten", key)
+		}
+		return true
+	})
+}
+
+// fakeStagingRepo はステージングファイルとパートをメモリに保持する
+type fakeStagingRepo struct {
+	fakeResumableRepo
+	staging   []byte
+	parts     []domain.UploadPart
+	completed bool
+}
+
+func (r *fakeStagingRepo) ListParts(ctx context.Context, id string) ([]domain.UploadPart, error) {
+	return r.parts, nil
+}
+
+func (r *fakeStagingRepo) AddPart(ctx context.Context, id string, part domain.UploadPart) error {
+	r.parts = append(r.parts, part)
+	return nil
+}
+
+func (r *fakeStagingRepo) SetS3UploadID(ctx context.Context, id, s3UploadID string) error {
+	upload := r.uploads[id]
+	upload.S3UploadID = s3UploadID
+	r.uploads[id] = upload
+	return nil
+}
+
+func (r *fakeStagingRepo) UpdateOffset(ctx context.Context, id string, offset int64) error {
+	upload := r.uploads[id]
+	upload.Offset = offset
+	r.uploads[id] = upload
+	return nil
+}
+
+func (r *fakeStagingRepo) MarkCompleted(ctx context.Context, id, etag string) error {
+	r.completed = true
+	return nil
+}
+
+func (r *fakeStagingRepo) WriteStaging(id string, stagedSize int64, body io.Reader, limit int64) (int64, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, body, limit)
+	r.staging = append(r.staging[:stagedSize], buf.Bytes()...)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *fakeStagingRepo) OpenStaging(id string, size int64) (io.ReadSeekCloser, error) {
+	return nopReadSeekCloser{bytes.NewReader(r.staging[:size])}, nil
+}
+
+type nopReadSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopReadSeekCloser) Close() error { return nil }
+
+func TestResumableUploadAppend_RejectsDataBeyondLength(t *testing.T) {
+	repo := &fakeStagingRepo{fakeResumableRepo: fakeResumableRepo{uploads: map[string]domain.ResumableUpload{
+		"u1": {ID: "u1", BucketName: "bucket", ObjectKey: "a.bin", Length: 10},
+	}}}
+	uploadRepo := &fakeUploadRepo{}
+	s := NewResumableUploadService(repo, uploadRepo, &fakeUploadListCache{}, 16, 0)
+
+	upload, err := s.Append(context.Background(), "u1", 0, strings.NewReader("0123456789extra"), nil)
+	if !errors.Is(err, serviceif.ErrUploadExceedsLength) {
+		t.Fatalf("expected ErrUploadExceedsLength, got %v", err)
+	}
+
+	// Upload-Length までのデータだけを受け付け、確定はしない
+	if upload.Offset != 10 || repo.uploads["u1"].Offset != 10 {
+		t.Errorf("expected offset 10, got %d (stored %d)", upload.Offset, repo.uploads["u1"].Offset)
+	}
+	if string(repo.staging) != "0123456789" {
+		t.Errorf("expected staged data %q, got %q", "0123456789", repo.staging)
+	}
+	if repo.completed || uploadRepo.completed != nil {
+		t.Error("expected the upload not to be completed")
+	}
+
+	// 同じオフセットから空のボディで送り直せば確定できる
+	upload, err = s.Append(context.Background(), "u1", 10, strings.NewReader(""), nil)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if upload.CompletedAt == nil || !repo.completed {
+		t.Error("expected the upload to be completed")
+	}
+}
+
+func TestNewResumableUploadService_LimitsMaxSizeToPartCount(t *testing.T) {
+	s := NewResumableUploadService(&fakeResumableRepo{}, &fakeMultipartRepo{}, &fakeUploadListCache{}, 16, 1<<40)
+	if got := s.MaxSize(); got != 16*maxUploadParts {
+		t.Errorf("expected max size %d, got %d", 16*maxUploadParts, got)
+	}
+}
@@ -2,17 +2,21 @@ package service
 
 import (
 	"context"
+	"log"
+
+	"github.com/pkg/errors"
 
 	"r2manager/domain"
 	serviceif "r2manager/service/interface"
 )
 
 type SettingsService struct {
-	repo serviceif.SettingsRepository
+	repo      serviceif.SettingsRepository
+	cacheRepo serviceif.CacheRepository
 }
 
-func NewSettingsService(repo serviceif.SettingsRepository) *SettingsService {
-	return &SettingsService{repo: repo}
+func NewSettingsService(repo serviceif.SettingsRepository, cacheRepo serviceif.CacheRepository) *SettingsService {
+	return &SettingsService{repo: repo, cacheRepo: cacheRepo}
 }
 
 func (s *SettingsService) GetAllBucketSettings(ctx context.Context) ([]domain.BucketSettings, error) {
@@ -27,6 +31,61 @@ func (s *SettingsService) UpdateBucketPublicUrl(ctx context.Context, bucketName,
 	return s.repo.UpsertBucketSettings(ctx, bucketName, publicUrl)
 }
 
+// UpdateBucketCachePolicy はバケットのキャッシュ設定を保存し、既存のキャッシュに反映する。
+func (s *SettingsService) UpdateBucketCachePolicy(ctx context.Context, bucketName string, policy domain.BucketCachePolicy) error {
+	if err := validateCachePolicy(policy); err != nil {
+		return err
+	}
+	if err := s.repo.UpsertBucketCachePolicy(ctx, bucketName, policy); err != nil {
+		return err
+	}
+	s.applyCachePolicy(ctx, bucketName, &policy)
+	return nil
+}
+
 func (s *SettingsService) BulkUpdateBucketSettings(ctx context.Context, settings []domain.BucketSettings) error {
-	return s.repo.BulkUpsertBucketSettings(ctx, settings)
+	for _, setting := range settings {
+		if setting.CachePolicy != nil {
+			if err := validateCachePolicy(*setting.CachePolicy); err != nil {
+				return errors.Wrapf(err, "bucket %s", setting.BucketName)
+			}
+		}
+	}
+
+	if err := s.repo.BulkUpsertBucketSettings(ctx, settings); err != nil {
+		return err
+	}
+
+	for _, setting := range settings {
+		if setting.CachePolicy != nil {
+			s.applyCachePolicy(ctx, setting.BucketName, setting.CachePolicy)
+		}
+	}
+	return nil
+}
+
+// applyCachePolicy は既存のキャッシュへの反映に失敗しても設定の保存は成功として扱う。
+// 反映されなかったエントリも有効期限切れや追い出しで徐々に入れ替わる。
+func (s *SettingsService) applyCachePolicy(ctx context.Context, bucketName string, policy *domain.BucketCachePolicy) {
+	deleted, err := s.cacheRepo.ApplyBucketPolicy(ctx, bucketName, policy)
+	if err != nil {
+		log.Printf("warning: failed to apply cache policy: bucket=%s: %v", bucketName, err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("applied cache policy: bucket=%s deleted=%d", bucketName, deleted)
+	}
+}
+
+func validateCachePolicy(policy domain.BucketCachePolicy) error {
+	if policy.TTLSeconds < 0 {
+		return errors.Wrap(serviceif.ErrInvalidCachePolicy, "ttl_seconds must not be negative")
+	}
+	if policy.MaxSize < 0 {
+		return errors.Wrap(serviceif.ErrInvalidCachePolicy, "max_size must not be negative")
+	}
+	if policy.NeverCache && policy.Pinned {
+		return errors.Wrap(serviceif.ErrInvalidCachePolicy, "never_cache and pinned cannot both be set")
+	}
+	return nil
 }
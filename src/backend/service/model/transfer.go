@@ -0,0 +1,795 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+const (
+	// 終了したジョブをメモリ上に保持する期間。以降はデータベースから返す
+	transferJobMemoryRetention = time.Hour
+	// 終了したジョブをデータベースに保持する期間
+	transferJobRetention = 7 * 24 * time.Hour
+	// 実行中のジョブのチェックポイントを保存する間隔
+	transferCheckpointInterval = 2 * time.Second
+
+	maxTransferFailures = 100
+	maxTransferPreview  = 1000
+)
+
+type TransferService struct {
+	conns              serviceif.TransferConnections
+	repo               serviceif.TransferJobRepository
+	defaultConcurrency int
+	maxConcurrency     int
+
+	// slot は同時に実行するジョブの数を制限する。後続のジョブは queued のまま待つ
+	slot chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*transferJob
+}
+
+type transferJob struct {
+	mu       sync.Mutex
+	job      domain.TransferJob
+	cancel   context.CancelFunc
+	onUpdate serviceif.TransferUpdateCallback
+
+	// targets は今回の実行で処理する対象。キーの昇順に並んでいる
+	targets []transferTarget
+	// results は対象ごとの結果。nil の対象は未処理
+	results []*transferResult
+	// next は未処理の対象のうち最初のもの。これより前の対象はすべて処理済み
+	next int
+	// saved は Checkpoint までの処理結果。再開時はこの件数から数え直す
+	saved      transferTally
+	checkpoint string
+}
+
+type transferTarget struct {
+	item domain.TransferItem
+	etag string
+}
+
+type transferOutcome int
+
+const (
+	transferCopied transferOutcome = iota
+	transferSkipped
+	transferFailed
+	// transferCanceled はジョブの中断で処理しなかった対象。処理済みとして扱わない
+	transferCanceled
+)
+
+type transferResult struct {
+	outcome transferOutcome
+	bytes   int64
+	err     error
+}
+
+// transferTally は Checkpoint までの処理結果。失敗した対象より後にチェックポイントは進まないため、失敗の数は含まない
+type transferTally struct {
+	copied, skipped int
+	bytesCopied     int64
+}
+
+func (t *transferTally) add(r transferResult) {
+	switch r.outcome {
+	case transferCopied:
+		t.copied++
+		t.bytesCopied += r.bytes
+	case transferSkipped:
+		t.skipped++
+	}
+}
+
+func NewTransferService(conns serviceif.TransferConnections, repo serviceif.TransferJobRepository, defaultConcurrency, maxConcurrency, maxRunningJobs int) *TransferService {
+	return &TransferService{
+		conns:              conns,
+		repo:               repo,
+		defaultConcurrency: max(defaultConcurrency, 1),
+		maxConcurrency:     max(maxConcurrency, 1),
+		slot:               make(chan struct{}, max(maxRunningJobs, 1)),
+		jobs:               make(map[string]*transferJob),
+	}
+}
+
+// Enqueue は転送ジョブを登録し、バックグラウンドで実行する。
+func (s *TransferService) Enqueue(ctx context.Context, params serviceif.TransferParams, onUpdate serviceif.TransferUpdateCallback) (*domain.TransferJob, error) {
+	if err := s.validateParams(ctx, &params); err != nil {
+		return nil, err
+	}
+
+	id, err := newTransferJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	j := newTransferJob(domain.TransferJob{
+		ID:           id,
+		Source:       params.Source,
+		Destination:  params.Destination,
+		Include:      params.Include,
+		Exclude:      params.Exclude,
+		SkipSameETag: params.SkipSameETag,
+		DryRun:       params.DryRun,
+		Concurrency:  params.Concurrency,
+		Status:       domain.TransferQueued,
+		CreatedAt:    time.Now().UTC(),
+	}, onUpdate)
+
+	if deleted, err := s.repo.DeleteFinishedBefore(ctx, time.Now().Add(-transferJobRetention)); err != nil {
+		log.Printf("warning: failed to delete old transfer jobs: %v", err)
+	} else if deleted > 0 {
+		log.Printf("deleted %d old transfer jobs", deleted)
+	}
+	if err := s.repo.SaveJob(ctx, j.persistent()); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.pruneJobs()
+	s.start(j)
+	s.mu.Unlock()
+
+	log.Printf("transfer job queued: id=%s source=%s:%s/%s destination=%s:%s/%s dry_run=%t",
+		id, params.Source.Connection, params.Source.Bucket, params.Source.Prefix,
+		params.Destination.Connection, params.Destination.Bucket, params.Destination.Prefix, params.DryRun)
+	snapshot := j.update(func(*domain.TransferJob) {})
+	return &snapshot, nil
+}
+
+func (s *TransferService) validateParams(ctx context.Context, params *serviceif.TransferParams) error {
+	for _, loc := range []*domain.TransferLocation{&params.Source, &params.Destination} {
+		if loc.Connection == "" {
+			loc.Connection = domain.DefaultConnectionName
+		}
+		if loc.Bucket == "" {
+			return errors.Wrap(serviceif.ErrInvalidTransferRequest, "source and destination bucket are required")
+		}
+		loc.Prefix = strings.TrimLeft(loc.Prefix, "/")
+		endpoint, err := s.conns.Endpoint(ctx, loc.Connection)
+		if err != nil {
+			return err
+		}
+		endpoint.Release()
+	}
+
+	src, dst := params.Source, params.Destination
+	// 同じバケット内で範囲が重なると、再開時の一覧に転送済みのオブジェクトが含まれてしまう
+	if src.Connection == dst.Connection && src.Bucket == dst.Bucket &&
+		(strings.HasPrefix(src.Prefix, dst.Prefix) || strings.HasPrefix(dst.Prefix, src.Prefix)) {
+		return errors.Wrap(serviceif.ErrInvalidTransferRequest, "source and destination must not overlap")
+	}
+
+	if params.Concurrency == 0 {
+		params.Concurrency = s.defaultConcurrency
+	}
+	if params.Concurrency < 0 || params.Concurrency > s.maxConcurrency {
+		return errors.Wrapf(serviceif.ErrInvalidTransferRequest, "concurrency must be between 1 and %d", s.maxConcurrency)
+	}
+
+	if _, err := newTransferMatcher(params.Include, params.Exclude); err != nil {
+		return errors.Wrap(serviceif.ErrInvalidTransferRequest, err.Error())
+	}
+	return nil
+}
+
+func (s *TransferService) GetJob(ctx context.Context, id string) (*domain.TransferJob, error) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if ok {
+		snapshot := j.snapshot()
+		return &snapshot, nil
+	}
+
+	job, err := s.repo.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, serviceif.ErrTransferJobNotFound
+	}
+	return job, nil
+}
+
+// ListJobs は保存しているジョブを作成順に返す。実行中のジョブは現在の状態を返す。
+func (s *TransferService) ListJobs(ctx context.Context) ([]domain.TransferJob, error) {
+	jobs, err := s.repo.ListJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range jobs {
+		if j, ok := s.jobs[jobs[i].ID]; ok {
+			jobs[i] = j.snapshot()
+		}
+	}
+	return jobs, nil
+}
+
+// Cancel は実行中または待機中のジョブを中断する。転送済みのオブジェクトはそのまま残り、Resume で続きから再開できる。
+// 再起動後にまだ再開していないジョブは、保存している状態を中断にする。
+func (s *TransferService) Cancel(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j, ok := s.jobs[id]; ok {
+		if snapshot := j.snapshot(); snapshot.Done() {
+			return errors.Wrapf(serviceif.ErrTransferJobNotRunning, "job is %s", snapshot.Status)
+		}
+		j.cancel()
+		return nil
+	}
+
+	job, err := s.repo.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return serviceif.ErrTransferJobNotFound
+	}
+	if job.Done() {
+		return errors.Wrapf(serviceif.ErrTransferJobNotRunning, "job is %s", job.Status)
+	}
+
+	now := time.Now().UTC()
+	job.Status = domain.TransferCanceled
+	job.FinishedAt = &now
+	if err := s.repo.SaveJob(ctx, job); err != nil {
+		return err
+	}
+	log.Printf("transfer job canceled before resuming: id=%s", id)
+	return nil
+}
+
+// Resume は失敗・中断したジョブや、転送に失敗したオブジェクトが残るジョブを、保存したチェックポイントより後のキーから再開する。
+// 失敗したオブジェクトはチェックポイントより後にあるため、再開時に転送し直す。
+func (s *TransferService) Resume(ctx context.Context, id string, onUpdate serviceif.TransferUpdateCallback) (*domain.TransferJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j, ok := s.jobs[id]; ok {
+		if snapshot := j.snapshot(); !snapshot.Done() {
+			return nil, serviceif.ErrTransferJobNotResumable
+		}
+	}
+	job, err := s.repo.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, serviceif.ErrTransferJobNotFound
+	}
+	switch {
+	case job.Status == domain.TransferFailed, job.Status == domain.TransferCanceled:
+	case job.Status == domain.TransferCompleted && job.Failed > 0:
+	default:
+		return nil, errors.Wrapf(serviceif.ErrTransferJobNotResumable, "job is %s", job.Status)
+	}
+
+	j := s.requeue(*job, onUpdate)
+	log.Printf("transfer job resumed: id=%s checkpoint=%q", id, job.Checkpoint)
+
+	snapshot := j.snapshot()
+	return &snapshot, nil
+}
+
+// ResumeUnfinished は前回の実行中に終了しなかったジョブをチェックポイントから再開する。
+func (s *TransferService) ResumeUnfinished(ctx context.Context, onUpdate serviceif.TransferUpdateCallback) (int, error) {
+	jobs, err := s.repo.ListUnfinishedJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range jobs {
+		if _, ok := s.jobs[job.ID]; ok {
+			continue
+		}
+		s.requeue(job, onUpdate)
+		log.Printf("transfer job resumed after restart: id=%s checkpoint=%q", job.ID, job.Checkpoint)
+	}
+	return len(jobs), nil
+}
+
+// requeue は保存されたジョブを待機中に戻して実行する。件数はチェックポイントまでの結果から数え直す。s.mu を保持した状態で呼ぶ。
+func (s *TransferService) requeue(job domain.TransferJob, onUpdate serviceif.TransferUpdateCallback) *transferJob {
+	job.Status = domain.TransferQueued
+	job.Copied = job.CheckpointCopied
+	job.Skipped = job.CheckpointSkipped
+	job.Failed = 0
+	job.BytesCopied = job.CheckpointBytesCopied
+	job.Failures = nil
+	job.Error = ""
+	job.FinishedAt = nil
+	job.Preview = nil
+	job.PreviewTruncated = false
+
+	j := newTransferJob(job, onUpdate)
+	s.save(j)
+	s.start(j)
+	return j
+}
+
+// start はジョブを登録してバックグラウンドで実行する。s.mu を保持した状態で呼ぶ。
+func (s *TransferService) start(j *transferJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	s.jobs[j.job.ID] = j
+	go s.run(ctx, j)
+}
+
+// pruneJobs は保持期間を過ぎた終了済みのジョブをメモリから削除する。s.mu を保持した状態で呼ぶ。
+func (s *TransferService) pruneJobs() {
+	now := time.Now()
+	for id, j := range s.jobs {
+		snapshot := j.snapshot()
+		if snapshot.FinishedAt != nil && now.Sub(*snapshot.FinishedAt) > transferJobMemoryRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *TransferService) run(ctx context.Context, j *transferJob) {
+	defer j.cancel()
+
+	select {
+	case s.slot <- struct{}{}:
+		defer func() { <-s.slot }()
+	case <-ctx.Done():
+		j.finish(ctx, nil)
+		s.save(j)
+		return
+	}
+
+	j.update(func(job *domain.TransferJob) {
+		now := time.Now().UTC()
+		job.Status = domain.TransferRunning
+		job.StartedAt = &now
+	})
+	s.save(j)
+
+	err := s.transfer(ctx, j)
+	j.finish(ctx, err)
+	s.save(j)
+}
+
+// transfer は転送元の一覧をキーの順に辿り、チェックポイントより後の対象を並行して転送する。
+func (s *TransferService) transfer(ctx context.Context, j *transferJob) error {
+	params := j.snapshot()
+
+	src, err := s.conns.Endpoint(ctx, params.Source.Connection)
+	if err != nil {
+		return err
+	}
+	defer src.Release()
+	dst, err := s.conns.Endpoint(ctx, params.Destination.Connection)
+	if err != nil {
+		return err
+	}
+	defer dst.Release()
+	shared, err := s.conns.SharesCredentials(ctx, params.Source.Connection, params.Destination.Connection)
+	if err != nil {
+		return err
+	}
+	mode := domain.TransferStream
+	if shared {
+		mode = domain.TransferServerCopy
+	}
+
+	matcher, err := newTransferMatcher(params.Include, params.Exclude)
+	if err != nil {
+		return err
+	}
+
+	objects, err := src.Objects.ListAllObjects(ctx, params.Source.Bucket, params.Source.Prefix)
+	if err != nil {
+		return errors.Wrap(err, "failed to list source objects")
+	}
+
+	var targets []transferTarget
+	var bytesTotal int64
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, params.Source.Prefix)
+		// プレフィックス自体のフォルダマーカーは転送しない
+		if rel == "" || !matcher.match(rel) {
+			continue
+		}
+		targets = append(targets, transferTarget{
+			item: domain.TransferItem{Key: obj.Key, DestinationKey: params.Destination.Prefix + rel, Size: obj.Size},
+			etag: obj.ETag,
+		})
+		bytesTotal += obj.Size
+	}
+	sort.Slice(targets, func(a, b int) bool { return targets[a].item.Key < targets[b].item.Key })
+
+	var destETags map[string]string
+	if params.SkipSameETag {
+		existing, err := dst.Objects.ListAllObjects(ctx, params.Destination.Bucket, params.Destination.Prefix)
+		if err != nil {
+			return errors.Wrap(err, "failed to list destination objects")
+		}
+		destETags = make(map[string]string, len(existing))
+		for _, obj := range existing {
+			destETags[obj.Key] = obj.ETag
+		}
+	}
+
+	// チェックポイント以前のキーは前回までに処理済み
+	start := 0
+	if params.Checkpoint != "" {
+		start = sort.Search(len(targets), func(i int) bool { return targets[i].item.Key > params.Checkpoint })
+	}
+	j.update(func(job *domain.TransferJob) {
+		job.Mode = mode
+		job.Total = len(targets)
+		job.BytesTotal = bytesTotal
+		j.targets = targets
+		j.results = make([]*transferResult, len(targets))
+		j.next = start
+	})
+
+	stopSaving := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(transferCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.save(j)
+			case <-stopSaving:
+				return
+			}
+		}
+	}()
+	defer close(stopSaving)
+
+	sem := make(chan struct{}, params.Concurrency)
+	var wg sync.WaitGroup
+	for i := start; i < len(targets); i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result := s.transferObject(ctx, params, mode, src, dst, targets[i], destETags, j)
+			j.update(func(job *domain.TransferJob) { j.record(job, i, result) })
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (s *TransferService) transferObject(ctx context.Context, params domain.TransferJob, mode domain.TransferMode, src, dst *serviceif.TransferEndpoint, t transferTarget, destETags map[string]string, j *transferJob) transferResult {
+	if destETags != nil && t.etag != "" && destETags[t.item.DestinationKey] == t.etag {
+		return transferResult{outcome: transferSkipped}
+	}
+	if params.DryRun {
+		j.addPreview(t.item)
+		return transferResult{outcome: transferCopied, bytes: t.item.Size}
+	}
+
+	var err error
+	if mode == domain.TransferServerCopy {
+		err = s.copyObject(ctx, params, dst, t)
+	} else {
+		err = s.streamObject(ctx, params, src, dst, t)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return transferResult{outcome: transferCanceled}
+		}
+		log.Printf("warning: transfer failed: job=%s key=%s: %v", params.ID, t.item.Key, err)
+		return transferResult{outcome: transferFailed, err: err}
+	}
+
+	if _, err := dst.Cache.ClearByKey(ctx, params.Destination.Bucket, t.item.DestinationKey); err != nil {
+		log.Printf("warning: failed to clear content cache: bucket=%s key=%s: %v", params.Destination.Bucket, t.item.DestinationKey, err)
+	}
+	return transferResult{outcome: transferCopied, bytes: t.item.Size}
+}
+
+// copyObject は転送先の認証情報で転送元のオブジェクトをサーバー側でコピーする。
+func (s *TransferService) copyObject(ctx context.Context, params domain.TransferJob, dst *serviceif.TransferEndpoint, t transferTarget) error {
+	var etag string
+	var err error
+	if t.item.Size <= maxSingleCopySize {
+		etag, err = dst.Copies.CopyObject(ctx, params.Source.Bucket, t.item.Key, params.Destination.Bucket, t.item.DestinationKey, true)
+	} else {
+		etag, err = dst.Copies.MultipartCopyObject(ctx, params.Source.Bucket, t.item.Key, params.Destination.Bucket, t.item.DestinationKey, t.item.Size, true, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	dst.ListCache.AddObject(params.Destination.Bucket, domain.Object{Key: t.item.DestinationKey, Size: t.item.Size, ETag: etag, LastModified: time.Now().UTC()})
+	return nil
+}
+
+// streamObject は転送元から取得したボディを、サイズに応じてマルチパートで転送先にアップロードする。
+// Content-Type などのヘッダとユーザー定義のメタデータは転送元の HeadObject の内容を引き継ぐ。
+func (s *TransferService) streamObject(ctx context.Context, params domain.TransferJob, src, dst *serviceif.TransferEndpoint, t transferTarget) error {
+	meta, err := src.Objects.HeadObjectMetadata(ctx, params.Source.Bucket, t.item.Key)
+	if err != nil {
+		return err
+	}
+
+	content, err := src.Content.GetContent(ctx, params.Source.Bucket, t.item.Key)
+	if err != nil {
+		return err
+	}
+	defer content.Body.Close()
+
+	// HeadObject と取得の間に書き換えられた場合、別の版のメタデータを付けないよう失敗として再開時に転送し直す
+	if meta.ETag != "" && content.ETag != "" && meta.ETag != content.ETag {
+		return serviceif.ErrObjectModified
+	}
+
+	meta.Key = t.item.DestinationKey
+	if meta.ContentType == "" {
+		meta.ContentType = content.ContentType
+	}
+	_, err = dst.Uploads.UploadObjectWithMetadata(ctx, params.Destination.Bucket, *meta, content.Body, content.Size, true, nil)
+	return err
+}
+
+func (s *TransferService) save(j *transferJob) {
+	if err := s.repo.SaveJob(context.Background(), j.persistent()); err != nil {
+		log.Printf("warning: failed to save transfer job: id=%s: %v", j.snapshot().ID, err)
+	}
+}
+
+func newTransferJob(job domain.TransferJob, onUpdate serviceif.TransferUpdateCallback) *transferJob {
+	return &transferJob{
+		job:      job,
+		onUpdate: onUpdate,
+		saved: transferTally{
+			copied:      job.CheckpointCopied,
+			skipped:     job.CheckpointSkipped,
+			bytesCopied: job.CheckpointBytesCopied,
+		},
+		checkpoint: job.Checkpoint,
+	}
+}
+
+// record は対象 i の結果を反映し、先頭から連続して処理済みになった分だけチェックポイントを進める。
+// 失敗した対象は再開時に転送し直すため、チェックポイントはその手前で止める。j.mu を保持した状態で呼ぶ。
+func (j *transferJob) record(job *domain.TransferJob, i int, r transferResult) {
+	if r.outcome == transferCanceled {
+		return
+	}
+
+	switch r.outcome {
+	case transferCopied:
+		job.Copied++
+		job.BytesCopied += r.bytes
+	case transferSkipped:
+		job.Skipped++
+	case transferFailed:
+		job.Failed++
+		j.addFailure(job, j.targets[i].item.Key, r.err)
+	}
+
+	j.results[i] = &r
+	for ; j.next < len(j.targets) && j.results[j.next] != nil && j.results[j.next].outcome != transferFailed; j.next++ {
+		j.saved.add(*j.results[j.next])
+		j.checkpoint = j.targets[j.next].item.Key
+	}
+	job.Checkpoint = j.checkpoint
+}
+
+// addFailure は失敗したオブジェクトを記録する。j.mu を保持した状態で呼ぶ。
+func (j *transferJob) addFailure(job *domain.TransferJob, key string, err error) {
+	for i, f := range job.Failures {
+		if f.Key == key {
+			job.Failures[i].Message = err.Error()
+			return
+		}
+	}
+	if len(job.Failures) < maxTransferFailures {
+		job.Failures = append(job.Failures, domain.TransferFailure{Key: key, Message: err.Error()})
+	}
+}
+
+func (j *transferJob) addPreview(item domain.TransferItem) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.job.Preview) >= maxTransferPreview {
+		j.job.PreviewTruncated = true
+		return
+	}
+	j.job.Preview = append(j.job.Preview, item)
+}
+
+// update はジョブの状態を変更し、変更後の写しをコールバックに渡す。
+func (j *transferJob) update(fn func(job *domain.TransferJob)) domain.TransferJob {
+	j.mu.Lock()
+	fn(&j.job)
+	snapshot := j.copyLocked()
+	j.mu.Unlock()
+
+	if j.onUpdate != nil {
+		j.onUpdate(snapshot)
+	}
+	return snapshot
+}
+
+func (j *transferJob) snapshot() domain.TransferJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.copyLocked()
+}
+
+func (j *transferJob) copyLocked() domain.TransferJob {
+	snapshot := j.job
+	snapshot.Include = append([]string(nil), j.job.Include...)
+	snapshot.Exclude = append([]string(nil), j.job.Exclude...)
+	snapshot.Failures = append([]domain.TransferFailure(nil), j.job.Failures...)
+	snapshot.Preview = append([]domain.TransferItem(nil), j.job.Preview...)
+	return snapshot
+}
+
+// persistent はデータベースに保存する状態を返す。再開用にチェックポイントまでの件数も保存する。
+func (j *transferJob) persistent() *domain.TransferJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job := j.copyLocked()
+	job.Checkpoint = j.checkpoint
+	job.CheckpointCopied = j.saved.copied
+	job.CheckpointSkipped = j.saved.skipped
+	job.CheckpointBytesCopied = j.saved.bytesCopied
+	return &job
+}
+
+func (j *transferJob) finish(ctx context.Context, err error) {
+	snapshot := j.update(func(job *domain.TransferJob) {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		switch {
+		case ctx.Err() != nil:
+			job.Status = domain.TransferCanceled
+		case err != nil:
+			job.Status = domain.TransferFailed
+			job.Error = err.Error()
+		default:
+			job.Status = domain.TransferCompleted
+		}
+	})
+
+	log.Printf("transfer job %s: id=%s mode=%s copied=%d skipped=%d failed=%d bytes=%d",
+		snapshot.Status, snapshot.ID, snapshot.Mode, snapshot.Copied, snapshot.Skipped, snapshot.Failed, snapshot.BytesCopied)
+}
+
+// transferMatcher は include / exclude の glob パターンで転送対象を絞り込む。
+type transferMatcher struct {
+	include []globPattern
+	exclude []globPattern
+}
+
+// globPattern の "*" と "?" は "/" に一致せず、"**" は "/" を含む任意の文字列に一致する。
+// "/" を含まないパターンはファイル名だけと比較する ("*.png" はどの階層の PNG にも一致する)。
+type globPattern struct {
+	re       *regexp.Regexp
+	baseName bool
+}
+
+func newTransferMatcher(include, exclude []string) (*transferMatcher, error) {
+	m := &transferMatcher{}
+	for _, p := range include {
+		g, err := compileGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		m.include = append(m.include, g)
+	}
+	for _, p := range exclude {
+		g, err := compileGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		m.exclude = append(m.exclude, g)
+	}
+	return m, nil
+}
+
+// match は転送元のプレフィックスからの相対パスが対象かを返す。
+func (m *transferMatcher) match(rel string) bool {
+	for _, g := range m.exclude {
+		if g.match(rel) {
+			return false
+		}
+	}
+	if len(m.include) == 0 {
+		return true
+	}
+	for _, g := range m.include {
+		if g.match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g globPattern) match(rel string) bool {
+	if g.baseName {
+		return g.re.MatchString(path.Base(rel))
+	}
+	return g.re.MatchString(rel)
+}
+
+func compileGlob(pattern string) (globPattern, error) {
+	p := strings.TrimLeft(strings.TrimSpace(pattern), "/")
+	if p == "" || strings.Contains(p, "\n") {
+		return globPattern{}, errors.Errorf("invalid pattern %q", pattern)
+	}
+	// "thumbs/" のようにスラッシュで終わるパターンはフォルダ配下のすべてに一致させる
+	if strings.HasSuffix(p, "/") {
+		p += "**"
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(p); {
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 3
+		case strings.HasPrefix(p[i:], "**"):
+			b.WriteString(".*")
+			i += 2
+		case p[i] == '*':
+			b.WriteString("[^/]*")
+			i++
+		case p[i] == '?':
+			b.WriteString("[^/]")
+			i++
+		default:
+			r, size := utf8.DecodeRuneInString(p[i:])
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			i += size
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return globPattern{}, errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+	return globPattern{re: re, baseName: !strings.Contains(p, "/")}, nil
+}
+
+func newTransferJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate transfer job id")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		// "/" を含まないパターンはファイル名と比較する
+		{"*.png", "a.png", true},
+		{"*.png", "img/2024/a.png", true},
+		{"*.png", "a.png.bak", false},
+		{"?.txt", "a.txt", true},
+		{"?.txt", "ab.txt", false},
+		// "/" を含むパターンは相対パス全体と比較する
+		{"img/*.png", "img/a.png", true},
+		{"img/*.png", "img/sub/a.png", false},
+		{"img/*.png", "other/img/a.png", false},
+		{"/img/*.png", "img/a.png", true},
+		// "**" は "/" をまたいで一致する
+		{"img/**", "img/a/b/c.png", true},
+		{"img/**/*.png", "img/a.png", true},
+		{"img/**/*.png", "img/a/b/c.png", true},
+		{"img/**/*.png", "img/a/b/c.jpg", false},
+		{"**/thumbs/*", "a/b/thumbs/c.png", true},
+		{"**/thumbs/*", "thumbs/c.png", true},
+		// "/" で終わるパターンはフォルダ配下のすべてに一致する
+		{"thumbs/", "thumbs/a/b.png", true},
+		{"thumbs/", "thumbs2/a.png", false},
+		// 正規表現の記号はそのまま比較する
+		{"a+b(1).png", "a+b(1).png", true},
+		{"a.png", "aXpng", false},
+	}
+	for _, tt := range tests {
+		g, err := compileGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", tt.pattern, err)
+		}
+		if got := g.match(tt.rel); got != tt.want {
+			t.Errorf("compileGlob(%q).match(%q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGlob_RejectsInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{"", "  ", "/", "a\nb"} {
+		if _, err := compileGlob(pattern); err == nil {
+			t.Errorf("expected error for pattern %q", pattern)
+		}
+	}
+}
+
+func TestTransferMatcher(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		rel              string
+		want             bool
+	}{
+		{name: "no patterns", rel: "a/b.png", want: true},
+		{name: "include match", include: []string{"*.png"}, rel: "a/b.png", want: true},
+		{name: "include miss", include: []string{"*.png"}, rel: "a/b.jpg", want: false},
+		{name: "any include", include: []string{"*.png", "*.jpg"}, rel: "a/b.jpg", want: true},
+		{name: "exclude match", exclude: []string{"tmp/"}, rel: "tmp/a.png", want: false},
+		{name: "exclude miss", exclude: []string{"tmp/"}, rel: "img/tmp.png", want: true},
+		{name: "exclude wins over include", include: []string{"*.png"}, exclude: []string{"drafts/**"}, rel: "drafts/a.png", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newTransferMatcher(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("newTransferMatcher: %v", err)
+			}
+			if got := m.match(tt.rel); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.rel, got, tt.want)
+			}
+		})
+	}
+}
+
+func newRecordTestJob(keys ...string) *transferJob {
+	j := newTransferJob(domain.TransferJob{ID: "job"}, nil)
+	for _, key := range keys {
+		j.targets = append(j.targets, transferTarget{item: domain.TransferItem{Key: key, Size: 10}})
+	}
+	j.results = make([]*transferResult, len(keys))
+	return j
+}
+
+func TestTransferJobRecord_OutOfOrderCompletion(t *testing.T) {
+	j := newRecordTestJob("a", "b", "c", "d")
+
+	// 後ろの対象が先に終わっても、先頭から連続して処理済みになるまでチェックポイントは進まない
+	j.update(func(job *domain.TransferJob) { j.record(job, 2, transferResult{outcome: transferCopied, bytes: 10}) })
+	j.update(func(job *domain.TransferJob) { j.record(job, 1, transferResult{outcome: transferSkipped}) })
+	if got := j.persistent(); got.Checkpoint != "" || got.CheckpointCopied != 0 || got.Copied != 1 || got.Skipped != 1 {
+		t.Fatalf("expected no checkpoint yet, got checkpoint=%q tally=%d copied=%d skipped=%d", got.Checkpoint, got.CheckpointCopied, got.Copied, got.Skipped)
+	}
+
+	j.update(func(job *domain.TransferJob) { j.record(job, 0, transferResult{outcome: transferCopied, bytes: 10}) })
+	got := j.persistent()
+	if got.Checkpoint != "c" {
+		t.Fatalf("expected checkpoint c, got %q", got.Checkpoint)
+	}
+	if got.CheckpointCopied != 2 || got.CheckpointSkipped != 1 || got.CheckpointBytesCopied != 20 {
+		t.Errorf("unexpected checkpoint tally: copied=%d skipped=%d bytes=%d", got.CheckpointCopied, got.CheckpointSkipped, got.CheckpointBytesCopied)
+	}
+
+	j.update(func(job *domain.TransferJob) { j.record(job, 3, transferResult{outcome: transferCopied, bytes: 10}) })
+	if got := j.persistent(); got.Checkpoint != "d" || got.CheckpointCopied != 3 {
+		t.Errorf("expected checkpoint d with 3 copied, got %q with %d", got.Checkpoint, got.CheckpointCopied)
+	}
+}
+
+func TestTransferJobRecord_StopsAtFailure(t *testing.T) {
+	j := newRecordTestJob("a", "b", "c")
+
+	j.update(func(job *domain.TransferJob) { j.record(job, 0, transferResult{outcome: transferCopied, bytes: 10}) })
+	j.update(func(job *domain.TransferJob) {
+		j.record(job, 1, transferResult{outcome: transferFailed, err: errors.New("boom")})
+	})
+	j.update(func(job *domain.TransferJob) { j.record(job, 2, transferResult{outcome: transferCopied, bytes: 10}) })
+
+	got := j.persistent()
+	if got.Checkpoint != "a" {
+		t.Errorf("expected checkpoint to stop before the failed key, got %q", got.Checkpoint)
+	}
+	if got.CheckpointCopied != 1 {
+		t.Errorf("expected 1 copied before the checkpoint, got %d", got.CheckpointCopied)
+	}
+	if got.Copied != 2 || got.Failed != 1 || len(got.Failures) != 1 || got.Failures[0].Key != "b" {
+		t.Errorf("unexpected job counts: copied=%d failed=%d failures=%v", got.Copied, got.Failed, got.Failures)
+	}
+}
+
+func TestTransferJobRecord_CanceledDoesNotAdvance(t *testing.T) {
+	j := newRecordTestJob("a", "b")
+
+	j.update(func(job *domain.TransferJob) { j.record(job, 0, transferResult{outcome: transferCanceled}) })
+	j.update(func(job *domain.TransferJob) { j.record(job, 1, transferResult{outcome: transferCopied, bytes: 10}) })
+
+	if got := j.persistent(); got.Checkpoint != "" || got.CheckpointCopied != 0 {
+		t.Errorf("expected no checkpoint after a canceled key, got %q with %d copied", got.Checkpoint, got.CheckpointCopied)
+	}
+}
+
+type fakeTransferJobRepo struct {
+	jobs map[string]domain.TransferJob
+}
+
+func (r *fakeTransferJobRepo) SaveJob(ctx context.Context, job *domain.TransferJob) error {
+	r.jobs[job.ID] = *job
+	return nil
+}
+
+func (r *fakeTransferJobRepo) GetJob(ctx context.Context, id string) (*domain.TransferJob, error) {
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func (r *fakeTransferJobRepo) ListJobs(ctx context.Context) ([]domain.TransferJob, error) {
+	return nil, nil
+}
+
+func (r *fakeTransferJobRepo) ListUnfinishedJobs(ctx context.Context) ([]domain.TransferJob, error) {
+	return nil, nil
+}
+
+func (r *fakeTransferJobRepo) DeleteFinishedBefore(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestTransferServiceCancel(t *testing.T) {
+	repo := &fakeTransferJobRepo{jobs: map[string]domain.TransferJob{
+		"interrupted": {ID: "interrupted", Status: domain.TransferRunning},
+		"completed":   {ID: "completed", Status: domain.TransferCompleted},
+	}}
+	s := NewTransferService(nil, repo, 1, 1, 1)
+	ctx := context.Background()
+
+	// 再起動後にまだ再開していないジョブは保存している状態を中断にする
+	if err := s.Cancel(ctx, "interrupted"); err != nil {
+		t.Fatalf("Cancel(interrupted): %v", err)
+	}
+	if job := repo.jobs["interrupted"]; job.Status != domain.TransferCanceled || job.FinishedAt == nil {
+		t.Errorf("expected persisted job to be canceled, got status=%s finished_at=%v", job.Status, job.FinishedAt)
+	}
+
+	if err := s.Cancel(ctx, "completed"); !errors.Is(err, serviceif.ErrTransferJobNotRunning) {
+		t.Errorf("expected ErrTransferJobNotRunning for a finished job, got %v", err)
+	}
+	if err := s.Cancel(ctx, "interrupted"); !errors.Is(err, serviceif.ErrTransferJobNotRunning) {
+		t.Errorf("expected ErrTransferJobNotRunning after canceling, got %v", err)
+	}
+	if err := s.Cancel(ctx, "missing"); !errors.Is(err, serviceif.ErrTransferJobNotFound) {
+		t.Errorf("expected ErrTransferJobNotFound, got %v", err)
+	}
+
+	// メモリ上で終了済みのジョブも中断できない
+	finished := newTransferJob(domain.TransferJob{ID: "finished", Status: domain.TransferCompleted}, nil)
+	finished.cancel = func() {}
+	s.jobs["finished"] = finished
+	if err := s.Cancel(ctx, "finished"); !errors.Is(err, serviceif.ErrTransferJobNotRunning) {
+		t.Errorf("expected ErrTransferJobNotRunning for a finished in-memory job, got %v", err)
+	}
+}
@@ -5,35 +5,102 @@ import (
 	"context"
 	"io"
 	"log"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"r2manager/domain"
 	"r2manager/progress"
 	serviceif "r2manager/service/interface"
 )
 
+// S3 のマルチパートアップロードで扱えるパート数の上限
+const maxUploadParts = 10000
+
 type UploadService struct {
 	repo      serviceif.UploadRepository
 	listCache serviceif.ListCacheRepository
+
+	// multipartThreshold が 0 の場合、マルチパートアップロードは使用しない
+	multipartThreshold   int64
+	partSize             int64
+	multipartConcurrency int
 }
 
-func NewUploadService(repo serviceif.UploadRepository, listCache serviceif.ListCacheRepository) *UploadService {
-	return &UploadService{repo: repo, listCache: listCache}
+func NewUploadService(repo serviceif.UploadRepository, listCache serviceif.ListCacheRepository, opts ...UploadOption) *UploadService {
+	s := &UploadService{repo: repo, listCache: listCache}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type UploadOption func(*UploadService)
+
+// WithMultipartUpload は threshold を超えるアップロードをマルチパートで送信するよう設定する。
+// 同時に保持するパートバッファは concurrency 個までとなる。
+func WithMultipartUpload(threshold, partSize int64, concurrency int) UploadOption {
+	return func(s *UploadService) {
+		s.multipartThreshold = threshold
+		s.partSize = partSize
+		s.multipartConcurrency = max(concurrency, 1)
+	}
 }
 
 func (s *UploadService) UploadObject(ctx context.Context, bucketName, key, contentType string, body io.Reader, size int64, overwrite bool, onProgress serviceif.ProgressCallback) (*serviceif.UploadResult, error) {
-	key = sanitizeObjectPath(key)
+	return s.UploadObjectWithMetadata(ctx, bucketName, domain.ObjectMetadata{Key: key, ContentType: contentType}, body, size, overwrite, onProgress)
+}
+
+// UploadObjectWithMetadata は meta.Key に meta のヘッダとユーザー定義のメタデータを付けてアップロードする。
+func (s *UploadService) UploadObjectWithMetadata(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.Reader, size int64, overwrite bool, onProgress serviceif.ProgressCallback) (*serviceif.UploadResult, error) {
+	key := sanitizeObjectPath(meta.Key)
 	if key == "" {
 		return nil, errors.New("invalid key")
 	}
+	meta.Key = key
+
+	// サイズが分からないボディは、しきい値までを読み込んでから送信方法を決める
+	var head *bytes.Buffer
+	if size < 0 {
+		var err error
+		if head, err = s.readHead(body); err != nil {
+			return nil, err
+		}
+		if s.multipartEnabled() && int64(head.Len()) > s.multipartThreshold {
+			body = io.MultiReader(head, body)
+		} else {
+			size = int64(head.Len())
+		}
+	}
+
+	if s.multipartEnabled() && (size < 0 || size > s.multipartThreshold) {
+		etag, uploaded, err := s.uploadMultipart(ctx, bucketName, meta, body, size, overwrite, onProgress)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to upload object")
+		}
+		size = uploaded
+
+		s.listCache.AddObject(bucketName, domain.Object{Key: key, Size: size, ETag: etag, LastModified: time.Now().UTC()})
+		log.Printf("uploaded object (multipart): bucket=%s key=%s size=%d", bucketName, key, size)
+
+		return &serviceif.UploadResult{
+			Key:  key,
+			Size: size,
+			ETag: etag,
+		}, nil
+	}
 
 	// リクエストボディを一度バッファに読み込み、io.ReadSeeker として渡すことで
 	// SDK がリトライ時にボディを巻き戻せるようにする
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, body); err != nil {
-		return nil, errors.Wrap(err, "failed to read request body")
+	buf := head
+	if buf == nil {
+		buf = &bytes.Buffer{}
+		if _, err := io.Copy(buf, body); err != nil {
+			return nil, errors.Wrap(err, "failed to read request body")
+		}
 	}
 
 	var reader io.ReadSeeker
@@ -51,16 +118,16 @@ func (s *UploadService) UploadObject(ctx context.Context, bucketName, key, conte
 	var etag string
 	var putErr error
 	if overwrite {
-		etag, putErr = s.repo.PutObject(ctx, bucketName, key, contentType, reader)
+		etag, putErr = s.repo.PutObject(ctx, bucketName, meta, reader)
 	} else {
-		etag, putErr = s.repo.PutObjectIfNotExists(ctx, bucketName, key, contentType, reader)
+		etag, putErr = s.repo.PutObjectIfNotExists(ctx, bucketName, meta, reader)
 	}
 	if putErr != nil {
 		return nil, errors.Wrap(putErr, "failed to upload object")
 	}
 
-	// Invalidate list cache for this bucket
-	s.listCache.InvalidateObjects(bucketName)
+	// Reflect the new object in the cached listings of its prefix
+	s.listCache.AddObject(bucketName, domain.Object{Key: key, Size: size, ETag: etag, LastModified: time.Now().UTC()})
 	log.Printf("uploaded object: bucket=%s key=%s size=%d", bucketName, key, size)
 
 	return &serviceif.UploadResult{
@@ -70,6 +137,29 @@ func (s *UploadService) UploadObject(ctx context.Context, bucketName, key, conte
 	}, nil
 }
 
+func (s *UploadService) multipartEnabled() bool {
+	return s.multipartThreshold > 0 && s.partSize > 0
+}
+
+// readHead はサイズの分からないボディを、マルチパートアップロードのしきい値を 1 バイト超えるまで読み込む。
+// マルチパートアップロードを使わない場合は全体を読み込む。
+func (s *UploadService) readHead(body io.Reader) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	var err error
+	if s.multipartEnabled() {
+		_, err = io.CopyN(&buf, body, s.multipartThreshold+1)
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		_, err = io.Copy(&buf, body)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read request body")
+	}
+	return &buf, nil
+}
+
 func (s *UploadService) CreateDirectory(ctx context.Context, bucketName, path string) (*serviceif.UploadResult, error) {
 	path = sanitizeObjectPath(path)
 	if path == "" {
@@ -79,12 +169,12 @@ func (s *UploadService) CreateDirectory(ctx context.Context, bucketName, path st
 		path = path + "/"
 	}
 
-	etag, err := s.repo.PutObject(ctx, bucketName, path, "application/x-directory", strings.NewReader(""))
+	etag, err := s.repo.PutObject(ctx, bucketName, domain.ObjectMetadata{Key: path, ContentType: "application/x-directory"}, strings.NewReader(""))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create directory")
 	}
 
-	s.listCache.InvalidateObjects(bucketName)
+	s.listCache.AddObject(bucketName, domain.Object{Key: path, ETag: etag, LastModified: time.Now().UTC()})
 	log.Printf("created directory: bucket=%s path=%s", bucketName, path)
 
 	return &serviceif.UploadResult{
@@ -94,6 +184,139 @@ func (s *UploadService) CreateDirectory(ctx context.Context, bucketName, path st
 	}, nil
 }
 
+// uploadMultipart は body を partSize ごとに読み出し、最大 multipartConcurrency 並列で UploadPart する。
+// メモリ上に保持するのはパートバッファ multipartConcurrency 個分のみで、失敗時はアップロードを中断する。
+// size が負の場合はサイズが分からないものとして扱い、アップロードしたサイズを返す。
+func (s *UploadService) uploadMultipart(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.Reader, size int64, overwrite bool, onProgress serviceif.ProgressCallback) (string, int64, error) {
+	key := meta.Key
+	// パート数の上限を超えないようにパートサイズを調整する
+	partSize := max(s.partSize, (size+maxUploadParts-1)/maxUploadParts)
+
+	uploadID, err := s.repo.CreateMultipartUpload(ctx, bucketName, meta)
+	if err != nil {
+		return "", 0, err
+	}
+
+	parts, err := s.uploadParts(ctx, bucketName, key, uploadID, body, partSize, onProgress)
+	if err == nil {
+		var etag string
+		etag, err = s.repo.CompleteMultipartUpload(ctx, bucketName, key, uploadID, parts, overwrite)
+		if err == nil {
+			var uploaded int64
+			for _, part := range parts {
+				uploaded += part.Size
+			}
+			return etag, uploaded, nil
+		}
+	}
+
+	// 呼び出し元のコンテキストがキャンセルされていても中断できるようにする
+	if abortErr := s.repo.AbortMultipartUpload(context.WithoutCancel(ctx), bucketName, key, uploadID); abortErr != nil {
+		log.Printf("warning: failed to abort multipart upload: bucket=%s key=%s: %v", bucketName, key, abortErr)
+	}
+
+	return "", 0, err
+}
+
+func (s *UploadService) uploadParts(ctx context.Context, bucketName, key, uploadID string, body io.Reader, partSize int64, onProgress serviceif.ProgressCallback) ([]domain.UploadPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type partJob struct {
+		number int32
+		data   []byte
+	}
+
+	// バッファプール。読み出し側はここからバッファを取得するため、同時に保持するパートは concurrency 個に制限される
+	buffers := make(chan []byte, s.multipartConcurrency)
+	for range s.multipartConcurrency {
+		buffers <- make([]byte, partSize)
+	}
+	jobs := make(chan partJob)
+
+	var (
+		mu       sync.Mutex
+		parts    []domain.UploadPart
+		uploaded int64
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for range s.multipartConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				etag, err := s.repo.UploadPart(ctx, bucketName, key, uploadID, job.number, bytes.NewReader(job.data))
+				buffers <- job.data[:cap(job.data)]
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				mu.Lock()
+				parts = append(parts, domain.UploadPart{PartNumber: job.number, ETag: etag, Size: int64(len(job.data))})
+				uploaded += int64(len(job.data))
+				if onProgress != nil {
+					onProgress(uploaded)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+readLoop:
+	for partNumber := int32(1); ; partNumber++ {
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-ctx.Done():
+			break readLoop
+		}
+
+		n, err := io.ReadFull(body, buf)
+		// サイズが分からないボディはパート数の上限を超えうる
+		if n > 0 && partNumber > maxUploadParts {
+			fail(errors.Errorf("object exceeds %d parts of %d bytes", maxUploadParts, partSize))
+			break
+		}
+		if n > 0 {
+			select {
+			case jobs <- partJob{number: partNumber, data: buf[:n]}:
+			case <-ctx.Done():
+				break readLoop
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			fail(errors.Wrap(err, "failed to read request body"))
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
 // sanitizeObjectPath はオブジェクトキーのパスを正規化・検証する。
 // 先頭スラッシュの除去、連続スラッシュの正規化、パストラバーサルの排除を行い、
 // 不正なパスの場合は空文字を返す。
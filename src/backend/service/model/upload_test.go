@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"r2manager/domain"
+	serviceif "r2manager/service/interface"
+)
+
+// fakeUploadRepo は受け取ったボディを単一の PUT とパートごとに記録する
+type fakeUploadRepo struct {
+	serviceif.UploadRepository
+
+	mu        sync.Mutex
+	put       string
+	parts     map[int32]string
+	completed []domain.UploadPart
+	aborted   bool
+}
+
+func (r *fakeUploadRepo) PutObject(ctx context.Context, bucketName string, meta domain.ObjectMetadata, body io.ReadSeeker) (string, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	r.put = string(data)
+	return `"put"`, nil
+}
+
+func (r *fakeUploadRepo) CreateMultipartUpload(ctx context.Context, bucketName string, meta domain.ObjectMetadata) (string, error) {
+	r.parts = make(map[int32]string)
+	return "upload-1", nil
+}
+
+func (r *fakeUploadRepo) UploadPart(ctx context.Context, bucketName, key, uploadID string, partNumber int32, body io.ReadSeeker) (string, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.parts[partNumber] = string(data)
+	return fmt.Sprintf(`"part-%d"`, partNumber), nil
+}
+
+func (r *fakeUploadRepo) CompleteMultipartUpload(ctx context.Context, bucketName, key, uploadID string, parts []domain.UploadPart, overwrite bool) (string, error) {
+	r.completed = parts
+	return `"multipart"`, nil
+}
+
+func (r *fakeUploadRepo) AbortMultipartUpload(ctx context.Context, bucketName, key, uploadID string) error {
+	r.aborted = true
+	return nil
+}
+
+type fakeUploadListCache struct {
+	serviceif.ListCacheRepository
+	added []domain.Object
+}
+
+func (c *fakeUploadListCache) AddObject(bucketName string, obj domain.Object) {
+	c.added = append(c.added, obj)
+}
+
+func TestUploadObject_UnknownSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantPut   string
+		wantParts []string
+	}{
+		{name: "empty", body: "", wantPut: ""},
+		{name: "small", body: "0123456789", wantPut: "0123456789"},
+		// しきい値と同じサイズまでは単一の PUT で送る
+		{name: "threshold", body: strings.Repeat("a", 16), wantPut: strings.Repeat("a", 16)},
+		{name: "large", body: strings.Repeat("a", 10) + strings.Repeat("b", 10) + "c", wantParts: []string{strings.Repeat("a", 10), strings.Repeat("b", 10), "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeUploadRepo{}
+			listCache := &fakeUploadListCache{}
+			s := NewUploadService(repo, listCache, WithMultipartUpload(16, 10, 2))
+
+			var progress int64
+			result, err := s.UploadObject(context.Background(), "bucket", "file.bin", "application/octet-stream",
+				strings.NewReader(tt.body), -1, true, func(n int64) { progress = n })
+			if err != nil {
+				t.Fatalf("UploadObject: %v", err)
+			}
+
+			// サイズは読み込んだボディから求める
+			if result.Size != int64(len(tt.body)) {
+				t.Errorf("expected size %d, got %d", len(tt.body), result.Size)
+			}
+			if len(listCache.added) != 1 || listCache.added[0].Size != int64(len(tt.body)) {
+				t.Errorf("expected the listing to get the object with size %d, got %+v", len(tt.body), listCache.added)
+			}
+
+			if tt.wantParts == nil {
+				if repo.parts != nil || repo.put != tt.wantPut {
+					t.Errorf("expected a single put of %q, got put=%q parts=%v", tt.wantPut, repo.put, repo.parts)
+				}
+				return
+			}
+
+			var parts []string
+			for n := int32(1); n <= int32(len(repo.parts)); n++ {
+				parts = append(parts, repo.parts[n])
+			}
+			if !reflect.DeepEqual(parts, tt.wantParts) {
+				t.Errorf("expected parts %q, got %q", tt.wantParts, parts)
+			}
+			if len(repo.completed) != len(tt.wantParts) || repo.aborted {
+				t.Errorf("expected %d completed parts without abort, got %+v aborted=%v", len(tt.wantParts), repo.completed, repo.aborted)
+			}
+			if result.ETag != `"multipart"` || progress != int64(len(tt.body)) {
+				t.Errorf("expected multipart etag and full progress, got etag=%s progress=%d", result.ETag, progress)
+			}
+		})
+	}
+}
+
+func TestUploadObject_UnknownSizeWithoutMultipart(t *testing.T) {
+	repo := &fakeUploadRepo{}
+	s := NewUploadService(repo, &fakeUploadListCache{})
+
+	body := strings.Repeat("x", 100)
+	result, err := s.UploadObject(context.Background(), "bucket", "file.bin", "", strings.NewReader(body), -1, true, nil)
+	if err != nil {
+		t.Fatalf("UploadObject: %v", err)
+	}
+	if result.Size != 100 || repo.put != body || repo.parts != nil {
+		t.Errorf("expected a single put of 100 bytes, got size=%d put=%d parts=%v", result.Size, len(repo.put), repo.parts)
+	}
+}
@@ -52,6 +52,7 @@ export async function uploadFile(formData: FormData): Promise<UploadActionResult
 
   const response = await fetch(url, {
     method: 'PUT',
+    headers: { 'X-Upload-Size': String(file.size) },
     body: uploadFormData,
   })
 